@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// account gathers what a creator made while logged in. Accounts have no
+// password: logging in is clicking a link sent to the address.
+type account struct {
+	Email      string    `json:"email"`
+	CreatedAt  time.Time `json:"created_at"`
+	Shortlinks []string  `json:"shortlinks,omitempty"` // codes
+	Cards      []string  `json:"cards,omitempty"`      // group card IDs
+	Identities []string  `json:"identities,omitempty"` // e.g. "google:1234"
+}
+
+type accountSession struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type loginRequest struct {
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Tokens are stored hashed, so a leaked file opens no session.
+type accountData struct {
+	Accounts    map[string]*account       `json:"accounts"`     // email hash
+	Sessions    map[string]accountSession `json:"sessions"`     // token hash
+	Logins      map[string]loginRequest   `json:"logins"`       // token hash
+	OAuthStates map[string]oauthState     `json:"oauth_states"` // state hash
+	APITokens   map[string]apiToken       `json:"api_tokens"`   // token hash
+}
+
+type accountStore struct {
+	mu     sync.Mutex
+	loaded bool
+	data   accountData
+}
+
+var accounts = accountStore{
+	data: accountData{
+		Accounts:    map[string]*account{},
+		Sessions:    map[string]accountSession{},
+		Logins:      map[string]loginRequest{},
+		OAuthStates: map[string]oauthState{},
+		APITokens:   map[string]apiToken{},
+	},
+}
+
+var loginLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: loginRateWindow,
+	max:    loginRateLimit,
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionEmail returns the address of the request's session, or "" when
+// the visitor is not logged in.
+func sessionEmail(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	if err := ensureAccountsLoaded(); err != nil {
+		slog.Error("account store load failed", "error", err)
+		return ""
+	}
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+	session, ok := accounts.data.Sessions[tokenHash(cookie.Value)]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return ""
+	}
+	return session.Email
+}
+
+// recordOwnership adds a shortlink code or group card ID to the account of
+// email, the request's session or API token; anonymous requests are left
+// alone.
+func recordOwnership(email, shortlink, card string) {
+	if email == "" {
+		return
+	}
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+	acc := accountLocked(email)
+	if shortlink != "" && !containsString(acc.Shortlinks, shortlink) {
+		acc.Shortlinks = append(acc.Shortlinks, shortlink)
+	}
+	if card != "" && !containsString(acc.Cards, card) {
+		acc.Cards = append(acc.Cards, card)
+	}
+	accountsFlush.schedule()
+}
+
+func accountLocked(email string) *account {
+	key := emailHash(email)
+	acc := accounts.data.Accounts[key]
+	if acc == nil {
+		acc = &account{Email: email, CreatedAt: time.Now().UTC()}
+		accounts.data.Accounts[key] = acc
+	}
+	return acc
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountPageData is the data model of public/account.html.
+type AccountPageData struct {
+	Site       SiteIdentity
+	Email      string
+	LinkSent   bool
+	Failed     bool
+	Shortlinks []ShortLinkResponse
+	Cards      []AccountCard
+	Reminders  []AccountReminder
+	Providers  []AccountProvider // enabled OAuth sign-in methods
+	APITokens  []AccountAPIToken
+	Scopes     []string
+	NewToken   string // shown once, right after minting
+}
+
+type AccountAPIToken struct {
+	ID         string // token hash
+	Name       string
+	Scopes     string
+	CreatedAt  time.Time
+	UsedToday  int
+	DailyQuota int
+}
+
+type AccountProvider struct {
+	Name   string
+	Label  string
+	Linked bool
+}
+
+type AccountCard struct {
+	Recipient  string
+	Signatures int
+	GroupCardResponse
+}
+
+type AccountReminder struct {
+	Name           string
+	Date           string // DD/MM
+	UnsubscribeURL string
+}
+
+// handleAccountPage serves /minhas-mensagens: the login form, or the
+// shortlinks, group cards and reminders of the logged-in creator. POST
+// sends the magic link.
+func handleAccountPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodPost)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if r.Method == http.MethodPost {
+		handleLoginRequest(w, r)
+		return
+	}
+	email := sessionEmail(r)
+	if email == "" {
+		serveAccountPage(w, r, http.StatusOK, AccountPageData{})
+		return
+	}
+	data, err := accountPageData(email)
+	if err != nil {
+		slog.Error("account page load failed", "error", err)
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível montar esta página."))
+		return
+	}
+	serveAccountPage(w, r, http.StatusOK, data)
+}
+
+func handleLoginRequest(w http.ResponseWriter, r *http.Request) {
+	if !smtpConfigured() {
+		http.Error(w, "", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := readLimitedBody(r, maxSendBodyBytes)
+	if err != nil {
+		http.Error(w, "", statusFromError(err))
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	email, ok := parseEmailAddress(form.Get("email"))
+	if err != nil || !ok {
+		serveAccountPage(w, r, http.StatusBadRequest, AccountPageData{Failed: true})
+		return
+	}
+	if !loginLimiter.allow(clientIP(r)) || !loginLimiter.allow(emailHash(email)) {
+		http.Error(w, "", http.StatusTooManyRequests)
+		return
+	}
+	if err := ensureAccountsLoaded(); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	token, err := randomToken()
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	accounts.mu.Lock()
+	accounts.data.Logins[tokenHash(token)] = loginRequest{Email: email, CreatedAt: time.Now().UTC()}
+	err = persistAccountsLocked()
+	accounts.mu.Unlock()
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if err := sendEmail(email, buildLoginEmail(email, token)); err != nil {
+		slog.Error("login email failed", "error", err)
+		http.Error(w, "", http.StatusBadGateway)
+		return
+	}
+	serveAccountPage(w, r, http.StatusOK, AccountPageData{LinkSent: true})
+}
+
+// handleLogin serves the magic link GET /minhas-mensagens/entrar?token=...,
+// trading the single-use token for a session cookie.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if err := ensureAccountsLoaded(); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	key := tokenHash(r.URL.Query().Get("token"))
+	accounts.mu.Lock()
+	login, ok := accounts.data.Logins[key]
+	if ok {
+		delete(accounts.data.Logins, key)
+		ok = time.Since(login.CreatedAt) <= loginTokenTTL
+	}
+	accounts.mu.Unlock()
+	if !ok {
+		writeHTML(w, r, http.StatusNotFound, errorPage("Este link de acesso é inválido ou expirou."))
+		return
+	}
+	if err := startSession(w, r, login.Email); err != nil {
+		slog.Error("session start failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/minhas-mensagens", http.StatusSeeOther)
+}
+
+// startSession logs the browser into the account of email, creating the
+// account on first login.
+func startSession(w http.ResponseWriter, r *http.Request, email string) error {
+	session, err := randomToken()
+	if err != nil {
+		return err
+	}
+	accounts.mu.Lock()
+	accountLocked(email)
+	accounts.data.Sessions[tokenHash(session)] = accountSession{Email: email, ExpiresAt: time.Now().Add(sessionTTL).UTC()}
+	err = persistAccountsLocked()
+	accounts.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session,
+		Path:     "/",
+		MaxAge:   int(sessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// handleLogout serves POST /minhas-mensagens/sair.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && ensureAccountsLoaded() == nil {
+		accounts.mu.Lock()
+		delete(accounts.data.Sessions, tokenHash(cookie.Value))
+		if err := persistAccountsLocked(); err != nil {
+			slog.Error("account store persist failed", "error", err)
+		}
+		accounts.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, "/minhas-mensagens", http.StatusSeeOther)
+}
+
+func accountPageData(email string) (AccountPageData, error) {
+	data := AccountPageData{Email: email}
+	accounts.mu.Lock()
+	var codes, cardIDs []string
+	if acc := accounts.data.Accounts[emailHash(email)]; acc != nil {
+		codes = append(codes, acc.Shortlinks...)
+		cardIDs = append(cardIDs, acc.Cards...)
+		for _, provider := range enabledOAuthProviders() {
+			linked := false
+			for _, identity := range acc.Identities {
+				linked = linked || strings.HasPrefix(identity, provider.Name+":")
+			}
+			data.Providers = append(data.Providers, AccountProvider{Name: provider.Name, Label: provider.Label, Linked: linked})
+		}
+	}
+	accounts.mu.Unlock()
+
+	if err := ensureShortlinksLoaded(); err != nil {
+		return data, err
+	}
+	shortlinks.mu.RLock()
+	for _, code := range codes {
+		if path, ok := shortlinks.byCode[code]; ok {
+			data.Shortlinks = append(data.Shortlinks, shortlinkResponse(code, path))
+		}
+	}
+	shortlinks.mu.RUnlock()
+
+	for _, id := range cardIDs {
+		card, ok, err := groupCard(id)
+		if err != nil {
+			return data, err
+		}
+		if ok {
+			data.Cards = append(data.Cards, AccountCard{Recipient: card.Recipient, Signatures: len(card.Signatures), GroupCardResponse: groupCardResponse(&card)})
+		}
+	}
+
+	if err := ensureRemindersLoaded(); err != nil {
+		return data, err
+	}
+	reminders.mu.Lock()
+	for token, rem := range reminders.byToken {
+		if rem.Email == email && rem.Confirmed {
+			data.Reminders = append(data.Reminders, AccountReminder{
+				Name:           rem.Name,
+				Date:           fmt.Sprintf("%02d/%02d", rem.Day, rem.Month),
+				UnsubscribeURL: reminderURL("unsubscribe", token),
+			})
+		}
+	}
+	reminders.mu.Unlock()
+	sort.Slice(data.Reminders, func(i, j int) bool { return data.Reminders[i].Name < data.Reminders[j].Name })
+	data.APITokens = accountAPITokens(email)
+	data.Scopes = apiScopes
+	return data, nil
+}
+
+func serveAccountPage(w http.ResponseWriter, r *http.Request, status int, data AccountPageData) {
+	data.Site = siteIdentity()
+	if data.Email == "" {
+		for _, provider := range enabledOAuthProviders() {
+			data.Providers = append(data.Providers, AccountProvider{Name: provider.Name, Label: provider.Label})
+		}
+	}
+	var b strings.Builder
+	if err := accountTemplate.Execute(&b, data); err != nil {
+		slog.Error("account page render failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Robots-Tag", "noindex")
+	writeHTML(w, r, status, b.String())
+}
+
+func buildLoginEmail(to, token string) []byte {
+	link := strings.TrimRight(publicBaseURL(), "/") + "/minhas-mensagens/entrar?token=" + url.QueryEscape(token)
+	var msg bytes.Buffer
+	writeEmailHeaders(&msg, to, "Seu link de acesso ao "+siteIdentity().Name, "text/plain; charset=utf-8")
+	msg.WriteString("Para ver suas mensagens, use o link abaixo (válido por 15 minutos, uma única vez):\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", link)
+	msg.WriteString("Se você não pediu este acesso, basta ignorar esta mensagem.\r\n")
+	return msg.Bytes()
+}
+
+func ensureAccountsLoaded() error {
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+	if accounts.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(accountsDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			accounts.loaded = true
+			return nil
+		}
+		return err
+	}
+	var stored accountData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	if stored.Accounts == nil {
+		stored.Accounts = map[string]*account{}
+	}
+	if stored.Sessions == nil {
+		stored.Sessions = map[string]accountSession{}
+	}
+	if stored.Logins == nil {
+		stored.Logins = map[string]loginRequest{}
+	}
+	if stored.OAuthStates == nil {
+		stored.OAuthStates = map[string]oauthState{}
+	}
+	if stored.APITokens == nil {
+		stored.APITokens = map[string]apiToken{}
+	}
+	accounts.data = stored
+	accounts.loaded = true
+	return nil
+}
+
+// accountsFlush has the store written apiUsagePersistDelay after API token
+// usage counts or the links owned change, so a request with a token or
+// creating a link does not wait for a write of the whole store.
+var accountsFlush = &storeFlush{name: "account", delay: apiUsagePersistDelay, write: func() error {
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+	return persistAccountsLocked()
+}}
+
+func persistAccountsLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := accountsDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	for key, session := range accounts.data.Sessions {
+		if now.After(session.ExpiresAt) {
+			delete(accounts.data.Sessions, key)
+		}
+	}
+	for key, login := range accounts.data.Logins {
+		if now.Sub(login.CreatedAt) > loginTokenTTL {
+			delete(accounts.data.Logins, key)
+		}
+	}
+	for key, state := range accounts.data.OAuthStates {
+		if now.Sub(state.CreatedAt) > oauthStateTTL {
+			delete(accounts.data.OAuthStates, key)
+		}
+	}
+	data, err := json.MarshalIndent(accounts.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func accountsDBPath() string {
+	if value := os.Getenv("ACCOUNTS_DB"); value != "" {
+		return value
+	}
+	return "data/accounts.json"
+}
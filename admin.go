@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminToken is the secret of the operator pages; without ADMIN_TOKEN they
+// are disabled.
+func adminToken() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// requireAdmin checks the admin token, sent as "Authorization: Bearer" or
+// as the password of HTTP Basic auth so browsers can open admin pages. It
+// writes the error response, an envelope for the API, and returns false
+// when access is denied.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := adminToken()
+	if token == "" {
+		if isAPIRequest(r) {
+			writeAPIError(w, http.StatusNotFound, "not_found")
+		} else {
+			http.Error(w, "", http.StatusNotFound)
+		}
+		return false
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, given, _ = r.BasicAuth()
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+		if isAPIRequest(r) {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+		} else {
+			http.Error(w, "", http.StatusUnauthorized)
+		}
+		return false
+	}
+	return true
+}
+
+// AdminShortlink is a shortlink as GET /admin/shortlinks lists it. Links
+// created before their creation time and creator were kept have neither.
+type AdminShortlink struct {
+	Code        string     `json:"code"`
+	Path        string     `json:"path"`
+	Destination string     `json:"destination"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Clicks      int        `json:"clicks"`
+	ShortlinkCreator
+}
+
+// AdminShortlinksResponse is a page of GET /admin/shortlinks. Total counts
+// the links matching the filter on every page.
+type AdminShortlinksResponse struct {
+	Shortlinks []AdminShortlink `json:"shortlinks"`
+	Page       int              `json:"page"`
+	PerPage    int              `json:"per_page"`
+	Total      int              `json:"total"`
+}
+
+// handleAdminShortlinks serves GET /admin/shortlinks, newest first, taking
+// ?page= (from 1), ?q=, a case-insensitive substring of the code or of the
+// path, encoded or not, and ?ip=, the address the links were created from.
+func handleAdminShortlinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	page := 1
+	if value := query.Get("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_query")
+			return
+		}
+		page = parsed
+	}
+	filter := strings.ToLower(strings.TrimSpace(query.Get("q")))
+	ipHash := ""
+	if value := strings.TrimSpace(query.Get("ip")); value != "" {
+		if net.ParseIP(value) == nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_query")
+			return
+		}
+		ipHash = creatorIPHash(value)
+	}
+
+	if err := ensureShortlinksLoaded(); err != nil {
+		slog.Error("shortlink store load failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	var matches []AdminShortlink
+	shortlinks.mu.RLock()
+	for code, path := range shortlinks.byCode {
+		if filter != "" && !matchesShortlinkFilter(code, path, filter) {
+			continue
+		}
+		link := shortlinkResponse(code, path)
+		matches = append(matches, AdminShortlink{Code: code, Path: link.Path, Destination: link.Destination})
+	}
+	shortlinks.mu.RUnlock()
+
+	for i := range matches {
+		if meta, ok := shortlinkMetaOf(matches[i].Code); ok {
+			if !meta.CreatedAt.IsZero() {
+				createdAt := meta.CreatedAt
+				matches[i].CreatedAt = &createdAt
+			}
+			matches[i].ExpiresAt = meta.ExpiresAt
+			matches[i].ShortlinkCreator = meta.ShortlinkCreator
+		}
+		matches[i].Clicks = shortlinkStatsOf(matches[i].Code).Clicks
+	}
+	if ipHash != "" {
+		matches = slices.DeleteFunc(matches, func(link AdminShortlink) bool {
+			return link.CreatorIPHash != ipHash
+		})
+	}
+	// Newest first, then links of unknown age, each by code so pages are stable
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i].CreatedAt, matches[j].CreatedAt
+		switch {
+		case a != nil && b != nil && !a.Equal(*b):
+			return a.After(*b)
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return matches[i].Code < matches[j].Code
+	})
+
+	resp := AdminShortlinksResponse{Shortlinks: []AdminShortlink{}, Page: page, PerPage: adminShortlinksPerPage, Total: len(matches)}
+	// Checked before multiplying, which a huge page would overflow
+	if pages := (len(matches) + adminShortlinksPerPage - 1) / adminShortlinksPerPage; page <= pages {
+		start := (page - 1) * adminShortlinksPerPage
+		resp.Shortlinks = matches[start:min(start+adminShortlinksPerPage, len(matches))]
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// matchesShortlinkFilter reports whether filter, lowercase, is in the code
+// or in the path as stored or decoded.
+func matchesShortlinkFilter(code, path, filter string) bool {
+	if strings.Contains(strings.ToLower(code), filter) || strings.Contains(strings.ToLower(path), filter) {
+		return true
+	}
+	decoded, err := url.PathUnescape(path)
+	return err == nil && strings.Contains(strings.ToLower(decoded), filter)
+}
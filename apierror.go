@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Errors from /api/* and /s are JSON envelopes:
+//
+//	{"error":{"code":"blocked_message","message":"Essa mensagem não é permitida."}}
+//
+// The code is stable for clients to branch on; the message is Portuguese
+// text fit to show people as is.
+
+// APIError is the body of an API error response, under "error".
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIErrorResponse is the body of every API error.
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// apiErrorMessages maps the error codes to their messages.
+var apiErrorMessages = map[string]string{
+	"method_not_allowed":  "Método não permitido.",
+	"invalid_body":        "Pedido inválido.",
+	"body_too_large":      "O conteúdo enviado é grande demais.",
+	"rate_limited":        "Muitas tentativas. Espere um pouco e tente de novo.",
+	"quota_exceeded":      "A cota diária deste token acabou.",
+	"unauthorized":        "Autenticação necessária.",
+	"invalid_token":       "Token inválido ou revogado.",
+	"insufficient_scope":  "O token não permite esta operação.",
+	"csrf_failed":         "A sessão expirou. Recarregue a página e tente de novo.",
+	"captcha_failed":      "Confirme que você não é um robô.",
+	"captcha_unavailable": "Não foi possível verificar o CAPTCHA agora.",
+	"pow_failed":          "A verificação do navegador falhou. Tente de novo.",
+	"pow_required":        "Resolva o desafio e envie de novo.",
+	"not_found":           "Não encontrado.",
+	"link_expired":        "Este link expirou.",
+	"invalid_path":        "Endereço de mensagem inválido.",
+	"path_too_long":       "A mensagem é longa demais.",
+	"empty_message":       "Escreva uma mensagem.",
+	"blocked_message":     "Essa mensagem não é permitida.",
+	"invalid_name":        "Nome inválido.",
+	"blocked_name":        "Esse nome não é permitido.",
+	"invalid_age":         "Idade inválida.",
+	"invalid_birthdate":   "Data de nascimento inválida.",
+	"invalid_occasion":    "Ocasião desconhecida.",
+	"invalid_passphrase":  "A senha deve ter de 4 a 100 caracteres.",
+	"invalid_query":       "Parâmetros inválidos.",
+	"invalid_email":       "E-mail inválido.",
+	"invalid_field":       "Algum campo está inválido.",
+	"invalid_expiry":      "A validade do link deve ser de 1 a 365 dias.",
+	"limit_reached":       "O limite foi atingido.",
+	"unsupported_image":   "Envie uma imagem JPEG ou PNG.",
+	"photo_rejected":      "Esta foto não foi aceita.",
+	"no_free_code":        "Não há códigos livres agora. Tente de novo mais tarde.",
+	"unavailable":         "Serviço indisponível no momento.",
+	"storage_unavailable": "Não é possível salvar agora. Tente de novo mais tarde.",
+	"read_only":           "O site está em manutenção e não aceita alterações agora. Tente de novo mais tarde.",
+	"upstream_failed":     "Um serviço externo falhou. Tente de novo mais tarde.",
+	"internal_error":      "Algo deu errado. Tente de novo mais tarde.",
+}
+
+// writeAPIError writes the envelope of code with status.
+func writeAPIError(w http.ResponseWriter, status int, code string) {
+	message, ok := apiErrorMessages[code]
+	if !ok {
+		message = http.StatusText(status)
+	}
+	writeJSON(w, status, APIErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// writeAPIBodyError writes the error of readLimitedBody.
+func writeAPIBodyError(w http.ResponseWriter, err error) {
+	if err == errTooLarge {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "body_too_large")
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, "invalid_body")
+}
+
+// writeStorageUnavailable writes the 503 of a write refused while the data
+// disk is unhealthy.
+func writeStorageUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(diskRetryAfter))
+	writeAPIError(w, http.StatusServiceUnavailable, "storage_unavailable")
+}
+
+// writeNameError writes the error of parseName.
+func writeNameError(w http.ResponseWriter, err error) {
+	if err == errNameBlocked {
+		writeAPIError(w, http.StatusForbidden, "blocked_name")
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, "invalid_name")
+}
+
+// isAPIRequest reports whether r is answered with API error envelopes,
+// for the checks shared with HTML pages.
+func isAPIRequest(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/s/") && strings.HasSuffix(r.URL.Path, ".json") {
+		return true
+	}
+	return r.URL.Path == "/s" || strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/admin/")
+}
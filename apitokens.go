@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API token scopes. A token may only do what its scopes allow.
+const (
+	scopeCreateShortlinks = "create-shortlinks"
+	scopeReadStats        = "read-stats"
+)
+
+var apiScopes = []string{scopeCreateShortlinks, scopeReadStats}
+
+// apiToken lets a bot act for an account as Authorization: Bearer. Like
+// sessions, it is stored by hash and shown only when minted.
+type apiToken struct {
+	Email     string         `json:"email"`
+	Name      string         `json:"name"`
+	Scopes    []string       `json:"scopes"`
+	CreatedAt time.Time      `json:"created_at"`
+	Usage     map[string]int `json:"usage,omitempty"` // UTC date -> requests
+}
+
+// Daily quotas per token, by account e-mail, from the config file.
+var customAPIQuotas = map[string]int{}
+
+func validateAPIQuotas(quotas map[string]int) error {
+	for email, quota := range quotas {
+		if normalized, ok := parseEmailAddress(email); !ok || normalized != email {
+			return fmt.Errorf("invalid e-mail %q", email)
+		}
+		if quota <= 0 {
+			return fmt.Errorf("%q: quota must be positive", email)
+		}
+	}
+	return nil
+}
+
+// apiDailyQuota is how many requests each token of the account of email may
+// make per UTC day: its api_quotas entry, else API_DAILY_QUOTA.
+func apiDailyQuota(email string) int {
+	configMu.RLock()
+	quota, ok := customAPIQuotas[email]
+	configMu.RUnlock()
+	if ok {
+		return quota
+	}
+	if value := os.Getenv("API_DAILY_QUOTA"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultAPIDailyQuota
+}
+
+// bearerAPIToken looks up the Bearer API token of r. present is false for
+// requests without one; an unknown token is present but not found.
+func bearerAPIToken(r *http.Request) (id string, token apiToken, present, found bool, err error) {
+	given, present := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !present {
+		return "", apiToken{}, false, false, nil
+	}
+	if err := ensureAccountsLoaded(); err != nil {
+		return "", apiToken{}, true, false, err
+	}
+	id = tokenHash(given)
+	accounts.mu.Lock()
+	token, found = accounts.data.APITokens[id]
+	accounts.mu.Unlock()
+	return id, token, true, found, nil
+}
+
+// apiTokenAuth checks the Bearer API token of r, if any, for scope and
+// counts the request against the token's daily quota, in memory until the
+// store is next written. It returns the
+// e-mail of the token's account, or "" for a request without a token. On an
+// unknown token, a missing scope or an exhausted quota it writes the error
+// and returns false.
+func apiTokenAuth(w http.ResponseWriter, r *http.Request, scope string) (string, bool) {
+	id, token, present, found, err := bearerAPIToken(r)
+	switch {
+	case !present:
+		return "", true
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return "", false
+	case !found:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeAPIError(w, http.StatusUnauthorized, "invalid_token")
+		return "", false
+	case !containsString(token.Scopes, scope):
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+		writeAPIError(w, http.StatusForbidden, "insufficient_scope")
+		return "", false
+	}
+
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+	quota := apiDailyQuota(token.Email)
+	accounts.mu.Lock()
+	token, found = accounts.data.APITokens[id]
+	used := token.Usage[day]
+	allowed := found && used < quota
+	if allowed {
+		used++
+		token.Usage = pruneUsage(token.Usage, now)
+		token.Usage[day] = used
+		accounts.data.APITokens[id] = token
+	}
+	accounts.mu.Unlock()
+	if allowed {
+		accountsFlush.schedule()
+	}
+	if !found {
+		// Revoked meanwhile
+		writeAPIError(w, http.StatusUnauthorized, "invalid_token")
+		return "", false
+	}
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(quota))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(max(quota-used, 0)))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+		writeAPIError(w, http.StatusTooManyRequests, "quota_exceeded")
+		return "", false
+	}
+	return token.Email, true
+}
+
+// pruneUsage returns usage without the days older than apiUsageDays,
+// allocating it if needed.
+func pruneUsage(usage map[string]int, now time.Time) map[string]int {
+	if usage == nil {
+		return map[string]int{}
+	}
+	oldest := now.AddDate(0, 0, -apiUsageDays+1).Format("2006-01-02")
+	for day := range usage {
+		if day < oldest {
+			delete(usage, day)
+		}
+	}
+	return usage
+}
+
+// handleAPITokens serves POST /minhas-mensagens/tokens, minting a token for
+// the logged-in account, and POST /minhas-mensagens/tokens/revogar.
+func handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	email := sessionEmail(r)
+	if email == "" {
+		http.Redirect(w, r, "/minhas-mensagens", http.StatusSeeOther)
+		return
+	}
+	body, err := readLimitedBody(r, maxSendBodyBytes)
+	if err != nil {
+		writeHTML(w, r, statusFromError(err), errorPage("Não foi possível ler o formulário."))
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("Não foi possível ler o formulário."))
+		return
+	}
+
+	if r.URL.Path == "/minhas-mensagens/tokens/revogar" {
+		accounts.mu.Lock()
+		id := form.Get("id")
+		if token, ok := accounts.data.APITokens[id]; ok && token.Email == email {
+			delete(accounts.data.APITokens, id)
+			err = persistAccountsLocked()
+		}
+		accounts.mu.Unlock()
+		if err != nil {
+			writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível salvar agora. Tente de novo mais tarde."))
+			return
+		}
+		http.Redirect(w, r, "/minhas-mensagens", http.StatusSeeOther)
+		return
+	}
+
+	name := strings.TrimSpace(form.Get("name"))
+	var scopes []string
+	for _, scope := range form["scope"] {
+		if !containsString(apiScopes, scope) {
+			writeHTML(w, r, http.StatusBadRequest, errorPage("Permissão desconhecida."))
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if name == "" || len([]rune(name)) > maxNameLen || len(scopes) == 0 {
+		writeHTML(w, r, http.StatusBadRequest, errorPage(fmt.Sprintf("Dê ao token um nome de até %d caracteres e escolha ao menos uma permissão.", maxNameLen)))
+		return
+	}
+	token, err := randomToken()
+	if err != nil {
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Algo deu errado. Tente de novo mais tarde."))
+		return
+	}
+	accounts.mu.Lock()
+	owned := 0
+	for _, t := range accounts.data.APITokens {
+		if t.Email == email {
+			owned++
+		}
+	}
+	if owned < maxAPITokensPerAccount {
+		accounts.data.APITokens[tokenHash(token)] = apiToken{Email: email, Name: name, Scopes: scopes, CreatedAt: time.Now().UTC()}
+		err = persistAccountsLocked()
+	}
+	accounts.mu.Unlock()
+	if owned >= maxAPITokensPerAccount {
+		writeHTML(w, r, http.StatusTooManyRequests, errorPage(fmt.Sprintf("Cada conta pode ter até %d tokens. Revogue um para criar outro.", maxAPITokensPerAccount)))
+		return
+	}
+	if err != nil {
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível salvar agora. Tente de novo mais tarde."))
+		return
+	}
+
+	data, err := accountPageData(email)
+	if err != nil {
+		slog.Error("account page load failed", "error", err)
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível montar esta página."))
+		return
+	}
+	data.NewToken = token
+	serveAccountPage(w, r, http.StatusCreated, data)
+}
+
+// accountAPITokens lists the tokens of email for the account page, oldest
+// first.
+func accountAPITokens(email string) []AccountAPIToken {
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+	var list []AccountAPIToken
+	now := time.Now().UTC()
+	for id, token := range accounts.data.APITokens {
+		if token.Email == email {
+			usage := tokenUsage(token, now)
+			list = append(list, AccountAPIToken{ID: id, Name: token.Name, Scopes: strings.Join(token.Scopes, ", "), CreatedAt: token.CreatedAt, UsedToday: usage.UsedToday, DailyQuota: usage.DailyQuota})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// AccountStat is a shortlink of the account with its view count.
+type AccountStat struct {
+	ShortLinkResponse
+	Views int `json:"views"`
+}
+
+// handleAccountStats serves GET /api/stats: the view counts of the
+// shortlinks of the account, for a read-stats token or a session.
+func handleAccountStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	email, ok := apiTokenAuth(w, r, scopeReadStats)
+	if !ok {
+		return
+	}
+	if email == "" {
+		email = sessionEmail(r)
+	}
+	if email == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	data, err := accountPageData(email)
+	if err != nil {
+		slog.Error("account stats load failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	result := []AccountStat{}
+	for _, link := range data.Shortlinks {
+		count, err := viewCount(link.Path)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		result = append(result, AccountStat{ShortLinkResponse: link, Views: count})
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// TokenUsage is the quota and recent requests of a token.
+type TokenUsage struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	DailyQuota int        `json:"daily_quota"`
+	UsedToday  int        `json:"used_today"`
+	Remaining  int        `json:"remaining"`
+	Days       []DayUsage `json:"days"` // oldest first
+}
+
+type DayUsage struct {
+	Date     string `json:"date"`
+	Requests int    `json:"requests"`
+}
+
+func tokenUsage(token apiToken, now time.Time) TokenUsage {
+	quota := apiDailyQuota(token.Email)
+	usage := TokenUsage{Name: token.Name, Scopes: token.Scopes, DailyQuota: quota, Days: []DayUsage{}}
+	oldest := now.AddDate(0, 0, -apiUsageDays+1).Format("2006-01-02")
+	for day, requests := range token.Usage {
+		if day >= oldest {
+			usage.Days = append(usage.Days, DayUsage{Date: day, Requests: requests})
+		}
+	}
+	sort.Slice(usage.Days, func(i, j int) bool { return usage.Days[i].Date < usage.Days[j].Date })
+	usage.UsedToday = token.Usage[now.Format("2006-01-02")]
+	usage.Remaining = max(quota-usage.UsedToday, 0)
+	return usage
+}
+
+// handleAPIUsage serves GET /api/usage: the usage of the Bearer token, or
+// of every token of the logged-in account. It does not count against the
+// quota.
+func handleAPIUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	_, bearer, present, found, err := bearerAPIToken(r)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	email := ""
+	if !present {
+		email = sessionEmail(r)
+	}
+	if (present && !found) || (!present && email == "") {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	now := time.Now().UTC()
+	var tokens []apiToken
+	accounts.mu.Lock()
+	if present {
+		tokens = append(tokens, bearer)
+	} else {
+		for _, token := range accounts.data.APITokens {
+			if token.Email == email {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	result := []TokenUsage{}
+	for _, token := range tokens {
+		result = append(result, tokenUsage(token, now))
+	}
+	accounts.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Logs are written by a goroutine of their own: the slog handler formats a
+// record in memory and queues it, so a stalled stdout (a full pipe to
+// journald, a slow disk) never holds up a request, and the track_event
+// lines do not wait on each other. When the queue is full the record is
+// dropped and counted; the count is logged once the sink catches up, at
+// most every logDropReportInterval.
+
+type asyncLogWriter struct {
+	sink    io.Writer
+	lines   chan []byte
+	done    chan struct{}
+	dropped atomic.Int64
+
+	mu     sync.RWMutex // guards closed against sends on a closed queue
+	closed bool
+}
+
+func newAsyncLogWriter(sink io.Writer, size int) *asyncLogWriter {
+	w := &asyncLogWriter{sink: sink, lines: make(chan []byte, size), done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+// Write queues a copy of p, since handlers reuse their buffers, and never
+// blocks. After Close it writes to the sink directly.
+func (w *asyncLogWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return w.sink.Write(p)
+	}
+	select {
+	case w.lines <- bytes.Clone(p):
+	default:
+		w.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Dropped returns how many records were dropped since the start.
+func (w *asyncLogWriter) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+func (w *asyncLogWriter) run() {
+	defer close(w.done)
+	var reported int64
+	var lastReport time.Time
+	for line := range w.lines {
+		_, _ = w.sink.Write(line)
+		if total := w.dropped.Load(); total > reported && time.Since(lastReport) >= logDropReportInterval {
+			w.reportDropped(total - reported)
+			reported, lastReport = total, time.Now()
+		}
+	}
+	if total := w.dropped.Load(); total > reported {
+		w.reportDropped(total - reported)
+	}
+}
+
+func (w *asyncLogWriter) reportDropped(count int64) {
+	slog.New(slog.NewJSONHandler(w.sink, nil)).Warn("log records dropped", "count", count, "total", w.dropped.Load())
+}
+
+// Close writes out the queued records, waiting for the sink.
+func (w *asyncLogWriter) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.lines)
+	w.mu.Unlock()
+	<-w.done
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Embedded music clips selectable with ?som=
+var validSounds = map[string]bool{
+	"parabens": true,
+	"festa":    true,
+	"valsa":    true,
+}
+
+func soundName(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !validSounds[value] {
+		return ""
+	}
+	return value
+}
+
+// handleAudio serves the embedded clips with Range support, which mobile
+// browsers require before they will play media.
+func handleAudio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	file := strings.TrimPrefix(r.URL.Path, "/audio/")
+	name, ok := strings.CutSuffix(file, ".wav")
+	if !ok || soundName(name) != name {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	data, err := embeddedFiles.ReadFile("public/audio/" + file)
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	setCacheHeaders(w, cacheStaticMedia, "static")
+	http.ServeContent(w, r, file, time.Time{}, bytes.NewReader(data))
+}
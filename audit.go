@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Privileged operations (cache purges, config reloads, restores and other
+// maintenance commands) are appended to AUDIT_LOG, one JSON object per
+// line, with who did them and the state before and after. The file is only
+// ever appended to; GET /api/audit reads it back.
+
+// AuditEntry is one privileged operation.
+type AuditEntry struct {
+	At     time.Time       `json:"at"`
+	Actor  string          `json:"actor"` // "admin", "signal" or "cli"
+	IP     string          `json:"ip,omitempty"`
+	Action string          `json:"action"`
+	Target string          `json:"target,omitempty"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+func auditLogPath() string {
+	if value := os.Getenv("AUDIT_LOG"); value != "" {
+		return value
+	}
+	return "data/audit.jsonl"
+}
+
+// auditState encodes a before or after state of an entry.
+func auditState(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// adminAudit starts the entry of an operation requested with the admin
+// token.
+func adminAudit(r *http.Request, action, target string) AuditEntry {
+	return AuditEntry{Actor: "admin", IP: clientIP(r), Action: action, Target: target}
+}
+
+// recordAudit appends entry to the audit log. The operation has already
+// happened, so a failure is logged rather than returned.
+func recordAudit(entry AuditEntry) {
+	if entry.At.IsZero() {
+		entry.At = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = appendAuditLine(append(data, '\n'))
+	}
+	if err != nil {
+		slog.Error("audit log write failed", "action", entry.Action, "error", err)
+	}
+}
+
+func appendAuditLine(line []byte) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	path := auditLogPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readAuditLog returns the entries matching action (any when empty) at or
+// after since, newest first, at most limit of them. Unreadable lines are
+// skipped.
+func readAuditLog(action string, since time.Time, limit int) ([]AuditEntry, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	file, err := os.Open(auditLogPath())
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), maxAuditLineBytes)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if (action == "" || entry.Action == action) && !entry.At.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	newest := make([]AuditEntry, 0, min(len(entries), limit))
+	for i := len(entries) - 1; i >= 0 && len(newest) < limit; i-- {
+		newest = append(newest, entries[i])
+	}
+	return newest, nil
+}
+
+// AuditResponse is served by GET /api/audit.
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// handleAudit serves the admin endpoint GET /api/audit, taking ?action=,
+// ?since= (RFC 3339) and ?limit=.
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_query")
+			return
+		}
+		since = parsed
+	}
+	limit := defaultAuditLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxAuditLimit {
+			writeAPIError(w, http.StatusBadRequest, "invalid_query")
+			return
+		}
+		limit = parsed
+	}
+	entries, err := readAuditLog(query.Get("action"), since, limit)
+	if err != nil {
+		slog.Error("audit log read failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, AuditResponse{Entries: entries})
+}
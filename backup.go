@@ -0,0 +1,491 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backups snapshot the data stores into one gzipped tar archive, named
+// parabens-backup-<UTC time>.tar.gz, in BACKUP_DIR or an S3 bucket
+// (BACKUP_S3_BUCKET). They run on the BACKUP_SCHEDULE cron expression and
+// with the backup command; after each one, archives beyond BACKUP_KEEP or
+// older than BACKUP_MAX_AGE_DAYS are deleted. The restore command writes an
+// archive's stores back to their configured paths, and is meant to run with
+// the server stopped, since a running one would overwrite them from memory.
+
+// backupStores are the files a backup holds, by their name in the archive.
+// The deferred shortlinks waiting for moderation live only in memory.
+var backupStores = []struct {
+	name  string
+	path  func() string
+	jsonl bool // appended one JSON object per line rather than rewritten
+	// read and restore replace reading and writing the file at path for a
+	// store that may be kept elsewhere
+	read    func() ([]byte, error)
+	restore func(data []byte, now time.Time) (string, error)
+}{
+	{name: "shortlinks.json", path: shortlinkDBPath, read: readShortlinkSnapshot, restore: restoreShortlinkStore},
+	{name: "shortlinks.quarantine.jsonl", path: func() string { return shortlinkQuarantinePath(shortlinkDBPath()) }, jsonl: true},
+	{name: "views.json", path: viewsDBPath},
+	{name: "stats.json", path: statsDBPath},
+	{name: "experiments.json", path: experimentsDBPath},
+	{name: "guestbook.json", path: guestbookDBPath},
+	{name: "email.json", path: emailDBPath},
+	{name: "reminders.json", path: remindersDBPath},
+	{name: "accounts.json", path: accountsDBPath},
+	{name: "cards.json", path: cardsDBPath},
+	{name: "protected.json", path: protectedDBPath},
+}
+
+// backupTarget stores backup archives by name.
+type backupTarget interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	List(prefix string) ([]string, error)
+	Delete(name string) error
+}
+
+var errNoBackupTarget = errors.New("no backup target: set BACKUP_DIR or BACKUP_S3_BUCKET")
+
+// backupTargetFromEnv returns the S3 bucket of BACKUP_S3_BUCKET or the
+// directory of BACKUP_DIR, or errNoBackupTarget when neither is set.
+func backupTargetFromEnv() (backupTarget, error) {
+	client, err := s3ClientFromEnv("BACKUP_S3")
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		return client, nil
+	}
+	if dir := os.Getenv("BACKUP_DIR"); dir != "" {
+		return localBackupDir(dir), nil
+	}
+	return nil, errNoBackupTarget
+}
+
+// localBackupDir keeps archives as files readable only by the server's
+// user, since the stores hold e-mail addresses.
+type localBackupDir string
+
+func (d localBackupDir) Put(name string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(string(d), name)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (d localBackupDir) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.Base(name)))
+}
+
+func (d localBackupDir) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, prefix) && !strings.HasSuffix(name, ".tmp") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (d localBackupDir) Delete(name string) error {
+	return os.Remove(filepath.Join(string(d), filepath.Base(name)))
+}
+
+func backupName(at time.Time) string {
+	return backupFilePrefix + at.UTC().Format(backupTimeFormat) + ".tar.gz"
+}
+
+// backupTime parses the time out of a backup's name.
+func backupTime(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, backupFilePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, ".tar.gz")
+	if !ok {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(backupTimeFormat, stamp)
+	return at, err == nil
+}
+
+// listBackups returns the names of the backups in target, oldest first.
+func listBackups(target backupTarget) ([]string, error) {
+	names, err := target.List(backupFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	backups := names[:0]
+	for _, name := range names {
+		if _, ok := backupTime(name); ok {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// createBackupArchive packs the stores that exist into a tar.gz archive,
+// returning it and the names of the stores in it.
+func createBackupArchive(now time.Time) ([]byte, []string, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	var included []string
+	for _, store := range backupStores {
+		var data []byte
+		var err error
+		if store.read != nil {
+			data, err = store.read()
+		} else {
+			data, err = readStoreSnapshot(store.path(), store.jsonl)
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", store.name, err)
+		}
+		header := &tar.Header{Name: store.name, Mode: 0o600, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, nil, err
+		}
+		included = append(included, store.name)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), included, nil
+}
+
+// readStoreSnapshot reads a store while the server may be writing it. A
+// JSON store caught mid-write is read again once before giving up.
+func readStoreSnapshot(path string, jsonl bool) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		data, err := os.ReadFile(path)
+		if err != nil || jsonl || json.Valid(data) {
+			return data, err
+		}
+		if attempt > 0 {
+			return nil, fmt.Errorf("%s is not valid JSON", path)
+		}
+		time.Sleep(backupRetryDelay)
+	}
+}
+
+// readShortlinkSnapshot reads the shortlink store as written: the object
+// of SHORTLINK_S3_BUCKET, or the file at SHORTLINK_DB. The object is read
+// as it is rather than through loadShortlinkStore, which would move the
+// version a running server merges the other servers' changes from.
+func readShortlinkSnapshot() ([]byte, error) {
+	bucket, err := shortlinkBucket()
+	if err != nil {
+		return nil, err
+	}
+	if bucket == nil {
+		return readStoreSnapshot(shortlinkDBPath(), false)
+	}
+	data, err := bucket.Get(shortlinkObjectName)
+	if isS3NotFound(err) {
+		return nil, os.ErrNotExist
+	}
+	return data, err
+}
+
+// restoreShortlinkStore writes the shortlink store of a backup where the
+// server reads it: to the bucket through writeShortlinkStore, as the import
+// command does, keeping the object it replaces as
+// shortlinks.json.pre-restore-<unix time>; without one, to the file at
+// SHORTLINK_DB like the other stores.
+func restoreShortlinkStore(data []byte, now time.Time) (string, error) {
+	path := shortlinkDBPath()
+	bucket, err := shortlinkBucket()
+	if err != nil {
+		return "", err
+	}
+	if bucket == nil {
+		return restoreStoreFile(path, data, now)
+	}
+	entries, _, bad, err := parseShortlinkRecords(data)
+	if err != nil {
+		return "", err
+	}
+	for _, record := range bad {
+		slog.Warn("shortlink record left out of the restore", "code", record.Code, "reason", record.Reason)
+	}
+	migrateLegacyShortlinks(entries.paths)
+
+	// Read for its ETag, which the write is conditional on
+	if _, _, err := loadShortlinkStore(path); err != nil {
+		return "", err
+	}
+	previous, err := bucket.Get(shortlinkObjectName)
+	if err == nil {
+		err = bucket.Put(fmt.Sprintf("%s.pre-restore-%d", shortlinkObjectName, now.Unix()), previous)
+	} else if isS3NotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := writeShortlinkStore(path, entries); err != nil {
+		return "", err
+	}
+	return shortlinkStoreName(path), nil
+}
+
+// runBackup stores a new backup in target and prunes the old ones,
+// returning the new backup's name.
+func runBackup(target backupTarget, now time.Time) (string, error) {
+	archive, stores, err := createBackupArchive(now)
+	if err != nil {
+		return "", err
+	}
+	name := backupName(now)
+	if err := target.Put(name, archive); err != nil {
+		return "", err
+	}
+	slog.Info("backup stored", "name", name, "stores", len(stores), "bytes", len(archive))
+	if removed, err := pruneBackups(target, now); err != nil {
+		slog.Error("backup pruning failed", "error", err)
+	} else if removed > 0 {
+		slog.Info("old backups deleted", "count", removed)
+	}
+	return name, nil
+}
+
+// pruneBackups deletes the backups beyond the newest backupKeep and those
+// older than backupMaxAge, always keeping the newest one.
+func pruneBackups(target backupTarget, now time.Time) (int, error) {
+	backups, err := listBackups(target)
+	if err != nil {
+		return 0, err
+	}
+	keep, maxAge := backupKeep(), backupMaxAge()
+	removed := 0
+	for i, name := range backups {
+		newer := len(backups) - 1 - i
+		if newer == 0 {
+			break
+		}
+		at, _ := backupTime(name)
+		if (keep > 0 && newer >= keep) || (maxAge > 0 && now.Sub(at) > maxAge) {
+			if err := target.Delete(name); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// backupKeep is BACKUP_KEEP, the number of backups kept; 0 keeps all.
+func backupKeep() int {
+	if value := os.Getenv("BACKUP_KEEP"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultBackupKeep
+}
+
+// backupMaxAge is BACKUP_MAX_AGE_DAYS, after which backups are deleted; 0,
+// the default, keeps them regardless of age.
+func backupMaxAge() time.Duration {
+	if value := os.Getenv("BACKUP_MAX_AGE_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return 0
+}
+
+// startBackupScheduler runs backups on BACKUP_SCHEDULE when a target is
+// configured.
+func startBackupScheduler() {
+	target, err := backupTargetFromEnv()
+	if errors.Is(err, errNoBackupTarget) {
+		return
+	}
+	if err != nil {
+		slog.Error("backups disabled", "error", err)
+		return
+	}
+	expr := os.Getenv("BACKUP_SCHEDULE")
+	if expr == "" {
+		expr = defaultBackupSchedule
+	}
+	schedule, err := parseCron(expr)
+	if err != nil {
+		slog.Error("backups disabled", "error", err)
+		return
+	}
+	go func() {
+		for {
+			next := schedule.next(time.Now())
+			if next.IsZero() {
+				slog.Error("backup schedule never runs", "schedule", expr)
+				return
+			}
+			time.Sleep(time.Until(next))
+			if _, err := runBackup(target, time.Now()); err != nil {
+				slog.Error("backup failed", "error", err)
+			}
+		}
+	}()
+}
+
+// runBackupCommand is the backup command: one backup now.
+func runBackupCommand(out io.Writer) error {
+	target, err := backupTargetFromEnv()
+	if err != nil {
+		return err
+	}
+	name, err := runBackup(target, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, name)
+	return nil
+}
+
+// runRestoreCommand is the restore command: it restores the backup named
+// in args, or the latest one.
+func runRestoreCommand(args []string, out io.Writer) error {
+	target, err := backupTargetFromEnv()
+	if err != nil {
+		return err
+	}
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	} else {
+		backups, err := listBackups(target)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return errors.New("no backups to restore")
+		}
+		name = backups[len(backups)-1]
+	}
+	archive, err := target.Get(name)
+	if err != nil {
+		return err
+	}
+	restored, err := restoreBackupArchive(archive, time.Now())
+	audit := AuditEntry{Actor: "cli", Action: "backup.restore", Target: name, After: auditState(restored)}
+	if err != nil {
+		audit.Error = err.Error()
+	}
+	recordAudit(audit)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, path := range restored {
+		fmt.Fprintf(out, "restored %s\n", path)
+	}
+	fmt.Fprintf(out, "%d stores restored from %s\n", len(restored), name)
+	return nil
+}
+
+// restoreBackupArchive writes the stores of archive to their configured
+// paths, returning the paths. The archive is read in full first, so a
+// damaged one restores nothing; a store it replaces is kept next to it as
+// <path>.pre-restore-<unix time>.
+func restoreBackupArchive(archive []byte, now time.Time) ([]string, error) {
+	stores := map[string]int{}
+	for i, store := range backupStores {
+		stores[store.name] = i
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	type restoredFile struct {
+		store int
+		data  []byte
+	}
+	var files []restoredFile
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		i, ok := stores[header.Name]
+		if !ok {
+			slog.Warn("unknown file in backup skipped", "name", header.Name)
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, restoredFile{store: i, data: data})
+	}
+
+	var restored []string
+	for _, file := range files {
+		store := backupStores[file.store]
+		var path string
+		if store.restore != nil {
+			path, err = store.restore(file.data, now)
+		} else {
+			path, err = restoreStoreFile(store.path(), file.data, now)
+		}
+		if err != nil {
+			return restored, fmt.Errorf("%s: %w", store.name, err)
+		}
+		restored = append(restored, path)
+	}
+	return restored, nil
+}
+
+// restoreStoreFile writes data as the store at path, keeping the file it
+// replaces as <path>.pre-restore-<unix time>, and returns path.
+func restoreStoreFile(path string, data []byte, now time.Time) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, fmt.Sprintf("%s.pre-restore-%d", path, now.Unix())); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := writeFileAtomic(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
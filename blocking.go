@@ -3,6 +3,7 @@ package main
 import (
 	"strings"
 	"sync"
+	"unicode"
 )
 
 var (
@@ -47,28 +48,26 @@ func loadBlockedTerms() {
 	}
 }
 
+// normalizeForBlock lowercases value and turns every run of characters
+// other than letters and digits into a single space, trimmed, in one pass.
 func normalizeForBlock(value string) string {
-	value = strings.TrimSpace(strings.ToLower(value))
-	if value == "" {
-		return ""
-	}
-	value = strings.ReplaceAll(value, "_", " ")
-	value = strings.ReplaceAll(value, "-", " ")
-	value = strings.Map(func(r rune) rune {
+	var b strings.Builder
+	b.Grow(len(value))
+	pendingSpace := false
+	for _, r := range value {
+		r = unicode.ToLower(r)
 		switch {
-		case r >= 'a' && r <= 'z':
-			return r
-		case r >= '0' && r <= '9':
-			return r
-		case r >= 'À' && r <= 'ÿ':
-			return r
-		case r == ' ':
-			return r
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r >= 'À' && r <= 'ÿ':
+			if pendingSpace && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			pendingSpace = false
+			b.WriteRune(r)
 		default:
-			return ' '
+			pendingSpace = true
 		}
-	}, value)
-	return strings.Join(strings.Fields(value), " ")
+	}
+	return b.String()
 }
 
 // Suspicious file extensions commonly used in exploit attempts
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	bodasNamesOnce sync.Once
+	bodasNames     map[int]string
+)
+
+func loadBodasNames() {
+	bodasNames = map[int]string{}
+	data, err := embeddedFiles.ReadFile("public/bodas.txt")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rawYears, name, ok := strings.Cut(line, "|")
+		years, err := strconv.Atoi(rawYears)
+		if !ok || err != nil {
+			continue
+		}
+		bodasNames[years] = name
+	}
+}
+
+// bodasName returns the traditional name of a wedding anniversary, "Prata"
+// for 25 years, or "" when the year has none.
+func bodasName(years int) string {
+	bodasNamesOnce.Do(loadBodasNames)
+	return bodasNames[years]
+}
+
+// bodasGreeting is the greeting of /bodas/{names}/{years}: "Felizes Bodas
+// de Prata", or the number of years when the anniversary has no name.
+func bodasGreeting(years int) string {
+	if name := bodasName(years); name != "" {
+		return "Felizes Bodas de " + name
+	}
+	return fmt.Sprintf("Felicidades pelos %d anos de casados", years)
+}
+
+func bodasSubtitle(years int) string {
+	if years == 1 {
+		return "Celebrando 1 ano de amor e parceria"
+	}
+	return fmt.Sprintf("Celebrando %d anos de amor e parceria", years)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var icsEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\;",
+	",", "\\,",
+	"\n", "\\n",
+)
+
+// handleCalendar serves a yearly-recurring birthday event linking back to
+// the greeting page: /calendar.ics?nome=João&data=25-12
+func handleCalendar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	query := r.URL.Query()
+	name, err := parseName(query.Get("nome"))
+	if err == errNameBlocked {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	day, month, ok := parseDayMonth(query.Get("data"))
+	if err != nil || name == "" || !ok {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	data := []byte(birthdayICS(name, day, month, time.Now().UTC()))
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="aniversario.ics"`)
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+// parseDayMonth parses "DD-MM" (or "DD/MM") into a valid calendar day.
+func parseDayMonth(value string) (day, month int, ok bool) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), "/", "-")
+	dd, mm, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, false
+	}
+	day, err1 := strconv.Atoi(dd)
+	month, err2 := strconv.Atoi(mm)
+	if err1 != nil || err2 != nil || month < 1 || month > 12 || day < 1 {
+		return 0, 0, false
+	}
+	// 2024 is a leap year, so 29-02 is accepted
+	date := time.Date(2024, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day {
+		return 0, 0, false
+	}
+	return day, month, true
+}
+
+// nextOccurrence returns the first date on or after now falling on day/month.
+func nextOccurrence(day, month int, now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for year := now.Year(); ; year++ {
+		date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if date.Day() == day && !date.Before(today) {
+			return date
+		}
+	}
+}
+
+func birthdayICS(name string, day, month int, now time.Time) string {
+	start := nextOccurrence(day, month, now)
+	domain := siteIdentity().Domain
+	link := strings.TrimRight(publicBaseURL(), "/") + "/aniversario/" + encodePathSegment(name)
+	uidSum := sha256.Sum256([]byte(fmt.Sprintf("%s|%02d-%02d", strings.ToLower(name), day, month)))
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//" + domain + "//aniversario//PT",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		"UID:" + hex.EncodeToString(uidSum[:8]) + "@" + domain,
+		"DTSTAMP:" + now.UTC().Format("20060102T150405Z"),
+		"DTSTART;VALUE=DATE:" + start.Format("20060102"),
+		"DTEND;VALUE=DATE:" + start.AddDate(0, 0, 1).Format("20060102"),
+		"RRULE:FREQ=YEARLY",
+		"SUMMARY:" + icsEscaper.Replace("🎂 Aniversário de "+name),
+		"DESCRIPTION:" + icsEscaper.Replace("Mande os parabéns: "+link),
+		"URL:" + link,
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(foldICSLine(line))
+	}
+	return b.String()
+}
+
+// foldICSLine splits content lines longer than 75 octets as required by
+// RFC 5545, without breaking UTF-8 sequences.
+func foldICSLine(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// captchaProvider is a CAPTCHA service whose widget the page embeds and
+// whose siteverify endpoint checks the token it produces.
+type captchaProvider struct {
+	ScriptURL   string
+	WidgetClass string
+	VerifyURL   string
+	// Response field the widget adds to its form
+	ResponseField string
+	// Sources the Content-Security-Policy must allow for the widget
+	CSPSources string
+}
+
+var captchaProviders = map[string]captchaProvider{
+	"turnstile": {
+		ScriptURL:     "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		WidgetClass:   "cf-turnstile",
+		VerifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		ResponseField: "cf-turnstile-response",
+		CSPSources:    "https://challenges.cloudflare.com",
+	},
+	"hcaptcha": {
+		ScriptURL:     "https://js.hcaptcha.com/1/api.js",
+		WidgetClass:   "h-captcha",
+		VerifyURL:     "https://api.hcaptcha.com/siteverify",
+		ResponseField: "h-captcha-response",
+		CSPSources:    "https://hcaptcha.com https://*.hcaptcha.com",
+	},
+}
+
+var captchaHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// CaptchaWidget is what the page needs to render the CAPTCHA.
+type CaptchaWidget struct {
+	ScriptURL     string
+	WidgetClass   string
+	SiteKey       string
+	ResponseField string
+}
+
+// captchaConfig returns the provider chosen by CAPTCHA_PROVIDER, with
+// CAPTCHA_SITE_KEY and CAPTCHA_SECRET. The CAPTCHA is off unless all three
+// are set.
+func captchaConfig() (provider captchaProvider, siteKey, secret string, ok bool) {
+	provider, ok = captchaProviders[strings.ToLower(os.Getenv("CAPTCHA_PROVIDER"))]
+	siteKey = os.Getenv("CAPTCHA_SITE_KEY")
+	secret = os.Getenv("CAPTCHA_SECRET")
+	return provider, siteKey, secret, ok && siteKey != "" && secret != ""
+}
+
+func captchaWidget() *CaptchaWidget {
+	provider, siteKey, _, ok := captchaConfig()
+	if !ok {
+		return nil
+	}
+	return &CaptchaWidget{ScriptURL: provider.ScriptURL, WidgetClass: provider.WidgetClass, SiteKey: siteKey, ResponseField: provider.ResponseField}
+}
+
+// checkCaptcha verifies the X-Captcha-Token of r with the provider when the
+// CAPTCHA is enabled, writing 403 for a missing or rejected token and 503
+// when the provider cannot be reached.
+func checkCaptcha(w http.ResponseWriter, r *http.Request) bool {
+	provider, _, secret, ok := captchaConfig()
+	if !ok {
+		return true
+	}
+	token := r.Header.Get(captchaHeaderName)
+	if token == "" {
+		writeAPIError(w, http.StatusForbidden, "captcha_failed")
+		return false
+	}
+	resp, err := captchaHTTPClient.PostForm(provider.VerifyURL, url.Values{
+		"secret":   {secret},
+		"response": {token},
+		"remoteip": {clientIP(r)},
+	})
+	if err != nil {
+		slog.Error("captcha verification failed", "error", err)
+		writeAPIError(w, http.StatusServiceUnavailable, "captcha_unavailable")
+		return false
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode != http.StatusOK {
+		slog.Error("captcha verification failed", "status", resp.StatusCode, "error", err)
+		writeAPIError(w, http.StatusServiceUnavailable, "captcha_unavailable")
+		return false
+	}
+	if !result.Success {
+		slog.Info("captcha rejected", "ip", clientIP(r), "errors", strings.Join(result.ErrorCodes, ","))
+		writeAPIError(w, http.StatusForbidden, "captcha_failed")
+		return false
+	}
+	return true
+}
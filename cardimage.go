@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var renderCardImageToFileFunc = renderCardImageToFile
+
+// cardImageSVG draws the whole card in story format (9:16): emoji, photo,
+// greeting, message, subtitle, signature, fun facts and birthday twins over
+// the theme's background with a sprinkle of confetti. Unlike the OG image,
+// nothing is cropped or truncated.
+func cardImageSVG(g greeting, sender string, theme Theme, accent string) string {
+	palette := theme.Palette
+	accentColor := palette.Accent
+	if accent != "" {
+		accentColor = "#" + accent
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		cardImageWidth, cardImageHeight, cardImageWidth, cardImageHeight)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`, palette.Background)
+	for _, p := range confettiPieces(accent) {
+		x := p.X * cardImageWidth / ogImageWidth
+		y := p.Y * cardImageHeight / ogImageHeight
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="27" height="15" rx="3" fill="%s" opacity="0.55" transform="rotate(%.0f %.1f %.1f)"/>`,
+			x-13.5, y-7.5, p.Color, p.Angle, x, y)
+	}
+
+	y := 420
+	if g.OgSpec.Photo != "" {
+		if data, err := os.ReadFile(photoPath(g.OgSpec.Photo)); err == nil {
+			fmt.Fprintf(&b, `<clipPath id="photo-clip"><circle cx="%d" cy="400" r="200"/></clipPath>`, cardImageWidth/2)
+			fmt.Fprintf(&b, `<image x="%d" y="200" width="400" height="400" preserveAspectRatio="xMidYMid slice" clip-path="url(#photo-clip)" xlink:href="data:image/jpeg;base64,%s"/>`,
+				cardImageWidth/2-200, base64.StdEncoding.EncodeToString(data))
+			y = 720
+		}
+	}
+	if y == 420 {
+		fmt.Fprintf(&b, `<text x="50%%" y="380" text-anchor="middle" font-size="180" font-family="%s">%s</text>`, cardImageEmojiFont, escapeXML(g.Emoji))
+	}
+
+	line := func(text string, size int, color, extra string) {
+		fmt.Fprintf(&b, `<text x="50%%" y="%d" text-anchor="middle" font-size="%d" font-family="%s" fill="%s"%s>%s</text>`,
+			y, size, cardImageFont, color, extra, escapeXML(text))
+		y += size * 5 / 4
+	}
+	for _, text := range wrapWords(g.Greeting+",", cardImageWrap*5/4) {
+		line(text, 64, accentColor, ` font-weight="700"`)
+	}
+	y += 20
+	lines := messageLines(g.DisplayMessage)
+	lines[len(lines)-1] += g.Punct
+	for _, messageLine := range lines {
+		for _, text := range wrapWords(messageLine, cardImageWrap) {
+			line(text, 80, palette.Text, ` font-weight="800"`)
+		}
+	}
+	y += 40
+	for _, text := range wrapWords(g.Subtitle, cardImageWrap+8) {
+		line(text, 44, palette.Text, ` opacity="0.75"`)
+	}
+	if sender != "" {
+		y += 20
+		line("— "+fromWord(g.Locale)+" "+sender, 44, palette.Text, ` font-style="italic" opacity="0.75"`)
+	}
+	facts := g.Facts
+	if g.BirthdayTwins != "" {
+		facts = append(facts[:len(facts):len(facts)], g.BirthdayTwins)
+	}
+	if len(facts) > 0 {
+		y += 30
+		for _, fact := range facts {
+			for _, text := range wrapWords(fact, cardImageWrap+12) {
+				line(text, 36, palette.Text, ` opacity="0.65"`)
+			}
+		}
+	}
+	fmt.Fprintf(&b, `<text x="50%%" y="%d" text-anchor="middle" font-size="40" font-family="%s" fill="%s" font-weight="700">%s</text>`,
+		cardImageHeight-100, cardImageFont, accentColor, escapeXML(siteIdentity().Domain))
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// wrapWords breaks text into lines of at most limit runes, splitting only
+// between words; a longer word gets a line of its own.
+func wrapWords(text string, limit int) []string {
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(text) {
+		if current != "" && len([]rune(current))+1+len([]rune(word)) > limit {
+			lines = append(lines, current)
+			current = ""
+		}
+		if current != "" {
+			current += " "
+		}
+		current += word
+	}
+	if current != "" || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// renderCardImageToFile rasterizes the card SVG at the given width.
+func renderCardImageToFile(svg string, width int, destPath string) error {
+	height := width * cardImageHeight / cardImageWidth
+	return rsvgConvert(svg, destPath, "-w", strconv.Itoa(width), "-h", strconv.Itoa(height))
+}
+
+// cardImageCachePath keys the PNG by the card's content and width.
+func cardImageCachePath(svg string, width int) string {
+	sum := sha256.Sum256([]byte(svg))
+	return filepath.Join(ogCacheDir(), "card", fmt.Sprintf("%s-%d.png", hex.EncodeToString(sum[:8]), width))
+}
+
+// cardImageWidthParam validates ?largura=, the PNG width in pixels.
+func cardImageWidthParam(value string) (int, bool) {
+	if value == "" {
+		return cardImageWidth, true
+	}
+	width, err := strconv.Atoi(value)
+	if err != nil || width < cardImageMinWidth || width > cardImageMaxWidth {
+		return 0, false
+	}
+	return width, true
+}
+
+// handleCardImage serves GET /card.png?path=/aniversario/João&largura=1080,
+// the full card as a 9:16 PNG for Instagram stories, rendered through the
+// OG queue and cached by content.
+func handleCardImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	query := r.URL.Query()
+	rawPath := query.Get("path")
+	if strings.TrimSpace(rawPath) == "" || len(rawPath) > maxPathLen {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	width, ok := cardImageWidthParam(query.Get("largura"))
+	if !ok {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	fullPath := normalizeGreetingPath(rawPath)
+	if status := validateGreetingPath(fullPath); status != http.StatusOK {
+		http.Error(w, "", status)
+		return
+	}
+	pathOnly, rawQuery, _ := strings.Cut(fullPath, "?")
+	cardQuery, _ := url.ParseQuery(rawQuery)
+	opts := greetingPageOptions(pathOnly, cardQuery)
+	g := buildGreeting(pathOnly, opts)
+	theme, ok := lookupTheme(opts.Theme)
+	if !ok {
+		theme, _ = lookupTheme("")
+	}
+	svg := cardImageSVG(g, opts.Sender, theme, g.OgSpec.Accent)
+	cachePath := cardImageCachePath(svg, width)
+	if ok, err := fileExists(cachePath); !ok || err != nil {
+		render := func(_ ogImageSpec, dest string) error { return renderCardImageToFileFunc(svg, width, dest) }
+		if err := ogQueue.renderTo(cachePath, g.OgSpec, render); err != nil {
+			slog.Error("card image render failed", "error", err)
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	name := strings.TrimSuffix(cardPDFFilename(g.Message), ".pdf") + ".png"
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+	writeCacheFile(w, r, cachePath, "image/png")
+}
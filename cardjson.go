@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// wantsJSON reports whether r prefers the greeting as JSON: an Accept with
+// application/json and without text/html, which browsers always send.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// CardJSON is the structured form of a greeting page, served to
+// Accept: application/json.
+type CardJSON struct {
+	Path           string   `json:"path"`
+	Occasion       string   `json:"occasion"`
+	Greeting       string   `json:"greeting"`
+	Emoji          string   `json:"emoji"`
+	Message        string   `json:"message"`
+	DisplayMessage string   `json:"display_message"`
+	Punct          string   `json:"punct"`
+	Title          string   `json:"title"`
+	Subtitle       string   `json:"subtitle"`
+	Description    string   `json:"description"`
+	Sender         string   `json:"sender,omitempty"`
+	Age            int      `json:"age,omitempty"`
+	Facts          []string `json:"facts,omitempty"`
+	BirthdayTwins  string   `json:"birthday_twins,omitempty"`
+	Theme          string   `json:"theme,omitempty"`
+	Accent         string   `json:"accent,omitempty"`
+	Effect         string   `json:"effect,omitempty"`
+	Locale         string   `json:"locale,omitempty"`
+	Views          int      `json:"views"`
+	URLs           CardURLs `json:"urls"`
+}
+
+// CardURLs links the renditions of a greeting.
+type CardURLs struct {
+	Page    string `json:"page"`
+	OgImage string `json:"og_image"`
+	OgVideo string `json:"og_video,omitempty"`
+	Card    string `json:"card_image"`
+	PDF     string `json:"pdf"`
+	Print   string `json:"print"`
+	Lottie  string `json:"lottie"`
+}
+
+func cardJSON(path, rawQuery string, opts pageOptions) CardJSON {
+	g := buildGreeting(path, opts)
+	base := strings.TrimRight(publicBaseURL(), "/")
+	fullPath := path
+	if rawQuery != "" {
+		fullPath += "?" + rawQuery
+	}
+	return CardJSON{
+		Path:           fullPath,
+		Occasion:       g.Occasion.Prefix,
+		Greeting:       g.Greeting,
+		Emoji:          g.Emoji,
+		Message:        g.Message,
+		DisplayMessage: g.DisplayMessage,
+		Punct:          g.Punct,
+		Title:          g.Title,
+		Subtitle:       g.Subtitle,
+		Description:    g.OgDesc,
+		Sender:         opts.Sender,
+		Age:            g.Age,
+		Facts:          g.Facts,
+		BirthdayTwins:  g.BirthdayTwins,
+		Theme:          themeName(opts.Theme),
+		Accent:         g.OgSpec.Accent,
+		Effect:         strings.TrimPrefix(effectClass(opts.Effect), "effect-"),
+		Locale:         g.Locale,
+		Views:          opts.Views,
+		URLs: CardURLs{
+			Page:    base + fullPath,
+			OgImage: g.OgImage,
+			OgVideo: g.OgVideo,
+			Card:    base + "/card.png?path=" + url.QueryEscape(fullPath),
+			PDF:     base + "/pdf" + fullPath,
+			Print:   base + "/print" + fullPath,
+			Lottie:  base + "/api/lottie?path=" + url.QueryEscape(fullPath),
+		},
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// GroupCard is a card many people sign for one recipient. The organizer
+// shares the signing link (with SignToken); the recipient gets the card link.
+type GroupCard struct {
+	ID         string           `json:"id"`
+	Recipient  string           `json:"recipient"`
+	Occasion   string           `json:"occasion"`
+	SignToken  string           `json:"sign_token"`
+	CreatedAt  string           `json:"created_at"`
+	Signatures []GuestbookEntry `json:"signatures"`
+}
+
+type GroupCardRequest struct {
+	Recipient string `json:"recipient"`
+	Occasion  string `json:"occasion"`
+}
+
+type GroupCardResponse struct {
+	ID      string `json:"id"`
+	CardURL string `json:"card_url"`
+	SignURL string `json:"sign_url"`
+}
+
+type SignatureRequest struct {
+	ID      string `json:"id"`
+	Token   string `json:"token"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+type cardStore struct {
+	mu     sync.Mutex
+	loaded bool
+	cards  map[string]*GroupCard
+}
+
+var groupCards = cardStore{
+	cards: map[string]*GroupCard{},
+}
+
+var cardLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: cardRateWindow,
+	max:    cardRateLimit,
+}
+
+var signatureLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: signatureRateWindow,
+	max:    signatureRateLimit,
+}
+
+var (
+	errCardNotFound = fmt.Errorf("card not found")
+	errCardFull     = fmt.Errorf("card full")
+)
+
+func groupCardResponse(card *GroupCard) GroupCardResponse {
+	base := strings.TrimRight(publicBaseURL(), "/")
+	return GroupCardResponse{
+		ID:      card.ID,
+		CardURL: base + "/cartao/" + card.ID,
+		SignURL: base + "/cartao/" + card.ID + "/assinar?t=" + card.SignToken,
+	}
+}
+
+// handleCardCreate creates a group card: POST /api/cards
+// {"recipient":"João","occasion":"aniversario"}
+func handleCardCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if !cardLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	body, err := readLimitedBody(r, maxGuestbookBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+	var req GroupCardRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+	recipient, err := parseName(req.Recipient)
+	if err == errNameBlocked {
+		writeAPIError(w, http.StatusForbidden, "blocked_name")
+		return
+	}
+	occasion := strings.ToLower(strings.TrimSpace(req.Occasion))
+	if _, ok := lookupOccasion(occasion); occasion != "" && !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_occasion")
+		return
+	}
+	if err != nil || recipient == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_name")
+		return
+	}
+
+	card, err := createGroupCard(recipient, occasion)
+	if err != nil {
+		slog.Error("card create failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	recordOwnership(sessionEmail(r), "", card.ID)
+	writeJSON(w, http.StatusCreated, groupCardResponse(card))
+}
+
+// handleCardSign adds a signature: POST /api/cards/sign
+// {"id":"…","token":"…","name":"Maria","message":"Felicidades!"}
+func handleCardSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if !signatureLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	body, err := readLimitedBody(r, maxGuestbookBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+	var req SignatureRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+	name := strings.Join(strings.Fields(req.Name), " ")
+	message := strings.Join(strings.Fields(req.Message), " ")
+	if name == "" || message == "" ||
+		utf8.RuneCountInString(name) > maxNameLen ||
+		utf8.RuneCountInString(message) > maxGuestbookMessageLen {
+		writeAPIError(w, http.StatusBadRequest, "invalid_field")
+		return
+	}
+	if isBlockedMessage(name) || isBlockedMessage(message) ||
+		looksLikePath(name) || looksLikePath(message) {
+		writeAPIError(w, http.StatusForbidden, "blocked_message")
+		return
+	}
+
+	entry := GuestbookEntry{
+		Name:      name,
+		Message:   message,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := signGroupCard(req.ID, req.Token, entry); err != nil {
+		switch err {
+		case errCardNotFound:
+			writeAPIError(w, http.StatusNotFound, "not_found")
+		case errCardFull:
+			writeAPIError(w, http.StatusConflict, "limit_reached")
+		default:
+			slog.Error("card sign failed", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		}
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+func createGroupCard(recipient, occasion string) (*GroupCard, error) {
+	if err := ensureCardsLoaded(); err != nil {
+		return nil, err
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	groupCards.mu.Lock()
+	defer groupCards.mu.Unlock()
+	var id string
+	for {
+		raw, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		if id = raw[:cardIDLen]; groupCards.cards[id] == nil {
+			break
+		}
+	}
+	card := &GroupCard{
+		ID:         id,
+		Recipient:  recipient,
+		Occasion:   occasion,
+		SignToken:  token,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		Signatures: []GuestbookEntry{},
+	}
+	groupCards.cards[id] = card
+	if err := persistCardsLocked(); err != nil {
+		delete(groupCards.cards, id)
+		return nil, err
+	}
+	return card, nil
+}
+
+// signGroupCard appends a signature when token matches the card's signing
+// token; a wrong token looks like a missing card.
+func signGroupCard(id, token string, entry GuestbookEntry) error {
+	if err := ensureCardsLoaded(); err != nil {
+		return err
+	}
+	groupCards.mu.Lock()
+	defer groupCards.mu.Unlock()
+	card := groupCards.cards[id]
+	if card == nil || subtle.ConstantTimeCompare([]byte(card.SignToken), []byte(token)) != 1 {
+		return errCardNotFound
+	}
+	if len(card.Signatures) >= maxCardSignatures {
+		return errCardFull
+	}
+	card.Signatures = append(card.Signatures, entry)
+	if err := persistCardsLocked(); err != nil {
+		card.Signatures = card.Signatures[:len(card.Signatures)-1]
+		return err
+	}
+	return nil
+}
+
+// groupCard returns a copy of the card with the given ID.
+func groupCard(id string) (GroupCard, bool, error) {
+	if err := ensureCardsLoaded(); err != nil {
+		return GroupCard{}, false, err
+	}
+	groupCards.mu.Lock()
+	defer groupCards.mu.Unlock()
+	card := groupCards.cards[id]
+	if card == nil {
+		return GroupCard{}, false, nil
+	}
+	copied := *card
+	copied.Signatures = append([]GuestbookEntry(nil), card.Signatures...)
+	return copied, true, nil
+}
+
+func ensureCardsLoaded() error {
+	groupCards.mu.Lock()
+	defer groupCards.mu.Unlock()
+	if groupCards.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(cardsDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			groupCards.loaded = true
+			return nil
+		}
+		return err
+	}
+	cards := map[string]*GroupCard{}
+	if err := json.Unmarshal(data, &cards); err != nil {
+		return err
+	}
+	groupCards.cards = cards
+	groupCards.loaded = true
+	return nil
+}
+
+func persistCardsLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := cardsDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(groupCards.cards, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func cardsDBPath() string {
+	if value := os.Getenv("CARDS_DB"); value != "" {
+		return value
+	}
+	return "data/cards.json"
+}
+
+// GroupCardPageData is the data model of public/card.html, which has three
+// modes: the organizer's form (no Card), the signing page and the card.
+type GroupCardPageData struct {
+	Title     string
+	OgDesc    string
+	OgURL     string
+	OgImage   string
+	Card      *GroupCard
+	Greeting  string
+	Emoji     string
+	SignToken string
+	Occasions []Occasion
+	Site      SiteIdentity
+}
+
+// handleCardPage serves /cartao (new card form), /cartao/{id} (the card for
+// the recipient) and /cartao/{id}/assinar?t=… (the signing page).
+func handleCardPage(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	site := siteIdentity()
+	base := strings.TrimRight(publicBaseURL(), "/")
+	data := GroupCardPageData{
+		Title:     "Cartão coletivo - " + site.Name,
+		OgDesc:    "Crie um cartão e reúna as mensagens de todo mundo em um só lugar.",
+		OgURL:     base + "/cartao",
+		OgImage:   ogImageURL(base, ogImageSpec{}),
+		Occasions: allOccasions(),
+		Site:      site,
+	}
+
+	if rest = strings.Trim(rest, "/"); rest != "" {
+		id, action, _ := strings.Cut(rest, "/")
+		card, ok, err := groupCard(id)
+		if err != nil {
+			slog.Error("cards load failed", "error", err)
+			writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível montar esta página."))
+			return
+		}
+		if !ok || (action != "" && action != "assinar") {
+			writeHTML(w, r, http.StatusNotFound, errorPage("Cartão não encontrado."))
+			return
+		}
+		if action == "assinar" {
+			token := r.URL.Query().Get("t")
+			if subtle.ConstantTimeCompare([]byte(card.SignToken), []byte(token)) != 1 {
+				writeHTML(w, r, http.StatusNotFound, errorPage("Cartão não encontrado."))
+				return
+			}
+			data.SignToken = token
+		}
+		occasion := generalOccasion()
+		if occ, ok := lookupOccasion(card.Occasion); ok && card.Occasion != "" {
+			occasion = occ
+		}
+		spec := ogImageSpec{Text: occasion.Greeting + ", " + card.Recipient}
+		if occasion.OgTemplate != "" {
+			spec.Occasion = occasion.Prefix
+		}
+		data.Card = &card
+		data.Greeting = occasion.Greeting
+		data.Emoji = occasion.Emoji
+		data.Title = fmt.Sprintf("%s, %s!", occasion.Greeting, card.Recipient)
+		data.OgDesc = fmt.Sprintf("Cartão coletivo com %d %s %s", len(card.Signatures), pluralPT(len(card.Signatures), "mensagem", "mensagens"), occasion.Emoji)
+		data.OgURL = base + "/cartao/" + url.PathEscape(card.ID)
+		data.OgImage = ogImageURL(base, spec)
+	}
+
+	var b strings.Builder
+	if err := cardTemplate.Execute(&b, data); err != nil {
+		slog.Error("card render failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	// Signatures arrive at any time, and signing links must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+	writeHTML(w, r, http.StatusOK, b.String())
+}
+
+func pluralPT(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
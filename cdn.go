@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Cache policies by resource class. Browsers keep max-age; a CDN in front
+// keeps s-maxage, much longer since it can be purged, and serves a stale
+// copy for stale-while-revalidate while it refetches in the background.
+const (
+	// Greeting and occasion pages
+	cachePages = "public, max-age=300, s-maxage=86400, stale-while-revalidate=86400"
+	// OG images and videos, card images and PDFs, rendered once per key
+	cacheOgImages = "public, max-age=86400, s-maxage=604800, stale-while-revalidate=604800"
+	// Embedded CSS and JavaScript, which change with each deploy
+	cacheStaticAssets = "public, max-age=300, s-maxage=86400, stale-while-revalidate=604800"
+	// Embedded images and sounds
+	cacheStaticMedia = "public, max-age=86400, s-maxage=604800, stale-while-revalidate=604800"
+	// Shortlink redirects; a code always points to the same path
+	cacheRedirects = "public, max-age=3600, s-maxage=604800, stale-while-revalidate=86400"
+	// An expired shortlink stays expired until it is purged
+	cacheExpiredShortlinks = "public, max-age=3600"
+)
+
+// Surrogate keys tag cached responses so a CDN can purge them together:
+// by class ("pages", "og", "static", "shortlinks"), by greeting, with
+// greetingSurrogateKey on the page and on the shortlinks leading to it, or
+// by uploaded photo, with photoSurrogateKey.
+// They are sent as Surrogate-Key (Fastly and others, space separated) and
+// Cache-Tag (Cloudflare, comma separated).
+
+// setCacheHeaders sets the Cache-Control policy and surrogate keys of a
+// response.
+func setCacheHeaders(w http.ResponseWriter, policy string, keys ...string) {
+	w.Header().Set("Cache-Control", policy)
+	if len(keys) > 0 {
+		w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+		w.Header().Set("Cache-Tag", strings.Join(keys, ","))
+	}
+}
+
+// greetingSurrogateKey names the greeting at path whatever its query,
+// language prefix or percent-encoding, so one purge covers every variant.
+func greetingSurrogateKey(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	_, path = splitLocalePath(path)
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	return "greeting-" + shortHash("/"+strings.Trim(path, "/"))
+}
+
+// photoSurrogateKey names an uploaded photo.
+func photoSurrogateKey(id string) string {
+	return "photo-" + id
+}
+
+// ogSurrogateKey names the OG image and video of an ogImageSpec cache key.
+func ogSurrogateKey(cacheKey string) string {
+	return "og-" + shortHash(cacheKey)
+}
+
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeHTMLConditional writes a rendered page with an ETag hashing its
+// content, or a bodyless 304 when If-None-Match already has that ETag, so
+// repeat visits and crawler re-checks cost a render but no transfer.
+func writeHTMLConditional(w http.ResponseWriter, r *http.Request, body string) {
+	etag := `"` + shortHash(body) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeHTML(w, r, http.StatusOK, body)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using
+// the weak comparison the header calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,696 @@
+// Code generated by gen.go from openapi.json; DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type AccountStat struct {
+	Code        string     `json:"code"`
+	Destination string     `json:"destination"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Path        string     `json:"path"`
+	ShortURL    string     `json:"short_url"`
+	StatsToken  string     `json:"stats_token,omitempty"`
+	Views       int        `json:"views"`
+}
+
+type AdminShortlink struct {
+	Clicks           int        `json:"clicks"`
+	Code             string     `json:"code"`
+	CreatedAt        *time.Time `json:"created_at,omitempty"`
+	CreatorID        string     `json:"creator_id,omitempty"`
+	CreatorIPHash    string     `json:"creator_ip_hash,omitempty"`
+	CreatorUserAgent string     `json:"creator_user_agent,omitempty"`
+	Destination      string     `json:"destination"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	Path             string     `json:"path"`
+}
+
+type AdminShortlinksResponse struct {
+	Page       int              `json:"page"`
+	PerPage    int              `json:"per_page"`
+	Shortlinks []AdminShortlink `json:"shortlinks"`
+	Total      int              `json:"total"`
+}
+
+type AuditEntry struct {
+	Action string          `json:"action"`
+	Actor  string          `json:"actor"`
+	After  json.RawMessage `json:"after,omitempty"`
+	At     time.Time       `json:"at"`
+	Before json.RawMessage `json:"before,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	IP     string          `json:"ip,omitempty"`
+	Target string          `json:"target,omitempty"`
+}
+
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+type DayUsage struct {
+	Date     string `json:"date"`
+	Requests int    `json:"requests"`
+}
+
+type DiskStatus struct {
+	Error     string `json:"error,omitempty"`
+	FreeBytes int    `json:"free_bytes,omitempty"`
+	Healthy   bool   `json:"healthy"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Writable  bool   `json:"writable"`
+}
+
+type ExperimentReport struct {
+	Name     string          `json:"name"`
+	Variants []VariantReport `json:"variants"`
+}
+
+type FamousBirthday struct {
+	Date        string `json:"date"`
+	Description string `json:"description"`
+	Name        string `json:"name"`
+}
+
+type GroupCardRequest struct {
+	Occasion  string `json:"occasion"`
+	Recipient string `json:"recipient"`
+}
+
+type GroupCardResponse struct {
+	CardURL string `json:"card_url"`
+	ID      string `json:"id"`
+	SignURL string `json:"sign_url"`
+}
+
+type GuestbookEntry struct {
+	CreatedAt string `json:"created_at"`
+	Message   string `json:"message"`
+	Name      string `json:"name"`
+}
+
+type GuestbookRequest struct {
+	Message string `json:"message"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+}
+
+type OccasionInfo struct {
+	Animation   string `json:"animation"`
+	ComposerURL string `json:"composer_url"`
+	Emoji       string `json:"emoji"`
+	ExampleURL  string `json:"example_url"`
+	Greeting    string `json:"greeting"`
+	Prefix      string `json:"prefix"`
+	Subtitle    string `json:"subtitle"`
+}
+
+type PhotoResponse struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type PowChallenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+}
+
+type PowRequiredResponse struct {
+	Challenge  string   `json:"challenge"`
+	Difficulty int      `json:"difficulty"`
+	Error      APIError `json:"error"`
+}
+
+type PreviewResponse struct {
+	Blocked       bool   `json:"blocked"`
+	Emoji         string `json:"emoji,omitempty"`
+	Greeting      string `json:"greeting,omitempty"`
+	Message       string `json:"message,omitempty"`
+	Occasion      string `json:"occasion"`
+	OgDescription string `json:"og_description,omitempty"`
+	OgImage       string `json:"og_image,omitempty"`
+	Path          string `json:"path"`
+	Punct         string `json:"punct"`
+	Title         string `json:"title,omitempty"`
+	Views         int    `json:"views"`
+}
+
+type ProtectedRequest struct {
+	Passphrase string `json:"passphrase"`
+	Path       string `json:"path"`
+}
+
+type ProtectedResponse struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type PurgeRequest struct {
+	OgKey string `json:"og_key,omitempty"`
+	Path  string `json:"path,omitempty"`
+}
+
+type PurgeResponse struct {
+	CDNError      string   `json:"cdn_error,omitempty"`
+	CDNPurged     bool     `json:"cdn_purged"`
+	Files         []string `json:"files"`
+	SurrogateKeys []string `json:"surrogate_keys"`
+}
+
+type Readiness struct {
+	Disks           []DiskStatus   `json:"disks,omitempty"`
+	OgRenderCircuit string         `json:"og_render_circuit"`
+	ReadOnly        bool           `json:"read_only,omitempty"`
+	Renderer        RendererStatus `json:"renderer"`
+	Status          string         `json:"status"`
+}
+
+type ReminderRequest struct {
+	Date  string `json:"date"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type RendererStatus struct {
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+}
+
+type SendRequest struct {
+	Email  string `json:"email"`
+	Path   string `json:"path"`
+	Sender string `json:"sender"`
+}
+
+type SendResponse struct {
+	Status string `json:"status"`
+}
+
+type ShareRequest struct {
+	Path string `json:"path"`
+}
+
+type ShareResponse struct {
+	ShortURL string `json:"short_url"`
+	Telegram string `json:"telegram"`
+	Text     string `json:"text"`
+	Whatsapp string `json:"whatsapp"`
+}
+
+type ShortLinkRequest struct {
+	CreatorID string `json:"creator_id,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+	Path      string `json:"path"`
+	Website   string `json:"website"`
+}
+
+type ShortLinkResponse struct {
+	Code        string     `json:"code"`
+	Destination string     `json:"destination"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Path        string     `json:"path"`
+	ShortURL    string     `json:"short_url"`
+	StatsToken  string     `json:"stats_token,omitempty"`
+}
+
+type ShortlinkPreview struct {
+	Code        string     `json:"code"`
+	Destination string     `json:"destination"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	OgImage     string     `json:"og_image"`
+	Path        string     `json:"path"`
+	Title       string     `json:"title"`
+}
+
+type ShortlinkStats struct {
+	Clicks         int            `json:"clicks"`
+	Code           string         `json:"code"`
+	LastAccessedAt *time.Time     `json:"last_accessed_at,omitempty"`
+	Referrers      map[string]int `json:"referrers"`
+}
+
+type SignatureRequest struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Name    string `json:"name"`
+	Token   string `json:"token"`
+}
+
+type SlackResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+	UnfurlLinks  bool   `json:"unfurl_links,omitempty"`
+}
+
+type SpamStats struct {
+	Challenged int   `json:"challenged"`
+	Deferred   int   `json:"deferred"`
+	Queued     int   `json:"queued"`
+	Scores     []int `json:"scores"`
+}
+
+type Theme struct {
+	Class   string       `json:"class"`
+	Label   string       `json:"label"`
+	Name    string       `json:"name"`
+	Palette ThemePalette `json:"palette"`
+}
+
+type ThemePalette struct {
+	Accent     string `json:"accent"`
+	Background string `json:"background"`
+	Text       string `json:"text"`
+}
+
+type TokenUsage struct {
+	DailyQuota int        `json:"daily_quota"`
+	Days       []DayUsage `json:"days"`
+	Name       string     `json:"name"`
+	Remaining  int        `json:"remaining"`
+	Scopes     []string   `json:"scopes"`
+	UsedToday  int        `json:"used_today"`
+}
+
+type TrackEvent struct {
+	AcceptLanguage string          `json:"accept_language,omitempty"`
+	Event          string          `json:"event,omitempty"`
+	Path           string          `json:"path,omitempty"`
+	Query          string          `json:"query,omitempty"`
+	Referrer       string          `json:"referrer,omitempty"`
+	Screen         json.RawMessage `json:"screen,omitempty"`
+	Timestamp      string          `json:"timestamp,omitempty"`
+	Timezone       string          `json:"timezone,omitempty"`
+	UserAgent      string          `json:"user_agent,omitempty"`
+	Viewport       json.RawMessage `json:"viewport,omitempty"`
+}
+
+type VariantReport struct {
+	ConversionRate float64 `json:"conversion_rate"`
+	Conversions    int     `json:"conversions"`
+	Exposures      int     `json:"exposures"`
+	Name           string  `json:"name"`
+}
+
+type VersionInfo struct {
+	Go       string         `json:"go"`
+	Renderer RendererStatus `json:"renderer"`
+	Version  string         `json:"version"`
+}
+
+type WebhookDelivery struct {
+	Attempts    int        `json:"attempts"`
+	Code        string     `json:"code"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	Event       string     `json:"event"`
+	ID          string     `json:"id"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastStatus  int        `json:"last_status,omitempty"`
+	Status      string     `json:"status"`
+	URL         string     `json:"url"`
+}
+
+type WebhookStatus struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Signed     bool              `json:"signed"`
+	URLs       []string          `json:"urls"`
+}
+
+// ListShortlinksParams are the query parameters of ListShortlinks.
+type ListShortlinksParams struct {
+	Page int
+	Q    string
+	IP   string
+}
+
+// ListShortlinks calls GET /admin/shortlinks: Shortlinks, newest first, 50 a page.
+func (c *Client) ListShortlinks(ctx context.Context, params ListShortlinksParams) (*AdminShortlinksResponse, error) {
+	var out AdminShortlinksResponse
+	query := url.Values{}
+	if params.Page != 0 {
+		query.Set("page", strconv.Itoa(params.Page))
+	}
+	if params.Q != "" {
+		query.Set("q", params.Q)
+	}
+	if params.IP != "" {
+		query.Set("ip", params.IP)
+	}
+	err := c.doJSON(ctx, "GET", "/admin/shortlinks", query, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAuditLogParams are the query parameters of ListAuditLog.
+type ListAuditLogParams struct {
+	Action string
+	Since  string
+	Limit  int
+}
+
+// ListAuditLog calls GET /api/audit: Privileged operations, newest first.
+func (c *Client) ListAuditLog(ctx context.Context, params ListAuditLogParams) (*AuditResponse, error) {
+	var out AuditResponse
+	query := url.Values{}
+	if params.Action != "" {
+		query.Set("action", params.Action)
+	}
+	if params.Since != "" {
+		query.Set("since", params.Since)
+	}
+	if params.Limit != 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	err := c.doJSON(ctx, "GET", "/api/audit", query, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListFamousBirthdays calls GET /api/birthdays/{date}: Famous people born on a day.
+func (c *Client) ListFamousBirthdays(ctx context.Context, date string) ([]FamousBirthday, error) {
+	var out []FamousBirthday
+	err := c.doJSON(ctx, "GET", "/api/birthdays/"+url.PathEscape(date), nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// PurgeCache calls POST /api/cache/purge: Purge the cached files of a greeting or OG key.
+func (c *Client) PurgeCache(ctx context.Context, body PurgeRequest) (*PurgeResponse, error) {
+	var out PurgeResponse
+	err := c.doJSON(ctx, "POST", "/api/cache/purge", nil, body, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateGroupCard calls POST /api/cards: Create a group card.
+func (c *Client) CreateGroupCard(ctx context.Context, body GroupCardRequest) (*GroupCardResponse, error) {
+	var out GroupCardResponse
+	err := c.doJSON(ctx, "POST", "/api/cards", nil, body, []int{201}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SignGroupCard calls POST /api/cards/sign: Sign a group card.
+func (c *Client) SignGroupCard(ctx context.Context, body SignatureRequest) (*GuestbookEntry, error) {
+	var out GuestbookEntry
+	err := c.doJSON(ctx, "POST", "/api/cards/sign", nil, body, []int{201}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetCSRFToken calls GET /api/csrf: CSRF token for the browser forms, also set as a cookie.
+func (c *Client) GetCSRFToken(ctx context.Context) (map[string]string, error) {
+	var out map[string]string
+	err := c.doJSON(ctx, "GET", "/api/csrf", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// GetFormToken calls GET /api/form-token: Token timing the composer form.
+func (c *Client) GetFormToken(ctx context.Context) (map[string]string, error) {
+	var out map[string]string
+	err := c.doJSON(ctx, "GET", "/api/form-token", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// ListGuestbookParams are the query parameters of ListGuestbook.
+type ListGuestbookParams struct {
+	Path string
+}
+
+// ListGuestbook calls GET /api/guestbook: Guestbook entries of a greeting.
+func (c *Client) ListGuestbook(ctx context.Context, params ListGuestbookParams) ([]GuestbookEntry, error) {
+	var out []GuestbookEntry
+	query := url.Values{}
+	if params.Path != "" {
+		query.Set("path", params.Path)
+	}
+	err := c.doJSON(ctx, "GET", "/api/guestbook", query, nil, []int{200}, &out)
+	return out, err
+}
+
+// SignGuestbook calls POST /api/guestbook: Sign the guestbook of a greeting.
+func (c *Client) SignGuestbook(ctx context.Context, body GuestbookRequest) (*GuestbookEntry, error) {
+	var out GuestbookEntry
+	err := c.doJSON(ctx, "POST", "/api/guestbook", nil, body, []int{201}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetLottieParams are the query parameters of GetLottie.
+type GetLottieParams struct {
+	Path string
+}
+
+// GetLottie calls GET /api/lottie: Lottie animation of a greeting.
+func (c *Client) GetLottie(ctx context.Context, params GetLottieParams) (map[string]any, error) {
+	var out map[string]any
+	query := url.Values{}
+	if params.Path != "" {
+		query.Set("path", params.Path)
+	}
+	err := c.doJSON(ctx, "GET", "/api/lottie", query, nil, []int{200}, &out)
+	return out, err
+}
+
+// ListOccasions calls GET /api/occasions: Occasions.
+func (c *Client) ListOccasions(ctx context.Context) ([]OccasionInfo, error) {
+	var out []OccasionInfo
+	err := c.doJSON(ctx, "GET", "/api/occasions", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// GetOpenAPI calls GET /api/openapi.json: This document.
+func (c *Client) GetOpenAPI(ctx context.Context) (map[string]any, error) {
+	var out map[string]any
+	err := c.doJSON(ctx, "GET", "/api/openapi.json", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// UploadPhoto calls POST /api/photos: Upload a photo for a greeting.
+func (c *Client) UploadPhoto(ctx context.Context, body io.Reader, contentType string) (*PhotoResponse, error) {
+	var out PhotoResponse
+	err := c.do(ctx, "POST", "/api/photos", nil, body, contentType, []int{201}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetPowChallenge calls GET /api/pow: Proof-of-work challenge.
+func (c *Client) GetPowChallenge(ctx context.Context) (*PowChallenge, error) {
+	var out PowChallenge
+	err := c.doJSON(ctx, "GET", "/api/pow", nil, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetPreviewParams are the query parameters of GetPreview.
+type GetPreviewParams struct {
+	Path string
+}
+
+// GetPreview calls GET /api/preview: Metadata of a greeting page.
+func (c *Client) GetPreview(ctx context.Context, params GetPreviewParams) (*PreviewResponse, error) {
+	var out PreviewResponse
+	query := url.Values{}
+	if params.Path != "" {
+		query.Set("path", params.Path)
+	}
+	err := c.doJSON(ctx, "GET", "/api/preview", query, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateProtectedGreeting calls POST /api/protected: Create a passphrase-protected greeting.
+func (c *Client) CreateProtectedGreeting(ctx context.Context, body ProtectedRequest) (*ProtectedResponse, error) {
+	var out ProtectedResponse
+	err := c.doJSON(ctx, "POST", "/api/protected", nil, body, []int{201}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateReminder calls POST /api/reminders: Subscribe to a yearly birthday reminder.
+func (c *Client) CreateReminder(ctx context.Context, body ReminderRequest) (*SendResponse, error) {
+	var out SendResponse
+	err := c.doJSON(ctx, "POST", "/api/reminders", nil, body, []int{201, 202}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SendCard calls POST /api/send: E-mail a greeting, after the recipient opts in.
+func (c *Client) SendCard(ctx context.Context, body SendRequest) (*SendResponse, error) {
+	var out SendResponse
+	err := c.doJSON(ctx, "POST", "/api/send", nil, body, []int{202}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Share calls POST /api/share: Shortlink and share links of a greeting.
+func (c *Client) Share(ctx context.Context, body ShareRequest) (*ShareResponse, error) {
+	var out ShareResponse
+	err := c.doJSON(ctx, "POST", "/api/share", nil, body, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAccountStats calls GET /api/stats: Views of the account's shortlinks.
+func (c *Client) GetAccountStats(ctx context.Context) ([]AccountStat, error) {
+	var out []AccountStat
+	err := c.doJSON(ctx, "GET", "/api/stats", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// GetExperimentStats calls GET /api/stats/experiments: Exposures and conversions of the experiments.
+func (c *Client) GetExperimentStats(ctx context.Context) ([]ExperimentReport, error) {
+	var out []ExperimentReport
+	err := c.doJSON(ctx, "GET", "/api/stats/experiments", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// GetSpamStats calls GET /api/stats/spam: Spam score histogram.
+func (c *Client) GetSpamStats(ctx context.Context) (*SpamStats, error) {
+	var out SpamStats
+	err := c.doJSON(ctx, "GET", "/api/stats/spam", nil, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SuggestNamesParams are the query parameters of SuggestNames.
+type SuggestNamesParams struct {
+	Q string
+}
+
+// SuggestNames calls GET /api/suggest: Popular names starting with q.
+func (c *Client) SuggestNames(ctx context.Context, params SuggestNamesParams) ([]string, error) {
+	var out []string
+	query := url.Values{}
+	if params.Q != "" {
+		query.Set("q", params.Q)
+	}
+	err := c.doJSON(ctx, "GET", "/api/suggest", query, nil, []int{200}, &out)
+	return out, err
+}
+
+// ListThemes calls GET /api/themes: Themes.
+func (c *Client) ListThemes(ctx context.Context) ([]Theme, error) {
+	var out []Theme
+	err := c.doJSON(ctx, "GET", "/api/themes", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// Track calls POST /api/track: Record a page event.
+func (c *Client) Track(ctx context.Context, body TrackEvent) error {
+	err := c.doJSON(ctx, "POST", "/api/track", nil, body, []int{204}, nil)
+	return err
+}
+
+// GetAPIUsage calls GET /api/usage: Quota and usage of the API tokens.
+func (c *Client) GetAPIUsage(ctx context.Context) ([]TokenUsage, error) {
+	var out []TokenUsage
+	err := c.doJSON(ctx, "GET", "/api/usage", nil, nil, []int{200}, &out)
+	return out, err
+}
+
+// GetWebhookStatus calls GET /api/webhooks: Webhook URLs and recent deliveries.
+func (c *Client) GetWebhookStatus(ctx context.Context) (*WebhookStatus, error) {
+	var out WebhookStatus
+	err := c.doJSON(ctx, "GET", "/api/webhooks", nil, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetReadiness calls GET /readyz: Readiness and degraded dependencies.
+func (c *Client) GetReadiness(ctx context.Context) (*Readiness, error) {
+	var out Readiness
+	err := c.doJSON(ctx, "GET", "/readyz", nil, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateShortlink calls POST /s: Create or reuse the shortlink of a greeting.
+func (c *Client) CreateShortlink(ctx context.Context, body ShortLinkRequest) (*ShortLinkResponse, error) {
+	var out ShortLinkResponse
+	err := c.doJSON(ctx, "POST", "/s", nil, body, []int{200, 201}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetShortlinkPreview calls GET /s/{code}.json: Destination, title and OG image of a shortlink, without redirecting.
+func (c *Client) GetShortlinkPreview(ctx context.Context, code string) (*ShortlinkPreview, error) {
+	var out ShortlinkPreview
+	err := c.doJSON(ctx, "GET", "/s/"+url.PathEscape(code)+".json", nil, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetShortlinkStats calls GET /s/{code}/stats: Redirects of a shortlink, for its creator.
+func (c *Client) GetShortlinkStats(ctx context.Context, code string) (*ShortlinkStats, error) {
+	var out ShortlinkStats
+	err := c.doJSON(ctx, "GET", "/s/"+url.PathEscape(code)+"/stats", nil, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetVersion calls GET /version: Build and renderer versions.
+func (c *Client) GetVersion(ctx context.Context) (*VersionInfo, error) {
+	var out VersionInfo
+	err := c.doJSON(ctx, "GET", "/version", nil, nil, []int{200}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
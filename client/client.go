@@ -0,0 +1,110 @@
+// Package client is a Go client of the parabens.vc HTTP API. The types and
+// one method per operation, in api.go, are generated from the OpenAPI
+// document the server publishes at /api/openapi.json:
+//
+//	c := client.New("https://parabens.vc", os.Getenv("PARABENS_TOKEN"))
+//	link, err := c.CreateShortlink(ctx, client.ShortLinkRequest{Path: "/aniversario/Ana"})
+//
+// Errors answered by the API are *Error values carrying its stable code.
+package client
+
+//go:generate go run .. openapi openapi.json
+//go:generate go run gen.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Client calls the API at BaseURL, authenticating with Token (an API token,
+// or the admin token for admin operations) when set.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client // http.DefaultClient when nil
+}
+
+// New returns a client of the API at baseURL.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token}
+}
+
+// Error is an answer other than the documented successes: an API error,
+// with its code and message, or an unexpected status, with its body.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Body       []byte
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("parabens: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("parabens: unexpected status %d", e.StatusCode)
+}
+
+// doJSON sends in, when not nil, as the JSON body.
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in any, statuses []int, out any) error {
+	if in == nil {
+		return c.do(ctx, method, path, query, nil, "", statuses, out)
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, query, bytes.NewReader(data), "application/json", statuses, out)
+}
+
+// do sends the request and decodes the answer into out when its status is
+// one of statuses.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string, statuses []int, out any) error {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(statuses, resp.StatusCode) {
+		apiErr := &Error{StatusCode: resp.StatusCode, Body: data}
+		var envelope APIErrorResponse
+		if json.Unmarshal(data, &envelope) == nil {
+			apiErr.Code, apiErr.Message = envelope.Error.Code, envelope.Error.Message
+		}
+		return apiErr
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
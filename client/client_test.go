@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got, gotBody = r, string(body)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/s":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ShortLinkResponse{Code: "abc1234", Path: "aniversario/Ana"})
+		case "/api/audit":
+			json.NewEncoder(w).Encode(AuditResponse{Entries: []AuditEntry{{Action: "cache.purge"}}})
+		case "/api/birthdays/03-14":
+			json.NewEncoder(w).Encode([]FamousBirthday{{Name: "Einstein"}})
+		case "/api/track":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(APIErrorResponse{Error: APIError{Code: "rate_limited", Message: "Muitas tentativas."}})
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL+"/", "tok")
+	ctx := context.Background()
+
+	link, err := c.CreateShortlink(ctx, ShortLinkRequest{Path: "/aniversario/Ana"})
+	if err != nil || link.Code != "abc1234" {
+		t.Fatalf("CreateShortlink = %+v, %v", link, err)
+	}
+	if got.Method != http.MethodPost || got.Header.Get("Authorization") != "Bearer tok" ||
+		got.Header.Get("Content-Type") != "application/json" || !strings.Contains(gotBody, `"path":"/aniversario/Ana"`) {
+		t.Errorf("request = %s %s %v %q", got.Method, got.URL, got.Header, gotBody)
+	}
+
+	audit, err := c.ListAuditLog(ctx, ListAuditLogParams{Action: "cache.purge", Limit: 5})
+	if err != nil || len(audit.Entries) != 1 || got.URL.RawQuery != "action=cache.purge&limit=5" {
+		t.Errorf("ListAuditLog = %+v, %v, query %q", audit, err, got.URL.RawQuery)
+	}
+	famous, err := c.ListFamousBirthdays(ctx, "03-14")
+	if err != nil || len(famous) != 1 {
+		t.Errorf("ListFamousBirthdays = %+v, %v", famous, err)
+	}
+	if err := c.Track(ctx, TrackEvent{Event: "page_view", Path: "/Ana"}); err != nil {
+		t.Errorf("Track = %v", err)
+	}
+
+	_, err = c.GetSpamStats(ctx)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Code != "rate_limited" {
+		t.Errorf("GetSpamStats error = %v", err)
+	}
+}
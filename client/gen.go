@@ -0,0 +1,277 @@
+//go:build ignore
+
+// gen writes api.go from openapi.json: a struct per schema and a method per
+// operation answering JSON. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+}
+
+type content map[string]struct {
+	Schema *schema `json:"schema"`
+}
+
+type operation struct {
+	OperationID string `json:"operationId"`
+	Summary     string `json:"summary"`
+	Parameters  []struct {
+		Name   string  `json:"name"`
+		In     string  `json:"in"`
+		Schema *schema `json:"schema"`
+	} `json:"parameters"`
+	RequestBody *struct {
+		Content content `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content content `json:"content"`
+	} `json:"responses"`
+}
+
+type spec struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+var initialisms = map[string]string{"id": "ID", "url": "URL", "urls": "URLs", "ip": "IP", "api": "API", "cdn": "CDN", "csrf": "CSRF", "json": "JSON"}
+
+// goName turns a snake_case or camelCase name into an exported Go name.
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' }) {
+		if initialism, ok := initialisms[strings.ToLower(part)]; ok {
+			b.WriteString(initialism)
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func goType(s *schema) string {
+	switch {
+	case s == nil:
+		return "json.RawMessage"
+	case s.Ref != "":
+		return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	}
+	switch s.Type {
+	case "string":
+		if s.Format == "date-time" {
+			return "time.Time"
+		}
+		return "string"
+	case "integer":
+		return "int"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + goType(s.Items)
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "map[string]" + goType(s.AdditionalProperties)
+		}
+		return "map[string]any"
+	}
+	return "json.RawMessage"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeTypes(b *bytes.Buffer, schemas map[string]*schema) {
+	for _, name := range sortedKeys(schemas) {
+		s := schemas[name]
+		required := map[string]bool{}
+		for _, field := range s.Required {
+			required[field] = true
+		}
+		fmt.Fprintf(b, "type %s struct {\n", name)
+		for _, field := range sortedKeys(s.Properties) {
+			typ, tag := goType(s.Properties[field]), field
+			if !required[field] {
+				tag += ",omitempty"
+				if typ == "time.Time" || schemas[typ] != nil {
+					typ = "*" + typ
+				}
+			}
+			fmt.Fprintf(b, "\t%s %s `json:%q`\n", goName(field), typ, tag)
+		}
+		b.WriteString("}\n\n")
+	}
+}
+
+// jsonResult returns the schema of the JSON success responses and their
+// statuses, with ok false when a success is not JSON or there are none.
+func jsonResult(op operation) (result *schema, statuses []string, ok bool) {
+	for _, status := range sortedKeys(op.Responses) {
+		if status[0] != '2' {
+			continue
+		}
+		body := op.Responses[status].Content
+		if len(body) == 0 {
+			statuses = append(statuses, status)
+			continue
+		}
+		media, isJSON := body["application/json"]
+		if !isJSON {
+			return nil, nil, false
+		}
+		if result == nil {
+			result = media.Schema
+		} else if goType(result) != goType(media.Schema) {
+			continue // an alternative answer, returned as an *Error
+		}
+		statuses = append(statuses, status)
+	}
+	return result, statuses, len(statuses) > 0
+}
+
+func writeOperation(b *bytes.Buffer, method, path string, op operation) {
+	result, statuses, ok := jsonResult(op)
+	if !ok {
+		return
+	}
+	name := goName(op.OperationID)
+	args := []string{"ctx context.Context"}
+	urlPath := fmt.Sprintf("%q", path)
+	var queryParams []string
+	paramTypes := map[string]string{}
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			arg := strings.ToLower(goName(p.Name)[:1]) + goName(p.Name)[1:]
+			args = append(args, arg+" string")
+			urlPath = strings.Replace(urlPath, "{"+p.Name+"}", `"+url.PathEscape(`+arg+`)+"`, 1)
+		case "query":
+			queryParams = append(queryParams, p.Name)
+			paramTypes[p.Name] = goType(p.Schema)
+		}
+	}
+	urlPath = strings.TrimSuffix(strings.ReplaceAll(urlPath, `+""`, ""), `+""`)
+
+	rawBody := false
+	if op.RequestBody != nil {
+		media, isJSON := op.RequestBody.Content["application/json"]
+		_, isForm := op.RequestBody.Content["application/x-www-form-urlencoded"]
+		switch {
+		case isJSON:
+			args = append(args, "body "+goType(media.Schema))
+		case isForm:
+			return // form posts come from other services, not API clients
+		default:
+			rawBody = true
+			args = append(args, "body io.Reader", "contentType string")
+		}
+	}
+	if len(queryParams) > 0 {
+		fmt.Fprintf(b, "// %sParams are the query parameters of %s.\ntype %sParams struct {\n", name, name, name)
+		for _, param := range queryParams {
+			fmt.Fprintf(b, "\t%s %s\n", goName(param), paramTypes[param])
+		}
+		b.WriteString("}\n\n")
+		args = append(args, "params "+name+"Params")
+	}
+
+	returns, out, ret := "error", "nil", "return err"
+	if result != nil {
+		typ := goType(result)
+		if strings.HasPrefix(typ, "[]") || strings.HasPrefix(typ, "map[") {
+			returns, out, ret = "("+typ+", error)", "&out", "return out, err"
+		} else {
+			returns, out, ret = "(*"+typ+", error)", "&out", "if err != nil {\n\t\treturn nil, err\n\t}\n\treturn &out, nil"
+		}
+	}
+	fmt.Fprintf(b, "// %s calls %s %s: %s.\n", name, method, path, op.Summary)
+	fmt.Fprintf(b, "func (c *Client) %s(%s) %s {\n", name, strings.Join(args, ", "), returns)
+	if result != nil {
+		fmt.Fprintf(b, "\tvar out %s\n", goType(result))
+	}
+	query := "nil"
+	if len(queryParams) > 0 {
+		query = "query"
+		b.WriteString("\tquery := url.Values{}\n")
+		for _, param := range queryParams {
+			field := "params." + goName(param)
+			if paramTypes[param] == "int" {
+				fmt.Fprintf(b, "\tif %s != 0 {\n\t\tquery.Set(%q, strconv.Itoa(%s))\n\t}\n", field, param, field)
+			} else {
+				fmt.Fprintf(b, "\tif %s != \"\" {\n\t\tquery.Set(%q, %s)\n\t}\n", field, param, field)
+			}
+		}
+	}
+	statusList := "[]int{" + strings.Join(statuses, ", ") + "}"
+	switch {
+	case rawBody:
+		fmt.Fprintf(b, "\terr := c.do(ctx, %q, %s, %s, body, contentType, %s, %s)\n", method, urlPath, query, statusList, out)
+	case op.RequestBody != nil:
+		fmt.Fprintf(b, "\terr := c.doJSON(ctx, %q, %s, %s, body, %s, %s)\n", method, urlPath, query, statusList, out)
+	default:
+		fmt.Fprintf(b, "\terr := c.doJSON(ctx, %q, %s, %s, nil, %s, %s)\n", method, urlPath, query, statusList, out)
+	}
+	fmt.Fprintf(b, "\t%s\n}\n\n", ret)
+}
+
+func main() {
+	data, err := os.ReadFile("openapi.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var doc spec
+	if err := json.Unmarshal(data, &doc); err != nil {
+		log.Fatal(err)
+	}
+
+	var code bytes.Buffer
+	writeTypes(&code, doc.Components.Schemas)
+	for _, path := range sortedKeys(doc.Paths) {
+		for _, method := range sortedKeys(doc.Paths[path]) {
+			writeOperation(&code, strings.ToUpper(method), path, doc.Paths[path][method])
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen.go from openapi.json; DO NOT EDIT.\n\npackage client\n\nimport (\n")
+	for _, pkg := range []string{"context", "encoding/json", "io", "net/url", "strconv", "time"} {
+		if bytes.Contains(code.Bytes(), []byte(pkg[strings.LastIndex(pkg, "/")+1:]+".")) {
+			fmt.Fprintf(&b, "\t%q\n", pkg)
+		}
+	}
+	b.WriteString(")\n\n")
+	b.Write(code.Bytes())
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		os.WriteFile("api.go", b.Bytes(), 0o644)
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("api.go", source, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
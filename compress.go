@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Text assets are gzipped once at startup and kept in memory, so serving
+// them compressed costs nothing per request. The standard library has no
+// brotli encoder, so their brotli encodings are made ahead of time by
+// scripts/compress-assets and embedded next to them.
+
+// precompressedAssets are the embedded files worth compressing.
+var precompressedAssets = []string{
+	"public/styles.css",
+	"public/print.css",
+	"public/app.js",
+	"public/countdown.js",
+	"public/card.js",
+	"public/favicon.svg",
+	"public/og-image.svg",
+}
+
+// gzippedAssets maps an embedded file name to its gzip encoding, for the
+// files where that is smaller.
+var gzippedAssets = func() map[string][]byte {
+	assets := map[string][]byte{}
+	for _, name := range precompressedAssets {
+		data, err := embeddedFiles.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		var b bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+		_, _ = zw.Write(data)
+		_ = zw.Close()
+		if b.Len() < len(data) {
+			assets[name] = b.Bytes()
+		}
+	}
+	return assets
+}()
+
+// brotliAssets maps an embedded file name to its brotli encoding, for the
+// files where that is smaller and was made from the file as embedded: one
+// changed without running scripts/compress-assets is served gzipped only.
+var brotliAssets = func() map[string][]byte {
+	assets := map[string][]byte{}
+	sums, err := embeddedFiles.ReadFile("public/brotli.sha256")
+	if err != nil {
+		return assets
+	}
+	for _, line := range strings.Split(string(sums), "\n") {
+		sum, file, ok := strings.Cut(line, "  ")
+		if !ok {
+			continue
+		}
+		name := "public/" + file
+		data, err := embeddedFiles.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if current := sha256.Sum256(data); hex.EncodeToString(current[:]) != sum {
+			continue
+		}
+		if encoded, err := embeddedFiles.ReadFile(name + ".br"); err == nil && len(encoded) < len(data) {
+			assets[name] = encoded
+		}
+	}
+	return assets
+}()
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding,
+// by name or through "*", with a non-zero quality.
+func acceptsEncoding(header, coding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != coding && name != "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == coding {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
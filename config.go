@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// siteConfig is the operator-provided configuration read from CONFIG_FILE.
+type siteConfig struct {
+	Occasions   []Occasion   `json:"occasions"`
+	Themes      []Theme      `json:"themes"`
+	Site        SiteIdentity `json:"site"`
+	Experiments []Experiment `json:"experiments"`
+	// Subdomain label -> occasion prefix
+	Subdomains map[string]string `json:"subdomains"`
+	// Account e-mail -> daily requests per API token
+	APIQuotas map[string]int `json:"api_quotas"`
+}
+
+var (
+	configMu        sync.RWMutex
+	loadedConfig    *siteConfig
+	customOccasions = map[string]Occasion{}
+	customThemes    = map[string]Theme{}
+)
+
+// isReservedOccasionPrefix reports whether prefix is taken by a route of
+// the mux or of handlePage, or by a locale prefix, which an occasion would
+// shadow or be shadowed by.
+func isReservedOccasionPrefix(prefix string) bool {
+	if _, ok := locales[prefix]; ok {
+		return true
+	}
+	return slices.Contains(routeWords(), prefix)
+}
+
+func configPath() string {
+	return os.Getenv("CONFIG_FILE")
+}
+
+func loadConfig(path string) (*siteConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg siteConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range cfg.Occasions {
+		if err := validateOccasion(&cfg.Occasions[i]); err != nil {
+			return nil, fmt.Errorf("occasion %d: %w", i, err)
+		}
+	}
+	if err := validateSite(&cfg.Site); err != nil {
+		return nil, fmt.Errorf("site: %w", err)
+	}
+	for i := range cfg.Themes {
+		if err := validateTheme(&cfg.Themes[i]); err != nil {
+			return nil, fmt.Errorf("theme %d: %w", i, err)
+		}
+	}
+	for i := range cfg.Experiments {
+		if err := validateExperiment(&cfg.Experiments[i]); err != nil {
+			return nil, fmt.Errorf("experiment %d: %w", i, err)
+		}
+	}
+	if err := validateSubdomains(cfg.Subdomains, cfg.Occasions); err != nil {
+		return nil, fmt.Errorf("subdomains: %w", err)
+	}
+	if err := validateAPIQuotas(cfg.APIQuotas); err != nil {
+		return nil, fmt.Errorf("api_quotas: %w", err)
+	}
+	return &cfg, nil
+}
+
+func validateOccasion(occ *Occasion) error {
+	occ.Prefix = strings.ToLower(strings.TrimSpace(occ.Prefix))
+	occ.Greeting = strings.TrimSpace(occ.Greeting)
+	if occ.Prefix == "" {
+		return fmt.Errorf("missing prefix")
+	}
+	if !isSlug(occ.Prefix) {
+		return fmt.Errorf("invalid prefix %q", occ.Prefix)
+	}
+	if isReservedOccasionPrefix(occ.Prefix) {
+		return fmt.Errorf("prefix %q is reserved", occ.Prefix)
+	}
+	if occ.Greeting == "" {
+		return fmt.Errorf("occasion %q: missing greeting", occ.Prefix)
+	}
+	if occ.Animation != "" && !lottieAnimations[occ.Animation] {
+		return fmt.Errorf("occasion %q: unknown animation %q", occ.Prefix, occ.Animation)
+	}
+	if occ.OgTemplate != "" {
+		if ok, err := fileExists(occ.OgTemplate); !ok {
+			if err == nil {
+				err = fmt.Errorf("not found")
+			}
+			return fmt.Errorf("occasion %q: og_template %s: %w", occ.Prefix, occ.OgTemplate, err)
+		}
+		data, err := os.ReadFile(occ.OgTemplate)
+		if err != nil {
+			return fmt.Errorf("occasion %q: og_template %s: %w", occ.Prefix, occ.OgTemplate, err)
+		}
+		if !strings.Contains(string(data), "__TEXT__") {
+			return fmt.Errorf("occasion %q: og_template %s: missing __TEXT__ placeholder", occ.Prefix, occ.OgTemplate)
+		}
+		occ.ogTemplateSum = shortHash(string(data))
+	}
+	return nil
+}
+
+func applyConfig(cfg *siteConfig) {
+	occs := make(map[string]Occasion, len(cfg.Occasions))
+	for _, occ := range cfg.Occasions {
+		occs[occ.Prefix] = occ
+	}
+	themes := make(map[string]Theme, len(cfg.Themes))
+	for _, theme := range cfg.Themes {
+		themes[theme.Name] = theme
+	}
+	subdomains := make(map[string]string, len(cfg.Subdomains))
+	for label, prefix := range cfg.Subdomains {
+		subdomains[label] = prefix
+	}
+	quotas := make(map[string]int, len(cfg.APIQuotas))
+	for email, quota := range cfg.APIQuotas {
+		quotas[email] = quota
+	}
+	configMu.Lock()
+	loadedConfig = cfg
+	customOccasions = occs
+	customThemes = themes
+	customSite = cfg.Site
+	customExperiments = cfg.Experiments
+	customSubdomains = subdomains
+	customAPIQuotas = quotas
+	configMu.Unlock()
+}
+
+// reloadConfig reads CONFIG_FILE and swaps in its contents. On error the
+// previous configuration stays active.
+func reloadConfig() error {
+	path := configPath()
+	if path == "" {
+		return nil
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	applyConfig(cfg)
+	slog.Info("config loaded", "path", path, "occasions", len(cfg.Occasions), "themes", len(cfg.Themes), "experiments", len(cfg.Experiments))
+	return nil
+}
+
+func watchConfigReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if err := reloadConfigAudited("signal"); err != nil {
+				slog.Error("config reload failed", "error", err)
+			}
+		}
+	}()
+}
+
+// reloadConfigAudited is reloadConfig recorded in the audit log, with the
+// configuration before and after.
+func reloadConfigAudited(actor string) error {
+	audit := AuditEntry{Actor: actor, Action: "config.reload", Target: configPath()}
+	if before := activeConfig(); before != nil {
+		audit.Before = auditState(before)
+	}
+	err := reloadConfig()
+	if err != nil {
+		audit.Error = err.Error()
+	} else if after := activeConfig(); after != nil {
+		audit.After = auditState(after)
+	}
+	recordAudit(audit)
+	return err
+}
+
+// activeConfig returns the configuration last loaded from CONFIG_FILE, or
+// nil before any.
+func activeConfig() *siteConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return loadedConfig
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Birthdays are counted down in Brazilian time (no DST since 2019), so the
+// card flips at local midnight rather than UTC.
+var countdownZone = time.FixedZone("BRT", -3*60*60)
+
+// CountdownPageData is the data model of public/countdown.html.
+type CountdownPageData struct {
+	Title       string
+	OgDesc      string
+	OgURL       string
+	OgImage     string
+	Name        string
+	Days        int
+	Date        string // "25/12"
+	Target      string // RFC 3339 midnight of the birthday, for the live timer
+	CalendarURL string
+	Site        SiteIdentity
+}
+
+// daysUntil returns how many days are left until day/month, 0 on the day.
+func daysUntil(day, month int, now time.Time) (int, time.Time) {
+	local := now.In(countdownZone)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	next := nextOccurrence(day, month, today)
+	target := time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, countdownZone)
+	return int(next.Sub(today).Hours() / 24), target
+}
+
+// countdownText is the headline and OG text: "Faltam 12 dias para o
+// aniversário de João".
+func countdownText(name string, days int) string {
+	if days == 1 {
+		return "Falta 1 dia para o aniversário de " + name
+	}
+	return fmt.Sprintf("Faltam %d dias para o aniversário de %s", days, name)
+}
+
+func newCountdownPageData(name, nameSegment string, day, month int, now time.Time) CountdownPageData {
+	days, target := daysUntil(day, month, now)
+	base := strings.TrimRight(publicBaseURL(), "/")
+	spec := ogImageSpec{Text: strings.Replace(countdownText(name, days), " para ", "\npara ", 1)}
+	if occ, ok := lookupOccasion("aniversario"); ok && occ.OgTemplate != "" {
+		spec.Occasion = occ.Prefix
+	}
+	date := fmt.Sprintf("%02d-%02d", day, month)
+	calendar := url.Values{}
+	calendar.Set("nome", name)
+	calendar.Set("data", date)
+	return CountdownPageData{
+		Title:       countdownText(name, days),
+		OgDesc:      fmt.Sprintf("Aniversário em %02d/%02d 🎂", day, month),
+		OgURL:       base + "/contagem/" + encodePathSegment(nameSegment) + "/" + date,
+		OgImage:     ogImageURL(base, spec),
+		Name:        name,
+		Days:        days,
+		Date:        fmt.Sprintf("%02d/%02d", day, month),
+		Target:      target.Format(time.RFC3339),
+		CalendarURL: "/calendar.ics?" + calendar.Encode(),
+		Site:        siteIdentity(),
+	}
+}
+
+// handleCountdown serves /contagem/{nome}/{DD-MM}: a live countdown to the
+// birthday that becomes the birthday card on the day itself.
+func handleCountdown(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	nameSegment, date, ok := strings.Cut(strings.Trim(rest, "/"), "/")
+	day, month, validDate := parseDayMonth(date)
+	name, err := parseName(nameSegment)
+	if err == errNameBlocked {
+		writeHTML(w, r, http.StatusForbidden, errorPage("Esta mensagem não está disponível."))
+		return
+	}
+	if !ok || !validDate || err != nil || name == "" {
+		writeHTML(w, r, http.StatusNotFound, errorPage("Use /contagem/Nome/DD-MM, por exemplo /contagem/João/25-12."))
+		return
+	}
+
+	data := newCountdownPageData(name, nameSegment, day, month, time.Now())
+	if data.Days == 0 {
+		serveGreeting(w, r, "/aniversario/"+nameSegment, indexTemplate)
+		return
+	}
+	var b strings.Builder
+	if err := countdownTemplate.Execute(&b, data); err != nil {
+		slog.Error("countdown render failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	// The day count changes at midnight
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeHTML(w, r, http.StatusOK, b.String())
+}
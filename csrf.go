@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+)
+
+// CSRF protection for the browser-facing POST endpoints: the request must
+// come from a page of this host (Origin, or Referer when there is no
+// Origin) and carry the double-submit token of GET /api/csrf in the
+// X-CSRF-Token header, matching the csrf cookie. Other sites can neither
+// read the token nor, under SameSite=Strict, send the cookie.
+
+// handleCSRFToken serves GET /api/csrf, issuing the token the page sends
+// back. Greeting pages are cached publicly, so the token cannot be part of
+// the HTML.
+func handleCSRFToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	token := ""
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == 32 {
+		token = cookie.Value
+	} else {
+		var err error
+		if token, err = randomToken(); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+// checkCSRF reports whether r passes the origin and token checks, writing
+// 403 csrf_failed when it does not.
+func checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if !sameOriginRequest(r) {
+		writeAPIError(w, http.StatusForbidden, "csrf_failed")
+		return false
+	}
+	cookie, err := r.Cookie(csrfCookieName)
+	given := r.Header.Get(csrfHeaderName)
+	if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(given)) != 1 {
+		writeAPIError(w, http.StatusForbidden, "csrf_failed")
+		return false
+	}
+	return true
+}
+
+// sameOriginRequest checks the Origin header, or the Referer when there is
+// none, against the request host. Requests with neither, such as from
+// scripts, pass: the token still has to match.
+func sameOriginRequest(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
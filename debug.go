@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DebugCheck is one moderation or validation decision for a path.
+type DebugCheck struct {
+	Name   string
+	Passed bool
+	Detail string
+}
+
+// DebugMeta is a <meta> tag of the rendered page.
+type DebugMeta struct {
+	Key     string // property or name
+	Content string
+}
+
+// DebugPreviewData is the data model of public/debug.html.
+type DebugPreviewData struct {
+	Path      string
+	Status    int
+	Checks    []DebugCheck
+	Occasion  string
+	Theme     string
+	Locale    string
+	Title     string
+	OgImage   string
+	OgVideo   string
+	CacheKey  string
+	CachePath string
+	Cached    bool
+	Meta      []DebugMeta
+	RenderErr string
+	Site      SiteIdentity
+	Submitted bool
+}
+
+var metaTagPattern = regexp.MustCompile(`<meta (?:property|name)="([^"]+)" content="([^"]*)"`)
+
+// debugPreview computes everything the link preview of fullPath depends on,
+// running the same checks and rendering as a visit.
+func debugPreview(fullPath string) DebugPreviewData {
+	data := DebugPreviewData{Path: fullPath, Submitted: true, Status: validateGreetingPath(fullPath)}
+	pathOnly, rawQuery, _ := strings.Cut(fullPath, "?")
+	query, queryErr := url.ParseQuery(rawQuery)
+	occasion, rawMessage := parseOccasionFromPath(pathOnly)
+	message := decodePath(rawMessage)
+
+	check := func(name string, passed bool, detail string) {
+		data.Checks = append(data.Checks, DebugCheck{Name: name, Passed: passed, Detail: detail})
+	}
+	check("mensagem presente", message != "", fmt.Sprintf("%q", message))
+	check("não parece caminho de exploit", !looksLikePath(message), "looksLikePath")
+	check("mensagem não bloqueada", !isBlockedMessage(message), "blocked-words.txt")
+	check("query string válida", queryErr == nil, rawQuery)
+	sender, err := parseName(query.Get("de"))
+	check("remetente (?de=)", err == nil, strings.TrimSpace(fmt.Sprintf("%q %s", sender, errOrEmpty(err))))
+	age, err := greetingAge(pathOnly, query)
+	check("idade", err == nil, strings.TrimSpace(fmt.Sprintf("%d %s", age, errOrEmpty(err))))
+	_, err = parseBirthdate(query.Get("nascimento"), time.Now())
+	check("data de nascimento (?nascimento=)", err == nil, errOrEmpty(err))
+
+	opts := greetingPageOptions(pathOnly, query)
+	g := buildGreeting(pathOnly, opts)
+	data.Occasion = occasion.Prefix
+	data.Theme = themeName(opts.Theme)
+	data.Locale = g.Locale
+	data.Title = g.Title
+	data.OgImage = g.OgImage
+	data.OgVideo = g.OgVideo
+
+	spec := g.OgSpec
+	spec.Text = ogImageTextPrefix(spec.Text)
+	if spec.Text != "" {
+		data.CacheKey = spec.cacheKey()
+		data.CachePath = ogCachePath(data.CacheKey)
+		data.Cached, _ = fileExists(data.CachePath)
+	}
+
+	rendered, err := renderIndexHTML(indexTemplate, pathOnly, opts)
+	if err != nil {
+		data.RenderErr = err.Error()
+		return data
+	}
+	for _, match := range metaTagPattern.FindAllStringSubmatch(rendered, -1) {
+		data.Meta = append(data.Meta, DebugMeta{Key: match[1], Content: html.UnescapeString(match[2])})
+	}
+	return data
+}
+
+func errOrEmpty(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// handleDebugPreview serves the admin page GET /debug/preview?path=...,
+// showing the meta tags, OG image, cache key and moderation decisions the
+// server computes for a greeting path.
+func handleDebugPreview(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	data := DebugPreviewData{}
+	if rawPath := r.URL.Query().Get("path"); strings.TrimSpace(rawPath) != "" {
+		if len(rawPath) > maxPathLen {
+			http.Error(w, "", http.StatusRequestURITooLong)
+			return
+		}
+		data = debugPreview(normalizeGreetingPath(rawPath))
+	}
+	data.Site = siteIdentity()
+
+	var b strings.Builder
+	if err := debugTemplate.Execute(&b, data); err != nil {
+		slog.Error("debug preview render failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	writeHTML(w, r, http.StatusOK, b.String())
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// diskFree cannot tell free space on this platform; only writability is
+// checked.
+func diskFree(dir string) (uint64, bool) {
+	return 0, false
+}
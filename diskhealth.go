@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The data directory (where the stores live) and the cache directory
+// (rendered images, PDFs and videos) are checked every diskCheckInterval
+// for free space and writability. While the data directory is unhealthy
+// new shortlinks are refused with 503 storage_unavailable, and while the
+// cache directory is, OG images are the static default instead of new
+// renders; everything already stored is served as usual. Both show on
+// /readyz.
+
+// DiskStatus describes a directory the server writes to.
+type DiskStatus struct {
+	Name      string `json:"name"` // "data" or "cache"
+	Path      string `json:"path"`
+	FreeBytes uint64 `json:"free_bytes,omitempty"` // unset where the platform cannot tell
+	Writable  bool   `json:"writable"`
+	Healthy   bool   `json:"healthy"`
+	Error     string `json:"error,omitempty"`
+}
+
+var diskHealth = struct {
+	mu      sync.RWMutex
+	checked bool
+	dirs    []DiskStatus
+}{}
+
+// diskMinFreeBytes is DISK_MIN_FREE_MB in bytes, defaulting to
+// defaultDiskMinFreeMB.
+func diskMinFreeBytes() uint64 {
+	mb := uint64(defaultDiskMinFreeMB)
+	if value := os.Getenv("DISK_MIN_FREE_MB"); value != "" {
+		if parsed, err := strconv.ParseUint(value, 10, 64); err == nil {
+			mb = parsed
+		}
+	}
+	return mb << 20
+}
+
+// checkDisk creates dir if needed, writes and removes a probe file in it
+// and reads its free space.
+func checkDisk(name, dir string) DiskStatus {
+	status := DiskStatus{Name: name, Path: dir}
+	err := os.MkdirAll(dir, 0o755)
+	if err == nil {
+		var probe *os.File
+		if probe, err = os.CreateTemp(dir, ".health-*"); err == nil {
+			_, err = probe.Write([]byte("ok"))
+			if closeErr := probe.Close(); err == nil {
+				err = closeErr
+			}
+			_ = os.Remove(probe.Name())
+		}
+	}
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Writable = true
+	free, known := diskFree(dir)
+	status.FreeBytes = free
+	if min := diskMinFreeBytes(); known && free < min {
+		status.Error = fmt.Sprintf("%d MB free, below %d MB", free>>20, min>>20)
+		return status
+	}
+	status.Healthy = true
+	return status
+}
+
+// checkDiskHealth checks the data and cache directories, logging the ones
+// whose health changed.
+func checkDiskHealth() []DiskStatus {
+	dirs := []DiskStatus{
+		checkDisk("data", filepath.Dir(shortlinkDBPath())),
+		checkDisk("cache", ogCacheDir()),
+	}
+	diskHealth.mu.Lock()
+	previous := diskHealth.dirs
+	diskHealth.checked, diskHealth.dirs = true, dirs
+	diskHealth.mu.Unlock()
+
+	for i, dir := range dirs {
+		wasHealthy := i >= len(previous) || previous[i].Healthy
+		switch {
+		case !dir.Healthy && wasHealthy:
+			slog.Error("disk unhealthy, degrading", "dir", dir.Name, "path", dir.Path, "error", dir.Error)
+		case dir.Healthy && !wasHealthy:
+			slog.Info("disk healthy again", "dir", dir.Name, "path", dir.Path)
+		}
+	}
+	return dirs
+}
+
+// startDiskMonitor checks the disks now and every diskCheckInterval.
+func startDiskMonitor() {
+	checkDiskHealth()
+	go func() {
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkDiskHealth()
+		}
+	}()
+}
+
+// diskStatuses returns the last check, none before the first.
+func diskStatuses() []DiskStatus {
+	diskHealth.mu.RLock()
+	defer diskHealth.mu.RUnlock()
+	return diskHealth.dirs
+}
+
+// diskHealthy reports whether the named directory passed the last check;
+// before any it is assumed to.
+func diskHealthy(name string) bool {
+	for _, dir := range diskStatuses() {
+		if dir.Name == name {
+			return dir.Healthy
+		}
+	}
+	return true
+}
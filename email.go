@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SendRequest struct {
+	Email  string `json:"email"`
+	Path   string `json:"path"`
+	Sender string `json:"sender"`
+}
+
+type SendResponse struct {
+	Status string `json:"status"`
+}
+
+// pendingCard is a card held back until its recipient confirms they want
+// to receive cards (double opt-in).
+type pendingCard struct {
+	Email     string    `json:"email"`
+	Path      string    `json:"path"`
+	Sender    string    `json:"sender"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type emailData struct {
+	Confirmed map[string]time.Time   `json:"confirmed"` // recipient hash → opt-in time
+	Pending   map[string]pendingCard `json:"pending"`   // token → card awaiting opt-in
+}
+
+type emailStore struct {
+	mu     sync.Mutex
+	loaded bool
+	data   emailData
+}
+
+var emails = emailStore{
+	data: emailData{
+		Confirmed: map[string]time.Time{},
+		Pending:   map[string]pendingCard{},
+	},
+}
+
+var sendLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: sendRateWindow,
+	max:    sendRateLimit,
+}
+
+// Limits confirmation requests per recipient so the endpoint can't be used
+// to flood someone's inbox.
+var optInLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: optInRateWindow,
+	max:    optInRateLimit,
+}
+
+var sendMailFunc = smtp.SendMail
+
+func handleSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if !smtpConfigured() {
+		writeAPIError(w, http.StatusServiceUnavailable, "unavailable")
+		return
+	}
+	if !sendLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	body, err := readLimitedBody(r, maxSendBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+
+	var req SendRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+	email, ok := parseEmailAddress(req.Email)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_email")
+		return
+	}
+	path := normalizeGreetingPath(req.Path)
+	if status, code := checkGreetingPath(path); status != http.StatusOK {
+		writeAPIError(w, status, code)
+		return
+	}
+	sender, err := parseName(req.Sender)
+	if err != nil {
+		writeNameError(w, err)
+		return
+	}
+
+	if err := ensureEmailsLoaded(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	card := pendingCard{Email: email, Path: path, Sender: sender, CreatedAt: time.Now().UTC()}
+	recipient := emailHash(email)
+
+	emails.mu.Lock()
+	_, confirmed := emails.data.Confirmed[recipient]
+	emails.mu.Unlock()
+	if confirmed {
+		if err := deliverCard(card); err != nil {
+			slog.Error("card delivery failed", "error", err)
+			writeAPIError(w, http.StatusBadGateway, "upstream_failed")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, SendResponse{Status: "sent"})
+		return
+	}
+
+	if !optInLimiter.allow(recipient) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	token, err := randomToken()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	emails.mu.Lock()
+	emails.data.Pending[token] = card
+	err = persistEmailsLocked()
+	emails.mu.Unlock()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	if err := sendEmail(email, buildOptInEmail(email, token, sender)); err != nil {
+		slog.Error("opt-in email failed", "error", err)
+		writeAPIError(w, http.StatusBadGateway, "upstream_failed")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, SendResponse{Status: "confirmation_sent"})
+}
+
+// handleSendConfirm serves the opt-in link of the confirmation email. Mail
+// scanners and link prefetchers follow links, so the GET only shows a form
+// posting back to the link; the POST confirms and delivers the held card.
+func handleSendConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		return
+	}
+	if err := ensureEmailsLoaded(); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodGet {
+		emails.mu.Lock()
+		card, ok := emails.data.Pending[token]
+		emails.mu.Unlock()
+		if !ok || time.Since(card.CreatedAt) > optInTokenTTL {
+			writeHTML(w, r, http.StatusNotFound, errorPage("Este link de confirmação é inválido ou expirou."))
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		action := "/api/send/confirm?token=" + url.QueryEscape(token)
+		writeHTML(w, r, http.StatusOK, formPage("Confirmar e-mail", "Receber o cartão?", "Confirme para receber este cartão, e os próximos, no seu e-mail.", action, "Confirmar"))
+		return
+	}
+
+	emails.mu.Lock()
+	card, ok := emails.data.Pending[token]
+	if ok {
+		delete(emails.data.Pending, token)
+		if time.Since(card.CreatedAt) <= optInTokenTTL {
+			emails.data.Confirmed[emailHash(card.Email)] = time.Now().UTC()
+		} else {
+			ok = false
+		}
+		if err := persistEmailsLocked(); err != nil {
+			slog.Error("email store persist failed", "error", err)
+		}
+	}
+	emails.mu.Unlock()
+	if !ok {
+		writeHTML(w, r, http.StatusNotFound, errorPage("Este link de confirmação é inválido ou expirou."))
+		return
+	}
+
+	if err := deliverCard(card); err != nil {
+		slog.Error("card delivery failed", "error", err)
+		writeHTML(w, r, http.StatusBadGateway, errorPage("Não foi possível enviar o cartão. Tente novamente mais tarde."))
+		return
+	}
+	writeHTML(w, r, http.StatusOK, messagePage("Confirmado", "Pronto!", "Seu cartão foi enviado para o seu e-mail."))
+}
+
+func parseEmailAddress(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > maxEmailLen {
+		return "", false
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return "", false
+	}
+	return strings.ToLower(addr.Address), true
+}
+
+func emailHash(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func deliverCard(card pendingCard) error {
+	msg, err := buildCardEmail(card)
+	if err != nil {
+		return err
+	}
+	return sendEmail(card.Email, msg)
+}
+
+func smtpConfigured() bool {
+	return os.Getenv("SMTP_HOST") != ""
+}
+
+func smtpFrom() string {
+	if value := os.Getenv("SMTP_FROM"); value != "" {
+		return value
+	}
+	return "parabens@" + siteIdentity().Domain
+}
+
+func sendEmail(to string, msg []byte) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return sendMailFunc(host+":"+port, auth, smtpFrom(), []string{to}, msg)
+}
+
+func writeEmailHeaders(buf *bytes.Buffer, to, subject, contentType string) {
+	fmt.Fprintf(buf, "From: %s\r\n", smtpFrom())
+	fmt.Fprintf(buf, "To: %s\r\n", to)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: %s\r\n\r\n", contentType)
+}
+
+// buildCardEmail renders the greeting as an HTML email with the OG image
+// attached inline, so it shows even when remote images are blocked.
+func buildCardEmail(card pendingCard) ([]byte, error) {
+	pathOnly, rawQuery, _ := strings.Cut(card.Path, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	opts := greetingPageOptions(pathOnly, query)
+	if card.Sender != "" {
+		opts.Sender = card.Sender
+	}
+	g := buildGreeting(pathOnly, opts)
+	link := strings.TrimRight(publicBaseURL(), "/") + card.Path
+	if card.Sender != "" && !strings.Contains(card.Path, "?") {
+		link += "?de=" + url.QueryEscape(card.Sender)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	htmlPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	from := ""
+	if card.Sender != "" {
+		from = " de " + escapeHTML(card.Sender)
+	}
+	html := fmt.Sprintf(`<!DOCTYPE html><html lang="pt-BR"><body style="font-family:system-ui,Arial,sans-serif;background:#0f172a;color:#f8fafc;margin:0;padding:24px;text-align:center">`+
+		`<h1 style="font-size:24px">%s</h1><p><a href="%s"><img src="cid:og-image" width="%d" height="%d" alt="%s" style="max-width:100%%;height:auto;border-radius:12px"></a></p>`+
+		`<p><a href="%s" style="color:#fbbf24;font-weight:600">Abrir cartão</a></p>`+
+		`<p style="font-size:12px;color:#94a3b8">Você recebeu este cartão%s via %s.</p></body></html>`,
+		escapeHTML(g.Title), escapeHTML(link), ogImageWidth, ogImageHeight, escapeHTML(g.Title),
+		escapeHTML(link), from, escapeHTML(siteIdentity().Name))
+	if err := writeBase64(htmlPart, []byte(html)); err != nil {
+		return nil, err
+	}
+
+	imagePart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/png"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-ID":                {"<og-image>"},
+		"Content-Disposition":       {`inline; filename="parabens.png"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64(imagePart, ogImagePNG(g.OgSpec)); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	writeEmailHeaders(&msg, card.Email, g.Title, `multipart/related; boundary="`+mw.Boundary()+`"`)
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func buildOptInEmail(to, token, sender string) []byte {
+	link := strings.TrimRight(publicBaseURL(), "/") + "/api/send/confirm?token=" + url.QueryEscape(token)
+	who := "Alguém"
+	if sender != "" {
+		who = sender
+	}
+	var msg bytes.Buffer
+	writeEmailHeaders(&msg, to, "Você recebeu um cartão de parabéns", "text/plain; charset=utf-8")
+	fmt.Fprintf(&msg, "%s quer te enviar um cartão pelo %s.\r\n\r\n", who, siteIdentity().Name)
+	fmt.Fprintf(&msg, "Para recebê-lo (e os próximos cartões), confirme seu e-mail:\r\n%s\r\n\r\n", link)
+	msg.WriteString("Se você não reconhece este pedido, basta ignorar esta mensagem.\r\n")
+	return msg.Bytes()
+}
+
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := w.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := w.Write([]byte(encoded + "\r\n"))
+	return err
+}
+
+func ensureEmailsLoaded() error {
+	emails.mu.Lock()
+	defer emails.mu.Unlock()
+	if emails.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(emailDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			emails.loaded = true
+			return nil
+		}
+		return err
+	}
+	var stored emailData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	if stored.Confirmed == nil {
+		stored.Confirmed = map[string]time.Time{}
+	}
+	if stored.Pending == nil {
+		stored.Pending = map[string]pendingCard{}
+	}
+	emails.data = stored
+	emails.loaded = true
+	return nil
+}
+
+func persistEmailsLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := emailDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	for token, card := range emails.data.Pending {
+		if time.Since(card.CreatedAt) > optInTokenTTL {
+			delete(emails.data.Pending, token)
+		}
+	}
+	data, err := json.MarshalIndent(emails.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func emailDBPath() string {
+	if value := os.Getenv("EMAIL_DB"); value != "" {
+		return value
+	}
+	return "data/email.json"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+)
+
+// celebrationEmoji is the allowlist for ?emoji=, in composer order.
+var celebrationEmoji = []string{
+	"🎉", "🎊", "🥳", "🎂", "🍰", "🧁", "🎁", "🎈", "🍾", "🥂",
+	"🎓", "🏆", "⭐", "🌟", "✨", "💒", "💍", "❤️", "💖", "💐",
+	"🌹", "🌻", "🎄", "🎆", "🐣", "👶", "🍼", "👋", "🙌", "👏",
+	"🤩", "😍", "🎵", "🌈", "🦄", "🏖️",
+}
+
+// emojiOptions is the composer's emoji <option> list, rendered once rather
+// than ranged over on every page view.
+var emojiOptions = func() template.HTML {
+	var b strings.Builder
+	for _, emoji := range celebrationEmoji {
+		b.WriteString(`<option value="` + escapeHTML(emoji) + `">` + escapeHTML(emoji) + `</option>`)
+	}
+	return template.HTML(b.String())
+}()
+
+// emojiName returns the allowlisted emoji matching value, ignoring the
+// variation selector (so "❤" and "❤️" are the same), or "".
+func emojiName(value string) string {
+	value = strings.ReplaceAll(strings.TrimSpace(value), "️", "")
+	if value == "" {
+		return ""
+	}
+	for _, emoji := range celebrationEmoji {
+		if strings.ReplaceAll(emoji, "️", "") == value {
+			return emoji
+		}
+	}
+	return ""
+}
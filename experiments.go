@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Experiment splits composer visitors between variants of its copy and
+// theme. Experiments are defined in the config file; the first variant is
+// usually the control, with no overrides.
+type Experiment struct {
+	Name     string              `json:"name"`
+	Variants []ExperimentVariant `json:"variants"`
+}
+
+// ExperimentVariant overrides parts of the composer; empty fields keep the
+// default.
+type ExperimentVariant struct {
+	Name           string `json:"name"`
+	ComposerTitle  string `json:"composer_title,omitempty"`
+	ComposerButton string `json:"composer_button,omitempty"`
+	Theme          string `json:"theme,omitempty"` // used when the URL has no ?theme=
+}
+
+var customExperiments []Experiment
+
+func validateExperiment(exp *Experiment) error {
+	if !isSlug(exp.Name) {
+		return fmt.Errorf("invalid name %q", exp.Name)
+	}
+	if len(exp.Variants) < 2 {
+		return fmt.Errorf("experiment %q: needs at least two variants", exp.Name)
+	}
+	seen := map[string]bool{}
+	for _, variant := range exp.Variants {
+		if !isSlug(variant.Name) {
+			return fmt.Errorf("experiment %q: invalid variant name %q", exp.Name, variant.Name)
+		}
+		if seen[variant.Name] {
+			return fmt.Errorf("experiment %q: duplicate variant %q", exp.Name, variant.Name)
+		}
+		seen[variant.Name] = true
+		if variant.Theme != "" && !isSlug(variant.Theme) {
+			return fmt.Errorf("experiment %q: invalid theme %q", exp.Name, variant.Theme)
+		}
+	}
+	return nil
+}
+
+func activeExperiments() []Experiment {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return customExperiments
+}
+
+// experimentVariant assigns a visitor to a variant by hashing the client IP
+// with the experiment name: stable across visits without a cookie, and
+// independent between experiments.
+func experimentVariant(exp Experiment, ip string) ExperimentVariant {
+	sum := sha256.Sum256([]byte(exp.Name + "\x00" + ip))
+	return exp.Variants[binary.BigEndian.Uint32(sum[:4])%uint32(len(exp.Variants))]
+}
+
+// applyExperiments applies the visitor's variants to the composer page and
+// records the exposures, unless the server is read-only.
+func applyExperiments(opts *pageOptions, ip string) {
+	for _, exp := range activeExperiments() {
+		variant := experimentVariant(exp, ip)
+		if variant.ComposerTitle != "" {
+			opts.ComposerTitle = variant.ComposerTitle
+		}
+		if variant.ComposerButton != "" {
+			opts.ComposerButton = variant.ComposerButton
+		}
+		if variant.Theme != "" && opts.Theme == "" {
+			opts.Theme = variant.Theme
+		}
+		slog.Info("experiment_exposure", "experiment", exp.Name, "variant", variant.Name)
+		if readOnly() {
+			continue
+		}
+		if err := recordExperimentEvent(exp.Name, variant.Name, false); err != nil {
+			slog.Error("experiment stats update failed", "error", err)
+		}
+	}
+}
+
+// recordExperimentConversions counts a shortlink creation as a conversion
+// of the visitor's variant in every experiment.
+func recordExperimentConversions(ip string) {
+	for _, exp := range activeExperiments() {
+		variant := experimentVariant(exp, ip)
+		slog.Info("experiment_conversion", "experiment", exp.Name, "variant", variant.Name)
+		if err := recordExperimentEvent(exp.Name, variant.Name, true); err != nil {
+			slog.Error("experiment stats update failed", "error", err)
+		}
+	}
+}
+
+type experimentCounts struct {
+	Exposures   int `json:"exposures"`
+	Conversions int `json:"conversions"`
+}
+
+type experimentStore struct {
+	mu     sync.Mutex
+	loaded bool
+	counts map[string]map[string]*experimentCounts // experiment -> variant
+}
+
+var experimentStats = experimentStore{
+	counts: map[string]map[string]*experimentCounts{},
+}
+
+func recordExperimentEvent(experiment, variant string, conversion bool) error {
+	if err := ensureExperimentsLoaded(); err != nil {
+		return err
+	}
+	experimentStats.mu.Lock()
+	defer experimentStats.mu.Unlock()
+	variants := experimentStats.counts[experiment]
+	if variants == nil {
+		variants = map[string]*experimentCounts{}
+		experimentStats.counts[experiment] = variants
+	}
+	counts := variants[variant]
+	if counts == nil {
+		counts = &experimentCounts{}
+		variants[variant] = counts
+	}
+	if conversion {
+		counts.Conversions++
+	} else {
+		counts.Exposures++
+	}
+	return persistExperimentsLocked()
+}
+
+func ensureExperimentsLoaded() error {
+	experimentStats.mu.Lock()
+	defer experimentStats.mu.Unlock()
+	if experimentStats.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(experimentsDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			experimentStats.loaded = true
+			return nil
+		}
+		return err
+	}
+
+	counts := map[string]map[string]*experimentCounts{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &counts); err != nil {
+			return err
+		}
+	}
+	experimentStats.counts = counts
+	experimentStats.loaded = true
+	return nil
+}
+
+func persistExperimentsLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := experimentsDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(experimentStats.counts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+func experimentsDBPath() string {
+	if value := os.Getenv("EXPERIMENTS_DB"); value != "" {
+		return value
+	}
+	return "data/experiments.json"
+}
+
+// ExperimentReport is an experiment's results in /api/stats/experiments.
+type ExperimentReport struct {
+	Name     string          `json:"name"`
+	Variants []VariantReport `json:"variants"`
+}
+
+type VariantReport struct {
+	Name           string  `json:"name"`
+	Exposures      int     `json:"exposures"`
+	Conversions    int     `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"` // conversions per exposure
+}
+
+func experimentReports() ([]ExperimentReport, error) {
+	if err := ensureExperimentsLoaded(); err != nil {
+		return nil, err
+	}
+	experiments := activeExperiments()
+	experimentStats.mu.Lock()
+	defer experimentStats.mu.Unlock()
+	reports := make([]ExperimentReport, 0, len(experiments))
+	for _, exp := range experiments {
+		report := ExperimentReport{Name: exp.Name}
+		for _, variant := range exp.Variants {
+			row := VariantReport{Name: variant.Name}
+			if counts := experimentStats.counts[exp.Name][variant.Name]; counts != nil {
+				row.Exposures = counts.Exposures
+				row.Conversions = counts.Conversions
+				if counts.Exposures > 0 {
+					row.ConversionRate = float64(counts.Conversions) / float64(counts.Exposures)
+				}
+			}
+			report.Variants = append(report.Variants, row)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// handleExperimentStats serves GET /api/stats/experiments: exposures and
+// conversions of each variant of the configured experiments.
+func handleExperimentStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	reports, err := experimentReports()
+	if err != nil {
+		slog.Error("experiment stats load failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, reports)
+}
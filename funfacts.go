@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// birthdate is a ?nascimento= date; Year is 0 when only DD-MM was given.
+type birthdate struct {
+	Day, Month, Year int
+}
+
+var errBirthdateInvalid = fmt.Errorf("invalid birthdate")
+
+// parseBirthdate validates ?nascimento= as DD-MM-AAAA, DD-MM or AAAA-MM-DD.
+// An empty value is not an error.
+func parseBirthdate(value string, now time.Time) (birthdate, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return birthdate{}, nil
+	}
+	for _, layout := range []string{"02-01-2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if t.Year() < minBirthYear || t.After(now) {
+				return birthdate{}, errBirthdateInvalid
+			}
+			return birthdate{Day: t.Day(), Month: int(t.Month()), Year: t.Year()}, nil
+		}
+	}
+	// 2000 is a leap year, so 29-02 is accepted without a year
+	if t, err := time.Parse("02-01-2006", value+"-2000"); err == nil {
+		return birthdate{Day: t.Day(), Month: int(t.Month())}, nil
+	}
+	return birthdate{}, errBirthdateInvalid
+}
+
+// zodiacSigns lists each sign with the day its period starts, in calendar
+// order from Capricorn's January part.
+var zodiacSigns = []struct {
+	Month, Day int
+	Name       string
+}{
+	{1, 1, "♑ Capricórnio"},
+	{1, 20, "♒ Aquário"},
+	{2, 19, "♓ Peixes"},
+	{3, 21, "♈ Áries"},
+	{4, 20, "♉ Touro"},
+	{5, 21, "♊ Gêmeos"},
+	{6, 21, "♋ Câncer"},
+	{7, 23, "♌ Leão"},
+	{8, 23, "♍ Virgem"},
+	{9, 23, "♎ Libra"},
+	{10, 23, "♏ Escorpião"},
+	{11, 22, "♐ Sagitário"},
+	{12, 22, "♑ Capricórnio"},
+}
+
+func zodiacSign(day, month int) string {
+	sign := zodiacSigns[0].Name
+	for _, s := range zodiacSigns {
+		if month > s.Month || (month == s.Month && day >= s.Day) {
+			sign = s.Name
+		}
+	}
+	return sign
+}
+
+// FamousBirthday is an entry of public/famous-birthdays.txt.
+type FamousBirthday struct {
+	Date        string `json:"date"` // MM-DD
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var (
+	famousBirthdaysOnce sync.Once
+	famousBirthdays     map[string][]FamousBirthday
+)
+
+func loadFamousBirthdays() {
+	famousBirthdays = map[string][]FamousBirthday{}
+	data, err := embeddedFiles.ReadFile("public/famous-birthdays.txt")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) != 3 {
+			continue
+		}
+		entry := FamousBirthday{Date: fields[0], Name: fields[1], Description: fields[2]}
+		famousBirthdays[entry.Date] = append(famousBirthdays[entry.Date], entry)
+	}
+}
+
+// famousBirthdaysOn returns the notable people born on day/month, in
+// dataset order (Brazilians first).
+func famousBirthdaysOn(day, month int) []FamousBirthday {
+	famousBirthdaysOnce.Do(loadFamousBirthdays)
+	return famousBirthdays[fmt.Sprintf("%02d-%02d", month, day)]
+}
+
+var weekdaysPT = [...]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"}
+
+// birthdateFacts returns the fun facts of a birthdate: the zodiac sign and
+// the weekday of birth when the year is known. Famous birthday twins get a
+// line of their own, see birthdayTwinsLine.
+func birthdateFacts(b birthdate) []string {
+	if b.Day == 0 {
+		return nil
+	}
+	facts := []string{"Signo: " + zodiacSign(b.Day, b.Month)}
+	if b.Year != 0 {
+		weekday := time.Date(b.Year, time.Month(b.Month), b.Day, 0, 0, 0, 0, time.UTC).Weekday()
+		article := "numa" // "-feira" days are feminine
+		if weekday == time.Sunday || weekday == time.Saturday {
+			article = "num"
+		}
+		facts = append(facts, fmt.Sprintf("Nasceu %s %s", article, weekdaysPT[weekday]))
+	}
+	return facts
+}
+
+// birthdayTwinsLine names up to birthdayTwinsLimit notable people born on
+// the same day and month: "Hoje também é aniversário de Pelé e Kevin
+// Kline". It is "" without a date or when nobody is known.
+func birthdayTwinsLine(b birthdate) string {
+	if b.Day == 0 {
+		return ""
+	}
+	famous := famousBirthdaysOn(b.Day, b.Month)
+	if len(famous) == 0 {
+		return ""
+	}
+	names := make([]string, 0, birthdayTwinsLimit)
+	for _, person := range famous {
+		if len(names) == birthdayTwinsLimit {
+			break
+		}
+		names = append(names, person.Name)
+	}
+	list := names[0]
+	if len(names) > 1 {
+		list = strings.Join(names[:len(names)-1], ", ") + " e " + names[len(names)-1]
+	}
+	return "Hoje também é aniversário de " + list
+}
+
+// handleBirthdays serves GET /api/birthdays/{mm-dd}: the notable people
+// born on that day, for the composer's birthdate hint.
+func handleBirthdays(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	var month, day int
+	date := strings.TrimPrefix(r.URL.Path, "/api/birthdays/")
+	if _, err := fmt.Sscanf(date, "%02d-%02d", &month, &day); err != nil || len(date) != 5 ||
+		time.Date(2000, time.Month(month), day, 0, 0, 0, 0, time.UTC).Format("01-02") != date {
+		writeAPIError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	famous := famousBirthdaysOn(day, month)
+	if famous == nil {
+		famous = []FamousBirthday{}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeJSON(w, http.StatusOK, famous)
+}
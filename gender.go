@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Grammatical gender of the recipient, as chosen with ?g=.
+const (
+	genderFeminine  = "f"
+	genderMasculine = "m"
+	genderNeutral   = "n"
+)
+
+// Names whose ending misleads the heuristic in genderFromName.
+var (
+	feminineNames = map[string]bool{
+		"beatriz": true, "isabel": true, "raquel": true, "ester": true, "rute": true,
+		"ruth": true, "carmen": true, "miriam": true, "lis": true, "liz": true,
+		"inês": true, "ines": true, "luz": true, "abigail": true, "rachel": true,
+		"iris": true, "íris": true, "thaís": true, "thais": true, "taís": true,
+		"tais": true, "lais": true, "laís": true, "ingrid": true, "karen": true,
+		"ellen": true, "hellen": true, "helen": true, "mabel": true, "sueli": true,
+		"suely": true, "nathaly": true, "kelly": true, "jenifer": true, "jennifer": true,
+	}
+	masculineNames = map[string]bool{
+		"luca": true, "lucca": true, "joshua": true, "nicola": true, "andrea": true,
+		"elias": true, "jonas": true, "tobias": true, "matias": true, "thomas": true,
+		"garcia": true, "bautista": true, "jeremias": true, "isaías": true, "isaias": true,
+	}
+)
+
+// genderName validates ?g=, returning "" for anything but f, m or n.
+func genderName(value string) string {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case genderFeminine, genderMasculine, genderNeutral:
+		return value
+	}
+	return ""
+}
+
+// genderFromName guesses the gender of a Brazilian first name by its
+// ending, with exception lists; unknown endings are neutral.
+func genderFromName(name string) string {
+	if utf8.RuneCountInString(name) < 2 {
+		return genderNeutral
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case feminineNames[lower]:
+		return genderFeminine
+	case masculineNames[lower]:
+		return genderMasculine
+	case strings.HasSuffix(lower, "a"), strings.HasSuffix(lower, "ane"), strings.HasSuffix(lower, "iele"),
+		strings.HasSuffix(lower, "elle"), strings.HasSuffix(lower, "ice"):
+		return genderFeminine
+	case strings.HasSuffix(lower, "o"), strings.HasSuffix(lower, "os"), strings.HasSuffix(lower, "ão"),
+		strings.HasSuffix(lower, "or"), strings.HasSuffix(lower, "el"), strings.HasSuffix(lower, "son"):
+		return genderMasculine
+	}
+	return genderNeutral
+}
+
+// recipientGender resolves the gender used for the greeting copy: the ?g=
+// override, else a guess from the name the message starts with.
+func recipientGender(override, message string) string {
+	if g := genderName(override); g != "" {
+		return g
+	}
+	return genderFromName(leadingName(message))
+}
+
+// inflect picks the word form matching gender.
+func inflect(gender, feminine, masculine, neutral string) string {
+	switch gender {
+	case genderFeminine:
+		return feminine
+	case genderMasculine:
+		return masculine
+	}
+	return neutral
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type GuestbookEntry struct {
+	Name      string `json:"name"`
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+}
+
+type GuestbookRequest struct {
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+type guestbookStore struct {
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string][]GuestbookEntry
+}
+
+var guestbook = guestbookStore{
+	entries: map[string][]GuestbookEntry{},
+}
+
+var guestbookLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: guestbookRateWindow,
+	max:    guestbookRateLimit,
+}
+
+var errGuestbookFull = fmt.Errorf("guestbook full")
+
+// guestbookTarget maps the different spellings of a greeting path
+// ("/aniversario/Jo%C3%A3o", "/ANIVERSARIO/João_") to one guestbook key,
+// also returning the decoded greeting message.
+func guestbookTarget(path string) (key, message string) {
+	path, _, _ = strings.Cut(path, "?")
+	occasion, rawMessage := parseOccasionFromPath(path)
+	message = decodePath(rawMessage)
+	if message == "" {
+		return "", ""
+	}
+	return occasion.Prefix + "/" + message, message
+}
+
+func handleGuestbook(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGuestbookList(w, r)
+	case http.MethodPost:
+		handleGuestbookPost(w, r)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func handleGuestbookList(w http.ResponseWriter, r *http.Request) {
+	key, _ := guestbookTarget(r.URL.Query().Get("path"))
+	if key == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_path")
+		return
+	}
+	entries, err := guestbookEntries(key)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func handleGuestbookPost(w http.ResponseWriter, r *http.Request) {
+	if !checkCSRF(w, r) || !checkCaptcha(w, r) {
+		return
+	}
+	if !guestbookLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	body, err := readLimitedBody(r, maxGuestbookBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+
+	var req GuestbookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+	key, greeting := guestbookTarget(req.Path)
+	name := strings.Join(strings.Fields(req.Name), " ")
+	message := strings.Join(strings.Fields(req.Message), " ")
+	if key == "" || name == "" || message == "" ||
+		utf8.RuneCountInString(name) > maxNameLen ||
+		utf8.RuneCountInString(message) > maxGuestbookMessageLen {
+		writeAPIError(w, http.StatusBadRequest, "invalid_field")
+		return
+	}
+	if isBlockedMessage(greeting) || isBlockedMessage(name) || isBlockedMessage(message) ||
+		looksLikePath(name) || looksLikePath(message) {
+		writeAPIError(w, http.StatusForbidden, "blocked_message")
+		return
+	}
+
+	entry := GuestbookEntry{
+		Name:      name,
+		Message:   message,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := addGuestbookEntry(key, entry); err != nil {
+		if err == errGuestbookFull {
+			writeAPIError(w, http.StatusConflict, "limit_reached")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+func guestbookEntries(key string) ([]GuestbookEntry, error) {
+	if err := ensureGuestbookLoaded(); err != nil {
+		return nil, err
+	}
+	guestbook.mu.Lock()
+	defer guestbook.mu.Unlock()
+	entries := make([]GuestbookEntry, len(guestbook.entries[key]))
+	copy(entries, guestbook.entries[key])
+	return entries, nil
+}
+
+func addGuestbookEntry(key string, entry GuestbookEntry) error {
+	if err := ensureGuestbookLoaded(); err != nil {
+		return err
+	}
+	guestbook.mu.Lock()
+	defer guestbook.mu.Unlock()
+	if len(guestbook.entries[key]) >= maxGuestbookEntries {
+		return errGuestbookFull
+	}
+	guestbook.entries[key] = append(guestbook.entries[key], entry)
+	if err := persistGuestbookLocked(); err != nil {
+		guestbook.entries[key] = guestbook.entries[key][:len(guestbook.entries[key])-1]
+		return err
+	}
+	return nil
+}
+
+func ensureGuestbookLoaded() error {
+	guestbook.mu.Lock()
+	defer guestbook.mu.Unlock()
+	if guestbook.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(guestbookDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			guestbook.loaded = true
+			return nil
+		}
+		return err
+	}
+	entries := map[string][]GuestbookEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	guestbook.entries = entries
+	guestbook.loaded = true
+	return nil
+}
+
+func persistGuestbookLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := guestbookDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(guestbook.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+func guestbookDBPath() string {
+	if value := os.Getenv("GUESTBOOK_DB"); value != "" {
+		return value
+	}
+	return "data/guestbook.json"
+}
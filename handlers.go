@@ -2,32 +2,37 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 )
 
 func handleTrack(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 	if !trackLimiter.allow(clientIP(r)) {
-		http.Error(w, "", http.StatusTooManyRequests)
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
 		return
 	}
 	body, err := readLimitedBody(r, maxTrackBodyBytes)
 	if err != nil {
-		http.Error(w, "", statusFromError(err))
+		writeAPIBodyError(w, err)
 		return
 	}
 
 	var evt TrackEvent
 	if err := json.Unmarshal(body, &evt); err != nil {
-		http.Error(w, "", http.StatusBadRequest)
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
 		return
 	}
 
@@ -45,143 +50,433 @@ func handleTrack(w http.ResponseWriter, r *http.Request) {
 		"referer", r.Referer(),
 		"accept_language", r.Header.Get("Accept-Language"),
 	)
+	if evt.Event == "page_view" && !isCrawler(r) {
+		count, err := recordView(evt.Path)
+		if err != nil {
+			slog.Error("view count failed", "error", err)
+		} else if count > 0 {
+			if err := recordStats(evt.Path, ip, count, time.Now()); err != nil {
+				slog.Error("stats update failed", "error", err)
+			}
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func handleShortlinkCreate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
-	if !shortlinkLimiter.allow(clientIP(r)) {
-		http.Error(w, "", http.StatusTooManyRequests)
+	owner, ok := apiTokenAuth(w, r, scopeCreateShortlinks)
+	if !ok {
 		return
 	}
+	viaToken := owner != ""
+	powSolved := 0
+	// Token requests count against the token's daily quota instead of the
+	// per-IP limit, and cannot be forged by other sites
+	if owner == "" {
+		if owner, powSolved, ok = checkAnonymousShortlink(w, r); !ok {
+			return
+		}
+	}
 
+	if !diskHealthy("data") {
+		writeStorageUnavailable(w)
+		return
+	}
 	if err := ensureShortlinksLoaded(); err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
 		return
 	}
 
 	body, err := readLimitedBody(r, maxShortlinkBodyBytes)
 	if err != nil {
-		http.Error(w, "", statusFromError(err))
+		writeAPIBodyError(w, err)
 		return
 	}
 
 	var req ShortLinkRequest
-	if err := json.Unmarshal(body, &req); err != nil || strings.TrimSpace(req.Path) == "" {
-		http.Error(w, "", http.StatusBadRequest)
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
 		return
 	}
+	if strings.TrimSpace(req.Path) == "" {
+		writeAPIError(w, http.StatusBadRequest, "empty_message")
+		return
+	}
+	if req.ExpiresIn < 0 || req.ExpiresIn > maxShortlinkExpiresInDays {
+		writeAPIError(w, http.StatusBadRequest, "invalid_expiry")
+		return
+	}
+	if !validCreatorID(req.CreatorID) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_field")
+		return
+	}
+	creator := shortlinkCreatorOf(r, req.CreatorID)
+	var expiresAt *time.Time
+	if req.ExpiresIn > 0 {
+		at := time.Now().UTC().Truncate(time.Second).AddDate(0, 0, req.ExpiresIn)
+		expiresAt = &at
+	}
 
 	// Store the full path (with occasion prefix and query string)
-	fullPath := strings.TrimSpace(req.Path)
-	if !strings.HasPrefix(fullPath, "/") {
-		fullPath = "/" + fullPath
+	fullPath := normalizeGreetingPath(req.Path)
+	if status, code := checkGreetingPath(fullPath); status != http.StatusOK {
+		writeAPIError(w, status, code)
+		return
+	}
+	if !viaToken {
+		if reason := botSignal(req.Website, r.Header.Get(formTokenHeaderName), powSolved, time.Now()); reason != "" {
+			recordBotAttempt(r, reason, fullPath)
+			decoy, err := decoyShortlink(fullPath)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "internal_error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, decoy)
+			return
+		}
 	}
+	if owner == "" && !checkSpamScore(w, r, fullPath, expiresAt, creator, powSolved) {
+		return
+	}
+
+	var code string
+	created := true
+	if expiresAt != nil {
+		code, err = createExpiringShortlink(fullPath, *expiresAt, creator)
+	} else {
+		code, created, err = createShortlink(fullPath, creator)
+	}
+	if err != nil {
+		if err == errNoFreeCode {
+			writeAPIError(w, http.StatusServiceUnavailable, "no_free_code")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	recordExperimentConversions(clientIP(r))
+	recordOwnership(owner, code, "")
+	resp := shortlinkResponse(code, fullPath)
+	resp.ExpiresAt = expiresAt
+	if created {
+		// The link works without it; its stats are then only the admin's
+		if resp.StatsToken, err = issueShortlinkStatsToken(code); err != nil {
+			slog.Error("shortlink stats token failed", "code", code, "error", err)
+		}
+	}
+	writeJSON(w, status, resp)
+}
+
+// checkAnonymousShortlink runs the checks of a shortlink created without an
+// API token, by POST /s or /api/share: the origin and CSRF token, the
+// CAPTCHA and proof of work, which logged-in creators skip, and the per-IP
+// rate limit. It returns the logged-in creator, if any, and the proof-of-work
+// difficulty solved, which the spam score asks for more of; on failure it
+// has written the answer.
+func checkAnonymousShortlink(w http.ResponseWriter, r *http.Request) (owner string, powSolved int, ok bool) {
+	if !checkCSRF(w, r) {
+		return "", 0, false
+	}
+	if owner = sessionEmail(r); owner == "" {
+		if !checkCaptcha(w, r) {
+			return "", 0, false
+		}
+		if powSolved, ok = checkPow(w, r); !ok {
+			return "", 0, false
+		}
+	}
+	if !shortlinkLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return "", 0, false
+	}
+	return owner, powSolved, true
+}
+
+func normalizeGreetingPath(path string) string {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	pathOnly, query, hasQuery := strings.Cut(path, "?")
+	if path = canonicalPath(pathOnly); hasQuery {
+		path += "?" + query
+	}
+	return path
+}
 
-	// Extract just the message for blocking check
-	pathOnly := fullPath
-	if idx := strings.Index(pathOnly, "?"); idx != -1 {
-		pathOnly = pathOnly[:idx]
+// validateGreetingPath applies the checks a greeting path must pass before
+// the server stores or sends it, returning the HTTP status to reply with.
+func validateGreetingPath(fullPath string) int {
+	status, _ := checkGreetingPath(fullPath)
+	return status
+}
+
+// checkGreetingPath is validateGreetingPath with the API error code of a
+// rejected path.
+func checkGreetingPath(fullPath string) (int, string) {
+	// Check the path as the page will see it: percent-decoded once by
+	// net/http, then sanitized
+	escapedPath, rawQuery, _ := strings.Cut(fullPath, "?")
+	pathOnly, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return http.StatusBadRequest, "invalid_path"
+	}
+	if pathOnly, err = sanitizePath(pathOnly); err == errPathTooLong {
+		return http.StatusRequestURITooLong, "path_too_long"
+	} else if err != nil {
+		return http.StatusBadRequest, "invalid_path"
 	}
 	_, rawMessage := parseOccasionFromPath(pathOnly)
 	message := decodePath(rawMessage)
 	if message == "" {
-		http.Error(w, "", http.StatusBadRequest)
-		return
+		return http.StatusBadRequest, "empty_message"
+	}
+	if looksLikePath(message) {
+		return http.StatusBadRequest, "invalid_path"
 	}
 	if isBlockedMessage(message) {
-		http.Error(w, "", http.StatusForbidden)
-		return
+		return http.StatusForbidden, "blocked_message"
 	}
-
-	shortlinks.mu.Lock()
-	if code, ok := shortlinks.byPath[fullPath]; ok {
-		resp := shortlinkResponse(code, fullPath)
-		shortlinks.mu.Unlock()
-		writeJSON(w, http.StatusOK, resp)
-		return
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return http.StatusBadRequest, "invalid_query"
 	}
-
-	var code string
-	for i := 0; i < 10; i++ {
-		code = generateCode(shortCodeLen)
-		if _, exists := shortlinks.byCode[code]; !exists {
-			break
+	if _, err := parseName(query.Get("de")); err != nil {
+		if err == errNameBlocked {
+			return http.StatusForbidden, "blocked_name"
 		}
+		return http.StatusBadRequest, "invalid_name"
 	}
-	if code == "" || shortlinks.byCode[code] != "" {
-		shortlinks.mu.Unlock()
-		http.Error(w, "", http.StatusServiceUnavailable)
-		return
+	if _, err := greetingAge(pathOnly, query); err != nil {
+		return http.StatusBadRequest, "invalid_age"
 	}
-
-	shortlinks.byCode[code] = fullPath
-	shortlinks.byPath[fullPath] = code
-	if err := persistShortlinksLocked(); err != nil {
-		delete(shortlinks.byCode, code)
-		delete(shortlinks.byPath, fullPath)
-		shortlinks.mu.Unlock()
-		http.Error(w, "", http.StatusInternalServerError)
-		return
+	if _, err := parseBirthdate(query.Get("nascimento"), time.Now()); err != nil {
+		return http.StatusBadRequest, "invalid_birthdate"
 	}
-	resp := shortlinkResponse(code, fullPath)
-	shortlinks.mu.Unlock()
-	writeJSON(w, http.StatusCreated, resp)
+	return http.StatusOK, ""
 }
 
 func handleShortlinkRedirect(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/s/")
+	if statsCode, ok := strings.CutSuffix(code, "/stats"); ok {
+		handleShortlinkStats(w, r, statsCode)
+		return
+	}
+	// Codes have no dots, so the suffix cannot be part of one
+	if previewCode, ok := strings.CutSuffix(code, ".json"); ok {
+		handleShortlinkPreview(w, r, previewCode)
+		return
+	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 	if err := ensureShortlinksLoaded(); err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível abrir este link agora. Tente de novo mais tarde."))
 		return
 	}
-
-	code := strings.TrimPrefix(r.URL.Path, "/s/")
+	notFound := messagePage("Link não encontrado", "Link não encontrado",
+		"Este link não existe. Confira se ele foi copiado inteiro ou peça um novo a quem enviou a mensagem.")
 	if code == "" {
-		http.Error(w, "", http.StatusNotFound)
+		writeHTML(w, r, http.StatusNotFound, notFound)
 		return
 	}
 
-	shortlinks.mu.Lock()
+	shortlinks.mu.RLock()
 	path, ok := shortlinks.byCode[code]
-	shortlinks.mu.Unlock()
+	shortlinks.mu.RUnlock()
 	if !ok {
-		http.Error(w, "", http.StatusNotFound)
+		if !redirectToCanonicalCode(w, r, code, "") {
+			writeHTML(w, r, http.StatusNotFound, notFound)
+		}
 		return
 	}
 
-	// New format: path starts with "/" (includes occasion/query)
-	// Old format: just the message (e.g., "João")
-	var redirectURL string
-	if strings.HasPrefix(path, "/") {
-		redirectURL = path
+	keys := []string{"shortlinks", "shortlink-" + code, greetingSurrogateKey(path)}
+	if meta, ok := shortlinkMetaOf(code); ok && meta.ExpiresAt != nil {
+		now := time.Now()
+		if meta.expired(now) {
+			setCacheHeaders(w, cacheExpiredShortlinks, keys...)
+			writeHTML(w, r, http.StatusGone, messagePage("Link expirado", "Link expirado",
+				"Este link tinha prazo de validade e já expirou. Peça um novo link a quem enviou a mensagem."))
+			return
+		}
+		setCacheHeaders(w, expiringShortlinkCache(*meta.ExpiresAt, now), keys...)
 	} else {
-		// Backwards compatibility: encode old-style message
-		encoded := encodePathSegment(path)
-		if encoded == "" {
-			http.Error(w, "", http.StatusNotFound)
+		setCacheHeaders(w, cacheRedirects, keys...)
+	}
+	// Clicks served from a cache are not counted, nor those of crawlers
+	if !readOnly() && !isCrawler(r) {
+		recordShortlinkClick(code, r.Referer(), time.Now())
+	}
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+// redirectToCanonicalCode answers a code typed in another case, or with 0
+// for o, with a permanent redirect to the link's own code followed by
+// suffix. It reports false, writing nothing, when no code or several fold
+// like code.
+func redirectToCanonicalCode(w http.ResponseWriter, r *http.Request, code, suffix string) bool {
+	canonical, ok := canonicalShortCode(code)
+	if !ok || canonical == code {
+		return false
+	}
+	setCacheHeaders(w, cacheRedirects, "shortlinks", "shortlink-"+canonical)
+	http.Redirect(w, r, "/s/"+url.PathEscape(canonical)+suffix, http.StatusMovedPermanently)
+	return true
+}
+
+// expiringShortlinkCache is the Cache-Control of a live expiring link: a
+// cache must not serve it past the expiry.
+func expiringShortlinkCache(expiresAt, now time.Time) string {
+	left := int(expiresAt.Sub(now) / time.Second)
+	return fmt.Sprintf("public, max-age=%d", min(left, 3600))
+}
+
+// ShortlinkPreview is what GET /s/{code}.json tells of a shortlink without
+// following it: where it leads and the title and image of that page.
+type ShortlinkPreview struct {
+	Code        string     `json:"code"`
+	Path        string     `json:"path"`
+	Destination string     `json:"destination"`
+	Title       string     `json:"title"`
+	OgImage     string     `json:"og_image"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// handleShortlinkPreview serves GET /s/{code}.json. Unlike the redirect
+// it is not counted as a click.
+func handleShortlinkPreview(w http.ResponseWriter, r *http.Request, code string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if err := ensureShortlinksLoaded(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	shortlinks.mu.RLock()
+	path, ok := shortlinks.byCode[code]
+	shortlinks.mu.RUnlock()
+	if !ok || code == "" {
+		if !redirectToCanonicalCode(w, r, code, ".json") {
+			writeAPIError(w, http.StatusNotFound, "not_found")
+		}
+		return
+	}
+
+	keys := []string{"shortlinks", "shortlink-" + code, greetingSurrogateKey(path)}
+	meta, _ := shortlinkMetaOf(code)
+	if meta.ExpiresAt != nil {
+		now := time.Now()
+		if meta.expired(now) {
+			setCacheHeaders(w, cacheExpiredShortlinks, keys...)
+			writeAPIError(w, http.StatusGone, "link_expired")
 			return
 		}
-		redirectURL = "/" + encoded
+		setCacheHeaders(w, expiringShortlinkCache(*meta.ExpiresAt, now), keys...)
+	} else {
+		setCacheHeaders(w, cacheRedirects, keys...)
 	}
 
-	http.Redirect(w, r, redirectURL, http.StatusFound)
+	link := shortlinkResponse(code, path)
+	g := shortlinkGreeting(path)
+	writeJSON(w, http.StatusOK, ShortlinkPreview{
+		Code:        code,
+		Path:        link.Path,
+		Destination: link.Destination,
+		Title:       g.Title,
+		OgImage:     g.OgImage,
+		ExpiresAt:   meta.ExpiresAt,
+	})
+}
+
+// shortlinkGreeting builds the greeting of a stored path with the options
+// of its query, as its page shows it.
+func shortlinkGreeting(fullPath string) greeting {
+	pathOnly, rawQuery, _ := strings.Cut(fullPath, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	return buildGreeting(pathOnly, greetingPageOptions(pathOnly, query))
+}
+
+// bareRoutePrefixes are the routes of handlePage that take a greeting path
+// and are not a page without one, so their trailing slash is kept: "/tts/"
+// must not become the greeting "/tts".
+var bareRoutePrefixes = []string{"/print/", "/contagem/", "/tts/", "/pdf/", "/p/"}
+
+// pageRoutes are the paths and prefixes handlePage serves before it reads
+// the path as a greeting.
+var pageRoutes = []string{
+	"/privacy", "/styles.css", "/app.js", "/countdown.js", "/print.css",
+	"/theme.css", "/random", "/card.js", "/cartao", "/retrospectiva",
+	"/ocasioes", "/favicon.svg", "/og-image.svg", "/og-image.png",
+	"/print/", "/ocasioes/", "/cartao/", "/retrospectiva/", "/contagem/",
+	"/tts/", "/pdf/", "/p/",
+}
+
+// routeWords returns the first segment, without extension, of every route
+// of the mux and of handlePage: the words a shortlink code or an occasion
+// prefix would be shadowed by.
+func routeWords() []string {
+	paths := slices.Clone(pageRoutes)
+	for _, route := range routes() {
+		paths = append(paths, route.pattern)
+	}
+	var words []string
+	for _, path := range paths {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		segment, _, _ = strings.Cut(segment, ".")
+		if segment != "" {
+			words = append(words, segment)
+		}
+	}
+	return words
 }
 
 func handlePage(w http.ResponseWriter, r *http.Request) {
 	if len(r.URL.Path) > maxPathLen {
-		writeHTML(w, http.StatusRequestURITooLong, errorPage("A mensagem é muito longa. Encurte o texto e tente novamente."))
+		writeHTML(w, r, http.StatusRequestURITooLong, errorPage("A mensagem é muito longa. Encurte o texto e tente novamente."))
+		return
+	}
+	path, err := sanitizePath(r.URL.Path)
+	if err == errPathTooLong {
+		writeHTML(w, r, http.StatusRequestURITooLong, errorPage("A mensagem é muito longa. Encurte o texto e tente novamente."))
+		return
+	} else if err != nil {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("Este endereço não é válido."))
+		return
+	}
+	canonical := canonicalPath(path)
+	if slices.Contains(bareRoutePrefixes, canonical+"/") {
+		canonical += "/"
+	}
+	if canonical != path {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			target := (&url.URL{Path: canonical, RawQuery: r.URL.RawQuery}).String()
+			setCacheHeaders(w, cacheRedirects)
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		path = canonical
+	}
+	r.URL.Path, r.URL.RawPath = path, ""
+
+	// Protected greetings also take the passphrase form's POST
+	if id, ok := strings.CutPrefix(r.URL.Path, "/p/"); ok && id != "" && !strings.Contains(id, "/") {
+		handleProtectedPage(w, r, id)
 		return
 	}
 
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
 		return
 	}
 
@@ -190,30 +485,101 @@ func handlePage(w http.ResponseWriter, r *http.Request) {
 		serveIndex(w, r, "")
 		return
 	case "/privacy":
-		serveEmbedded(w, r, "public/privacy.html", "text/html; charset=utf-8", "")
+		var b strings.Builder
+		if err := privacyTemplate.Execute(&b, siteIdentity()); err != nil {
+			slog.Error("privacy render failed", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeHTML(w, r, http.StatusOK, b.String())
 		return
 	case "/styles.css":
-		serveEmbedded(w, r, "public/styles.css", "text/css; charset=utf-8", "public, max-age=300")
+		serveEmbedded(w, r, "public/styles.css", "text/css; charset=utf-8", cacheStaticAssets)
 		return
 	case "/app.js":
-		serveEmbedded(w, r, "public/app.js", "application/javascript; charset=utf-8", "public, max-age=300")
+		serveEmbedded(w, r, "public/app.js", "application/javascript; charset=utf-8", cacheStaticAssets)
+		return
+	case "/countdown.js":
+		serveEmbedded(w, r, "public/countdown.js", "application/javascript; charset=utf-8", cacheStaticAssets)
+		return
+	case "/print.css":
+		serveEmbedded(w, r, "public/print.css", "text/css; charset=utf-8", cacheStaticAssets)
+		return
+	case "/theme.css":
+		handleThemeCSS(w, r)
+		return
+	case "/random":
+		handleRandom(w, r)
+		return
+	case "/card.js":
+		serveEmbedded(w, r, "public/card.js", "application/javascript; charset=utf-8", cacheStaticAssets)
+		return
+	case "/cartao":
+		handleCardPage(w, r, "")
+		return
+	case "/retrospectiva":
+		handleRetrospective(w, r, "")
+		return
+	case "/ocasioes":
+		handleOccasionsPage(w, r, "")
 		return
 	case "/favicon.svg":
-		serveEmbedded(w, r, "public/favicon.svg", "image/svg+xml", "public, max-age=86400")
+		serveEmbedded(w, r, "public/favicon.svg", "image/svg+xml", cacheStaticMedia)
 		return
 	case "/og-image.svg":
-		serveEmbedded(w, r, "public/og-image.svg", "image/svg+xml", "public, max-age=86400")
+		serveEmbedded(w, r, "public/og-image.svg", "image/svg+xml", cacheStaticMedia)
 		return
 	case "/og-image.png":
 		handleOgImage(w, r)
 		return
 	default:
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/print/"); ok {
+			serveGreeting(w, r, "/"+rest, printTemplate)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/ocasioes/"); ok {
+			handleOccasionsPage(w, r, rest)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/cartao/"); ok {
+			handleCardPage(w, r, rest)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/retrospectiva/"); ok {
+			handleRetrospective(w, r, rest)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/contagem/"); ok {
+			handleCountdown(w, r, rest)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/tts/"); ok {
+			handleSpeech(w, r, "/"+rest)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/pdf/"); ok {
+			handleCardPDF(w, r, "/"+rest)
+			return
+		}
+		if prefix, ok := subdomainOccasion(r.Host); ok {
+			serveIndex(w, r, subdomainPath(prefix, r.URL.Path))
+			return
+		}
 		serveIndex(w, r, r.URL.Path)
 		return
 	}
 }
 
 func serveIndex(w http.ResponseWriter, r *http.Request, path string) {
+	tpl := indexTemplate
+	if r.URL.Query().Get("print") == "1" {
+		tpl = printTemplate
+	}
+	serveGreeting(w, r, path, tpl)
+}
+
+// serveGreeting renders a greeting path with the page or print template.
+func serveGreeting(w http.ResponseWriter, r *http.Request, path string, tpl *template.Template) {
 	_, rawMessage := parseOccasionFromPath(path)
 	message := decodePath(rawMessage)
 	if looksLikePath(message) {
@@ -221,13 +587,71 @@ func serveIndex(w http.ResponseWriter, r *http.Request, path string) {
 		return
 	}
 	if isBlockedMessage(message) {
-		writeHTML(w, http.StatusForbidden, errorPage("Esta mensagem não está disponível."))
+		writeHTML(w, r, http.StatusForbidden, errorPage("Esta mensagem não está disponível."))
 		return
 	}
-	theme := r.URL.Query().Get("theme")
-	rendered := renderIndexHTML(indexTemplate, path, theme)
-	w.Header().Set("Cache-Control", "public, max-age=300")
-	writeHTML(w, http.StatusOK, rendered)
+	query := r.URL.Query()
+	if _, err := parseName(query.Get("de")); err == errNameBlocked {
+		writeHTML(w, r, http.StatusForbidden, errorPage("Esta mensagem não está disponível."))
+		return
+	}
+	if _, err := greetingAge(path, query); err != nil {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("Idade inválida."))
+		return
+	}
+	if _, err := parseBirthdate(query.Get("nascimento"), time.Now()); err != nil {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("Data de nascimento inválida."))
+		return
+	}
+	opts := greetingPageOptions(path, query)
+	opts.decodedMessage = message
+	if text, _ := textCardMode(r); tpl != indexTemplate || (!text && !wantsJSON(r)) {
+		sendPreloadHints(w, tpl, opts)
+	}
+	if key, _ := guestbookTarget(path); key != "" {
+		entries, err := guestbookEntries(key)
+		if err != nil {
+			slog.Error("guestbook load failed", "error", err)
+		}
+		opts.Guestbook = entries
+	}
+	if count, err := viewCount(path); err != nil {
+		slog.Error("view count load failed", "error", err)
+	} else {
+		opts.Views = count
+	}
+	// The composer's experiment variants depend on the visitor's IP
+	if tpl == indexTemplate && message == "" && len(activeExperiments()) > 0 {
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		if text, _ := textCardMode(r); !text && !isCrawler(r) {
+			applyExperiments(&opts, clientIP(r))
+		}
+	}
+	// Callers serving private greetings (/p/) set their own policy
+	if w.Header().Get("Cache-Control") == "" {
+		setCacheHeaders(w, cachePages, "pages", greetingSurrogateKey(path))
+	}
+	if tpl == indexTemplate {
+		w.Header().Add("Vary", "Accept")
+		if pickedByUserAgent(r) && strings.HasPrefix(w.Header().Get("Cache-Control"), "public") {
+			w.Header().Set("Cache-Control", "private, max-age=300")
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, cardJSON(path, r.URL.RawQuery, opts))
+			return
+		}
+		if text, color := textCardMode(r); text {
+			writeText(w, r, http.StatusOK, renderTextCard(buildGreeting(path, opts), opts.Sender, color))
+			return
+		}
+	}
+	rendered, err := renderIndexHTML(tpl, path, opts)
+	if err != nil {
+		slog.Error("index render failed", "error", err)
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível montar esta página."))
+		return
+	}
+	writeHTMLConditional(w, r, rendered)
 }
 
 func serveEmbedded(w http.ResponseWriter, r *http.Request, name, contentType, cacheControl string) {
@@ -238,10 +662,24 @@ func serveEmbedded(w http.ResponseWriter, r *http.Request, name, contentType, ca
 	}
 	w.Header().Set("Content-Type", contentType)
 	if cacheControl != "" {
-		w.Header().Set("Cache-Control", cacheControl)
-	}
+		setCacheHeaders(w, cacheControl, "static")
+	}
+	gzipped, hasGzip := gzippedAssets[name]
+	brotli, hasBrotli := brotliAssets[name]
+	if hasGzip || hasBrotli {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	accept := r.Header.Get("Accept-Encoding")
+	switch {
+	case hasBrotli && acceptsEncoding(accept, "br"):
+		w.Header().Set("Content-Encoding", "br")
+		data = brotli
+	case hasGzip && acceptsEncoding(accept, "gzip"):
+		w.Header().Set("Content-Encoding", "gzip")
+		data = gzipped
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
 	if r.Method == http.MethodHead {
-		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
 		return
 	}
 	_, _ = w.Write(data)
@@ -249,29 +687,61 @@ func serveEmbedded(w http.ResponseWriter, r *http.Request, name, contentType, ca
 
 func handleOgImage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
 		return
 	}
-	text := ogImageTextPrefix(r.URL.Query().Get("text"))
-	if text == "" || looksLikePath(text) || isBlockedMessage(text) {
-		serveEmbedded(w, r, "public/og-image.png", "image/png", "public, max-age=86400")
+	spec, ok := ogImageSpecFromQuery(r.URL.Query())
+	if !ok {
+		serveEmbedded(w, r, "public/og-image.png", "image/png", cacheStaticMedia)
+		return
+	}
+	if !rendererStatus().Available || !diskHealthy("cache") {
+		// Degraded: briefly, so images are rendered once the renderer or
+		// disk is back
+		serveEmbedded(w, r, "public/og-image.png", "image/png", "public, max-age=300")
 		return
 	}
-	key := ogCacheKey(text)
+	key := spec.cacheKey()
 	cachePath := ogCachePath(key)
 	if ok, err := fileExists(cachePath); ok && err == nil {
-		writePngFile(w, r, cachePath)
+		writeCacheFile(w, r, cachePath, "image/png", "og", ogSurrogateKey(key))
 		return
 	}
-	if err := ogQueue.render(key, text); err != nil {
-		slog.Error("og-image render failed", "error", err)
-		serveEmbedded(w, r, "public/og-image.png", "image/png", "public, max-age=86400")
+	if err := ogQueue.render(key, spec); err != nil {
+		if !errors.Is(err, errRenderCircuitOpen) {
+			slog.Error("og-image render failed", "error", err)
+		}
+		// Briefly, so the image is rendered again soon
+		serveEmbedded(w, r, "public/og-image.png", "image/png", "public, max-age=300")
 		return
 	}
-	writePngFile(w, r, cachePath)
+	writeCacheFile(w, r, cachePath, "image/png", "og", ogSurrogateKey(key))
 }
 
-func writePngFile(w http.ResponseWriter, r *http.Request, path string) {
+// ogImageSpecFromQuery parses the parameters written by ogAssetURL. It
+// reports false when the text is missing or not allowed.
+func ogImageSpecFromQuery(query url.Values) (ogImageSpec, bool) {
+	text := ogImageTextPrefix(query.Get("text"))
+	if text == "" || looksLikePath(text) || isBlockedMessage(text) {
+		return ogImageSpec{}, false
+	}
+	spec := ogImageSpec{
+		Text:   text,
+		Photo:  photoID(query.Get("foto")),
+		Accent: accentColor(query.Get("cor")),
+		Emoji:  emojiName(query.Get("emoji")),
+		Theme:  themeName(query.Get("theme")),
+		Locale: localeName(query.Get("lang")),
+	}
+	if occ, ok := lookupOccasion(query.Get("occasion")); ok && occ.OgTemplate != "" {
+		spec.Occasion = occ.Prefix
+	}
+	return spec, true
+}
+
+// writeCacheFile serves a rendered file from the disk cache, tagged with
+// the surrogate keys.
+func writeCacheFile(w http.ResponseWriter, r *http.Request, path, contentType string, keys ...string) {
 	file, err := os.Open(path)
 	if err != nil {
 		http.Error(w, "", http.StatusNotFound)
@@ -283,8 +753,8 @@ func writePngFile(w http.ResponseWriter, r *http.Request, path string) {
 		http.Error(w, "", http.StatusNotFound)
 		return
 	}
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Type", contentType)
+	setCacheHeaders(w, cacheOgImages, keys...)
 	w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
 	if r.Method == http.MethodHead {
 		return
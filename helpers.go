@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type rateLimiter struct {
@@ -47,16 +52,142 @@ func (rl *rateLimiter) allow(key string) bool {
 	return true
 }
 
+// storeFlush has a store written delay after it changes, so the requests
+// changing it on every page view do not each wait for a write of the whole
+// store. A failed write is retried after the same delay.
+type storeFlush struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	dirty bool
+	name  string
+	delay time.Duration
+	write func() error // takes the store's lock
+}
+
+// schedule marks the store as changed and has it written in delay, unless
+// a write is already scheduled.
+func (f *storeFlush) schedule() {
+	if readOnly() {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dirty = true
+	if f.timer != nil {
+		return
+	}
+	f.timer = time.AfterFunc(f.delay, func() {
+		if err := f.flush(); err != nil {
+			slog.Error(f.name+" store write failed", "error", err)
+		}
+	})
+}
+
+// flush writes the store now if it changed since it was last written.
+func (f *storeFlush) flush() error {
+	f.mu.Lock()
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	dirty := f.dirty
+	f.dirty = false
+	f.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+	if err := f.write(); err != nil {
+		f.schedule()
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to path through a temporary file synced
+// before the rename, so a crash leaves the old file or the whole new one,
+// never a truncated one.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 func decodePath(raw string) string {
 	if raw == "" {
 		return ""
 	}
 	decoded, err := urlPathUnescape(raw)
 	if err != nil {
-		return raw
+		decoded = raw
+	}
+	decoded = stripInvalidText(decoded)
+	// One pass doing what messageLines does, with "_" as a space: this
+	// runs on every page view.
+	if !strings.ContainsAny(decoded, "_~\n\r") && strings.TrimSpace(decoded) == decoded {
+		return decoded
+	}
+	var b strings.Builder
+	b.Grow(len(decoded))
+	for rest := decoded; rest != ""; {
+		line := rest
+		if i := strings.IndexAny(rest, "~\n"); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+		line = strings.TrimFunc(line, isPathSpace)
+		if line == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		for i := 0; i < len(line); i++ {
+			switch c := line[i]; c {
+			case '\r':
+			case '_':
+				b.WriteByte(' ')
+			default:
+				b.WriteByte(c)
+			}
+		}
 	}
-	decoded = strings.ReplaceAll(decoded, "_", " ")
-	return strings.TrimSpace(decoded)
+	return b.String()
+}
+
+// isPathSpace reports whether r is a space in a greeting path, where "_"
+// stands for one.
+func isPathSpace(r rune) bool {
+	return r == '_' || unicode.IsSpace(r)
+}
+
+var lineBreakReplacer = strings.NewReplacer("~", "\n", "\r", "")
+
+// messageLines splits a message on the line-break tokens ("~" or a newline,
+// %0A in paths), trimming each line and dropping empty ones.
+func messageLines(message string) []string {
+	message = lineBreakReplacer.Replace(message)
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
 }
 
 func encodePathSegment(value string) string {
@@ -75,7 +206,7 @@ func urlPathUnescape(s string) (string, error) {
 func publicBaseURL() string {
 	base := os.Getenv("PUBLIC_BASE_URL")
 	if base == "" {
-		return "https://" + siteDomain
+		return "https://" + siteIdentity().Domain
 	}
 	return base
 }
@@ -88,15 +219,47 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 	_, _ = w.Write(data)
 }
 
-func writeHTML(w http.ResponseWriter, status int, body string) {
+// methodNotAllowed answers a request whose method the handler does not
+// take, listing the ones it does in Allow: 204 to OPTIONS, which every route
+// answers this way, and 405 to anything else.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, methods ...string) {
+	w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if isAPIRequest(r) {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	http.Error(w, "", http.StatusMethodNotAllowed)
+}
+
+// writeHTML writes a rendered page, or only its headers, Content-Length
+// included, to a HEAD request.
+func writeHTML(w http.ResponseWriter, r *http.Request, status int, body string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
 	w.WriteHeader(status)
-	_, _ = w.Write([]byte(body))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.WriteString(w, body)
 }
 
 func errorPage(message string) string {
-	return fmt.Sprintf("<!DOCTYPE html><html lang=\"pt-BR\"><head><meta charset=\"utf-8\"><meta name=\"viewport\" content=\"width=device-width,initial-scale=1\"><title>Erro</title><style>body{font-family:system-ui,Arial,sans-serif;background:#0f172a;color:#f8fafc;display:flex;align-items:center;justify-content:center;min-height:100vh;margin:0}.card{max-width:520px;padding:24px;border:1px solid rgba(148,163,184,.3);border-radius:16px;background:rgba(15,23,42,.85);text-align:center}</style></head><body><div class=\"card\"><h1>Ops!</h1><p>%s</p><a href=\"/\" style=\"color:#93c5fd\">Voltar</a></div></body></html>", escapeHTML(message))
+	return messagePage("Erro", "Ops!", message)
+}
+
+func messagePage(title, heading, message string) string {
+	return fmt.Sprintf("<!DOCTYPE html><html lang=\"pt-BR\"><head><meta charset=\"utf-8\"><meta name=\"viewport\" content=\"width=device-width,initial-scale=1\"><title>%s</title><style>body{font-family:system-ui,Arial,sans-serif;background:#0f172a;color:#f8fafc;display:flex;align-items:center;justify-content:center;min-height:100vh;margin:0}.card{max-width:520px;padding:24px;border:1px solid rgba(148,163,184,.3);border-radius:16px;background:rgba(15,23,42,.85);text-align:center}</style></head><body><div class=\"card\"><h1>%s</h1><p>%s</p><a href=\"/\" style=\"color:#93c5fd\">Voltar</a></div></body></html>", escapeHTML(title), escapeHTML(heading), escapeHTML(message))
+}
+
+// formPage is a messagePage whose message is followed by a form posting to
+// action, for links whose action must not run on a GET.
+func formPage(title, heading, message, action, button string) string {
+	form := fmt.Sprintf("<form method=\"post\" action=\"%s\"><button type=\"submit\">%s</button></form>", escapeHTML(action), escapeHTML(button))
+	return strings.Replace(messagePage(title, heading, message), "<a href=\"/\"", form+"<a href=\"/\"", 1)
 }
 
 func readLimitedBody(r *http.Request, max int64) ([]byte, error) {
@@ -164,56 +327,120 @@ func fileExists(path string) (bool, error) {
 	return !info.IsDir(), nil
 }
 
-// Valid theme names
-var validThemes = map[string]bool{
-	"":        true, // default
-	"light":   true,
-	"warm":    true,
-	"elegant": true,
-	"pixel":   true,
+func themeClass(theme string) string {
+	t, ok := lookupTheme(theme)
+	if !ok {
+		return ""
+	}
+	return t.Class
+}
+
+// themeName returns the canonical name of a known, non-default theme.
+func themeName(theme string) string {
+	t, ok := lookupTheme(theme)
+	if !ok {
+		return ""
+	}
+	return t.Name
 }
 
-func themeClass(theme string) string {
-	theme = strings.ToLower(strings.TrimSpace(theme))
-	if !validThemes[theme] || theme == "" {
+// paperSize validates ?papel= for the print view; A5 is the default.
+func paperSize(value string) string {
+	if strings.EqualFold(strings.TrimSpace(value), "a4") {
+		return "a4"
+	}
+	return "a5"
+}
+
+// accentColor validates ?cor=RRGGBB, returning the lowercase hex digits (no
+// "#") or "" for anything else, so only a color ever reaches CSS or SVG.
+func accentColor(value string) string {
+	if len(value) != 6 {
 		return ""
 	}
-	return "theme-" + theme
+	for _, r := range value {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') && (r < 'A' || r > 'F') {
+			return ""
+		}
+	}
+	return strings.ToLower(value)
+}
+
+// Valid celebration effects (?efeito=); empty means balloons + confetti
+var validEffects = map[string]bool{
+	"confete": true,
+	"baloes":  true,
+	"fogos":   true,
+	"neve":    true,
+}
+
+func effectClass(effect string) string {
+	effect = strings.ToLower(strings.TrimSpace(effect))
+	if !validEffects[effect] {
+		return ""
+	}
+	return "effect-" + effect
 }
 
 // Occasion defines a celebration type with its display properties
 type Occasion struct {
-	Prefix   string // URL prefix (e.g., "aniversario")
-	Greeting string // Greeting text (e.g., "Feliz Aniversário")
-	Subtitle string // Subtitle text
-	Emoji    string // Emoji for subtitle
+	Prefix     string `json:"prefix"`                // URL prefix (e.g., "aniversario")
+	Greeting   string `json:"greeting"`              // Greeting text (e.g., "Feliz Aniversário")
+	Subtitle   string `json:"subtitle"`              // Subtitle text
+	SubtitleF  string `json:"subtitle_f,omitempty"`  // Optional feminine subtitle
+	SubtitleM  string `json:"subtitle_m,omitempty"`  // Optional masculine subtitle
+	SubtitleP  string `json:"subtitle_p,omitempty"`  // Optional plural subtitle
+	Emoji      string `json:"emoji"`                 // Emoji for subtitle
+	OgTemplate string `json:"og_template,omitempty"` // Optional SVG template path for OG images
+	Animation  string `json:"animation,omitempty"`   // Lottie animation: confete (default), bolo or fogos
+
+	ogTemplateSum string // hash of OgTemplate when the configuration was loaded
+}
+
+// subtitleFor returns the subtitle agreeing with gender and number, falling
+// back to the neutral one.
+func (o Occasion) subtitleFor(gender string, plural bool) string {
+	subtitle := inflect(gender, o.SubtitleF, o.SubtitleM, "")
+	if plural {
+		subtitle = o.SubtitleP
+	}
+	if subtitle == "" {
+		return o.Subtitle
+	}
+	return subtitle
 }
 
 var defaultOccasion = Occasion{
-	Prefix:   "",
-	Greeting: "Parabéns",
-	Subtitle: "Celebrando com balões e confetes",
-	Emoji:    "🎉",
+	Prefix:    "",
+	Greeting:  "Parabéns",
+	Subtitle:  "Celebrando com balões e confetes",
+	SubtitleP: "Vocês merecem balões e confetes",
+	Emoji:     "🎉",
 }
 
 var occasions = map[string]Occasion{
 	"aniversario": {
-		Prefix:   "aniversario",
-		Greeting: "Feliz Aniversário",
-		Subtitle: "Celebrando mais um ano de vida",
-		Emoji:    "🎂",
+		Prefix:    "aniversario",
+		Greeting:  "Feliz Aniversário",
+		Subtitle:  "Celebrando mais um ano de vida",
+		Emoji:     "🎂",
+		Animation: lottieCake,
 	},
 	"formatura": {
-		Prefix:   "formatura",
-		Greeting: "Parabéns pela formatura",
-		Subtitle: "Uma conquista para celebrar",
-		Emoji:    "🎓",
+		Prefix:    "formatura",
+		Greeting:  "Parabéns pela formatura",
+		Subtitle:  "Uma conquista para celebrar",
+		SubtitleF: "Formada e pronta para conquistar o mundo",
+		SubtitleM: "Formado e pronto para conquistar o mundo",
+		SubtitleP: "Vocês merecem comemorar essa conquista",
+		Emoji:     "🎓",
 	},
 	"promocao": {
-		Prefix:   "promocao",
-		Greeting: "Parabéns pela promoção",
-		Subtitle: "Seu esforço foi reconhecido",
-		Emoji:    "🏆",
+		Prefix:    "promocao",
+		Greeting:  "Parabéns pela promoção",
+		Subtitle:  "Seu esforço foi reconhecido",
+		SubtitleP: "O esforço de vocês foi reconhecido",
+		Emoji:     "🏆",
 	},
 	"casamento": {
 		Prefix:   "casamento",
@@ -222,94 +449,487 @@ var occasions = map[string]Occasion{
 		Emoji:    "💒",
 	},
 	"boas-vindas": {
-		Prefix:   "boas-vindas",
-		Greeting: "Boas-vindas",
-		Subtitle: "É um prazer ter você aqui",
-		Emoji:    "👋",
+		Prefix:    "boas-vindas",
+		Greeting:  "Boas-vindas",
+		Subtitle:  "É um prazer ter você aqui",
+		SubtitleF: "Seja muito bem-vinda",
+		SubtitleM: "Seja muito bem-vindo",
+		SubtitleP: "É um prazer ter vocês aqui",
+		Emoji:     "👋",
+	},
+	"natal": {
+		Prefix:    "natal",
+		Greeting:  "Feliz Natal",
+		Subtitle:  "Que a magia do Natal ilumine seus dias",
+		SubtitleP: "Que a magia do Natal ilumine os dias de vocês",
+		Emoji:     "🎄",
+	},
+	"ano-novo": {
+		Prefix:    "ano-novo",
+		Greeting:  "Feliz Ano Novo",
+		Subtitle:  "Um novo ciclo cheio de conquistas",
+		Emoji:     "🎆",
+		Animation: lottieFireworks,
+	},
+	"dia-das-maes": {
+		Prefix:    "dia-das-maes",
+		Greeting:  "Feliz Dia das Mães",
+		Subtitle:  "Celebrando todo o seu amor e carinho",
+		SubtitleP: "Celebrando todo o amor e carinho de vocês",
+		Emoji:     "💐",
+	},
+	"dia-dos-pais": {
+		Prefix:    "dia-dos-pais",
+		Greeting:  "Feliz Dia dos Pais",
+		Subtitle:  "Celebrando seu exemplo e dedicação",
+		SubtitleP: "Celebrando o exemplo e a dedicação de vocês",
+		Emoji:     "👔",
 	},
+	"pascoa": {
+		Prefix:   "pascoa",
+		Greeting: "Feliz Páscoa",
+		Subtitle: "Tempo de renovação e esperança",
+		Emoji:    "🐣",
+	},
+	"aposentadoria": {
+		Prefix:    "aposentadoria",
+		Greeting:  "Feliz Aposentadoria",
+		Subtitle:  "Uma nova fase para aproveitar",
+		SubtitleF: "Aposentada e pronta para aproveitar",
+		SubtitleM: "Aposentado e pronto para aproveitar",
+		SubtitleP: "Vocês merecem aproveitar essa nova fase",
+		Emoji:     "🏖️",
+	},
+	"bodas": {
+		Prefix:   "bodas",
+		Greeting: "Felicidades pelas bodas",
+		Subtitle: "Celebrando anos de amor e parceria",
+		Emoji:    "💍",
+	},
+}
+
+// lookupOccasion resolves a URL prefix to an occasion, preferring the ones
+// defined in the config file over the built-in catalog.
+func lookupOccasion(prefix string) (Occasion, bool) {
+	prefix = strings.ToLower(prefix)
+	configMu.RLock()
+	occ, ok := customOccasions[prefix]
+	configMu.RUnlock()
+	if ok {
+		return occ, true
+	}
+	occ, ok = occasions[prefix]
+	return occ, ok
 }
 
 // parseOccasionFromPath extracts occasion prefix and remaining message from path
 // e.g., "/aniversario/João" → (Occasion{...}, "João")
-// e.g., "/João" → (defaultOccasion, "João")
+// e.g., "/João" → (generalOccasion(), "João")
 func parseOccasionFromPath(path string) (Occasion, string) {
+	_, path = splitLocalePath(path)
 	path = strings.TrimPrefix(path, "/")
 	if path == "" {
-		return defaultOccasion, ""
+		return generalOccasion(), ""
 	}
 
 	// Check if path starts with a known occasion prefix
-	parts := strings.SplitN(path, "/", 2)
-	if len(parts) >= 1 {
-		if occ, ok := occasions[strings.ToLower(parts[0])]; ok {
-			message := ""
-			if len(parts) == 2 {
-				message = parts[1]
-			}
-			return occ, message
+	prefix, rest, found := strings.Cut(path, "/")
+	if occ, ok := lookupOccasion(prefix); ok {
+		message := ""
+		if found {
+			message, _ = splitAgeSuffix(occ, rest)
 		}
+		return occ, message
 	}
 
-	return defaultOccasion, path
+	return generalOccasion(), path
+}
+
+var errAgeInvalid = fmt.Errorf("invalid age")
+
+// splitAgeSuffix separates the age from a birthday message, or the years
+// from a wedding anniversary one: "João/30" → ("João", "30"). Other
+// occasions have no age segment.
+func splitAgeSuffix(occ Occasion, rawMessage string) (string, string) {
+	if occ.Prefix != "aniversario" && occ.Prefix != "bodas" {
+		return rawMessage, ""
+	}
+	idx := strings.LastIndex(rawMessage, "/")
+	if idx == -1 {
+		return rawMessage, ""
+	}
+	suffix := rawMessage[idx+1:]
+	if suffix == "" || strings.Trim(suffix, "0123456789") != "" {
+		return rawMessage, ""
+	}
+	return rawMessage[:idx], suffix
+}
+
+// ageFromPath returns the raw age segment of /aniversario/{message}/{age}
+// or /bodas/{message}/{years}.
+func ageFromPath(path string) string {
+	_, path = splitLocalePath(path)
+	prefix, rest, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !found {
+		return ""
+	}
+	occ, ok := lookupOccasion(prefix)
+	if !ok {
+		return ""
+	}
+	_, age := splitAgeSuffix(occ, rest)
+	return age
 }
 
-func renderIndexHTML(tpl string, path string, theme string) string {
+// greetingAge reads the birthday age from the path, falling back to ?idade=.
+func greetingAge(path string, query url.Values) (int, error) {
+	rawAge := ageFromPath(path)
+	if rawAge == "" {
+		rawAge = query.Get("idade")
+	}
+	return parseAge(rawAge)
+}
+
+// parseAge validates an age given in the path or as ?idade=. An empty value
+// is not an error.
+func parseAge(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	age, err := strconv.Atoi(value)
+	if err != nil || age < minAge || age > maxAge {
+		return 0, errAgeInvalid
+	}
+	return age, nil
+}
+
+// pageOptions holds the per-request data that customizes a greeting page.
+type pageOptions struct {
+	Theme     string
+	Effect    string
+	Sound     string
+	Photo     string
+	Accent    string
+	Sender    string
+	Guestbook []GuestbookEntry
+	Views     int
+	Age       int
+	Paper     string
+	Emoji     string
+	Gender    string
+	FixCase   bool
+	Birthdate birthdate
+	Locale    string
+	// Composer copy of an experiment variant
+	ComposerTitle  string
+	ComposerButton string
+	// decodePath of the path's message, when the caller already decoded it
+	// to moderate the message; buildGreeting decodes it otherwise
+	decodedMessage string
+}
+
+// greetingPageOptions reads the options of the greeting at path from its
+// query, so the page and everything drawn from it (preview, OG image, card
+// image, PDF, audio, e-mail, share text) show the same greeting. A ?de=,
+// age or ?nascimento= that does not validate is left out; callers answering
+// an error for one check it first.
+func greetingPageOptions(path string, query url.Values) pageOptions {
+	sender, _ := parseName(query.Get("de"))
+	age, _ := greetingAge(path, query)
+	born, _ := parseBirthdate(query.Get("nascimento"), time.Now())
+	return pageOptions{
+		Theme:     query.Get("theme"),
+		Effect:    query.Get("efeito"),
+		Sound:     query.Get("som"),
+		Photo:     photoID(query.Get("foto")),
+		Accent:    query.Get("cor"),
+		Sender:    sender,
+		Age:       age,
+		Paper:     query.Get("papel"),
+		Emoji:     query.Get("emoji"),
+		Gender:    query.Get("g"),
+		FixCase:   query.Get("fix") == "1",
+		Birthdate: born,
+		Locale:    query.Get("lang"),
+	}
+}
+
+var (
+	errNameInvalid = fmt.Errorf("invalid name")
+	errNameBlocked = fmt.Errorf("blocked name")
+)
+
+// parseName validates a person's name given as a parameter, such as the
+// ?de= signature. An empty value is not an error.
+func parseName(raw string) (string, error) {
+	name := strings.Join(strings.Fields(strings.ReplaceAll(raw, "_", " ")), " ")
+	if name == "" {
+		return "", nil
+	}
+	if utf8.RuneCountInString(name) > maxNameLen || looksLikePath(name) {
+		return "", errNameInvalid
+	}
+	if isBlockedMessage(name) {
+		return "", errNameBlocked
+	}
+	return name, nil
+}
+
+// greeting is everything computed from a greeting path and its options,
+// shared by the HTML page and the other representations of a card.
+type greeting struct {
+	Occasion       Occasion
+	Greeting       string // occasion greeting, with the age for birthdays
+	Emoji          string // occasion emoji, or the ?emoji= override
+	Age            int
+	Message        string
+	DisplayMessage string
+	RichMessage    string // DisplayMessage keeping the rich-text markers
+	Punct          string
+	Title          string
+	Subtitle       string
+	OgDesc         string
+	OgURL          string
+	OgImage        string
+	OgVideo        string
+	OgSpec         ogImageSpec
+	Facts          []string // ?nascimento= fun facts
+	BirthdayTwins  string   // "Hoje também é aniversário de…"
+	Locale         string   // ?lang=, "" for Portuguese
+}
+
+func buildGreeting(path string, opts pageOptions) greeting {
+	// A language prefix in the path takes precedence over ?lang=
+	if code, rest := splitLocalePath(path); code != "" {
+		opts.Locale = code
+		path = rest
+	}
 	occasion, rawMessage := parseOccasionFromPath(path)
-	message := decodePath(rawMessage)
-	displayMessage := buildDisplayMessage(message)
+	occasion = localizeOccasion(occasion, opts.Locale)
+	loc, localized := locales[opts.Locale]
+	decoded := opts.decodedMessage
+	if decoded == "" {
+		decoded = decodePath(rawMessage)
+	}
+	marked := fixNameCase(decoded, opts.FixCase)
+	message := stripRichText(marked)
+	gender := recipientGender(opts.Gender, message)
+	displayMessage := buildDisplayMessage(message, gender)
+	if localized {
+		// "você" is Portuguese; other languages show the message as typed
+		displayMessage = message
+		if message == "" {
+			displayMessage = loc.DefaultMessage
+		}
+	}
+	richMessage := displayMessage
+	if message != "" {
+		richMessage = strings.TrimSuffix(displayMessage, message) + marked
+	}
 	punct := "!"
 	if hasFinalPunctuation(message) || hasEncodedFinalPunctuation(rawMessage) {
 		punct = ""
 	}
 
+	greetingText := occasion.Greeting
+	age := 0
+	if occasion.Prefix == "aniversario" || occasion.Prefix == "bodas" {
+		age = opts.Age
+	}
+	if localized && age >= 1 {
+		greetingText = loc.withAge(greetingText, age)
+	} else if occasion.Prefix == "bodas" && age >= 1 {
+		greetingText = bodasGreeting(age)
+		occasion.Subtitle, occasion.SubtitleP = bodasSubtitle(age), ""
+	} else if age == 1 {
+		greetingText += " de 1 ano"
+	} else if age > 1 {
+		greetingText += fmt.Sprintf(" de %d anos", age)
+	}
+
 	// Build title using occasion greeting + display message
-	title := fmt.Sprintf("%s, %s%s", occasion.Greeting, displayMessage, punct)
+	title := fmt.Sprintf("%s, %s%s", greetingText, strings.Join(messageLines(displayMessage), " "), punct)
+	emoji := occasion.Emoji
+	if custom := emojiName(opts.Emoji); custom != "" {
+		emoji = custom
+	}
+	subtitle := occasion.subtitleFor(gender, isPluralRecipient(message))
+	ogDesc := subtitle + " " + emoji
+	if opts.Sender != "" {
+		title += " — " + fromWord(opts.Locale) + " " + opts.Sender
+		ogDesc += " — " + fromWord(opts.Locale) + " " + opts.Sender
+	}
+	facts := birthdateFacts(opts.Birthdate)
+	if len(facts) > 0 {
+		ogDesc += " · " + strings.Join(facts, " · ")
+	}
+	twins := birthdayTwinsLine(opts.Birthdate)
+	if twins != "" {
+		ogDesc += " · " + twins
+	}
 
-	// Build OG URL
+	// Build OG URL, the canonical URL in the greeting's language
 	baseURL := publicBaseURL()
 	ogURL := baseURL
 	if path != "" && path != "/" {
-		ogURL = strings.TrimRight(baseURL, "/") + path
+		ogURL = strings.TrimRight(baseURL, "/") + localizedPath(path, localeName(opts.Locale))
 	}
 
 	// OG image uses the occasion greeting + message
 	ogImageText := message
-	if message != "" && occasion.Greeting != "Parabéns" {
-		ogImageText = occasion.Greeting + ", " + message
+	if message != "" && greetingText != siteIdentity().DefaultGreeting {
+		ogImageText = greetingText + ", " + message
+	}
+	ogSpec := ogImageSpec{
+		Text:   ogImageText,
+		Photo:  opts.Photo,
+		Accent: accentColor(opts.Accent),
+		Theme:  themeName(opts.Theme),
+		Emoji:  emojiName(opts.Emoji),
+		Locale: localeName(opts.Locale),
+	}
+	if occasion.OgTemplate != "" {
+		ogSpec.Occasion = occasion.Prefix
 	}
-	ogImage := ogImageURL(baseURL, ogImageText)
 
-	subtitle := occasion.Subtitle + " " + occasion.Emoji
+	return greeting{
+		Occasion:       occasion,
+		Greeting:       greetingText,
+		Emoji:          emoji,
+		Age:            age,
+		Message:        message,
+		DisplayMessage: displayMessage,
+		RichMessage:    richMessage,
+		Punct:          punct,
+		Title:          title,
+		Subtitle:       subtitle + " " + emoji,
+		OgDesc:         ogDesc,
+		OgURL:          ogURL,
+		OgImage:        ogImageURL(baseURL, ogSpec),
+		OgVideo:        ogVideoURL(baseURL, ogSpec),
+		OgSpec:         ogSpec,
+		Facts:          facts,
+		BirthdayTwins:  twins,
+		Locale:         localeName(opts.Locale),
+	}
+}
+
+// TemplateData is the data model of the greeting page template
+// (public/index.html).
+type TemplateData struct {
+	Lang           string
+	OgLocale       string
+	Title          string
+	OgDesc         string
+	OgURL          string
+	Alternates     []Alternate
+	OgImage        string
+	OgImageWidth   int
+	OgImageHeight  int
+	OgVideo        string
+	OgVideoWidth   int
+	OgVideoHeight  int
+	ThemeColor     string
+	Greeting       string
+	Age            int
+	Message        string
+	MessageLines   []template.HTML
+	Punct          string
+	Subtitle       string
+	Facts          []string
+	BirthdayTwins  string
+	Sender         string
+	From           string
+	Sound          string
+	SpeechURL      string
+	Photo          string
+	ThemeClass     string
+	ThemeCSS       string
+	Paper          string
+	Site           SiteIdentity
+	EffectClass    string
+	ShowComposer   bool
+	ComposerTitle  string
+	ComposerButton string
+	EmojiOptions   template.HTML
+	Guestbook      []GuestbookEntry
+	Views          int
+	Captcha        *CaptchaWidget // nil unless CAPTCHA_PROVIDER is set
+}
 
-	// Determine if we should show the composer form
-	showComposer := "false"
-	if message == "" {
-		showComposer = "true"
+func newTemplateData(path string, opts pageOptions) TemplateData {
+	g := buildGreeting(path, opts)
+	lang, ogLocale := htmlLang(g.Locale)
+	var alternates []Alternate
+	if g.Message != "" {
+		alternates = greetingAlternates(publicBaseURL(), path)
 	}
+	return TemplateData{
+		Lang:           lang,
+		OgLocale:       ogLocale,
+		Title:          g.Title,
+		OgDesc:         g.OgDesc,
+		OgURL:          g.OgURL,
+		Alternates:     alternates,
+		OgImage:        g.OgImage,
+		OgImageWidth:   ogImageWidth,
+		OgImageHeight:  ogImageHeight,
+		OgVideo:        g.OgVideo,
+		OgVideoWidth:   ogVideoWidth,
+		OgVideoHeight:  ogVideoHeight,
+		ThemeColor:     themeColor(opts.Theme, opts.Accent),
+		Greeting:       g.Greeting,
+		Age:            g.Age,
+		Message:        g.DisplayMessage,
+		MessageLines:   richMessageLines(g.RichMessage),
+		Punct:          g.Punct,
+		Subtitle:       g.Subtitle,
+		Facts:          g.Facts,
+		BirthdayTwins:  g.BirthdayTwins,
+		Sender:         opts.Sender,
+		From:           fromWord(g.Locale),
+		Sound:          soundName(opts.Sound),
+		SpeechURL:      speechURL(g),
+		Photo:          opts.Photo,
+		ThemeClass:     themeClass(opts.Theme),
+		ThemeCSS:       themeCSSURL(opts.Theme, opts.Accent),
+		Paper:          paperSize(opts.Paper),
+		Site:           siteIdentity(),
+		Captcha:        captchaWidget(),
+		EffectClass:    effectClass(opts.Effect),
+		ShowComposer:   g.Message == "",
+		ComposerTitle:  opts.ComposerTitle,
+		ComposerButton: opts.ComposerButton,
+		EmojiOptions:   emojiOptions,
+		Guestbook:      opts.Guestbook,
+		Views:          opts.Views,
+	}
+}
 
-	return strings.NewReplacer(
-		"__TITLE__", escapeHTML(title),
-		"__OG_TITLE__", escapeHTML(title),
-		"__OG_DESC__", escapeHTML(occasion.Subtitle+" "+occasion.Emoji),
-		"__OG_URL__", escapeHTML(ogURL),
-		"__OG_IMAGE__", escapeHTML(ogImage),
-		"__GREETING__", escapeHTML(occasion.Greeting),
-		"__MESSAGE__", escapeHTML(displayMessage),
-		"__PUNCT__", punct,
-		"__SUBTITLE__", escapeHTML(subtitle),
-		"__THEME_CLASS__", themeClass(theme),
-		"__SHOW_COMPOSER__", showComposer,
-	).Replace(tpl)
+// renderedPageSize is a little more than a rendered greeting page, so the
+// builder does not grow while the template executes.
+const renderedPageSize = 16 << 10
+
+func renderIndexHTML(tpl *template.Template, path string, opts pageOptions) (string, error) {
+	var b strings.Builder
+	b.Grow(renderedPageSize)
+	if err := tpl.Execute(&b, newTemplateData(stripInvalidText(path), opts)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
-func buildDisplayMessage(value string) string {
+func buildDisplayMessage(value, gender string) string {
 	value = strings.TrimSpace(value)
 	if value == "" {
-		return "você é um(a) amigo(a)"
+		return "você é " + inflect(gender, "uma amiga", "um amigo", "uma pessoa querida")
 	}
 	lower := strings.ToLower(value)
-	if strings.HasPrefix(lower, "voce ") || strings.HasPrefix(lower, "você ") || strings.HasPrefix(lower, "vc ") {
-		return value
+	for _, prefix := range []string{"voce ", "você ", "vc ", "voces ", "vocês ", "vcs "} {
+		if strings.HasPrefix(lower, prefix) {
+			return value
+		}
 	}
 	if startsWithProperName(value) {
 		return value
@@ -344,11 +964,38 @@ func startsWithProperName(value string) bool {
 	return true
 }
 
+// isPluralRecipient reports whether the message is a list of names, such as
+// "João e Maria" or "Ana, Bia e Carla", so the copy addresses "vocês".
+func isPluralRecipient(value string) bool {
+	value = strings.TrimRight(strings.TrimSpace(value), "!?.…")
+	words := strings.Fields(strings.ReplaceAll(value, ",", " , "))
+	particles := map[string]bool{"da": true, "de": true, "do": true, "das": true, "dos": true}
+	names, inName := 0, false
+	for i, word := range words {
+		switch {
+		case word == "," || word == "e" || word == "&":
+			if !inName {
+				return false
+			}
+			inName = false
+		case isCapitalized(word):
+			if !inName {
+				names++
+			}
+			inName = true
+		case inName && particles[strings.ToLower(word)] && i+1 < len(words) && isCapitalized(words[i+1]):
+		default:
+			return false
+		}
+	}
+	return inName && names >= 2
+}
+
 func tokenizeWords(value string) []string {
 	var tokens []string
 	var buf bytes.Buffer
 	for _, ch := range value {
-		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= 'À' && ch <= 'ÿ') || ch == '\'' || ch == 0x2019 {
+		if unicode.IsLetter(ch) || unicode.IsMark(ch) || ch == '\'' || ch == 0x2019 {
 			buf.WriteRune(ch)
 		} else if buf.Len() > 0 {
 			tokens = append(tokens, buf.String())
@@ -361,12 +1008,15 @@ func tokenizeWords(value string) []string {
 	return tokens
 }
 
+// isCapitalized reports whether token starts with an uppercase or titlecase
+// letter, or with a letter of a caseless script such as Arabic or CJK, where
+// any name counts as capitalized.
 func isCapitalized(token string) bool {
-	if token == "" {
+	r, _ := utf8.DecodeRuneInString(token)
+	if !unicode.IsLetter(r) {
 		return false
 	}
-	r := []rune(token)
-	return strings.ToUpper(string(r[0])) == string(r[0]) && strings.ToLower(string(r[0])) != string(r[0])
+	return unicode.IsUpper(r) || unicode.IsTitle(r) || unicode.SimpleFold(r) == r
 }
 
 func hasFinalPunctuation(value string) bool {
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bots filling the composer are caught two ways: they fill the hidden
+// "website" field people never see, or they submit faster than a person
+// can type. The composer fetches a signed form token when it is shown and
+// sends it back in X-Form-Token; a token younger than minFormFillTime, or
+// none at all, gives the bot away, and so does a token submitted again,
+// since a script would otherwise fetch one and replay it for a day. Bots
+// get a decoy response, so they do not learn to adapt, and nothing is
+// created.
+
+// formTokensUsed records the form tokens submitted, until they expire.
+var formTokensUsed = struct {
+	mu   sync.Mutex
+	seen map[string]formTokenUse
+}{seen: map[string]formTokenUse{}}
+
+// formTokenUse is a submission of a form token: when, and the proof of
+// work solved with it.
+type formTokenUse struct {
+	at  time.Time
+	pow int
+}
+
+// formToken signs the time the composer was shown, with a random part so
+// every composer gets its own token.
+func formToken(now time.Time) (string, error) {
+	random, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	issued := strconv.FormatInt(now.Unix(), 10) + "." + random
+	return issued + "." + formTokenSignature(issued), nil
+}
+
+func formTokenSignature(issued string) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte("form:" + issued))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleFormToken serves GET /api/form-token.
+func handleFormToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	token, err := formToken(time.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+// botSignal returns why a composer submission looks automated, or "".
+// powSolved is the difficulty of the proof of work the submission solved.
+func botSignal(honeypot, token string, powSolved int, now time.Time) string {
+	if strings.TrimSpace(honeypot) != "" {
+		return "honeypot"
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(formTokenSignature(parts[0]+"."+parts[1]))) {
+		return "form_token"
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "form_token"
+	}
+	if elapsed := now.Sub(time.Unix(issued, 0)); elapsed < minFormFillTime {
+		return "too_fast"
+	} else if elapsed > formTokenTTL {
+		return "form_token"
+	}
+	if !spendFormToken(token, powSolved, now) {
+		return "form_token_reused"
+	}
+	return ""
+}
+
+// spendFormToken records a submission of token, reporting false when it
+// was submitted before. The composer answers 428 pow_required by sending
+// the same token again with the proof of work asked for, so one more
+// submission is let through when it solved a harder one than the first.
+func spendFormToken(token string, powSolved int, now time.Time) bool {
+	formTokensUsed.mu.Lock()
+	defer formTokensUsed.mu.Unlock()
+	for key, use := range formTokensUsed.seen {
+		if now.Sub(use.at) > formTokenTTL {
+			delete(formTokensUsed.seen, key)
+		}
+	}
+	use, used := formTokensUsed.seen[token]
+	if used && (use.pow < 0 || powSolved <= use.pow) {
+		return false
+	}
+	if used {
+		// The retry: spent for good
+		powSolved = -1
+	}
+	formTokensUsed.seen[token] = formTokenUse{at: now, pow: powSolved}
+	return true
+}
+
+// recordBotAttempt logs a discarded submission next to the track events.
+func recordBotAttempt(r *http.Request, reason, path string) {
+	slog.Info("track_event",
+		"event", "bot_attempt",
+		"reason", reason,
+		"path", path,
+		"ip", clientIP(r),
+		"user_agent", r.UserAgent(),
+	)
+}
+
+// decoyShortlink looks like a created shortlink but is not stored.
+func decoyShortlink(fullPath string) (ShortLinkResponse, error) {
+	code, err := newShortCode()
+	if err != nil {
+		return ShortLinkResponse{}, err
+	}
+	return shortlinkResponse(code, fullPath), nil
+}
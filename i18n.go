@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// locale is the copy of a language other than the default Portuguese. Only
+// the greeting text is translated: names and messages are kept as typed,
+// and occasions from the config file keep their own text.
+type locale struct {
+	Code     string // value of ?lang=
+	Tag      string // BCP 47, for <html lang>
+	OgLocale string
+	// DisplayMessage of an empty message
+	DefaultMessage string
+	From           string // "— de Maria"
+	withAge        func(greeting string, age int) string
+	// Translations by occasion prefix; "" is the general greeting
+	Occasions map[string]occasionText
+	// Path slugs by occasion prefix: /en/birthday/John
+	Slugs map[string]string
+}
+
+type occasionText struct {
+	Greeting, Subtitle, SubtitleP string
+}
+
+var locales = map[string]locale{
+	"en": {
+		Code:           "en",
+		Tag:            "en",
+		OgLocale:       "en_US",
+		DefaultMessage: "you are a dear friend",
+		From:           "from",
+		withAge: func(greeting string, age int) string {
+			return strings.Replace(greeting, "Happy ", "Happy "+englishOrdinal(age)+" ", 1)
+		},
+		Occasions: map[string]occasionText{
+			"":              {"Congratulations", "Celebrating with balloons and confetti", "You deserve balloons and confetti"},
+			"aniversario":   {"Happy Birthday", "Celebrating another year of life", ""},
+			"formatura":     {"Congratulations on your graduation", "An achievement to celebrate", ""},
+			"promocao":      {"Congratulations on your promotion", "Your hard work has been recognized", ""},
+			"casamento":     {"Best wishes", "Celebrating love", ""},
+			"boas-vindas":   {"Welcome", "It's a pleasure to have you here", ""},
+			"natal":         {"Merry Christmas", "May the magic of Christmas light up your days", ""},
+			"ano-novo":      {"Happy New Year", "A new chapter full of achievements", ""},
+			"dia-das-maes":  {"Happy Mother's Day", "Celebrating all your love and care", ""},
+			"dia-dos-pais":  {"Happy Father's Day", "Celebrating your example and dedication", ""},
+			"pascoa":        {"Happy Easter", "A time of renewal and hope", ""},
+			"aposentadoria": {"Happy Retirement", "A new chapter to enjoy", ""},
+			"bodas":         {"Happy Anniversary", "Celebrating years of love and partnership", ""},
+		},
+		Slugs: map[string]string{
+			"aniversario":   "birthday",
+			"formatura":     "graduation",
+			"promocao":      "promotion",
+			"casamento":     "wedding",
+			"boas-vindas":   "welcome",
+			"natal":         "christmas",
+			"ano-novo":      "new-year",
+			"dia-das-maes":  "mothers-day",
+			"dia-dos-pais":  "fathers-day",
+			"pascoa":        "easter",
+			"aposentadoria": "retirement",
+			"bodas":         "anniversary",
+		},
+	},
+	"es": {
+		Code:           "es",
+		Tag:            "es",
+		OgLocale:       "es_ES",
+		DefaultMessage: "eres una persona querida",
+		From:           "de",
+		withAge: func(greeting string, age int) string {
+			if age == 1 {
+				return greeting + ", 1 año"
+			}
+			return fmt.Sprintf("%s, %d años", greeting, age)
+		},
+		Occasions: map[string]occasionText{
+			"":              {"Felicidades", "Celebrando con globos y confeti", "Ustedes merecen globos y confeti"},
+			"aniversario":   {"Feliz Cumpleaños", "Celebrando un año más de vida", ""},
+			"formatura":     {"Felicidades por tu graduación", "Un logro para celebrar", "Ustedes merecen celebrar este logro"},
+			"promocao":      {"Felicidades por tu ascenso", "Tu esfuerzo fue reconocido", "El esfuerzo de ustedes fue reconocido"},
+			"casamento":     {"Felicidades", "Celebrando el amor", ""},
+			"boas-vindas":   {"Te damos la bienvenida", "Es un placer tenerte aquí", "Es un placer tenerlos aquí"},
+			"natal":         {"Feliz Navidad", "Que la magia de la Navidad ilumine tus días", ""},
+			"ano-novo":      {"Feliz Año Nuevo", "Un nuevo ciclo lleno de logros", ""},
+			"dia-das-maes":  {"Feliz Día de la Madre", "Celebrando todo tu amor y cariño", ""},
+			"dia-dos-pais":  {"Feliz Día del Padre", "Celebrando tu ejemplo y dedicación", ""},
+			"pascoa":        {"Felices Pascuas", "Tiempo de renovación y esperanza", ""},
+			"aposentadoria": {"Feliz Jubilación", "Una nueva etapa para disfrutar", ""},
+			"bodas":         {"Feliz Aniversario", "Celebrando años de amor y compañerismo", ""},
+		},
+		Slugs: map[string]string{
+			"aniversario":   "cumpleanos",
+			"formatura":     "graduacion",
+			"promocao":      "ascenso",
+			"casamento":     "boda",
+			"boas-vindas":   "bienvenida",
+			"natal":         "navidad",
+			"ano-novo":      "ano-nuevo",
+			"dia-das-maes":  "dia-de-la-madre",
+			"dia-dos-pais":  "dia-del-padre",
+			"pascoa":        "pascua",
+			"aposentadoria": "jubilacion",
+			"bodas":         "bodas",
+		},
+	},
+}
+
+// localeName validates ?lang=; "" is the default Portuguese.
+func localeName(value string) string {
+	if loc, ok := locales[strings.ToLower(strings.TrimSpace(value))]; ok {
+		return loc.Code
+	}
+	return ""
+}
+
+// localizeOccasion returns occ with the greeting and subtitles of code.
+// Occasions without a translation, such as the ones from the config file,
+// are returned unchanged.
+func localizeOccasion(occ Occasion, code string) Occasion {
+	loc, ok := locales[code]
+	if !ok {
+		return occ
+	}
+	text, ok := loc.Occasions[occ.Prefix]
+	if !ok {
+		return occ
+	}
+	occ.Greeting = text.Greeting
+	occ.Subtitle = text.Subtitle
+	// The translations are gender-neutral
+	occ.SubtitleF, occ.SubtitleM, occ.SubtitleP = "", "", text.SubtitleP
+	return occ
+}
+
+// splitLocalePath recognizes a language prefix, translating the occasion
+// slug back to its prefix: "/en/birthday/John" → ("en", "/aniversario/John").
+// Other paths are returned unchanged with an empty code.
+func splitLocalePath(path string) (code, rest string) {
+	code, rest, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	loc, ok := locales[code]
+	if !found || !ok || rest == "" {
+		return "", path
+	}
+	slug, tail, hasTail := strings.Cut(rest, "/")
+	for prefix, translated := range loc.Slugs {
+		if strings.EqualFold(slug, translated) {
+			rest = "/" + prefix
+			if hasTail {
+				rest += "/" + tail
+			}
+			return loc.Code, rest
+		}
+	}
+	// Untranslated, the rest is the path after the language code
+	return loc.Code, path[len(path)-len(rest)-1:]
+}
+
+// localizedPath is the URL of a Portuguese greeting path in the language
+// code, with the occasion slug translated.
+func localizedPath(path, code string) string {
+	loc, ok := locales[code]
+	if !ok || path == "" || path == "/" {
+		return path
+	}
+	first, tail, hasTail := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if occ, ok := lookupOccasion(first); ok && hasTail {
+		if slug := loc.Slugs[occ.Prefix]; slug != "" {
+			first = slug
+		}
+	}
+	localized := "/" + loc.Code + "/" + first
+	if hasTail {
+		localized += "/" + tail
+	}
+	return localized
+}
+
+// Alternate is a <link rel="alternate" hreflang> of a greeting page.
+type Alternate struct {
+	Lang string
+	URL  string
+}
+
+// greetingAlternates lists the page in Portuguese and every translation,
+// with Portuguese as the x-default.
+func greetingAlternates(baseURL, path string) []Alternate {
+	_, path = splitLocalePath(path)
+	base := strings.TrimRight(baseURL, "/")
+	alternates := []Alternate{{Lang: "pt-BR", URL: base + path}}
+	codes := make([]string, 0, len(locales))
+	for code := range locales {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		alternates = append(alternates, Alternate{Lang: locales[code].Tag, URL: base + localizedPath(path, code)})
+	}
+	return append(alternates, Alternate{Lang: "x-default", URL: base + path})
+}
+
+// fromWord introduces the sender's signature: "— de Maria".
+func fromWord(code string) string {
+	if loc, ok := locales[code]; ok {
+		return loc.From
+	}
+	return "de"
+}
+
+// htmlLang returns the <html lang> and og:locale of code.
+func htmlLang(code string) (tag, ogLocale string) {
+	if loc, ok := locales[code]; ok {
+		return loc.Tag, loc.OgLocale
+	}
+	return "pt-BR", "pt_BR"
+}
+
+func englishOrdinal(n int) string {
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Lottie animations of an occasion (Occasion.Animation); occasions without
+// one get confetti.
+const (
+	lottieConfetti  = "confete"
+	lottieCake      = "bolo"
+	lottieFireworks = "fogos"
+)
+
+var lottieAnimations = map[string]bool{lottieConfetti: true, lottieCake: true, lottieFireworks: true}
+
+// lottieObject is a node of the Lottie (Bodymovin 5) JSON document. The
+// format is deeply nested and mostly positional, so it is built from maps
+// rather than mirrored in structs.
+type lottieObject = map[string]any
+
+func lottieStatic(value any) lottieObject {
+	return lottieObject{"a": 0, "k": value}
+}
+
+type lottieKeyframe struct {
+	Frame float64
+	Value []float64
+	Hold  bool
+}
+
+// lottieAnimated interpolates linearly between keyframes.
+func lottieAnimated(keyframes ...lottieKeyframe) lottieObject {
+	k := make([]lottieObject, len(keyframes))
+	for i, kf := range keyframes {
+		k[i] = lottieObject{"t": kf.Frame, "s": kf.Value}
+		if kf.Hold {
+			k[i]["h"] = 1
+		} else if i < len(keyframes)-1 {
+			k[i]["i"] = lottieObject{"x": []float64{1}, "y": []float64{1}}
+			k[i]["o"] = lottieObject{"x": []float64{0}, "y": []float64{0}}
+		}
+	}
+	return lottieObject{"a": 1, "k": k}
+}
+
+// lottieTransform builds a layer transform; nil properties stay at rest.
+func lottieTransform(position, rotation, scale, opacity lottieObject) lottieObject {
+	if position == nil {
+		position = lottieStatic([]float64{0, 0, 0})
+	}
+	if rotation == nil {
+		rotation = lottieStatic(0)
+	}
+	if scale == nil {
+		scale = lottieStatic([]float64{100, 100, 100})
+	}
+	if opacity == nil {
+		opacity = lottieStatic(100)
+	}
+	return lottieObject{"p": position, "r": rotation, "s": scale, "o": opacity, "a": lottieStatic([]float64{0, 0, 0})}
+}
+
+// lottieColor converts #rrggbb to Lottie's 0..1 RGBA.
+func lottieColor(hex string) []float64 {
+	value, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil {
+		return []float64{1, 1, 1, 1}
+	}
+	return []float64{float64(value>>16&0xff) / 255, float64(value>>8&0xff) / 255, float64(value&0xff) / 255, 1}
+}
+
+func lottieRect(x, y, w, h, radius float64) lottieObject {
+	return lottieObject{"ty": "rc", "p": lottieStatic([]float64{x, y}), "s": lottieStatic([]float64{w, h}), "r": lottieStatic(radius)}
+}
+
+func lottieEllipse(x, y, w, h float64) lottieObject {
+	return lottieObject{"ty": "el", "p": lottieStatic([]float64{x, y}), "s": lottieStatic([]float64{w, h})}
+}
+
+func lottieFill(color string) lottieObject {
+	return lottieObject{"ty": "fl", "c": lottieStatic(lottieColor(color)), "o": lottieStatic(100)}
+}
+
+// lottieGroup wraps shapes and their fill, closed by the mandatory group
+// transform.
+func lottieGroup(items ...lottieObject) lottieObject {
+	items = append(items, lottieObject{
+		"ty": "tr", "p": lottieStatic([]float64{0, 0}), "a": lottieStatic([]float64{0, 0}),
+		"s": lottieStatic([]float64{100, 100}), "r": lottieStatic(0), "o": lottieStatic(100),
+	})
+	return lottieObject{"ty": "gr", "it": items}
+}
+
+func lottieShapeLayer(name string, transform lottieObject, groups ...lottieObject) lottieObject {
+	return lottieObject{
+		"ddd": 0, "ty": 4, "nm": name, "sr": 1, "ks": transform, "ao": 0,
+		"shapes": groups, "ip": 0, "op": lottieFrames, "st": 0, "bm": 0,
+	}
+}
+
+// lottieTextLayer shows the greeting centered at (x, y); lines are
+// separated by "\r", as Lottie expects.
+func lottieTextLayer(text string, x, y float64) lottieObject {
+	document := lottieObject{
+		"s": 30, "f": "OpenSans-Bold", "t": text, "j": 2, "tr": 0,
+		"lh": 38, "ls": 0, "fc": []float64{1, 1, 1},
+	}
+	return lottieObject{
+		"ddd": 0, "ty": 5, "nm": "mensagem", "sr": 1,
+		"ks": lottieTransform(lottieStatic([]float64{x, y, 0}), nil, nil, nil), "ao": 0,
+		"t": lottieObject{
+			"d": lottieObject{"k": []lottieObject{{"s": document, "t": 0}}},
+			"p": lottieObject{}, "m": lottieObject{"g": 1, "a": lottieStatic([]float64{0, 0})}, "a": []any{},
+		},
+		"ip": 0, "op": lottieFrames, "st": 0, "bm": 0,
+	}
+}
+
+// lottieConfettiLayers drops the same pieces as the video preview, each
+// wrapping from the bottom back to the top so the loop is seamless.
+func lottieConfettiLayers(accent string) []lottieObject {
+	pieces := confettiPieces(accent)
+	layers := make([]lottieObject, 0, len(pieces))
+	top, bottom := -10.0, ogImageHeight+10.0
+	for i, p := range pieces {
+		wrap := lottieFrames * (bottom - p.Y) / (bottom - top)
+		position := lottieAnimated(
+			lottieKeyframe{Frame: 0, Value: []float64{p.X, p.Y, 0}},
+			lottieKeyframe{Frame: wrap, Value: []float64{p.X + p.Drift, bottom, 0}, Hold: true},
+			lottieKeyframe{Frame: wrap + 1, Value: []float64{p.X + p.Drift, top, 0}},
+			lottieKeyframe{Frame: lottieFrames, Value: []float64{p.X, p.Y, 0}},
+		)
+		rotation := lottieAnimated(
+			lottieKeyframe{Frame: 0, Value: []float64{p.Angle}},
+			lottieKeyframe{Frame: lottieFrames, Value: []float64{p.Angle + p.Spin}},
+		)
+		layers = append(layers, lottieShapeLayer(fmt.Sprintf("confete %d", i+1),
+			lottieTransform(position, rotation, nil, nil),
+			lottieGroup(lottieRect(0, 0, 9, 5, 1), lottieFill(p.Color))))
+	}
+	return layers
+}
+
+// lottieFireworksLayers bursts rings of sparks one after the other.
+func lottieFireworksLayers(accent string) []lottieObject {
+	colors := confettiColors
+	if accent != "" {
+		colors = append([]string{"#" + accent}, colors...)
+	}
+	bursts := [][2]float64{{110, 90}, {490, 80}, {300, 60}, {180, 200}, {430, 210}}
+	layers := make([]lottieObject, 0, len(bursts))
+	for i, at := range bursts {
+		start := float64(i) * lottieFrames / float64(len(bursts))
+		end := start + lottieFrames/3
+		sparks := make([]lottieObject, 0, 12)
+		for j := 0; j < 12; j++ {
+			angle := 2 * math.Pi * float64(j) / 12
+			sparks = append(sparks, lottieEllipse(math.Round(45*math.Cos(angle)), math.Round(45*math.Sin(angle)), 6, 6))
+		}
+		sparks = append(sparks, lottieFill(colors[i%len(colors)]))
+		scale := lottieAnimated(
+			lottieKeyframe{Frame: start, Value: []float64{0, 0, 100}},
+			lottieKeyframe{Frame: end, Value: []float64{100, 100, 100}},
+		)
+		opacity := lottieAnimated(
+			lottieKeyframe{Frame: start, Value: []float64{100}},
+			lottieKeyframe{Frame: end, Value: []float64{0}},
+		)
+		layers = append(layers, lottieShapeLayer(fmt.Sprintf("fogos %d", i+1),
+			lottieTransform(lottieStatic([]float64{at[0], at[1], 0}), nil, scale, opacity),
+			lottieGroup(sparks...)))
+	}
+	return layers
+}
+
+// lottieCakeLayers draws a two-tier cake with a flickering candle below
+// the text.
+func lottieCakeLayers(accent string) []lottieObject {
+	frosting := ogDefaultAccent
+	if accent != "" {
+		frosting = "#" + accent
+	}
+	flicker := lottieAnimated(
+		lottieKeyframe{Frame: 0, Value: []float64{100, 100, 100}},
+		lottieKeyframe{Frame: lottieFrames / 4, Value: []float64{80, 115, 100}},
+		lottieKeyframe{Frame: lottieFrames / 2, Value: []float64{105, 90, 100}},
+		lottieKeyframe{Frame: 3 * lottieFrames / 4, Value: []float64{85, 110, 100}},
+		lottieKeyframe{Frame: lottieFrames, Value: []float64{100, 100, 100}},
+	)
+	return []lottieObject{
+		lottieShapeLayer("chama", lottieTransform(lottieStatic([]float64{300, 192, 0}), nil, flicker, nil),
+			lottieGroup(lottieEllipse(0, 0, 10, 18), lottieFill("#fb923c"))),
+		lottieShapeLayer("bolo", lottieTransform(lottieStatic([]float64{300, 250, 0}), nil, nil, nil),
+			lottieGroup(lottieRect(0, -46, 5, 24, 1), lottieFill("#f8fafc")),
+			lottieGroup(lottieRect(0, -24, 80, 24, 4), lottieFill(frosting)),
+			lottieGroup(lottieRect(0, 4, 130, 34, 5), lottieFill("#f472b6")),
+			lottieGroup(lottieEllipse(0, 26, 170, 14), lottieFill("#e2e8f0"))),
+	}
+}
+
+// lottieAnimation returns the Lottie document of greeting g.
+func lottieAnimation(g greeting, accent string) lottieObject {
+	lines := append([]string{g.Greeting + ","}, messageLines(g.DisplayMessage)...)
+	lines[len(lines)-1] += g.Punct
+	textY := 110.0
+	if g.Occasion.Animation == lottieCake {
+		textY = 70
+	}
+	layers := []lottieObject{lottieTextLayer(strings.Join(lines, "\r"), ogImageWidth/2, textY)}
+	switch g.Occasion.Animation {
+	case lottieCake:
+		layers = append(layers, lottieCakeLayers(accent)...)
+	case lottieFireworks:
+		layers = append(layers, lottieFireworksLayers(accent)...)
+	default:
+		layers = append(layers, lottieConfettiLayers(accent)...)
+	}
+	for i, layer := range layers {
+		layer["ind"] = i + 1
+	}
+	return lottieObject{
+		"v": "5.7.4", "fr": lottieFPS, "ip": 0, "op": lottieFrames,
+		"w": ogImageWidth, "h": ogImageHeight, "nm": g.Title, "ddd": 0, "assets": []any{},
+		"fonts": lottieObject{"list": []lottieObject{{
+			"fName": "OpenSans-Bold", "fFamily": "Open Sans", "fStyle": "Bold", "ascent": 75,
+		}}},
+		"layers": layers,
+	}
+}
+
+// handleLottie returns the occasion's Lottie animation with the greeting
+// text: GET /api/lottie?path=/aniversario/João
+func handleLottie(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	rawPath := r.URL.Query().Get("path")
+	if strings.TrimSpace(rawPath) == "" || len(rawPath) > maxPathLen {
+		writeAPIError(w, http.StatusBadRequest, "invalid_path")
+		return
+	}
+	fullPath := normalizeGreetingPath(rawPath)
+	if status, code := checkGreetingPath(fullPath); status != http.StatusOK {
+		writeAPIError(w, status, code)
+		return
+	}
+	pathOnly, rawQuery, _ := strings.Cut(fullPath, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	g := buildGreeting(pathOnly, greetingPageOptions(pathOnly, query))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, lottieAnimation(g, accentColor(query.Get("cor"))))
+}
@@ -1,37 +1,213 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 const (
-	maxTrackBodyBytes     = 16 * 1024
-	maxPathLen            = 512
-	maxShortlinkBodyBytes = 8 * 1024
-	shortCodeLen          = 7
-	shortlinkRateLimit    = 20
-	shortlinkRateWindow   = time.Minute
-	trackRateLimit        = 120
-	trackRateWindow       = time.Minute
-	ogImageWidth          = 600
-	ogImageHeight         = 315
-	ogImageTextLimit      = 39
-	ogRenderTimeout       = 5 * time.Second
-	siteDomain            = "parabens.vc"
+	maxTrackBodyBytes          = 16 * 1024
+	maxPathLen                 = 512
+	maxPathRunes               = 256
+	maxNameLen                 = 40
+	minAge                     = 1
+	maxAge                     = 120
+	maxShortlinkBodyBytes      = 8 * 1024
+	shortCodeLen               = 7
+	minShortCodeLen            = 4
+	maxShortCodeLen            = 32
+	defaultShortCodeAlphabet   = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz" // base58: no 0, O, I or l
+	shortlinkRateLimit         = 20
+	shortlinkRateWindow        = time.Minute
+	trackRateLimit             = 120
+	trackRateWindow            = time.Minute
+	previewRateLimit           = 60
+	previewRateWindow          = time.Minute
+	ttsRateLimit               = 20
+	ttsRateWindow              = time.Hour
+	ttsTimeout                 = 15 * time.Second
+	retrospectiveTopNames      = 5
+	retrospectiveMinNameViews  = 5
+	maxStatsVisitors           = 100000
+	suggestRateLimit           = 120
+	suggestRateWindow          = time.Minute
+	suggestLimit               = 10
+	suggestTrendingMinViews    = 5
+	suggestTrendingMax         = 500
+	guestbookRateLimit         = 10
+	guestbookRateWindow        = time.Minute
+	maxGuestbookBodyBytes      = 4 * 1024
+	maxGuestbookMessageLen     = 280
+	protectedIDLen             = 12
+	protectedRateLimit         = 20
+	protectedRateWindow        = time.Hour
+	unlockRateLimit            = 10
+	unlockRateWindow           = 10 * time.Minute
+	unlockCookieMaxAge         = 30 * 24 * time.Hour
+	minPassphraseLen           = 4
+	maxPassphraseLen           = 100
+	passphraseIterations       = 100000
+	maxCardSignatures          = 100
+	cardIDLen                  = 12
+	cardRateLimit              = 10
+	cardRateWindow             = time.Hour
+	signatureRateLimit         = 10
+	signatureRateWindow        = time.Minute
+	maxGuestbookEntries        = 100
+	sendRateLimit              = 5
+	sendRateWindow             = time.Hour
+	optInRateLimit             = 3
+	optInRateWindow            = 24 * time.Hour
+	optInTokenTTL              = 48 * time.Hour
+	reminderRateLimit          = 5
+	reminderRateWindow         = time.Hour
+	maxRemindersPerEmail       = 10
+	reminderCheckInterval      = time.Hour
+	loginRateLimit             = 5
+	loginRateWindow            = time.Hour
+	loginTokenTTL              = 15 * time.Minute
+	sessionTTL                 = 30 * 24 * time.Hour
+	sessionCookieName          = "sessao"
+	oauthStateTTL              = 10 * time.Minute
+	oauthStateCookieName       = "oauth_state"
+	maxAPITokensPerAccount     = 10
+	defaultAPIDailyQuota       = 1000
+	apiUsageDays               = 30
+	apiUsagePersistDelay       = 10 * time.Second
+	viewPersistDelay           = 10 * time.Second
+	maxViewKeys                = 200000
+	csrfCookieName             = "csrf"
+	csrfHeaderName             = "X-CSRF-Token"
+	captchaHeaderName          = "X-Captcha-Token"
+	powChallengeHeaderName     = "X-PoW-Challenge"
+	powNonceHeaderName         = "X-PoW-Nonce"
+	powChallengeTTL            = 5 * time.Minute
+	maxPowDifficulty           = 32
+	formTokenHeaderName        = "X-Form-Token"
+	minFormFillTime            = 3 * time.Second
+	formTokenTTL               = 24 * time.Hour
+	spamWindow                 = 10 * time.Minute
+	spamBurstAllowance         = 3
+	spamChallengeScore         = 50
+	spamDeferScore             = 80
+	spamPowDifficulty          = 20
+	spamDeferDelay             = 15 * time.Minute
+	maxDeferredShortlinks      = 1000
+	logBufferLines             = 4096
+	shutdownTimeout            = 20 * time.Second
+	ogBreakerFailures          = 5
+	ogBreakerWindow            = time.Minute
+	ogBreakerCooldown          = 5 * time.Minute
+	diskCheckInterval          = 30 * time.Second
+	defaultDiskMinFreeMB       = 100
+	diskRetryAfter             = 300 // seconds
+	logDropReportInterval      = time.Minute
+	maxSendBodyBytes           = 4 * 1024
+	maxEmailLen                = 254
+	maxPhotoBytes              = 5 << 20
+	maxPhotoDimension          = 6000
+	photoDisplaySize           = 1200
+	photoRateLimit             = 10
+	photoRateWindow            = time.Hour
+	photoModerationTimeout     = 10 * time.Second
+	photoSweepInterval         = time.Hour
+	defaultPhotoTTL            = 30 * 24 * time.Hour
+	ogImageWidth               = 600
+	ogImageHeight              = 315
+	ogImageTextLimit           = 39
+	ogImageMaxLines            = 2
+	ogRenderTimeout            = 5 * time.Second
+	cardPDFWidth               = 1123 // A4 landscape width at 96 dpi
+	cardPDFHeight              = 590  // keeps the OG template's aspect ratio
+	maxSiteNameLen             = 60
+	maxFooterTextLen           = 200
+	ogDefaultAccent            = "#fbbf24"
+	ogDefaultEmoji             = "🎉"
+	ogVideoWidth               = 2 * ogImageWidth // libx264 needs even dimensions
+	ogVideoHeight              = 2 * ogImageHeight
+	ogVideoFPS                 = 12
+	ogVideoFrames              = 30 // a 2.5 s loop
+	ogVideoConfetti            = 40
+	ogVideoTimeout             = 30 * time.Second
+	lottieFPS                  = 30
+	lottieFrames               = 90 // a 3 s loop
+	minBirthYear               = 1900
+	birthdayTwinsLimit         = 3
+	cardImageWidth             = 1080 // story format, 9:16
+	cardImageHeight            = 1920
+	cardImageMinWidth          = 360
+	cardImageMaxWidth          = 2160
+	cardImageWrap              = 22 // runes per line of the card's message text
+	cardImageFont              = "Segoe UI, system-ui, sans-serif"
+	cardImageEmojiFont         = "Apple Color Emoji, Segoe UI Emoji, Noto Color Emoji, system-ui"
+	maxPurgeBodyBytes          = 4 * 1024
+	defaultBackupSchedule      = "0 3 * * *" // daily at 03:00 UTC
+	defaultBackupKeep          = 14
+	backupFilePrefix           = "parabens-backup-"
+	backupTimeFormat           = "20060102T150405Z"
+	backupRetryDelay           = 100 * time.Millisecond
+	defaultAuditLimit          = 100
+	maxAuditLimit              = 1000
+	maxAuditLineBytes          = 1 << 20
+	readOnlyRetryAfter         = 300 // seconds
+	webhookMaxAttempts         = 5
+	maxWebhookDeliveries       = 200
+	maxSlackBodyBytes          = 8 * 1024
+	slackSignatureMaxAge       = 5 * time.Minute
+	maxShortlinkExpiresInDays  = 365
+	shortlinkSweepInterval     = time.Hour
+	shortlinkExpiredGrace      = 30 * 24 * time.Hour
+	minShortlinkUnusedDays     = 30
+	maxShortlinkReferrers      = 20
+	shortlinkPersistDelay      = 250 * time.Millisecond
+	shortlinkPersistRetry      = 5 * time.Second
+	shortlinkClickPersistDelay = 10 * time.Second
+	shortlinkStoreVersion      = 3
+	shortlinkObjectName        = "shortlinks.json"
+	shortlinkBucketRefresh     = 5 * time.Second
+	adminShortlinksPerPage     = 50
+	maxShortlinkRecordBytes    = 1 << 20
+	maxCreatorUserAgentLen     = 256
+	maxCreatorIDLen            = 100
 )
 
-//go:embed public/index.html public/privacy.html public/styles.css public/app.js public/favicon.svg public/og-image.svg public/og-image.png public/og-template.svg public/blocked-words.txt
+//go:embed public/index.html public/privacy.html public/print.html public/occasions.html public/countdown.html public/retrospective.html public/card.html public/protected.html public/debug.html public/account.html public/styles.css public/print.css public/app.js public/countdown.js public/card.js public/favicon.svg public/og-image.svg public/og-image.png public/og-template.svg public/*.br public/brotli.sha256 public/blocked-words.txt public/random-greetings.txt public/names.txt public/famous-birthdays.txt public/bodas.txt public/audio/*.wav
 var embeddedFiles embed.FS
 
-var indexTemplate string
+var (
+	indexTemplate         *template.Template
+	privacyTemplate       *template.Template
+	printTemplate         *template.Template
+	occasionsTemplate     *template.Template
+	countdownTemplate     *template.Template
+	retrospectiveTemplate *template.Template
+	cardTemplate          *template.Template
+	protectedTemplate     *template.Template
+	debugTemplate         *template.Template
+	accountTemplate       *template.Template
+)
 
 func init() {
-	tpl, _ := embeddedFiles.ReadFile("public/index.html")
-	indexTemplate = string(tpl)
+	indexTemplate = template.Must(template.ParseFS(embeddedFiles, "public/index.html"))
+	privacyTemplate = template.Must(template.ParseFS(embeddedFiles, "public/privacy.html"))
+	printTemplate = template.Must(template.ParseFS(embeddedFiles, "public/print.html"))
+	occasionsTemplate = template.Must(template.ParseFS(embeddedFiles, "public/occasions.html"))
+	countdownTemplate = template.Must(template.ParseFS(embeddedFiles, "public/countdown.html"))
+	retrospectiveTemplate = template.Must(template.ParseFS(embeddedFiles, "public/retrospective.html"))
+	cardTemplate = template.Must(template.ParseFS(embeddedFiles, "public/card.html"))
+	protectedTemplate = template.Must(template.ParseFS(embeddedFiles, "public/protected.html"))
+	debugTemplate = template.Must(template.ParseFS(embeddedFiles, "public/debug.html"))
+	accountTemplate = template.Must(template.ParseFS(embeddedFiles, "public/account.html"))
 }
 
 type TrackEvent struct {
@@ -48,34 +224,73 @@ type TrackEvent struct {
 }
 
 type ShortLinkRequest struct {
-	Path string `json:"path"`
+	Path      string `json:"path"`
+	Website   string `json:"website"`              // honeypot, left empty by people
+	ExpiresIn int    `json:"expires_in,omitempty"` // days; the link is permanent when 0
+	CreatorID string `json:"creator_id,omitempty"` // the client's own name for its user, kept for moderation
 }
 
 type ShortLinkResponse struct {
-	Code        string `json:"code"`
-	ShortURL    string `json:"short_url"`
-	Path        string `json:"path"`
-	Destination string `json:"destination"`
+	Code        string     `json:"code"`
+	ShortURL    string     `json:"short_url"`
+	Path        string     `json:"path"`
+	Destination string     `json:"destination"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// StatsToken reads GET /s/{code}/stats; only the creation returns it
+	StatsToken string `json:"stats_token,omitempty"`
 }
 
 func main() {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	logs := newAsyncLogWriter(os.Stdout, logBufferLines)
+	defer logs.Close()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], os.Stdout); err != nil {
+			slog.Error("command failed", "command", os.Args[1], "error", err)
+			logs.Close()
+			os.Exit(1)
+		}
+		return
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
+	if err := reloadConfig(); err != nil {
+		slog.Error("config load failed", "error", err)
+		logs.Close()
+		os.Exit(1)
+	}
+	if err := checkShortCodeConfig(); err != nil {
+		slog.Error("invalid shortcode settings", "error", err)
+		logs.Close()
+		os.Exit(1)
+	}
+	watchConfigReload()
+	probeRenderer()
+	startDiskMonitor()
+	startShortlinkBucketRefresher()
+	if readOnly() {
+		slog.Warn("read-only mode, refusing writes")
+	} else {
+		startPhotoSweeper()
+		startReminderScheduler()
+		startDeferredShortlinkReleaser()
+		startShortlinkSweeper()
+	}
+	startBackupScheduler()
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/track", handleTrack)
-	mux.HandleFunc("/s", handleShortlinkCreate)
-	mux.HandleFunc("/s/", handleShortlinkRedirect)
-	mux.HandleFunc("/og-image.png", handleOgImage)
-	mux.HandleFunc("/", handlePage)
+	for _, route := range routes() {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
 
 	srv := &http.Server{
 		Addr:              ":" + port,
-		Handler:           withRequestLogging(withSecurityHeaders(mux)),
+		Handler:           withRequestLogging(withSecurityHeaders(withReadOnly(mux))),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      15 * time.Second,
@@ -83,8 +298,124 @@ func main() {
 		MaxHeaderBytes:    1 << 20,
 	}
 
-	slog.Info("server starting", "addr", "0.0.0.0:"+port)
-	if err := srv.ListenAndServe(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", "0.0.0.0:"+port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
 		slog.Error("server error", "error", err)
+		return
+	case <-ctx.Done():
+	}
+
+	// Let in-flight requests finish, then the render they may have queued,
+	// and write the shortlinks they created
+	slog.Info("server shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server shutdown", "error", err)
+	}
+	if err := flushShortlinks(); err != nil {
+		slog.Error("shortlink store flush", "error", err)
+	}
+	if err := accountsFlush.flush(); err != nil {
+		slog.Error("account store flush", "error", err)
+	}
+	if err := viewsFlush.flush(); err != nil {
+		slog.Error("view store flush", "error", err)
+	}
+	if err := statsFlush.flush(); err != nil {
+		slog.Error("stats store flush", "error", err)
+	}
+	if err := ogQueue.Close(shutdownCtx); err != nil {
+		slog.Error("og render queue shutdown", "error", err)
+	}
+}
+
+// route is a handler and the ServeMux pattern main registers it under.
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// routes are the site's handlers. The first segment of every pattern is a
+// reserved shortlink code.
+func routes() []route {
+	return []route{
+		{"/readyz", handleReadyz},
+		{"/version", handleVersion},
+		{"/api/csrf", handleCSRFToken},
+		{"/api/pow", handlePowChallenge},
+		{"/api/form-token", handleFormToken},
+		{"/api/track", handleTrack},
+		{"/api/guestbook", handleGuestbook},
+		{"/api/send", handleSend},
+		{"/api/send/confirm", handleSendConfirm},
+		{"/api/reminders", handleReminders},
+		{"/api/reminders/confirm", handleReminderConfirm},
+		{"/api/reminders/unsubscribe", handleReminderUnsubscribe},
+		{"/minhas-mensagens", handleAccountPage},
+		{"/minhas-mensagens/entrar", handleLogin},
+		{"/minhas-mensagens/sair", handleLogout},
+		{"/minhas-mensagens/oauth/", handleOAuth},
+		{"/minhas-mensagens/tokens", handleAPITokens},
+		{"/minhas-mensagens/tokens/revogar", handleAPITokens},
+		{"/api/share", handleShare},
+		{"/api/preview", handlePreview},
+		{"/api/suggest", handleSuggest},
+		{"/api/lottie", handleLottie},
+		{"/api/cards", handleCardCreate},
+		{"/api/protected", handleProtectedCreate},
+		{"/api/cards/sign", handleCardSign},
+		{"/api/themes", handleThemes},
+		{"/api/occasions", handleOccasions},
+		{"/api/stats", handleAccountStats},
+		{"/api/usage", handleAPIUsage},
+		{"/api/stats/experiments", handleExperimentStats},
+		{"/api/stats/spam", handleSpamStats},
+		{"/api/cache/purge", handleCachePurge},
+		{"/api/audit", handleAudit},
+		{"/api/webhooks", handleWebhooks},
+		{"/admin/shortlinks", handleAdminShortlinks},
+		{"/api/integrations/slack", handleSlackCommand},
+		{"/api/openapi.json", handleOpenAPI},
+		{"/api/birthdays/", handleBirthdays},
+		{"/s", handleShortlinkCreate},
+		{"/s/", handleShortlinkRedirect},
+		{"/og-image.png", handleOgImage},
+		{"/og-video.mp4", handleOgVideo},
+		{"/card.png", handleCardImage},
+		{"/debug/preview", handleDebugPreview},
+		{"/calendar.ics", handleCalendar},
+		{"/audio/", handleAudio},
+		{"/api/photos", handlePhotoUpload},
+		{"/photos/", handlePhoto},
+		{"/", handlePage},
+	}
+}
+
+// runCommand runs the maintenance command named by args[0] instead of the
+// server.
+func runCommand(args []string, out io.Writer) error {
+	switch args[0] {
+	case "migrate-shortlinks":
+		return runShortlinkMigration(out)
+	case "backup":
+		return runBackupCommand(out)
+	case "restore":
+		return runRestoreCommand(args[1:], out)
+	case "openapi":
+		return runOpenAPICommand(args[1:], out)
+	case "export":
+		return runShortlinkExport(args[1:], out)
+	case "import":
+		return runShortlinkImport(args[1:], out)
 	}
+	return fmt.Errorf("unknown command %q", args[0])
 }
@@ -10,7 +10,7 @@ func withSecurityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("Referrer-Policy", "no-referrer")
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self'; base-uri 'self'; frame-ancestors 'none'")
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy())
 		if r.TLS != nil {
 			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
 		}
@@ -18,6 +18,18 @@ func withSecurityHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// contentSecurityPolicy allows only this origin, plus the CAPTCHA
+// provider's script, frames and API when the CAPTCHA is enabled.
+func contentSecurityPolicy() string {
+	extra := ""
+	if provider, _, _, ok := captchaConfig(); ok {
+		extra = " " + provider.CSPSources
+	}
+	return "default-src 'self'; script-src 'self'" + extra + "; style-src 'self'" + extra +
+		"; img-src 'self'; connect-src 'self'" + extra + "; frame-src 'self'" + extra +
+		"; base-uri 'self'; frame-ancestors 'none'"
+}
+
 type responseRecorder struct {
 	http.ResponseWriter
 	status int
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// nameParticles stay lowercase inside a title-cased name.
+var nameParticles = map[string]bool{"da": true, "de": true, "do": true, "das": true, "dos": true, "e": true}
+
+// fixNameCase polishes lazily typed names: with ?fix=1 the whole message is
+// title-cased; otherwise only a single lowercase word matching a popular
+// first name is replaced by its dataset spelling ("joao" -> "João").
+func fixNameCase(message string, force bool) string {
+	if force {
+		return titleCaseName(message)
+	}
+	if name := knownName(message); name != "" {
+		return name
+	}
+	return message
+}
+
+// titleCaseName capitalizes every word except the particles, lowercasing
+// the rest ("joão DA silva" -> "João da Silva"). Hyphenated and apostrophe
+// parts are capitalized too ("ana-maria d'ávila" -> "Ana-Maria D'Ávila").
+func titleCaseName(value string) string {
+	var b strings.Builder
+	first := true
+	start := 0
+	runes := []rune(value)
+	flush := func(end int) {
+		word := strings.ToLower(string(runes[start:end]))
+		if word == "" {
+			return
+		}
+		if !first && nameParticles[word] {
+			b.WriteString(word)
+			return
+		}
+		first = false
+		wr := []rune(word)
+		wr[0] = unicode.ToUpper(wr[0])
+		b.WriteString(string(wr))
+	}
+	for i, r := range runes {
+		if unicode.IsLetter(r) {
+			continue
+		}
+		flush(i)
+		b.WriteRune(r)
+		start = i + 1
+	}
+	flush(len(runes))
+	return b.String()
+}
+
+// knownName returns the popular first name a single lowercase word spells,
+// ignoring accents, or "".
+func knownName(word string) string {
+	if word == "" || word != strings.ToLower(word) || strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+		return ""
+	}
+	popularNamesOnce.Do(loadPopularNames)
+	key := suggestKey(word)
+	for _, name := range popularNames {
+		if suggestKey(name) == key {
+			return name
+		}
+	}
+	return ""
+}
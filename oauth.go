@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// oauthProvider is an OpenID Connect identity provider offered as an
+// alternative to the e-mail magic link.
+type oauthProvider struct {
+	Name     string // path segment and identity prefix
+	Label    string
+	AuthURL  string
+	TokenURL string
+	Issuer   string
+	Scope    string
+	// Apple returns the code in a POST when e-mail is requested
+	FormPost bool
+}
+
+var oauthProviders = map[string]oauthProvider{
+	"google": {
+		Name:     "google",
+		Label:    "Google",
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token",
+		Issuer:   "https://accounts.google.com",
+		Scope:    "openid email",
+	},
+	"apple": {
+		Name:     "apple",
+		Label:    "Apple",
+		AuthURL:  "https://appleid.apple.com/auth/authorize",
+		TokenURL: "https://appleid.apple.com/auth/token",
+		Issuer:   "https://appleid.apple.com",
+		Scope:    "email",
+		FormPost: true,
+	},
+}
+
+var oauthHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oauthCredentials reads the provider's client ID and secret, e.g.
+// GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET. A provider without them is
+// disabled.
+func oauthCredentials(name string) (clientID, secret string, ok bool) {
+	prefix := strings.ToUpper(name)
+	clientID = os.Getenv(prefix + "_CLIENT_ID")
+	secret = os.Getenv(prefix + "_CLIENT_SECRET")
+	return clientID, secret, clientID != "" && secret != ""
+}
+
+// enabledOAuthProviders lists the configured providers, Google first.
+func enabledOAuthProviders() []oauthProvider {
+	var enabled []oauthProvider
+	for _, name := range []string{"google", "apple"} {
+		if _, _, ok := oauthCredentials(name); ok {
+			enabled = append(enabled, oauthProviders[name])
+		}
+	}
+	return enabled
+}
+
+func oauthRedirectURI(provider oauthProvider) string {
+	return strings.TrimRight(publicBaseURL(), "/") + "/minhas-mensagens/oauth/" + provider.Name + "/callback"
+}
+
+// oauthState is a sign-in in progress. It remembers the logged-in account,
+// if any, because Apple's form POST arrives without the Lax session cookie.
+type oauthState struct {
+	Provider  string    `json:"provider"`
+	LinkEmail string    `json:"link_email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// handleOAuth serves /minhas-mensagens/oauth/{provider}, which sends the
+// browser to the provider, and .../callback, where it comes back.
+func handleOAuth(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/minhas-mensagens/oauth/")
+	name, callback := strings.CutSuffix(rest, "/callback")
+	provider, ok := oauthProviders[name]
+	clientID, secret, configured := oauthCredentials(name)
+	if !ok || !configured || strings.Contains(name, "/") {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if callback {
+		handleOAuthCallback(w, r, provider, clientID, secret)
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if !loginLimiter.allow(clientIP(r)) {
+		http.Error(w, "", http.StatusTooManyRequests)
+		return
+	}
+	if err := ensureAccountsLoaded(); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	linkEmail := sessionEmail(r)
+	accounts.mu.Lock()
+	accounts.data.OAuthStates[tokenHash(state)] = oauthState{Provider: provider.Name, LinkEmail: linkEmail, CreatedAt: time.Now().UTC()}
+	err = persistAccountsLocked()
+	accounts.mu.Unlock()
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	// Binds the state to this browser. SameSite=None lets Apple's
+	// cross-site POST carry it, which browsers only allow on Secure cookies.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    state,
+		Path:     "/minhas-mensagens/oauth/",
+		MaxAge:   int(oauthStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	query := url.Values{
+		"client_id":     {clientID},
+		"redirect_uri":  {oauthRedirectURI(provider)},
+		"response_type": {"code"},
+		"scope":         {provider.Scope},
+		"state":         {state},
+	}
+	if provider.FormPost {
+		query.Set("response_mode", "form_post")
+	}
+	http.Redirect(w, r, provider.AuthURL+"?"+query.Encode(), http.StatusFound)
+}
+
+func handleOAuthCallback(w http.ResponseWriter, r *http.Request, provider oauthProvider, clientID, secret string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		return
+	}
+	params := r.URL.Query()
+	if r.Method == http.MethodPost {
+		body, err := readLimitedBody(r, maxSendBodyBytes)
+		if err != nil {
+			http.Error(w, "", statusFromError(err))
+			return
+		}
+		if params, err = url.ParseQuery(string(body)); err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookieName, Path: "/minhas-mensagens/oauth/", MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteNoneMode})
+
+	stateValue := params.Get("state")
+	cookie, err := r.Cookie(oauthStateCookieName)
+	if err != nil || stateValue == "" || cookie.Value != stateValue {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("Este acesso expirou. Tente entrar novamente."))
+		return
+	}
+	if err := ensureAccountsLoaded(); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	key := tokenHash(stateValue)
+	accounts.mu.Lock()
+	state, ok := accounts.data.OAuthStates[key]
+	delete(accounts.data.OAuthStates, key)
+	accounts.mu.Unlock()
+	if !ok || state.Provider != provider.Name || time.Since(state.CreatedAt) > oauthStateTTL {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("Este acesso expirou. Tente entrar novamente."))
+		return
+	}
+	if params.Get("error") != "" || params.Get("code") == "" {
+		writeHTML(w, r, http.StatusUnauthorized, errorPage("O acesso não foi autorizado."))
+		return
+	}
+
+	claims, err := exchangeOAuthCode(provider, clientID, secret, params.Get("code"))
+	if err != nil {
+		slog.Error("oauth exchange failed", "provider", provider.Name, "error", err)
+		writeHTML(w, r, http.StatusBadGateway, errorPage("Não foi possível entrar agora. Tente novamente mais tarde."))
+		return
+	}
+	email, err := linkOAuthIdentity(provider.Name+":"+claims.Subject, claims.Email, state.LinkEmail)
+	if err != nil {
+		writeHTML(w, r, http.StatusConflict, errorPage(fmt.Sprintf("Esta conta %s já está vinculada a outro e-mail.", provider.Label)))
+		return
+	}
+	if email == "" {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("O e-mail desta conta não foi verificado."))
+		return
+	}
+	if err := startSession(w, r, email); err != nil {
+		slog.Error("session start failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/minhas-mensagens", http.StatusSeeOther)
+}
+
+// idTokenClaims are the OpenID Connect claims used to sign in. Email is
+// empty unless the provider verified it.
+type idTokenClaims struct {
+	Subject string
+	Email   string
+}
+
+// exchangeOAuthCode trades the authorization code for an ID token. The
+// token comes straight from the provider over TLS, so its claims are
+// checked without verifying the signature (OpenID Connect Core 3.1.3.7).
+func exchangeOAuthCode(provider oauthProvider, clientID, secret, code string) (idTokenClaims, error) {
+	resp, err := oauthHTTPClient.PostForm(provider.TokenURL, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oauthRedirectURI(provider)},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+	})
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return idTokenClaims{}, fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return idTokenClaims{}, err
+	}
+	return parseIDToken(token.IDToken, provider.Issuer, clientID, time.Now())
+}
+
+func parseIDToken(idToken, issuer, clientID string, now time.Time) (idTokenClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return idTokenClaims{}, fmt.Errorf("malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("malformed id_token: %w", err)
+	}
+	var claims struct {
+		Issuer        string          `json:"iss"`
+		Audience      json.RawMessage `json:"aud"`
+		Expiry        int64           `json:"exp"`
+		Subject       string          `json:"sub"`
+		Email         string          `json:"email"`
+		EmailVerified any             `json:"email_verified"` // Apple sends "true"
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return idTokenClaims{}, fmt.Errorf("malformed id_token: %w", err)
+	}
+	var audiences []string
+	if json.Unmarshal(claims.Audience, &audiences) != nil {
+		audiences = []string{""}
+		_ = json.Unmarshal(claims.Audience, &audiences[0])
+	}
+	switch {
+	case strings.TrimPrefix(claims.Issuer, "https://") != strings.TrimPrefix(issuer, "https://"):
+		return idTokenClaims{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case !containsString(audiences, clientID):
+		return idTokenClaims{}, fmt.Errorf("unexpected audience")
+	case now.Unix() >= claims.Expiry:
+		return idTokenClaims{}, fmt.Errorf("expired id_token")
+	case claims.Subject == "":
+		return idTokenClaims{}, fmt.Errorf("missing subject")
+	}
+	result := idTokenClaims{Subject: claims.Subject}
+	if claims.EmailVerified == true || claims.EmailVerified == "true" {
+		result.Email, _ = parseEmailAddress(claims.Email)
+	}
+	return result, nil
+}
+
+var errIdentityLinked = fmt.Errorf("identity linked to another account")
+
+// linkOAuthIdentity resolves a provider identity ("google:1234") to the
+// account it signs into. An identity already linked keeps its account;
+// otherwise it is linked to the logged-in account (linkEmail) or, for a new
+// sign-in, to the account of the verified e-mail. It returns "" when there
+// is no account to use.
+func linkOAuthIdentity(identity, verifiedEmail, linkEmail string) (string, error) {
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+	for _, acc := range accounts.data.Accounts {
+		if containsString(acc.Identities, identity) {
+			if linkEmail != "" && linkEmail != acc.Email {
+				return "", errIdentityLinked
+			}
+			return acc.Email, nil
+		}
+	}
+	email := linkEmail
+	if email == "" {
+		email = verifiedEmail
+	}
+	if email == "" {
+		return "", nil
+	}
+	acc := accountLocked(email)
+	acc.Identities = append(acc.Identities, identity)
+	if err := persistAccountsLocked(); err != nil {
+		slog.Error("account store persist failed", "error", err)
+	}
+	return email, nil
+}
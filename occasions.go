@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type OccasionInfo struct {
+	Prefix      string `json:"prefix"`
+	Greeting    string `json:"greeting"`
+	Subtitle    string `json:"subtitle"`
+	Emoji       string `json:"emoji"`
+	Animation   string `json:"animation"`
+	ExampleURL  string `json:"example_url"`
+	ComposerURL string `json:"composer_url"`
+}
+
+const exampleOccasionName = "João"
+
+// allOccasions returns the general greeting followed by every occasion,
+// config-defined ones replacing built-ins with the same prefix.
+func allOccasions() []Occasion {
+	merged := make(map[string]Occasion, len(occasions))
+	for prefix, occ := range occasions {
+		merged[prefix] = occ
+	}
+	configMu.RLock()
+	for prefix, occ := range customOccasions {
+		merged[prefix] = occ
+	}
+	configMu.RUnlock()
+
+	list := make([]Occasion, 0, len(merged)+1)
+	for _, occ := range merged {
+		list = append(list, occ)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Prefix < list[j].Prefix })
+	return append([]Occasion{generalOccasion()}, list...)
+}
+
+func occasionInfo(occ Occasion) OccasionInfo {
+	base := strings.TrimRight(publicBaseURL(), "/")
+	path := "/" + encodePathSegment(exampleOccasionName)
+	composer := "/"
+	if occ.Prefix != "" {
+		path = "/" + occ.Prefix + path
+		composer = "/?ocasiao=" + occ.Prefix
+	}
+	animation := occ.Animation
+	if animation == "" {
+		animation = lottieConfetti
+	}
+	return OccasionInfo{
+		Prefix:      occ.Prefix,
+		Animation:   animation,
+		Greeting:    occ.Greeting,
+		Subtitle:    occ.Subtitle,
+		Emoji:       occ.Emoji,
+		ExampleURL:  base + path,
+		ComposerURL: base + composer,
+	}
+}
+
+func handleOccasions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	list := allOccasions()
+	infos := make([]OccasionInfo, 0, len(list))
+	for _, occ := range list {
+		infos = append(infos, occasionInfo(occ))
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// OccasionsPageData is the data model of public/occasions.html.
+type OccasionsPageData struct {
+	Title     string
+	Heading   string
+	OgDesc    string
+	OgURL     string
+	OgImage   string
+	Single    bool
+	Occasions []OccasionInfo
+}
+
+// handleOccasionsPage serves /ocasioes, a crawlable index of every occasion,
+// and /ocasioes/{prefix}, one occasion with its own OG tags.
+func handleOccasionsPage(w http.ResponseWriter, r *http.Request, prefix string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	site := siteIdentity()
+	base := strings.TrimRight(publicBaseURL(), "/")
+	data := OccasionsPageData{
+		Title:   "Ocasiões - " + site.Name,
+		Heading: "Ocasiões",
+		OgDesc:  "Crie mensagens de parabéns para aniversários, formaturas, casamentos e muito mais.",
+		OgURL:   base + "/ocasioes",
+		OgImage: ogImageURL(base, ogImageSpec{}),
+	}
+	if prefix == "" {
+		for _, occ := range allOccasions() {
+			data.Occasions = append(data.Occasions, occasionInfo(occ))
+		}
+	} else {
+		occ, ok := lookupOccasion(prefix)
+		if !ok {
+			writeHTML(w, r, http.StatusNotFound, errorPage("Ocasião não encontrada."))
+			return
+		}
+		spec := ogImageSpec{Text: occ.Greeting}
+		if occ.OgTemplate != "" {
+			spec.Occasion = occ.Prefix
+		}
+		data.Title = occ.Greeting + " - " + site.Name
+		data.Heading = occ.Emoji + " " + occ.Greeting
+		data.OgDesc = occ.Subtitle + " " + occ.Emoji
+		data.OgURL = base + "/ocasioes/" + occ.Prefix
+		data.OgImage = ogImageURL(base, spec)
+		data.Single = true
+		data.Occasions = []OccasionInfo{occasionInfo(occ)}
+	}
+
+	var b strings.Builder
+	if err := occasionsTemplate.Execute(&b, data); err != nil {
+		slog.Error("occasions render failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	setCacheHeaders(w, cachePages, "pages")
+	writeHTML(w, r, http.StatusOK, b.String())
+}
@@ -3,68 +3,310 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
+// ogImageSpec describes everything that affects a rendered OG image.
+type ogImageSpec struct {
+	Text     string
+	Occasion string // prefix of an occasion with its own OG template
+	Photo    string // ID of an uploaded photo shown next to the text
+	Accent   string // validated RRGGBB replacing the default accent color
+	Theme    string // name of a non-default theme recoloring the image
+	Emoji    string // allowlisted emoji replacing the template's 🎉
+	Locale   string // ?lang= of a translated greeting
+}
+
+func (s ogImageSpec) cacheKey() string {
+	key := ogCacheKey(s.Text)
+	if s.Occasion != "" {
+		key = s.Occasion + "--" + key
+	}
+	key += "--v-" + ogTemplateVersion(s.Occasion)
+	if s.Photo != "" {
+		key += "--" + s.Photo
+	}
+	if s.Accent != "" {
+		key += "--" + s.Accent
+	}
+	if s.Theme != "" {
+		key += "--t-" + s.Theme
+		if theme, ok := lookupTheme(s.Theme); ok {
+			key += "-" + theme.paletteVersion()
+		}
+	}
+	if s.Emoji != "" {
+		key += "--e-" + hex.EncodeToString([]byte(s.Emoji))
+	}
+	if s.Locale != "" {
+		key += "--l-" + s.Locale
+	}
+	return key
+}
+
+// ogDefaultTemplateSum hashes the embedded OG template.
+var ogDefaultTemplateSum = func() string {
+	data, _ := embeddedFiles.ReadFile("public/og-template.svg")
+	return shortHash(string(data))
+}()
+
+// ogTemplateVersion hashes the template the images of occasion are drawn
+// from, as loaded with the configuration, and the site name written on
+// them, so images rendered before either changed are not served from the
+// cache.
+func ogTemplateVersion(occasion string) string {
+	sum := ogDefaultTemplateSum
+	if occ, ok := lookupOccasion(occasion); ok && occ.ogTemplateSum != "" {
+		sum = occ.ogTemplateSum
+	}
+	return shortHash(sum + siteIdentity().Name)[:8]
+}
+
 type ogImageJob struct {
-	key  string
-	text string
-	done chan error
+	spec   ogImageSpec
+	path   string
+	render func(ogImageSpec, string) error
+	done   chan error
 }
 
+// ogImageQueue runs renders one at a time on a single worker. A render
+// that panics fails its own job and the worker carries on with the next;
+// Close lets the current render finish and fails the queued ones.
 type ogImageQueue struct {
-	jobs chan ogImageJob
+	jobs    chan ogImageJob
+	quit    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	breaker *renderBreaker // OG images only
 }
 
+var (
+	errOgQueueClosed     = errors.New("og render queue closed")
+	errRenderCircuitOpen = errors.New("og render circuit open")
+)
+
 var ogQueue = newOgImageQueue()
 
 var renderOgImageToFileFunc = renderOgImageToFile
 
 func newOgImageQueue() *ogImageQueue {
-	q := &ogImageQueue{jobs: make(chan ogImageJob, 32)}
+	q := &ogImageQueue{
+		jobs:    make(chan ogImageJob, 32),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		breaker: &renderBreaker{threshold: ogBreakerFailures, window: ogBreakerWindow, cooldown: ogBreakerCooldown},
+	}
 	go q.run()
 	return q
 }
 
 func (q *ogImageQueue) run() {
-	for job := range q.jobs {
-		cachePath := ogCachePath(job.key)
-		if ok, err := fileExists(cachePath); ok && err == nil {
-			job.done <- nil
-			continue
+	defer close(q.stopped)
+	for {
+		select {
+		case job := <-q.jobs:
+			select {
+			case <-q.quit:
+				job.done <- errOgQueueClosed
+			default:
+				job.done <- runOgImageJob(job)
+			}
+		case <-q.quit:
+			for {
+				select {
+				case job := <-q.jobs:
+					job.done <- errOgQueueClosed
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// runOgImageJob renders job unless its file already exists, turning a
+// panic in the renderer into the job's error.
+func runOgImageJob(job ogImageJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("og render panicked", "path", job.path, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("og render panicked: %v", r)
 		}
-		job.done <- renderOgImageToFileFunc(job.text, cachePath)
+	}()
+	if ok, err := fileExists(job.path); ok && err == nil {
+		return nil
 	}
+	return job.render(job.spec, job.path)
 }
 
-func (q *ogImageQueue) render(key, text string) error {
+// render renders the OG image for spec into its cache file. While the
+// circuit is open it returns errRenderCircuitOpen without trying.
+func (q *ogImageQueue) render(key string, spec ogImageSpec) error {
+	if !q.breaker.allow() {
+		return errRenderCircuitOpen
+	}
+	err := q.renderTo(ogCachePath(key), spec, renderOgImageToFileFunc)
+	switch {
+	case err == nil:
+		q.breaker.success()
+	case !errors.Is(err, errOgQueueClosed):
+		q.breaker.failure()
+	}
+	return err
+}
+
+// renderBreaker stops OG renders after threshold failures within window:
+// when the converter keeps failing (missing fonts, killed for memory), every
+// crawler hit would otherwise wait on a doomed subprocess. The circuit stays
+// open for cooldown, then lets renders through again; one more failure
+// before a success opens it straight away.
+type renderBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  []time.Time
+	openUntil time.Time
+	probing   bool // cooldown over, no success since
+}
+
+func (b *renderBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+func (b *renderBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.probing = nil, false
+}
+
+func (b *renderBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	cutoff := now.Add(-b.window)
+	recent := b.failures[:0]
+	for _, ts := range b.failures {
+		if ts.After(cutoff) {
+			recent = append(recent, ts)
+		}
+	}
+	b.failures = append(recent, now)
+	if b.probing || len(b.failures) >= b.threshold {
+		b.openUntil, b.probing, b.failures = now.Add(b.cooldown), true, nil
+		slog.Error("og render circuit open, serving the default image", "cooldown", b.cooldown.String())
+	}
+}
+
+// isOpen reports whether renders are being refused.
+func (b *renderBreaker) isOpen() bool {
+	return !b.allow()
+}
+
+// renderTo renders spec into destPath with render unless the file already
+// exists; OG images and card PDFs share the queue so rsvg-convert runs once
+// at a time. It returns errOgQueueClosed once the queue is closed.
+func (q *ogImageQueue) renderTo(destPath string, spec ogImageSpec, render func(ogImageSpec, string) error) error {
 	done := make(chan error, 1)
-	q.jobs <- ogImageJob{key: key, text: text, done: done}
-	return <-done
+	select {
+	case q.jobs <- ogImageJob{spec: spec, path: destPath, render: render, done: done}:
+	case <-q.quit:
+		return errOgQueueClosed
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-q.stopped:
+		// The worker may have answered just before stopping
+		select {
+		case err := <-done:
+			return err
+		default:
+			return errOgQueueClosed
+		}
+	}
 }
 
-func renderOgImageToFile(text, destPath string) error {
-	converter, err := exec.LookPath("rsvg-convert")
-	if err != nil {
-		return fmt.Errorf("rsvg-convert not found: %w", err)
+// Close stops taking jobs, fails the queued ones and waits for the render
+// in progress, or for ctx.
+func (q *ogImageQueue) Close(ctx context.Context) error {
+	q.once.Do(func() { close(q.quit) })
+	select {
+	case <-q.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+}
+
+func renderOgImageToFile(spec ogImageSpec, destPath string) error {
+	svg, err := ogImageSVG(spec)
+	if err != nil {
 		return err
 	}
-	tpl, err := embeddedFiles.ReadFile("public/og-template.svg")
+	return rsvgConvert(svg, destPath, "-w", strconv.Itoa(ogImageWidth), "-h", strconv.Itoa(ogImageHeight))
+}
+
+// ogImageSVG fills the OG template for spec: text, site name, colors and photo.
+func ogImageSVG(spec ogImageSpec) (string, error) {
+	tpl, err := ogTemplate(spec)
+	if err != nil {
+		return "", err
+	}
+	svg := ogTextSVG(string(tpl), spec.Text)
+	svg = strings.ReplaceAll(svg, "__SITE_NAME__", escapeXML(siteIdentity().Name))
+	if spec.Accent != "" {
+		svg = strings.ReplaceAll(svg, ogDefaultAccent, "#"+spec.Accent)
+	}
+	if spec.Emoji != "" {
+		svg = strings.Replace(svg, ">"+ogDefaultEmoji+"</text>", ">"+escapeXML(spec.Emoji)+"</text>", 1)
+	}
+	if theme, ok := lookupTheme(spec.Theme); ok && spec.Theme != "" {
+		svg = ogApplyPalette(svg, theme.Palette, spec.Accent != "")
+	}
+	if spec.Photo != "" {
+		photo, err := ogPhotoSVG(spec.Photo)
+		if err != nil {
+			return "", err
+		}
+		if idx := strings.LastIndex(svg, "</svg>"); idx != -1 {
+			svg = svg[:idx] + photo + svg[idx:]
+		}
+	}
+	return svg, nil
+}
+
+// rsvgConvert converts svg into destPath, passing args (size, format) to
+// rsvg-convert.
+func rsvgConvert(svg, destPath string, args ...string) error {
+	converter, err := rendererCommand()
 	if err != nil {
+		return fmt.Errorf("rsvg-convert not found: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
 		return err
 	}
-	svg := strings.ReplaceAll(string(tpl), "__TEXT__", escapeXML(text))
 	ctx, cancel := context.WithTimeout(context.Background(), ogRenderTimeout)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, converter, "-w", strconv.Itoa(ogImageWidth), "-h", strconv.Itoa(ogImageHeight), "-o", destPath)
+	cmd := exec.CommandContext(ctx, converter, append(args, "-o", destPath)...)
 	cmd.Stdin = strings.NewReader(svg)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -78,25 +320,114 @@ func renderOgImageToFile(text, destPath string) error {
 	return nil
 }
 
-func ogImageURL(baseURL, message string) string {
+// ogImagePNG returns the rendered OG image for spec, falling back to the
+// static default image when the text is unusable or rendering fails.
+func ogImagePNG(spec ogImageSpec) []byte {
+	spec.Text = ogImageTextPrefix(spec.Text)
+	if spec.Text != "" && !looksLikePath(spec.Text) && !isBlockedMessage(spec.Text) && rendererStatus().Available && diskHealthy("cache") {
+		key := spec.cacheKey()
+		cachePath := ogCachePath(key)
+		if ok, err := fileExists(cachePath); !ok || err != nil {
+			if err := ogQueue.render(key, spec); err != nil && !errors.Is(err, errRenderCircuitOpen) {
+				slog.Error("og-image render failed", "error", err)
+			}
+		}
+		if data, err := os.ReadFile(cachePath); err == nil {
+			return data
+		}
+	}
+	data, _ := embeddedFiles.ReadFile("public/og-image.png")
+	return data
+}
+
+// ogTemplate returns the SVG template for spec: the occasion's configured
+// template when it has one, the embedded default otherwise.
+func ogTemplate(spec ogImageSpec) ([]byte, error) {
+	if spec.Occasion != "" {
+		if occ, ok := lookupOccasion(spec.Occasion); ok && occ.OgTemplate != "" {
+			return os.ReadFile(occ.OgTemplate)
+		}
+	}
+	return embeddedFiles.ReadFile("public/og-template.svg")
+}
+
+func ogImageURL(baseURL string, spec ogImageSpec) string {
+	if ogImageTextPrefix(spec.Text) == "" {
+		return strings.TrimRight(baseURL, "/") + "/og-image.png"
+	}
+	return ogAssetURL(baseURL, "/og-image.png", spec)
+}
+
+// ogAssetURL links an asset rendered from spec, such as the OG image or
+// video; the handler rebuilds the spec with ogImageSpecFromQuery.
+func ogAssetURL(baseURL, path string, spec ogImageSpec) string {
 	base := strings.TrimRight(baseURL, "/")
-	prefix := ogImageTextPrefix(message)
-	if prefix == "" {
-		return base + "/og-image.png"
+	prefix := ogImageTextPrefix(spec.Text)
+	query := "text=" + url.QueryEscape(prefix)
+	if spec.Occasion != "" {
+		query += "&occasion=" + url.QueryEscape(spec.Occasion)
+	}
+	if spec.Photo != "" {
+		query += "&foto=" + url.QueryEscape(spec.Photo)
+	}
+	if spec.Accent != "" {
+		query += "&cor=" + spec.Accent
+	}
+	if spec.Theme != "" {
+		query += "&theme=" + url.QueryEscape(spec.Theme)
+	}
+	if spec.Emoji != "" {
+		query += "&emoji=" + url.QueryEscape(spec.Emoji)
+	}
+	if spec.Locale != "" {
+		query += "&lang=" + spec.Locale
 	}
-	return base + "/og-image.png?text=" + url.QueryEscape(prefix)
+	return base + path + "?" + query
 }
 
+var svgXAttr = regexp.MustCompile(`\sx="([^"]*)"`)
+
+// ogTextSVG fills the __TEXT__ placeholder. Multi-line text becomes tspans
+// centered on the template's baseline, aligned to the x of its <text>.
+func ogTextSVG(tpl, text string) string {
+	lines := strings.Split(text, "\n")
+	if len(lines) == 1 {
+		return strings.ReplaceAll(tpl, "__TEXT__", escapeXML(text))
+	}
+	x := "0"
+	if idx := strings.Index(tpl, "__TEXT__"); idx != -1 {
+		if start := strings.LastIndex(tpl[:idx], "<text"); start != -1 {
+			if m := svgXAttr.FindStringSubmatch(tpl[start:idx]); m != nil {
+				x = m[1]
+			}
+		}
+	}
+	var b strings.Builder
+	for i, line := range lines {
+		dy := "1.2em"
+		if i == 0 {
+			dy = fmt.Sprintf("-%.1fem", 0.6*float64(len(lines)-1))
+		}
+		fmt.Fprintf(&b, `<tspan x="%s" dy="%s">%s</tspan>`, x, dy, escapeXML(line))
+	}
+	return strings.ReplaceAll(tpl, "__TEXT__", b.String())
+}
+
+// ogImageTextPrefix normalizes the OG image text, keeping at most
+// ogImageMaxLines lines of up to ogImageTextLimit runes each.
 func ogImageTextPrefix(message string) string {
-	message = strings.Join(strings.Fields(strings.TrimSpace(message)), " ")
-	if message == "" {
-		return ""
+	lines := messageLines(message)
+	if len(lines) > ogImageMaxLines {
+		lines = append(lines[:ogImageMaxLines-1], strings.Join(lines[ogImageMaxLines-1:], " "))
 	}
-	runes := []rune(message)
-	if len(runes) > ogImageTextLimit {
-		return string(runes[:ogImageTextLimit]) + "…"
+	for i, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if runes := []rune(line); len(runes) > ogImageTextLimit {
+			line = string(runes[:ogImageTextLimit]) + "…"
+		}
+		lines[i] = line
 	}
-	return message
+	return strings.Join(lines, "\n")
 }
 
 func ogCacheKey(message string) string {
@@ -111,19 +442,25 @@ func ogCacheKey(message string) string {
 			return r
 		case r >= '0' && r <= '9':
 			return r
-		case r == ' ':
-			return '-'
+		case r == '\n':
+			return '~'
 		default:
 			return '-'
 		}
 	}, normalized)
 	normalized = strings.Trim(normalized, "-")
 	if normalized == "" {
-		return "default"
+		normalized = "default"
 	}
 	if len(normalized) > ogImageTextLimit {
 		normalized = normalized[:ogImageTextLimit]
 	}
+	// Letters beyond Latin-1 (Cyrillic, Greek, CJK...) all become dashes, so
+	// a hash keeps "Мария" and "Алексей" from sharing an image
+	if strings.IndexFunc(prefix, func(r rune) bool { return r > 'ÿ' && unicode.IsLetter(r) }) >= 0 {
+		sum := sha256.Sum256([]byte(prefix))
+		normalized += "-" + hex.EncodeToString(sum[:4])
+	}
 	return normalized
 }
 
@@ -132,6 +469,7 @@ func ogCachePath(key string) string {
 }
 
 func ogCacheDir() string {
+	siteDomain := siteIdentity().Domain
 	if value := os.Getenv("XDG_CACHE_DIR"); value != "" {
 		return filepath.Join(value, siteDomain)
 	}
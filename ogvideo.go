@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var renderOgVideoToFileFunc = renderOgVideoToFile
+
+// ogVideoEnabled reports whether og:video greetings are turned on. Encoding
+// takes a few seconds per card, so deployments opt in with OG_VIDEO=1.
+func ogVideoEnabled() bool {
+	return os.Getenv("OG_VIDEO") == "1"
+}
+
+// confettiPiece is one falling rectangle of the video. Pieces fall a whole
+// number of screens per loop, so the video loops seamlessly.
+type confettiPiece struct {
+	X, Y, Drift float64
+	Laps        int
+	Angle, Spin float64
+	Color       string
+}
+
+var confettiColors = []string{"#fbbf24", "#f472b6", "#60a5fa", "#34d399", "#a78bfa", "#f87171"}
+
+func confettiPieces(accent string) []confettiPiece {
+	rng := rand.New(rand.NewPCG(2, 3))
+	colors := confettiColors
+	if accent != "" {
+		colors = append([]string{"#" + accent}, colors...)
+	}
+	pieces := make([]confettiPiece, ogVideoConfetti)
+	for i := range pieces {
+		pieces[i] = confettiPiece{
+			X:     rng.Float64() * ogImageWidth,
+			Y:     rng.Float64() * ogImageHeight,
+			Drift: rng.Float64()*30 - 15,
+			Laps:  1 + rng.IntN(2),
+			Angle: rng.Float64() * 360,
+			Spin:  360 * float64(1+rng.IntN(2)),
+			Color: colors[i%len(colors)],
+		}
+	}
+	return pieces
+}
+
+// confettiFrameSVG draws the given frame of the falling confetti over the
+// card.
+func confettiFrameSVG(svg string, pieces []confettiPiece, frame int) string {
+	t := float64(frame) / ogVideoFrames
+	var b strings.Builder
+	for _, p := range pieces {
+		span := ogImageHeight + 20.0
+		y := p.Y + t*float64(p.Laps)*span
+		for y > ogImageHeight+10 {
+			y -= span
+		}
+		x := p.X + p.Drift*math.Sin(2*math.Pi*t)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="9" height="5" rx="1" fill="%s" transform="rotate(%.0f %.1f %.1f)"/>`,
+			x-4.5, y-2.5, p.Color, math.Mod(p.Angle+p.Spin*t, 360), x, y)
+	}
+	if idx := strings.LastIndex(svg, "</svg>"); idx != -1 {
+		return svg[:idx] + b.String() + svg[idx:]
+	}
+	return svg
+}
+
+// renderOgVideoToFile renders the card with falling confetti frame by frame
+// through rsvg-convert and encodes the frames to an MP4 with ffmpeg.
+func renderOgVideoToFile(spec ogImageSpec, destPath string) error {
+	encoder, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg not found: %w", err)
+	}
+	svg, err := ogImageSVG(spec)
+	if err != nil {
+		return err
+	}
+	frames, err := os.MkdirTemp("", "og-video-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(frames)
+	pieces := confettiPieces(spec.Accent)
+	for i := 0; i < ogVideoFrames; i++ {
+		frame := filepath.Join(frames, fmt.Sprintf("frame%03d.png", i))
+		if err := rsvgConvert(confettiFrameSVG(svg, pieces, i), frame, "-w", strconv.Itoa(ogVideoWidth), "-h", strconv.Itoa(ogVideoHeight)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return err
+	}
+	tmpPath := destPath + ".tmp.mp4"
+	ctx, cancel := context.WithTimeout(context.Background(), ogVideoTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, encoder, "-y", "-loglevel", "error",
+		"-framerate", strconv.Itoa(ogVideoFPS), "-i", filepath.Join(frames, "frame%03d.png"),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart", tmpPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(tmpPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.Rename(tmpPath, destPath)
+}
+
+func ogVideoCachePath(key string) string {
+	return filepath.Join(ogCacheDir(), "video", key+".mp4")
+}
+
+// ogVideoURL is the og:video of spec, or "" when videos are disabled or
+// there is no text to animate.
+func ogVideoURL(baseURL string, spec ogImageSpec) string {
+	if !ogVideoEnabled() || ogImageTextPrefix(spec.Text) == "" {
+		return ""
+	}
+	return ogAssetURL(baseURL, "/og-video.mp4", spec)
+}
+
+// handleOgVideo serves GET /og-video.mp4 with the same parameters as
+// /og-image.png, rendering through the OG queue on a cache miss.
+func handleOgVideo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !ogVideoEnabled() {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	spec, ok := ogImageSpecFromQuery(r.URL.Query())
+	if !ok {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	cachePath := ogVideoCachePath(spec.cacheKey())
+	if ok, err := fileExists(cachePath); !ok || err != nil {
+		if err := ogQueue.renderTo(cachePath, spec, renderOgVideoToFileFunc); err != nil {
+			slog.Error("og-video render failed", "error", err)
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	file, err := os.Open(cachePath)
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	setCacheHeaders(w, cacheOgImages, "og", ogSurrogateKey(spec.cacheKey()))
+	http.ServeContent(w, r, "parabens.mp4", info.ModTime(), file)
+}
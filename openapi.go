@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The HTTP API is described by apiOperations, with the Go types the
+// handlers read and write. buildOpenAPI turns it into an OpenAPI 3.1
+// document, reflecting the types into schemas, so the document cannot
+// drift from the handlers; it is served at /api/openapi.json, printed by
+// "parabens-vc openapi" and generates the client in ./client.
+
+// apiOperation is one method on one route.
+type apiOperation struct {
+	Method, Path string
+	ID, Summary  string
+	Tag          string
+	Auth         string // "", "token" (API token or session), "admin" or "stats" (a shortlink's stats token)
+	Params       []apiParam
+	Request      any      // JSON body, as a zero value of its type
+	RequestTypes []string // content types of a non-JSON body
+	Responses    []apiResponse
+}
+
+type apiParam struct {
+	Name, In    string // In is "query", "path" or "header"
+	Type        string // "string" (the default) or "integer"
+	Required    bool
+	Description string
+}
+
+type apiResponse struct {
+	Status      int
+	Description string
+	Body        any    // JSON body, as a zero value of its type
+	ContentType string // of a non-JSON body
+}
+
+var (
+	greetingPathParam = apiParam{Name: "path", In: "query", Required: true, Description: "Greeting path, with its query string, as /aniversario/Ana?de=Bia"}
+	tokenParam        = apiParam{Name: "token", In: "query", Required: true, Description: "Token from the e-mail"}
+	errorResponse     = apiResponse{Description: "Error", Body: APIErrorResponse{}}
+)
+
+var apiOperations = []apiOperation{
+	{Method: http.MethodGet, Path: "/readyz", ID: "getReadiness", Tag: "health", Summary: "Readiness and degraded dependencies",
+		Responses: []apiResponse{{Status: 200, Body: Readiness{}}}},
+	{Method: http.MethodGet, Path: "/version", ID: "getVersion", Tag: "health", Summary: "Build and renderer versions",
+		Responses: []apiResponse{{Status: 200, Body: VersionInfo{}}}},
+	{Method: http.MethodGet, Path: "/api/openapi.json", ID: "getOpenAPI", Tag: "health", Summary: "This document",
+		Responses: []apiResponse{{Status: 200, Body: map[string]any{}}}},
+
+	{Method: http.MethodGet, Path: "/api/csrf", ID: "getCSRFToken", Tag: "security", Summary: "CSRF token for the browser forms, also set as a cookie",
+		Responses: []apiResponse{{Status: 200, Body: map[string]string{}}}},
+	{Method: http.MethodGet, Path: "/api/pow", ID: "getPowChallenge", Tag: "security", Summary: "Proof-of-work challenge",
+		Responses: []apiResponse{{Status: 200, Body: PowChallenge{}}}},
+	{Method: http.MethodGet, Path: "/api/form-token", ID: "getFormToken", Tag: "security", Summary: "Token timing the composer form",
+		Responses: []apiResponse{{Status: 200, Body: map[string]string{}}}},
+
+	{Method: http.MethodPost, Path: "/s", ID: "createShortlink", Tag: "shortlinks", Auth: "token", Summary: "Create or reuse the shortlink of a greeting",
+		Params: []apiParam{
+			{Name: csrfHeaderName, In: "header", Description: "Without an API token"},
+			{Name: captchaHeaderName, In: "header", Description: "With CAPTCHA_PROVIDER, anonymous requests"},
+			{Name: powChallengeHeaderName, In: "header", Description: "With POW_DIFFICULTY or a 428, anonymous requests"},
+			{Name: powNonceHeaderName, In: "header"},
+			{Name: formTokenHeaderName, In: "header", Description: "Without an API token"},
+		},
+		Request: ShortLinkRequest{},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Existing shortlink", Body: ShortLinkResponse{}},
+			{Status: 201, Description: "New shortlink", Body: ShortLinkResponse{}},
+			{Status: 202, Description: "Deferred by the spam filter", Body: map[string]string{}},
+			{Status: 428, Description: "Proof of work required", Body: PowRequiredResponse{}},
+		}},
+	{Method: http.MethodGet, Path: "/s/{code}", ID: "followShortlink", Tag: "shortlinks", Summary: "Redirect to the greeting",
+		Params:    []apiParam{{Name: "code", In: "path", Required: true}},
+		Responses: []apiResponse{{Status: 302, Description: "Redirect to the greeting"}}},
+	{Method: http.MethodGet, Path: "/s/{code}.json", ID: "getShortlinkPreview", Tag: "shortlinks", Summary: "Destination, title and OG image of a shortlink, without redirecting",
+		Params:    []apiParam{{Name: "code", In: "path", Required: true}},
+		Responses: []apiResponse{{Status: 200, Body: ShortlinkPreview{}}}},
+	{Method: http.MethodGet, Path: "/s/{code}/stats", ID: "getShortlinkStats", Tag: "shortlinks", Auth: "stats", Summary: "Redirects of a shortlink, for its creator",
+		Params:    []apiParam{{Name: "code", In: "path", Required: true}},
+		Responses: []apiResponse{{Status: 200, Body: ShortlinkStats{}}}},
+	{Method: http.MethodPost, Path: "/api/share", ID: "share", Tag: "shortlinks", Summary: "Shortlink and share links of a greeting",
+		Params: []apiParam{
+			{Name: csrfHeaderName, In: "header", Required: true},
+			{Name: captchaHeaderName, In: "header", Description: "With CAPTCHA_PROVIDER, anonymous requests"},
+			{Name: powChallengeHeaderName, In: "header", Description: "With POW_DIFFICULTY or a 428, anonymous requests"},
+			{Name: powNonceHeaderName, In: "header"},
+		},
+		Request: ShareRequest{},
+		Responses: []apiResponse{
+			{Status: 200, Body: ShareResponse{}},
+			{Status: 202, Description: "Deferred by the spam filter", Body: map[string]string{}},
+			{Status: 428, Description: "Proof of work required", Body: PowRequiredResponse{}},
+		}},
+
+	{Method: http.MethodGet, Path: "/api/preview", ID: "getPreview", Tag: "greetings", Summary: "Metadata of a greeting page",
+		Params: []apiParam{greetingPathParam}, Responses: []apiResponse{{Status: 200, Body: PreviewResponse{}}}},
+	{Method: http.MethodGet, Path: "/api/suggest", ID: "suggestNames", Tag: "greetings", Summary: "Popular names starting with q",
+		Params: []apiParam{{Name: "q", In: "query", Required: true}}, Responses: []apiResponse{{Status: 200, Body: []string{}}}},
+	{Method: http.MethodGet, Path: "/api/lottie", ID: "getLottie", Tag: "greetings", Summary: "Lottie animation of a greeting",
+		Params: []apiParam{greetingPathParam}, Responses: []apiResponse{{Status: 200, Body: map[string]any{}}}},
+	{Method: http.MethodGet, Path: "/api/occasions", ID: "listOccasions", Tag: "greetings", Summary: "Occasions",
+		Responses: []apiResponse{{Status: 200, Body: []OccasionInfo{}}}},
+	{Method: http.MethodGet, Path: "/api/themes", ID: "listThemes", Tag: "greetings", Summary: "Themes",
+		Responses: []apiResponse{{Status: 200, Body: []Theme{}}}},
+	{Method: http.MethodGet, Path: "/api/birthdays/{date}", ID: "listFamousBirthdays", Tag: "greetings", Summary: "Famous people born on a day",
+		Params:    []apiParam{{Name: "date", In: "path", Required: true, Description: "MM-DD"}},
+		Responses: []apiResponse{{Status: 200, Body: []FamousBirthday{}}}},
+
+	{Method: http.MethodPost, Path: "/api/track", ID: "track", Tag: "tracking", Summary: "Record a page event",
+		Request: TrackEvent{}, Responses: []apiResponse{{Status: 204, Description: "Recorded"}}},
+
+	{Method: http.MethodGet, Path: "/api/guestbook", ID: "listGuestbook", Tag: "messages", Summary: "Guestbook entries of a greeting",
+		Params: []apiParam{greetingPathParam}, Responses: []apiResponse{{Status: 200, Body: []GuestbookEntry{}}}},
+	{Method: http.MethodPost, Path: "/api/guestbook", ID: "signGuestbook", Tag: "messages", Summary: "Sign the guestbook of a greeting",
+		Params:  []apiParam{{Name: csrfHeaderName, In: "header", Required: true}, {Name: captchaHeaderName, In: "header"}},
+		Request: GuestbookRequest{}, Responses: []apiResponse{{Status: 201, Body: GuestbookEntry{}}}},
+	{Method: http.MethodPost, Path: "/api/send", ID: "sendCard", Tag: "messages", Summary: "E-mail a greeting, after the recipient opts in",
+		Request: SendRequest{}, Responses: []apiResponse{{Status: 202, Body: SendResponse{}}}},
+	{Method: http.MethodGet, Path: "/api/send/confirm", ID: "confirmSendPage", Tag: "messages", Summary: "Opt-in page of the e-mail",
+		Params: []apiParam{tokenParam}, Responses: []apiResponse{{Status: 200, ContentType: "text/html"}}},
+	{Method: http.MethodPost, Path: "/api/send/confirm", ID: "confirmSend", Tag: "messages", Summary: "Opt in and receive the held card",
+		Params: []apiParam{tokenParam}, Responses: []apiResponse{{Status: 200, ContentType: "text/html"}}},
+	{Method: http.MethodPost, Path: "/api/reminders", ID: "createReminder", Tag: "messages", Summary: "Subscribe to a yearly birthday reminder",
+		Request: ReminderRequest{}, Responses: []apiResponse{{Status: 201, Body: SendResponse{}}, {Status: 202, Body: SendResponse{}}}},
+	{Method: http.MethodGet, Path: "/api/reminders/confirm", ID: "confirmReminder", Tag: "messages", Summary: "Opt-in link of the reminder",
+		Params: []apiParam{tokenParam}, Responses: []apiResponse{{Status: 200, ContentType: "text/html"}}},
+	{Method: http.MethodGet, Path: "/api/reminders/unsubscribe", ID: "unsubscribeReminderPage", Tag: "messages", Summary: "Unsubscribe page of a reminder",
+		Params: []apiParam{tokenParam}, Responses: []apiResponse{{Status: 200, ContentType: "text/html"}}},
+	{Method: http.MethodPost, Path: "/api/reminders/unsubscribe", ID: "unsubscribeReminder", Tag: "messages", Summary: "Unsubscribe from a reminder",
+		Params: []apiParam{tokenParam}, Responses: []apiResponse{{Status: 200, ContentType: "text/html"}}},
+	{Method: http.MethodPost, Path: "/api/cards", ID: "createGroupCard", Tag: "messages", Summary: "Create a group card",
+		Request: GroupCardRequest{}, Responses: []apiResponse{{Status: 201, Body: GroupCardResponse{}}}},
+	{Method: http.MethodPost, Path: "/api/cards/sign", ID: "signGroupCard", Tag: "messages", Summary: "Sign a group card",
+		Request: SignatureRequest{}, Responses: []apiResponse{{Status: 201, Body: GuestbookEntry{}}}},
+	{Method: http.MethodPost, Path: "/api/protected", ID: "createProtectedGreeting", Tag: "messages", Summary: "Create a passphrase-protected greeting",
+		Request: ProtectedRequest{}, Responses: []apiResponse{{Status: 201, Body: ProtectedResponse{}}}},
+	{Method: http.MethodPost, Path: "/api/photos", ID: "uploadPhoto", Tag: "messages", Summary: "Upload a photo for a greeting",
+		Params:       []apiParam{{Name: csrfHeaderName, In: "header", Required: true}},
+		RequestTypes: []string{"image/jpeg", "image/png"}, Responses: []apiResponse{{Status: 201, Body: PhotoResponse{}}}},
+
+	{Method: http.MethodGet, Path: "/api/stats", ID: "getAccountStats", Tag: "stats", Auth: "token", Summary: "Views of the account's shortlinks",
+		Responses: []apiResponse{{Status: 200, Body: []AccountStat{}}}},
+	{Method: http.MethodGet, Path: "/api/usage", ID: "getAPIUsage", Tag: "stats", Auth: "token", Summary: "Quota and usage of the API tokens",
+		Responses: []apiResponse{{Status: 200, Body: []TokenUsage{}}}},
+	{Method: http.MethodGet, Path: "/api/stats/experiments", ID: "getExperimentStats", Tag: "stats", Summary: "Exposures and conversions of the experiments",
+		Responses: []apiResponse{{Status: 200, Body: []ExperimentReport{}}}},
+
+	{Method: http.MethodGet, Path: "/api/stats/spam", ID: "getSpamStats", Tag: "moderation", Auth: "admin", Summary: "Spam score histogram",
+		Responses: []apiResponse{{Status: 200, Body: SpamStats{}}}},
+	{Method: http.MethodPost, Path: "/api/cache/purge", ID: "purgeCache", Tag: "moderation", Auth: "admin", Summary: "Purge the cached files of a greeting or OG key",
+		Request: PurgeRequest{}, Responses: []apiResponse{{Status: 200, Body: PurgeResponse{}}}},
+
+	{Method: http.MethodGet, Path: "/api/audit", ID: "listAuditLog", Tag: "admin", Auth: "admin", Summary: "Privileged operations, newest first",
+		Params: []apiParam{
+			{Name: "action", In: "query"},
+			{Name: "since", In: "query", Description: "RFC 3339 time"},
+			{Name: "limit", In: "query", Type: "integer"},
+		},
+		Responses: []apiResponse{{Status: 200, Body: AuditResponse{}}}},
+	{Method: http.MethodGet, Path: "/api/webhooks", ID: "getWebhookStatus", Tag: "admin", Auth: "admin", Summary: "Webhook URLs and recent deliveries",
+		Responses: []apiResponse{{Status: 200, Body: WebhookStatus{}}}},
+	{Method: http.MethodGet, Path: "/admin/shortlinks", ID: "listShortlinks", Tag: "admin", Auth: "admin", Summary: "Shortlinks, newest first, 50 a page",
+		Params: []apiParam{
+			{Name: "page", In: "query", Type: "integer", Description: "From 1"},
+			{Name: "q", In: "query", Description: "Substring of the code or path, case-insensitive"},
+			{Name: "ip", In: "query", Description: "Address the links were created from"},
+		},
+		Responses: []apiResponse{{Status: 200, Body: AdminShortlinksResponse{}}}},
+
+	{Method: http.MethodPost, Path: "/api/integrations/slack", ID: "slackCommand", Tag: "integrations", Summary: "Slack slash command, signed with SLACK_SIGNING_SECRET",
+		Params: []apiParam{
+			{Name: "X-Slack-Request-Timestamp", In: "header", Required: true},
+			{Name: "X-Slack-Signature", In: "header", Required: true},
+		},
+		RequestTypes: []string{"application/x-www-form-urlencoded"}, Responses: []apiResponse{{Status: 200, Body: SlackResponse{}}}},
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+)
+
+// buildOpenAPI returns the OpenAPI document of apiOperations, indented.
+func buildOpenAPI() []byte {
+	openAPIOnce.Do(func() {
+		schemas := map[string]any{}
+		paths := map[string]map[string]any{}
+		for _, op := range apiOperations {
+			if paths[op.Path] == nil {
+				paths[op.Path] = map[string]any{}
+			}
+			paths[op.Path][strings.ToLower(op.Method)] = op.document(schemas)
+		}
+		doc := map[string]any{
+			"openapi": "3.1.0",
+			"info": map[string]any{
+				"title":   "parabens.vc",
+				"version": "1",
+				"description": "Errors are APIErrorResponse envelopes with a stable code. " +
+					"API tokens are sent as Authorization: Bearer; the admin token likewise.",
+			},
+			"paths": paths,
+			"components": map[string]any{
+				"schemas": schemas,
+				"securitySchemes": map[string]any{
+					"apiToken":   map[string]any{"type": "http", "scheme": "bearer", "description": "API token of an account"},
+					"adminToken": map[string]any{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN"},
+					"statsToken": map[string]any{"type": "http", "scheme": "bearer", "description": "stats_token of the shortlink's creation"},
+				},
+			},
+		}
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		openAPIDocument = append(data, '\n')
+	})
+	return openAPIDocument
+}
+
+func (op apiOperation) document(schemas map[string]any) map[string]any {
+	doc := map[string]any{"operationId": op.ID, "summary": op.Summary, "tags": []string{op.Tag}}
+	switch op.Auth {
+	case "token":
+		doc["security"] = []map[string][]string{{"apiToken": {}}, {}}
+	case "admin":
+		doc["security"] = []map[string][]string{{"adminToken": {}}}
+	case "stats":
+		doc["security"] = []map[string][]string{{"statsToken": {}}, {"adminToken": {}}}
+	}
+	var params []map[string]any
+	for _, p := range op.Params {
+		param := map[string]any{"name": p.Name, "in": p.In, "schema": map[string]any{"type": "string"}}
+		if p.Type != "" {
+			param["schema"] = map[string]any{"type": p.Type}
+		}
+		if p.Required {
+			param["required"] = true
+		}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if params != nil {
+		doc["parameters"] = params
+	}
+	switch {
+	case op.Request != nil:
+		doc["requestBody"] = map[string]any{"required": true, "content": map[string]any{
+			"application/json": map[string]any{"schema": typeSchema(reflect.TypeOf(op.Request), schemas)},
+		}}
+	case op.RequestTypes != nil:
+		body := map[string]any{}
+		for _, contentType := range op.RequestTypes {
+			body[contentType] = map[string]any{}
+		}
+		doc["requestBody"] = map[string]any{"required": true, "content": body}
+	}
+	responses := map[string]any{}
+	for _, resp := range append(op.Responses, errorResponse) {
+		description := resp.Description
+		if description == "" {
+			description = http.StatusText(resp.Status)
+		}
+		response := map[string]any{"description": description}
+		switch {
+		case resp.Body != nil:
+			response["content"] = map[string]any{"application/json": map[string]any{"schema": typeSchema(reflect.TypeOf(resp.Body), schemas)}}
+		case resp.ContentType != "":
+			response["content"] = map[string]any{resp.ContentType: map[string]any{}}
+		}
+		key := "default"
+		if resp.Status != 0 {
+			key = strconv.Itoa(resp.Status)
+		}
+		responses[key] = response
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// typeSchema returns the schema of t, adding the named structs it uses to
+// schemas and referring to them.
+func typeSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Uint, reflect.Uint64, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64, reflect.Float32:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		values := typeSchema(t.Elem(), schemas)
+		if len(values) == 0 {
+			return map[string]any{"type": "object"}
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // placeholder against recursion
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// structSchema lists the JSON fields of t, those of embedded structs
+// included; fields without omitempty are required.
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if field.Anonymous && tag == "" {
+				addFields(field.Type)
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = typeSchema(field.Type, schemas)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	sort.Strings(required)
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// handleOpenAPI serves /api/openapi.json.
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(buildOpenAPI(), &doc); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	doc["servers"] = []map[string]string{{"url": strings.TrimRight(publicBaseURL(), "/")}}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// runOpenAPICommand prints the document, or writes it to args[0].
+func runOpenAPICommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		_, err := out.Write(buildOpenAPI())
+		return err
+	}
+	if err := os.WriteFile(args[0], buildOpenAPI(), 0o644); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%s: written\n", args[0])
+	return err
+}
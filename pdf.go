@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var renderCardPDFToFileFunc = renderCardPDFToFile
+
+// renderCardPDFToFile renders the OG artwork of spec as a vector PDF page.
+func renderCardPDFToFile(spec ogImageSpec, destPath string) error {
+	svg, err := ogImageSVG(spec)
+	if err != nil {
+		return err
+	}
+	return rsvgConvert(svg, destPath, "-f", "pdf", "-w", strconv.Itoa(cardPDFWidth), "-h", strconv.Itoa(cardPDFHeight))
+}
+
+func cardPDFCachePath(key string) string {
+	return filepath.Join(ogCacheDir(), "pdf", key+".pdf")
+}
+
+// cardPDFFilename names the download after the message, e.g. "joao.pdf".
+func cardPDFFilename(message string) string {
+	name := strings.ReplaceAll(ogCacheKey(message), "~", "-")
+	if name == "default" {
+		name = "cartao"
+	}
+	return name + ".pdf"
+}
+
+// handleCardPDF serves GET /pdf/{path}: the greeting's card artwork as a
+// downloadable PDF, cached next to the OG images.
+func handleCardPDF(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	_, rawMessage := parseOccasionFromPath(path)
+	message := decodePath(rawMessage)
+	if message == "" || looksLikePath(message) {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	if isBlockedMessage(message) {
+		writeHTML(w, r, http.StatusForbidden, errorPage("Esta mensagem não está disponível."))
+		return
+	}
+	query := r.URL.Query()
+	if _, err := greetingAge(path, query); err != nil {
+		writeHTML(w, r, http.StatusBadRequest, errorPage("Idade inválida."))
+		return
+	}
+	g := buildGreeting(path, greetingPageOptions(path, query))
+	spec := g.OgSpec
+	spec.Text = ogImageTextPrefix(spec.Text)
+	cachePath := cardPDFCachePath(spec.cacheKey())
+	if ok, err := fileExists(cachePath); !ok || err != nil {
+		if err := ogQueue.renderTo(cachePath, spec, renderCardPDFToFileFunc); err != nil {
+			slog.Error("card pdf render failed", "error", err)
+			writeHTML(w, r, http.StatusServiceUnavailable, errorPage("Não foi possível gerar o PDF agora."))
+			return
+		}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", cardPDFFilename(message)))
+	writeCacheFile(w, r, cachePath, "application/pdf")
+}
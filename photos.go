@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PhotoResponse struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+var photoLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: photoRateWindow,
+	max:    photoRateLimit,
+}
+
+var errPhotoRejected = fmt.Errorf("photo rejected by moderation")
+
+// moderatePhotoFunc lets operators veto uploads before they are published.
+var moderatePhotoFunc = moderatePhoto
+
+func handlePhotoUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	if !photoLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	switch r.Header.Get("Content-Type") {
+	case "image/jpeg", "image/png":
+	default:
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_image")
+		return
+	}
+	if !diskHealthy("data") {
+		writeStorageUnavailable(w)
+		return
+	}
+	body, err := readLimitedBody(r, maxPhotoBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+
+	img, err := decodePhoto(body)
+	if err != nil {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_image")
+		return
+	}
+	id, err := storePhoto(img)
+	if err != nil {
+		if err == errPhotoRejected {
+			writeAPIError(w, http.StatusUnprocessableEntity, "photo_rejected")
+			return
+		}
+		slog.Error("photo store failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, PhotoResponse{ID: id, URL: "/photos/" + id + ".jpg"})
+}
+
+func handlePhoto(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/photos/"), ".jpg")
+	if !ok || !validPhotoID(id) {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(photoPath(id))
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	setCacheHeaders(w, cacheStaticMedia, "static", photoSurrogateKey(id))
+	http.ServeContent(w, r, id+".jpg", info.ModTime(), file)
+}
+
+// decodePhoto checks the dimensions before decoding so a tiny file can't
+// expand into a huge bitmap, then shrinks the image to the display size.
+func decodePhoto(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxPhotoDimension || cfg.Height > maxPhotoDimension {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return downscale(img, photoDisplaySize), nil
+}
+
+// downscale resizes img (nearest neighbour) so its longest side is at most max.
+func downscale(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	nw, nh := max, h*max/w
+	if h > w {
+		nw, nh = w*max/h, max
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		sy := b.Min.Y + y*h/nh
+		for x := 0; x < nw; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*w/nw, sy))
+		}
+	}
+	return dst
+}
+
+// storePhoto re-encodes img as JPEG, which drops EXIF and any other
+// metadata from the upload, and publishes it under a random ID.
+func storePhoto(img image.Image) (string, error) {
+	if readOnly() {
+		return "", errReadOnly
+	}
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(photoDir(), 0o755); err != nil {
+		return "", err
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Over)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 85}); err != nil {
+		return "", err
+	}
+
+	tmpPath := photoPath(id) + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	if err := moderatePhotoFunc(tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, photoPath(id)); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	return id, nil
+}
+
+// moderatePhoto runs PHOTO_MODERATION_CMD, when configured, with the photo
+// path as its only argument; a non-zero exit rejects the photo.
+func moderatePhoto(path string) error {
+	command := os.Getenv("PHOTO_MODERATION_CMD")
+	if command == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), photoModerationTimeout)
+	defer cancel()
+	if err := exec.CommandContext(ctx, command, path).Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+			return errPhotoRejected
+		}
+		return fmt.Errorf("photo moderation: %w", err)
+	}
+	return nil
+}
+
+func validPhotoID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, r := range id {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// photoID validates the ?foto= parameter, returning the ID only when the
+// photo is still stored.
+func photoID(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !validPhotoID(value) {
+		return ""
+	}
+	if ok, err := fileExists(photoPath(value)); !ok || err != nil {
+		return ""
+	}
+	return value
+}
+
+func photoDir() string {
+	if value := os.Getenv("PHOTO_DIR"); value != "" {
+		return value
+	}
+	return "data/photos"
+}
+
+func photoPath(id string) string {
+	return filepath.Join(photoDir(), id+".jpg")
+}
+
+func photoTTL() time.Duration {
+	if value := os.Getenv("PHOTO_TTL_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return defaultPhotoTTL
+}
+
+// sweepPhotos deletes photos (and abandoned uploads) older than the TTL.
+func sweepPhotos(now time.Time) (int, error) {
+	entries, err := os.ReadDir(photoDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	cutoff := now.Add(-photoTTL())
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(photoDir(), entry.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func startPhotoSweeper() {
+	go func() {
+		ticker := time.NewTicker(photoSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			removed, err := sweepPhotos(now)
+			if err != nil {
+				slog.Error("photo sweep failed", "error", err)
+				continue
+			}
+			if removed > 0 {
+				slog.Info("photos expired", "count", removed)
+			}
+		}
+	}()
+}
+
+// ogPhotoSVG returns the SVG fragment placing the photo in the OG image.
+func ogPhotoSVG(id string) (string, error) {
+	data, err := os.ReadFile(photoPath(id))
+	if err != nil {
+		return "", err
+	}
+	return `<clipPath id="photo-clip"><circle cx="490" cy="160" r="90"/></clipPath>` +
+		`<image x="400" y="70" width="180" height="180" preserveAspectRatio="xMidYMid slice" clip-path="url(#photo-clip)" ` +
+		`xlink:href="data:image/jpeg;base64,` + base64.StdEncoding.EncodeToString(data) + `"/>`, nil
+}
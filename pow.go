@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proof of work: with POW_DIFFICULTY set, anonymous POST /s must carry a
+// nonce such that sha256(challenge + nonce) starts with that many zero bits.
+// A browser solves one in about a second; a bot creating thousands of links
+// pays for each. Challenges are signed rather than stored, so issuing them
+// costs the server nothing, and each is accepted once.
+
+// PowChallenge is served by GET /api/pow.
+type PowChallenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// PowRequiredResponse is the 428 error envelope carrying the challenge to
+// solve, with its fields next to "error".
+type PowRequiredResponse struct {
+	Error APIError `json:"error"`
+	PowChallenge
+}
+
+var powUsed = struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}{seen: map[string]time.Time{}}
+
+// powDifficulty returns POW_DIFFICULTY, or 0 when proof of work is off.
+func powDifficulty() int {
+	n, err := strconv.Atoi(os.Getenv("POW_DIFFICULTY"))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return min(n, maxPowDifficulty)
+}
+
+func powSignature(issued, random, difficulty string) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte("pow:" + issued + "." + random + "." + difficulty))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newPowChallenge signs a challenge asking for difficulty zero bits; the
+// difficulty is part of it, so a harder challenge also satisfies an easier
+// requirement.
+func newPowChallenge(now time.Time, difficulty int) (string, error) {
+	random, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	issued := strconv.FormatInt(now.Unix(), 10)
+	bitsText := strconv.Itoa(difficulty)
+	return issued + "." + random + "." + bitsText + "." + powSignature(issued, random, bitsText), nil
+}
+
+// handlePowChallenge serves GET /api/pow.
+func handlePowChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	difficulty := powDifficulty()
+	if difficulty == 0 {
+		writeAPIError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	challenge, err := newPowChallenge(time.Now(), difficulty)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, PowChallenge{Challenge: challenge, Difficulty: difficulty})
+}
+
+// verifyPow returns the difficulty of challenge if nonce solves it: the
+// challenge is ours, fresh and unused, and the hash has the leading zero
+// bits it asks for. It returns 0 otherwise.
+func verifyPow(challenge, nonce string, now time.Time) int {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 || nonce == "" || len(nonce) > 32 {
+		return 0
+	}
+	if !hmac.Equal([]byte(parts[3]), []byte(powSignature(parts[0], parts[1], parts[2]))) {
+		return 0
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Sub(time.Unix(issued, 0)) > powChallengeTTL {
+		return 0
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil || leadingZeroBits(sha256.Sum256([]byte(challenge+nonce))) < difficulty {
+		return 0
+	}
+
+	powUsed.mu.Lock()
+	defer powUsed.mu.Unlock()
+	for key, at := range powUsed.seen {
+		if now.Sub(at) > powChallengeTTL {
+			delete(powUsed.seen, key)
+		}
+	}
+	if _, used := powUsed.seen[challenge]; used {
+		return 0
+	}
+	powUsed.seen[challenge] = now
+	return difficulty
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// checkPow verifies the X-PoW-Challenge and X-PoW-Nonce headers of r,
+// returning the difficulty solved. When proof of work is enabled and the
+// headers do not solve a challenge that hard, it writes 403 and returns
+// false.
+func checkPow(w http.ResponseWriter, r *http.Request) (int, bool) {
+	solved := verifyPow(r.Header.Get(powChallengeHeaderName), r.Header.Get(powNonceHeaderName), time.Now())
+	if solved < powDifficulty() {
+		writeAPIError(w, http.StatusForbidden, "pow_failed")
+		return solved, false
+	}
+	return solved, true
+}
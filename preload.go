@@ -0,0 +1,43 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+)
+
+// earlyHintsEnabled reports whether greeting pages send a 103 Early Hints
+// response. Some older proxies mishandle 1xx responses, so deployments opt
+// in with EARLY_HINTS=1; the Link headers are sent either way.
+func earlyHintsEnabled() bool {
+	return os.Getenv("EARLY_HINTS") == "1"
+}
+
+// preloadLinks lists the subresources a greeting page needs for first
+// paint, as Link header values: stylesheets, the script and the card photo.
+func preloadLinks(tpl *template.Template, opts pageOptions) []string {
+	var links []string
+	if tpl == printTemplate {
+		links = append(links, "</print.css>; rel=preload; as=style")
+	} else {
+		links = append(links, "</styles.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script")
+	}
+	if css := themeCSSURL(opts.Theme, opts.Accent); css != "" && tpl != printTemplate {
+		links = append(links, "<"+css+">; rel=preload; as=style")
+	}
+	if opts.Photo != "" {
+		links = append(links, "</photos/"+opts.Photo+".jpg>; rel=preload; as=image")
+	}
+	return links
+}
+
+// sendPreloadHints adds the Link headers of the page and, when enabled,
+// flushes them early as 103 Early Hints while the page is rendered.
+func sendPreloadHints(w http.ResponseWriter, tpl *template.Template, opts pageOptions) {
+	for _, link := range preloadLinks(tpl, opts) {
+		w.Header().Add("Link", link)
+	}
+	if earlyHintsEnabled() {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type PreviewResponse struct {
+	Path          string `json:"path"`
+	Blocked       bool   `json:"blocked"`
+	Occasion      string `json:"occasion"`
+	Greeting      string `json:"greeting,omitempty"`
+	Emoji         string `json:"emoji,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Message       string `json:"message,omitempty"`
+	Punct         string `json:"punct"`
+	OgDescription string `json:"og_description,omitempty"`
+	OgImage       string `json:"og_image,omitempty"`
+	Views         int    `json:"views"`
+}
+
+var previewLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: previewRateWindow,
+	max:    previewRateLimit,
+}
+
+// handlePreview returns the metadata the greeting page would be rendered
+// with: GET /api/preview?path=/aniversario/João%3Fde%3DMaria
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !previewLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	rawPath := r.URL.Query().Get("path")
+	if strings.TrimSpace(rawPath) == "" || len(rawPath) > maxPathLen {
+		writeAPIError(w, http.StatusBadRequest, "invalid_path")
+		return
+	}
+
+	fullPath := normalizeGreetingPath(rawPath)
+	pathOnly, rawQuery, _ := strings.Cut(fullPath, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_query")
+		return
+	}
+	occasion, rawMessage := parseOccasionFromPath(pathOnly)
+	message := decodePath(rawMessage)
+	if looksLikePath(message) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_path")
+		return
+	}
+	resp := PreviewResponse{Path: fullPath, Occasion: occasion.Prefix}
+	_, err = parseName(query.Get("de"))
+	if isBlockedMessage(message) || err == errNameBlocked {
+		resp.Blocked = true
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_name")
+		return
+	}
+	if _, err := greetingAge(pathOnly, query); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_age")
+		return
+	}
+	if _, err := parseBirthdate(query.Get("nascimento"), time.Now()); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_birthdate")
+		return
+	}
+
+	g := buildGreeting(pathOnly, greetingPageOptions(pathOnly, query))
+	resp.Greeting = g.Greeting
+	resp.Emoji = g.Emoji
+	resp.Title = g.Title
+	resp.Message = g.DisplayMessage
+	resp.Punct = g.Punct
+	resp.OgDescription = g.OgDesc
+	resp.OgImage = g.OgImage
+	if count, err := viewCount(pathOnly); err == nil {
+		resp.Views = count
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ProtectedGreeting is a greeting path shown only after its passphrase is
+// entered at /p/{id}. Only a salted PBKDF2 hash of the passphrase is kept.
+type ProtectedGreeting struct {
+	Path      string `json:"path"`
+	Salt      string `json:"salt"`
+	Hash      string `json:"hash"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ProtectedRequest struct {
+	Path       string `json:"path"`
+	Passphrase string `json:"passphrase"`
+}
+
+type ProtectedResponse struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type protectedStore struct {
+	mu      sync.Mutex
+	loaded  bool
+	records map[string]ProtectedGreeting
+}
+
+var protectedGreetings = protectedStore{
+	records: map[string]ProtectedGreeting{},
+}
+
+var protectedLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: protectedRateWindow,
+	max:    protectedRateLimit,
+}
+
+// unlockLimiter slows down passphrase guessing.
+var unlockLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: unlockRateWindow,
+	max:    unlockRateLimit,
+}
+
+var (
+	sessionSecretOnce sync.Once
+	sessionSecretKey  []byte
+)
+
+// sessionSecret signs unlock cookies. Without SESSION_SECRET a random key is
+// used, so visitors re-enter passphrases after a restart.
+func sessionSecret() []byte {
+	sessionSecretOnce.Do(func() {
+		if value := os.Getenv("SESSION_SECRET"); value != "" {
+			sessionSecretKey = []byte(value)
+			return
+		}
+		sessionSecretKey = make([]byte, 32)
+		if _, err := rand.Read(sessionSecretKey); err != nil {
+			panic(err)
+		}
+	})
+	return sessionSecretKey
+}
+
+// pbkdf2SHA256 derives a 32-byte key (a single PBKDF2-HMAC-SHA256 block).
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func hashPassphrase(passphrase string, salt []byte) string {
+	return hex.EncodeToString(pbkdf2SHA256([]byte(passphrase), salt, passphraseIterations))
+}
+
+func (p ProtectedGreeting) verify(passphrase string) bool {
+	salt, err := hex.DecodeString(p.Salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashPassphrase(passphrase, salt)), []byte(p.Hash)) == 1
+}
+
+// unlockCookie is the session cookie value for a protected greeting; it
+// changes with the hash, so it can't be forged or reused across greetings.
+func unlockCookie(id string, p ProtectedGreeting) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte(id + ":" + p.Hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func unlockCookieName(id string) string {
+	return "pv_" + id
+}
+
+// handleProtectedCreate stores a passphrase-protected greeting:
+// POST /api/protected {"path":"/aniversario/João","passphrase":"segredo"}
+func handleProtectedCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if !protectedLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	body, err := readLimitedBody(r, maxShortlinkBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+	var req ProtectedRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+	if strings.TrimSpace(req.Path) == "" {
+		writeAPIError(w, http.StatusBadRequest, "empty_message")
+		return
+	}
+	if n := utf8.RuneCountInString(req.Passphrase); n < minPassphraseLen || n > maxPassphraseLen {
+		writeAPIError(w, http.StatusBadRequest, "invalid_passphrase")
+		return
+	}
+	fullPath := normalizeGreetingPath(req.Path)
+	if status, code := checkGreetingPath(fullPath); status != http.StatusOK {
+		writeAPIError(w, status, code)
+		return
+	}
+
+	id, err := createProtectedGreeting(fullPath, req.Passphrase)
+	if err != nil {
+		slog.Error("protected greeting create failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, ProtectedResponse{
+		ID:  id,
+		URL: strings.TrimRight(publicBaseURL(), "/") + "/p/" + id,
+	})
+}
+
+func createProtectedGreeting(fullPath, passphrase string) (string, error) {
+	if err := ensureProtectedLoaded(); err != nil {
+		return "", err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	record := ProtectedGreeting{
+		Path:      fullPath,
+		Salt:      hex.EncodeToString(salt),
+		Hash:      hashPassphrase(passphrase, salt),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	protectedGreetings.mu.Lock()
+	defer protectedGreetings.mu.Unlock()
+	var id string
+	for {
+		raw, err := randomToken()
+		if err != nil {
+			return "", err
+		}
+		if id = raw[:protectedIDLen]; protectedGreetings.records[id].Path == "" {
+			break
+		}
+	}
+	protectedGreetings.records[id] = record
+	if err := persistProtectedLocked(); err != nil {
+		delete(protectedGreetings.records, id)
+		return "", err
+	}
+	return id, nil
+}
+
+func protectedGreeting(id string) (ProtectedGreeting, bool, error) {
+	if err := ensureProtectedLoaded(); err != nil {
+		return ProtectedGreeting{}, false, err
+	}
+	protectedGreetings.mu.Lock()
+	defer protectedGreetings.mu.Unlock()
+	record, ok := protectedGreetings.records[id]
+	return record, ok, nil
+}
+
+// ProtectedPageData is the data model of public/protected.html, the
+// passphrase form. Its OG tags only tease the occasion.
+type ProtectedPageData struct {
+	Title   string
+	OgDesc  string
+	OgURL   string
+	OgImage string
+	Action  string
+	Failed  bool
+	Site    SiteIdentity
+}
+
+// handleProtectedPage serves /p/{id}: the passphrase form, its POST, and
+// the greeting itself once the unlock cookie is set.
+func handleProtectedPage(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodPost)
+		return
+	}
+	record, ok, err := protectedGreeting(id)
+	if err != nil {
+		slog.Error("protected greetings load failed", "error", err)
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível montar esta página."))
+		return
+	}
+	if !ok {
+		writeHTML(w, r, http.StatusNotFound, errorPage("Mensagem não encontrada."))
+		return
+	}
+	// Neither the form nor the unlocked greeting may be stored by caches
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	if r.Method == http.MethodPost {
+		if !unlockLimiter.allow(clientIP(r)) {
+			http.Error(w, "", http.StatusTooManyRequests)
+			return
+		}
+		body, err := readLimitedBody(r, maxShortlinkBodyBytes)
+		if err != nil {
+			http.Error(w, "", statusFromError(err))
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil || !record.verify(form.Get("senha")) {
+			serveProtectedForm(w, r, id, record, http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     unlockCookieName(id),
+			Value:    unlockCookie(id, record),
+			Path:     "/p/" + id,
+			MaxAge:   int(unlockCookieMaxAge / time.Second),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/p/"+id, http.StatusSeeOther)
+		return
+	}
+
+	cookie, err := r.Cookie(unlockCookieName(id))
+	if err != nil || !hmac.Equal([]byte(cookie.Value), []byte(unlockCookie(id, record))) {
+		serveProtectedForm(w, r, id, record, http.StatusOK)
+		return
+	}
+	pathOnly, rawQuery, _ := strings.Cut(record.Path, "?")
+	unlocked := r.Clone(r.Context())
+	unlocked.URL.Path = pathOnly
+	unlocked.URL.RawQuery = rawQuery
+	serveGreeting(w, unlocked, pathOnly, indexTemplate)
+}
+
+func serveProtectedForm(w http.ResponseWriter, r *http.Request, id string, record ProtectedGreeting, status int) {
+	pathOnly, _, _ := strings.Cut(record.Path, "?")
+	occasion, _ := parseOccasionFromPath(pathOnly)
+	site := siteIdentity()
+	base := strings.TrimRight(publicBaseURL(), "/")
+	spec := ogImageSpec{Text: occasion.Greeting + "\n🔒 Mensagem protegida"}
+	if occasion.OgTemplate != "" {
+		spec.Occasion = occasion.Prefix
+	}
+	data := ProtectedPageData{
+		Title:   occasion.Greeting + " 🔒 - " + site.Name,
+		OgDesc:  "Uma mensagem especial, protegida por senha " + occasion.Emoji,
+		OgURL:   base + "/p/" + id,
+		OgImage: ogImageURL(base, spec),
+		Action:  "/p/" + id,
+		Failed:  status == http.StatusUnauthorized,
+		Site:    site,
+	}
+	var b strings.Builder
+	if err := protectedTemplate.Execute(&b, data); err != nil {
+		slog.Error("protected form render failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	writeHTML(w, r, status, b.String())
+}
+
+func ensureProtectedLoaded() error {
+	protectedGreetings.mu.Lock()
+	defer protectedGreetings.mu.Unlock()
+	if protectedGreetings.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(protectedDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			protectedGreetings.loaded = true
+			return nil
+		}
+		return err
+	}
+	records := map[string]ProtectedGreeting{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	protectedGreetings.records = records
+	protectedGreetings.loaded = true
+	return nil
+}
+
+func persistProtectedLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := protectedDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(protectedGreetings.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func protectedDBPath() string {
+	if value := os.Getenv("PROTECTED_DB"); value != "" {
+		return value
+	}
+	return "data/protected.json"
+}
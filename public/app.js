@@ -7,30 +7,299 @@ const queryParams = Object.fromEntries(url.searchParams.entries());
 
 const balloonColors = ["#fbbf24", "#60a5fa", "#f472b6", "#34d399", "#f97316"];
 
+// POST /s and guestbook posts need the CSRF token, fetched once per page
+let csrfPromise = null;
+function csrfToken() {
+    if (!csrfPromise) {
+        csrfPromise = fetch("/api/csrf")
+            .then((response) => (response.ok ? response.json() : {}))
+            .then((data) => data.token || "");
+    }
+    return csrfPromise;
+}
+
+// With the CAPTCHA enabled, its widget leaves a single-use token in the form
+// or section it is in
+function captchaToken(form) {
+    const input = form.querySelector('[name="cf-turnstile-response"], [name="h-captcha-response"]');
+    return input ? input.value : "";
+}
+
+// With POW_DIFFICULTY set, POST /s and /api/share need a solved proof-of-work
+// challenge: a nonce whose SHA-256 with the challenge starts with enough zero
+// bits
+async function powHeaders() {
+    const response = await fetch("/api/pow");
+    if (!response.ok) return {};
+    return solvePow(await response.json());
+}
+
+async function solvePow({ challenge, difficulty }) {
+    const encoder = new TextEncoder();
+    for (let nonce = 0; ; nonce += 1) {
+        const digest = new Uint8Array(await crypto.subtle.digest("SHA-256", encoder.encode(challenge + nonce)));
+        if (leadingZeroBits(digest) >= difficulty) {
+            return { "X-PoW-Challenge": challenge, "X-PoW-Nonce": String(nonce) };
+        }
+    }
+}
+
+function leadingZeroBits(bytes) {
+    let n = 0;
+    for (const b of bytes) {
+        if (b !== 0) return n + Math.clz32(b) - 24;
+        n += 8;
+    }
+    return n;
+}
+
+function resetCaptcha() {
+    if (window.turnstile) window.turnstile.reset();
+    if (window.hcaptcha) window.hcaptcha.reset();
+}
+
 // Composer form handling
 if (composerForm) {
+    // Signed when the composer is shown; POST /s rejects instant submissions
+    const formToken = document.body.dataset.showComposer === "true"
+        ? fetch("/api/form-token")
+            .then((response) => (response.ok ? response.json() : {}))
+            .then((data) => data.token || "")
+            .catch(() => "")
+        : Promise.resolve("");
+
+    // The age field only applies to birthdays, the years to wedding anniversaries
+    const occasionSelect = document.getElementById("occasion-select");
+    occasionSelect.addEventListener("change", function() {
+        document.getElementById("age-group").hidden = occasionSelect.value !== "aniversario";
+        document.getElementById("years-group").hidden = occasionSelect.value !== "bodas";
+    });
+
+    // Links from /ocasioes preselect the occasion with ?ocasiao=
+    function preselectOccasion() {
+        if (queryParams.ocasiao && occasionSelect.value !== queryParams.ocasiao) {
+            occasionSelect.value = queryParams.ocasiao;
+            occasionSelect.dispatchEvent(new Event("change"));
+        }
+    }
+    preselectOccasion();
+
+    // Occasions come from the server, so config-defined ones show up too
+    fetch("/api/occasions")
+        .then((response) => (response.ok ? response.json() : []))
+        .then((occasions) => {
+            const select = document.getElementById("occasion-select");
+            const known = new Set(Array.from(select.options, (option) => option.value));
+            occasions.filter((occ) => !known.has(occ.prefix)).forEach((occ) => {
+                select.add(new Option(occ.emoji + " " + occ.greeting, occ.prefix));
+            });
+            preselectOccasion();
+        })
+        .catch(() => {});
+
+    // Themes registered in the server config join the built-in options
+    fetch("/api/themes")
+        .then((response) => (response.ok ? response.json() : []))
+        .then((themes) => {
+            const select = document.getElementById("theme-select");
+            const known = new Set(Array.from(select.options, (option) => option.value));
+            themes.filter((theme) => !known.has(theme.name)).forEach((theme) => {
+                select.add(new Option("🎨 " + theme.label, theme.name));
+            });
+        })
+        .catch(() => {});
+
+    // Notable people born on the chosen birthdate
+    const birthdateInput = document.getElementById("birthdate-input");
+    const twinsHint = document.getElementById("birthday-twins-hint");
+    birthdateInput.addEventListener("change", async function() {
+        twinsHint.hidden = true;
+        const date = birthdateInput.value; // AAAA-MM-DD
+        if (!date) {
+            return;
+        }
+        try {
+            const response = await fetch("/api/birthdays/" + date.slice(5));
+            const famous = response.ok ? await response.json() : [];
+            if (famous.length > 0) {
+                const names = famous.slice(0, 3).map((person) => person.name);
+                const list = names.length > 1 ? names.slice(0, -1).join(", ") + " e " + names[names.length - 1] : names[0];
+                twinsHint.textContent = "🎈 Nesse dia também é aniversário de " + list;
+                twinsHint.hidden = false;
+            }
+        } catch (error) {
+            // The hint is optional
+        }
+    });
+
+    // Name autocomplete for the signature, suggested by the server
+    const senderInput = document.getElementById("sender-input");
+    const nameSuggestions = document.getElementById("name-suggestions");
+    let suggestTimer = null;
+    senderInput.addEventListener("input", function() {
+        clearTimeout(suggestTimer);
+        suggestTimer = setTimeout(async function() {
+            const q = senderInput.value.trim();
+            nameSuggestions.replaceChildren();
+            if (q.length < 2 || q.includes(" ")) {
+                return;
+            }
+            try {
+                const response = await fetch("/api/suggest?q=" + encodeURIComponent(q));
+                const names = response.ok ? await response.json() : [];
+                names.forEach((name) => nameSuggestions.append(new Option(name)));
+            } catch {
+                // Suggestions are optional
+            }
+        }, 200);
+    });
+
+    // Live preview of the card title, computed by the server
+    const previewEl = document.getElementById("composer-preview");
+    let previewTimer = null;
+    composerForm.addEventListener("input", function() {
+        clearTimeout(previewTimer);
+        previewTimer = setTimeout(updatePreview, 300);
+    });
+
+    async function updatePreview() {
+        const occasion = document.getElementById("occasion-select").value;
+        const message = document.getElementById("message-input").value.trim();
+        const sender = document.getElementById("sender-input").value.trim();
+        if (!message) {
+            previewEl.textContent = "";
+            return;
+        }
+        let path = "/" + (occasion ? occasion + "/" : "") + encodeURIComponent(message.replace(/\s*\n\s*/g, "~").replace(/ /g, "_"));
+        if (sender) {
+            path += "?de=" + encodeURIComponent(sender.replace(/ /g, "_"));
+        }
+        try {
+            const response = await fetch("/api/preview?path=" + encodeURIComponent(path));
+            if (!response.ok) {
+                previewEl.textContent = "";
+                return;
+            }
+            const preview = await response.json();
+            previewEl.textContent = preview.blocked ? "Esta mensagem não está disponível." : preview.emoji + " " + preview.title;
+        } catch {
+            previewEl.textContent = "";
+        }
+    }
+
     composerForm.addEventListener("submit", async function(e) {
         e.preventDefault();
 
         const occasion = document.getElementById("occasion-select").value;
         const message = document.getElementById("message-input").value.trim();
         const theme = document.getElementById("theme-select").value;
+        const customColor = document.getElementById("color-check").checked;
+        const color = document.getElementById("color-input").value.replace("#", "");
+        const effect = document.getElementById("effect-select").value;
+        const emoji = document.getElementById("emoji-select").value;
+        const gender = document.getElementById("gender-select").value;
+        const sound = document.getElementById("sound-select").value;
+        const photoFile = document.getElementById("photo-input").files[0];
+        const sender = document.getElementById("sender-input").value.trim();
         const useShortlink = document.getElementById("shortlink-check").checked;
+        const passphrase = document.getElementById("passphrase-input").value;
         const button = composerForm.querySelector("button");
+        const buttonLabel = button.textContent;
 
         if (!message) {
             document.getElementById("message-input").focus();
             return;
         }
 
+        const age = parseInt(document.getElementById("age-input").value, 10);
+        const years = parseInt(document.getElementById("years-input").value, 10);
+        const birthdate = document.getElementById("birthdate-input").value;
+
         // Build the full path
-        const encodedMessage = message.replace(/ /g, "_");
+        // Line breaks become "~", the server's line-break token
+        const encodedMessage = message.replace(/\s*\n\s*/g, "~").replace(/ /g, "_");
         let path = "/" + encodedMessage;
         if (occasion) {
             path = "/" + occasion + "/" + encodedMessage;
         }
+        if (occasion === "aniversario" && age >= 1 && age <= 120) {
+            path += "/" + age;
+        }
+        if (occasion === "bodas" && years >= 1 && years <= 120) {
+            path += "/" + years;
+        }
+        const params = new URLSearchParams();
+        if (photoFile) {
+            button.disabled = true;
+            button.textContent = "Enviando foto...";
+            try {
+                const response = await fetch("/api/photos", {
+                    method: "POST",
+                    headers: {
+                        "Content-Type": photoFile.type,
+                        "X-CSRF-Token": await csrfToken()
+                    },
+                    body: photoFile
+                });
+                if (response.ok) {
+                    const photo = await response.json();
+                    params.set("foto", photo.id);
+                }
+            } catch {
+                // continue without the photo
+            }
+        }
         if (theme) {
-            path += "?theme=" + theme;
+            params.set("theme", theme);
+        }
+        if (emoji) {
+            params.set("emoji", emoji);
+        }
+        if (gender) {
+            params.set("g", gender);
+        }
+        if (effect) {
+            params.set("efeito", effect);
+        }
+        if (customColor && /^[0-9a-fA-F]{6}$/.test(color)) {
+            params.set("cor", color.toLowerCase());
+        }
+        if (sound) {
+            params.set("som", sound);
+        }
+        if (sender) {
+            params.set("de", sender.replace(/ /g, "_"));
+        }
+        if (occasion === "aniversario" && birthdate) {
+            params.set("nascimento", birthdate);
+        }
+        if (params.toString()) {
+            path += "?" + params.toString();
+        }
+
+        // A passphrase hides the greeting behind a /p/ link
+        if (passphrase) {
+            button.disabled = true;
+            button.textContent = "Protegendo...";
+            try {
+                const response = await fetch("/api/protected", {
+                    method: "POST",
+                    headers: { "Content-Type": "application/json" },
+                    body: JSON.stringify({ path: path, passphrase: passphrase })
+                });
+                if (response.ok) {
+                    const data = await response.json();
+                    try { await navigator.clipboard.writeText(data.url); } catch {}
+                    window.location.href = data.url;
+                    return;
+                }
+            } catch {
+                // reported below
+            }
+            button.disabled = false;
+            button.textContent = buttonLabel;
+            alert("Não foi possível proteger a mensagem. Verifique a senha (mínimo de 4 caracteres).");
+            return;
         }
 
         // Direct link or shortlink based on checkbox
@@ -44,18 +313,28 @@ if (composerForm) {
         button.textContent = "Criando...";
 
         try {
-            const response = await fetch("/s", {
-                method: "POST",
-                headers: { "Content-Type": "application/json" },
-                body: JSON.stringify({ path: path })
-            });
+            const headers = {
+                "Content-Type": "application/json",
+                "X-CSRF-Token": await csrfToken(),
+                "X-Captcha-Token": captchaToken(composerForm),
+                "X-Form-Token": await formToken,
+                ...(await powHeaders()),
+            };
+            const body = JSON.stringify({ path: path, website: composerForm.elements.website.value });
+            let response = await fetch("/s", { method: "POST", headers: headers, body: body });
+            // Requests that look like spam must solve a proof of work first
+            if (response.status === 428) {
+                Object.assign(headers, await solvePow(await response.json()));
+                response = await fetch("/s", { method: "POST", headers: headers, body: body });
+            }
 
-            if (response.ok) {
+            if (response.status === 201 || response.status === 200) {
                 const data = await response.json();
                 try { await navigator.clipboard.writeText(data.short_url); } catch {}
                 // Pass flash message via hash
                 window.location.href = path + (path.includes("?") ? "&" : "?") + "_flash=link_copied#" + encodeURIComponent(data.short_url);
             } else {
+                // Also when the shortlink is deferred (202)
                 window.location.href = path;
             }
         } catch {
@@ -64,6 +343,116 @@ if (composerForm) {
     });
 }
 
+// Optional background music (browsers only allow playback after a tap)
+const musicButton = document.getElementById("music-button");
+if (musicButton) {
+    const music = document.getElementById("music");
+    musicButton.addEventListener("click", function() {
+        if (music.paused) {
+            music.play().catch(() => {});
+            musicButton.textContent = "🔇 Pausar música";
+        } else {
+            music.pause();
+            musicButton.textContent = "🎵 Tocar música";
+        }
+    });
+}
+
+// Print view keeps the card's query parameters
+const printLink = document.getElementById("print-link");
+if (printLink) {
+    printLink.href = "/print" + url.pathname + url.search;
+}
+const storyLink = document.getElementById("story-link");
+if (storyLink) {
+    storyLink.href = "/card.png?path=" + encodeURIComponent(url.pathname + url.search);
+}
+
+// Share buttons: the server builds the share text and URLs
+document.querySelectorAll("[data-share]").forEach((button) => {
+    button.addEventListener("click", async function() {
+        button.disabled = true;
+        try {
+            const headers = {
+                "Content-Type": "application/json",
+                "X-CSRF-Token": await csrfToken(),
+                "X-Captcha-Token": captchaToken(document.getElementById("share")),
+                ...(await powHeaders()),
+            };
+            const body = JSON.stringify({ path: url.pathname + url.search });
+            let response = await fetch("/api/share", { method: "POST", headers: headers, body: body });
+            if (response.status === 428) {
+                Object.assign(headers, await solvePow(await response.json()));
+                response = await fetch("/api/share", { method: "POST", headers: headers, body: body });
+            }
+            if (response.status === 200) {
+                const data = await response.json();
+                window.location.href = data[button.dataset.share];
+            } else if (response.status === 202) {
+                // The shortlink is deferred: share the page itself
+                const shares = {
+                    whatsapp: "https://wa.me/?text=" + encodeURIComponent(url.href),
+                    telegram: "https://t.me/share/url?url=" + encodeURIComponent(url.href),
+                };
+                window.location.href = shares[button.dataset.share];
+            }
+        } catch {
+            // ignore share errors
+        } finally {
+            button.disabled = false;
+        }
+    });
+});
+
+// Guestbook form handling
+const guestbookForm = document.getElementById("guestbook-form");
+if (guestbookForm) {
+    guestbookForm.addEventListener("submit", async function(e) {
+        e.preventDefault();
+
+        const nameInput = document.getElementById("guestbook-name");
+        const messageInput = document.getElementById("guestbook-message");
+        const button = guestbookForm.querySelector("button");
+        button.disabled = true;
+
+        try {
+            const response = await fetch("/api/guestbook", {
+                method: "POST",
+                headers: {
+                    "Content-Type": "application/json",
+                    "X-CSRF-Token": await csrfToken(),
+                    "X-Captcha-Token": captchaToken(guestbookForm),
+                },
+                body: JSON.stringify({
+                    path: url.pathname,
+                    name: nameInput.value.trim(),
+                    message: messageInput.value.trim(),
+                })
+            });
+
+            if (response.ok) {
+                const entry = await response.json();
+                const item = document.createElement("li");
+                item.className = "guestbook-entry";
+                const text = document.createElement("p");
+                text.className = "guestbook-message";
+                text.textContent = entry.message;
+                const name = document.createElement("p");
+                name.className = "guestbook-name";
+                name.textContent = "— " + entry.name;
+                item.append(text, name);
+                document.getElementById("guestbook-list").appendChild(item);
+                messageInput.value = "";
+            }
+        } catch {
+            // ignore guestbook errors
+        } finally {
+            resetCaptcha();
+            button.disabled = false;
+        }
+    });
+}
+
 function createBalloons() {
     const total = 12;
     for (let i = 0; i < total; i += 1) {
@@ -131,6 +520,110 @@ function updateConfetti() {
     });
 }
 
+function createSnow() {
+    const count = 150;
+    for (let i = 0; i < count; i += 1) {
+        confettiPieces.push({
+            x: Math.random() * confettiCanvas.width,
+            y: Math.random() * confettiCanvas.height,
+            r: Math.random() * 3 + 1,
+            speed: Math.random() * 1 + 0.5,
+            drift: Math.random() * Math.PI * 2,
+        });
+    }
+}
+
+function drawSnow() {
+    confettiCtx.clearRect(0, 0, confettiCanvas.width, confettiCanvas.height);
+    confettiCtx.fillStyle = "rgba(255, 255, 255, 0.9)";
+    confettiPieces.forEach((p) => {
+        confettiCtx.beginPath();
+        confettiCtx.arc(p.x, p.y, p.r, 0, Math.PI * 2);
+        confettiCtx.fill();
+        p.drift += 0.01;
+        p.y += p.speed;
+        p.x += Math.sin(p.drift) * 0.5;
+        if (p.y > confettiCanvas.height) {
+            p.y = -5;
+            p.x = Math.random() * confettiCanvas.width;
+        }
+    });
+    requestAnimationFrame(drawSnow);
+}
+
+let fireworkTimer = 0;
+
+function launchFirework() {
+    const x = Math.random() * confettiCanvas.width;
+    const y = Math.random() * confettiCanvas.height * 0.5 + confettiCanvas.height * 0.1;
+    const color = balloonColors[Math.floor(Math.random() * balloonColors.length)];
+    for (let i = 0; i < 60; i += 1) {
+        const angle = (Math.PI * 2 * i) / 60;
+        const speed = Math.random() * 3 + 1;
+        confettiPieces.push({
+            x,
+            y,
+            vx: Math.cos(angle) * speed,
+            vy: Math.sin(angle) * speed,
+            life: 60 + Math.random() * 30,
+            color,
+        });
+    }
+}
+
+function drawFireworks() {
+    confettiCtx.clearRect(0, 0, confettiCanvas.width, confettiCanvas.height);
+    fireworkTimer -= 1;
+    if (fireworkTimer <= 0) {
+        launchFirework();
+        fireworkTimer = 40 + Math.random() * 40;
+    }
+    for (let i = confettiPieces.length - 1; i >= 0; i -= 1) {
+        const p = confettiPieces[i];
+        p.x += p.vx;
+        p.y += p.vy;
+        p.vy += 0.04;
+        p.life -= 1;
+        if (p.life <= 0) {
+            confettiPieces.splice(i, 1);
+            continue;
+        }
+        confettiCtx.globalAlpha = Math.min(1, p.life / 40);
+        confettiCtx.fillStyle = p.color;
+        confettiCtx.beginPath();
+        confettiCtx.arc(p.x, p.y, 2, 0, Math.PI * 2);
+        confettiCtx.fill();
+    }
+    confettiCtx.globalAlpha = 1;
+    requestAnimationFrame(drawFireworks);
+}
+
+// The server validates ?efeito= and exposes it as an effect-* class
+function startEffect() {
+    const match = document.body.className.match(/\beffect-(\w+)/);
+    const effect = match ? match[1] : "";
+    switch (effect) {
+    case "baloes":
+        createBalloons();
+        break;
+    case "confete":
+        createConfetti();
+        drawConfetti();
+        break;
+    case "fogos":
+        drawFireworks();
+        break;
+    case "neve":
+        createSnow();
+        drawSnow();
+        break;
+    default:
+        createBalloons();
+        createConfetti();
+        drawConfetti();
+    }
+}
+
 async function trackView() {
     try {
         const timezone = Intl.DateTimeFormat().resolvedOptions().timeZone || null;
@@ -171,9 +664,7 @@ async function trackView() {
 
 window.addEventListener("resize", resizeCanvas);
 resizeCanvas();
-createBalloons();
-createConfetti();
-drawConfetti();
+startEffect();
 trackView();
 
 // Show flash toast if short link was copied
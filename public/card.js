@@ -0,0 +1,68 @@
+// Group cards: the organizer's form on /cartao and the signing form on
+// /cartao/{id}/assinar.
+const cardForm = document.getElementById("card-form");
+if (cardForm) {
+    cardForm.addEventListener("submit", async function(e) {
+        e.preventDefault();
+        const button = cardForm.querySelector("button");
+        button.disabled = true;
+        try {
+            const response = await fetch("/api/cards", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({
+                    recipient: document.getElementById("card-recipient").value.trim(),
+                    occasion: document.getElementById("card-occasion").value,
+                })
+            });
+            if (!response.ok) {
+                const body = await response.json().catch(() => ({}));
+                throw new Error(body.error?.message || "Não foi possível criar o cartão.");
+            }
+            const card = await response.json();
+            const signLink = document.getElementById("card-sign-url");
+            const cardLink = document.getElementById("card-url");
+            signLink.href = signLink.textContent = card.sign_url;
+            cardLink.href = cardLink.textContent = card.card_url;
+            document.getElementById("card-links").hidden = false;
+            cardForm.hidden = true;
+        } catch (error) {
+            // Network failures are TypeErrors with browser text
+            alert(error instanceof TypeError ? "Não foi possível criar o cartão." : error.message);
+        } finally {
+            button.disabled = false;
+        }
+    });
+}
+
+const signForm = document.getElementById("sign-form");
+if (signForm) {
+    signForm.addEventListener("submit", async function(e) {
+        e.preventDefault();
+        const status = document.getElementById("sign-status");
+        const button = signForm.querySelector("button");
+        button.disabled = true;
+        try {
+            const response = await fetch("/api/cards/sign", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({
+                    id: document.getElementById("group-card").dataset.card,
+                    token: signForm.dataset.token,
+                    name: document.getElementById("sign-name").value.trim(),
+                    message: document.getElementById("sign-message").value.trim(),
+                })
+            });
+            if (!response.ok) {
+                const body = await response.json().catch(() => ({}));
+                status.textContent = body.error?.message || "Não foi possível assinar agora.";
+                return;
+            }
+            window.location.reload();
+        } catch {
+            status.textContent = "Não foi possível assinar agora.";
+        } finally {
+            button.disabled = false;
+        }
+    });
+}
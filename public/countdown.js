@@ -0,0 +1,26 @@
+// Live countdown for /contagem/ pages; the server renders the day count and
+// turns the page into the birthday card on the day, so reload at zero.
+const countdownEl = document.getElementById("countdown");
+const clockEl = document.getElementById("countdown-clock");
+
+function pad(value) {
+    return String(value).padStart(2, "0");
+}
+
+function tick() {
+    const remaining = new Date(countdownEl.dataset.target).getTime() - Date.now();
+    if (remaining <= 0) {
+        window.location.reload();
+        return;
+    }
+    const seconds = Math.floor(remaining / 1000);
+    const days = Math.floor(seconds / 86400);
+    const hours = Math.floor((seconds % 86400) / 3600);
+    const minutes = Math.floor((seconds % 3600) / 60);
+    clockEl.textContent = days + "d " + pad(hours) + "h " + pad(minutes) + "m " + pad(seconds % 60) + "s";
+    setTimeout(tick, 1000);
+}
+
+if (countdownEl && countdownEl.dataset.target) {
+    tick();
+}
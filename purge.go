@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// POST /api/cache/purge drops what is cached for a greeting path or an OG
+// cache key, so a moderation action or a template fix shows at once. Pages
+// are rendered on each request and not kept in memory, so that is the
+// rendered files on disk (OG images, videos, PDFs, audio) and, with
+// CDN_PURGE_URL, the surrogate keys of the CDN.
+
+// PurgeRequest names what to purge: a greeting path, with every query and
+// language variant of its files, or one OG cache key.
+type PurgeRequest struct {
+	Path  string `json:"path,omitempty"`
+	OgKey string `json:"og_key,omitempty"`
+}
+
+// PurgeResponse lists the files removed, relative to the cache directory,
+// and the surrogate keys purged, or to purge by hand without a CDN
+// configured.
+type PurgeResponse struct {
+	Files         []string `json:"files"`
+	SurrogateKeys []string `json:"surrogate_keys"`
+	CDNPurged     bool     `json:"cdn_purged"`
+	CDNError      string   `json:"cdn_error,omitempty"`
+}
+
+var cdnPurgeHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// purgeCacheDirs are the cache subdirectories keyed by ogImageSpec.cacheKey,
+// with their file extensions.
+var purgeCacheDirs = []struct{ dir, ext string }{{"og", ".png"}, {"video", ".mp4"}, {"pdf", ".pdf"}}
+
+func handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	body, err := readLimitedBody(r, maxPurgeBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+	var req PurgeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+
+	var resp PurgeResponse
+	var audit AuditEntry
+	switch {
+	case strings.TrimSpace(req.Path) != "":
+		fullPath := normalizeGreetingPath(req.Path)
+		audit = adminAudit(r, "cache.purge", fullPath)
+		resp, err = purgeGreeting(fullPath)
+	case req.OgKey != "":
+		if strings.ContainsAny(req.OgKey, `/\`) || strings.Contains(req.OgKey, "..") {
+			writeAPIError(w, http.StatusBadRequest, "invalid_field")
+			return
+		}
+		audit = adminAudit(r, "cache.purge", "og:"+req.OgKey)
+		resp, err = purgeOgKey(req.OgKey)
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_field")
+		return
+	}
+	if err != nil {
+		slog.Error("cache purge failed", "path", req.Path, "og_key", req.OgKey, "error", err)
+		audit.Error = err.Error()
+		recordAudit(audit)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	if err := purgeCDN(resp.SurrogateKeys); err != nil {
+		slog.Error("CDN purge failed", "keys", resp.SurrogateKeys, "error", err)
+		resp.CDNError = err.Error()
+	} else {
+		resp.CDNPurged = cdnPurgeURL() != ""
+	}
+	sort.Strings(resp.Files)
+	audit.After = auditState(resp)
+	recordAudit(audit)
+	slog.Info("cache purged", "path", req.Path, "og_key", req.OgKey, "files", len(resp.Files), "cdn", resp.CDNPurged)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// purgeGreeting removes the files of the greeting at fullPath, read with
+// its query as the page is: those of its OG cache key and of every variant
+// of it (theme, accent, photo and emoji add suffixes to the key), and its
+// audio, in Portuguese and in every translation, whose text and so key
+// differ.
+func purgeGreeting(fullPath string) (PurgeResponse, error) {
+	pathOnly, rawQuery, _ := strings.Cut(fullPath, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	_, path := splitLocalePath(pathOnly)
+	opts := greetingPageOptions(path, query)
+	codes := []string{""}
+	for code := range locales {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes[1:])
+
+	resp := PurgeResponse{Files: []string{}, SurrogateKeys: []string{greetingSurrogateKey(pathOnly)}}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		opts.Locale = code
+		g := buildGreeting(path, opts)
+		// The plain key, which every variant of the image extends
+		spec := ogImageSpec{Text: g.OgSpec.Text, Occasion: g.OgSpec.Occasion}
+		keys, err := removeOgCacheFiles(spec.cacheKey(), true, &resp)
+		if err != nil {
+			return resp, err
+		}
+		if removed, err := removeCacheFile(speechCachePath(speechText(g))); err != nil {
+			return resp, err
+		} else if removed != "" {
+			resp.Files = append(resp.Files, removed)
+		}
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				resp.SurrogateKeys = append(resp.SurrogateKeys, ogSurrogateKey(k))
+			}
+		}
+	}
+	return resp, nil
+}
+
+// purgeOgKey removes the files of exactly the OG cache key.
+func purgeOgKey(key string) (PurgeResponse, error) {
+	resp := PurgeResponse{Files: []string{}, SurrogateKeys: []string{ogSurrogateKey(key)}}
+	_, err := removeOgCacheFiles(key, false, &resp)
+	return resp, err
+}
+
+// removeOgCacheFiles removes the cached files of key, and with variants
+// those of the keys extending it, adding them to resp. It returns the keys
+// of the files removed, key first.
+func removeOgCacheFiles(key string, variants bool, resp *PurgeResponse) ([]string, error) {
+	keys := []string{key}
+	seen := map[string]bool{key: true}
+	for _, cache := range purgeCacheDirs {
+		dir, ext := cache.dir, cache.ext
+		entries, err := os.ReadDir(filepath.Join(ogCacheDir(), dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), ext)
+			if !ok || name != key && !(variants && strings.HasPrefix(name, key+"--")) {
+				continue
+			}
+			removed, err := removeCacheFile(filepath.Join(ogCacheDir(), dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if removed != "" {
+				resp.Files = append(resp.Files, removed)
+			}
+			if !seen[name] {
+				seen[name] = true
+				keys = append(keys, name)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// removeCacheFile removes path, returning it relative to the cache
+// directory, or "" when there was nothing to remove.
+func removeCacheFile(path string) (string, error) {
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(ogCacheDir(), path)
+	if err != nil {
+		return path, nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// cdnPurgeURL is the endpoint purging surrogate keys (CDN_PURGE_URL), such
+// as Cloudflare's purge_cache; without it the keys are only returned.
+func cdnPurgeURL() string {
+	return os.Getenv("CDN_PURGE_URL")
+}
+
+// purgeCDN posts {"tags": keys} to CDN_PURGE_URL, with CDN_PURGE_TOKEN as
+// a bearer token.
+func purgeCDN(keys []string) error {
+	endpoint := cdnPurgeURL()
+	if endpoint == "" || len(keys) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(map[string][]string{"tags": keys})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("CDN_PURGE_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := cdnPurgeHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("CDN purge: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	randomGreetingsOnce sync.Once
+	randomGreetings     []string
+)
+
+// randomIndex picks an entry of the pool; replaced in tests.
+var randomIndex = rand.IntN
+
+// loadRandomGreetings reads the curated pool, dropping entries that fail the
+// same validation as short links (blocked words, invalid names or ages).
+func loadRandomGreetings() {
+	data, err := embeddedFiles.ReadFile("public/random-greetings.txt")
+	if err != nil {
+		randomGreetings = nil
+		return
+	}
+	lines := strings.Split(string(data), "\n")
+	randomGreetings = make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if status := validateGreetingPath(line); status != http.StatusOK {
+			slog.Warn("random greeting rejected", "path", line, "status", status)
+			continue
+		}
+		randomGreetings = append(randomGreetings, line)
+	}
+}
+
+// handleRandom redirects to a random greeting from the curated pool.
+func handleRandom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	randomGreetingsOnce.Do(loadRandomGreetings)
+	target := "/"
+	if len(randomGreetings) > 0 {
+		target = randomGreetings[randomIndex(len(randomGreetings))]
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// With READ_ONLY=1 the server serves pages, shortlinks and images as usual,
+// without counting shortlink clicks, but refuses every request that would
+// change a store (new shortlinks, tracking, uploads, cards, sign-ins and the
+// rest) with a 503, so the data stays still during migrations, restores and
+// incidents. The background jobs that write (the photo sweeper, the reminder
+// scheduler, the deferred shortlink releaser and the expired shortlink
+// sweeper) do not start; backups still run. The 503 is a courtesy to
+// clients: the functions that write the stores refuse to themselves, so a
+// request that writes as a side effect, like a GET counting an API token's
+// use, changes nothing on disk either.
+
+// readOnly reports whether READ_ONLY is set.
+func readOnly() bool {
+	value, _ := strconv.ParseBool(os.Getenv("READ_ONLY"))
+	return value
+}
+
+// errReadOnly is the error of a store write while READ_ONLY is set.
+var errReadOnly = errors.New("read-only mode")
+
+// readOnlyPosts are the POSTs that write nothing: unlocking a protected
+// greeting only sets a cookie, and a cache purge only drops rendered files.
+var readOnlyPosts = []string{"/p/", "/api/cache/purge"}
+
+// writingGets are the GETs that write: the confirmation, unsubscribe and
+// sign-in links and the OAuth flow, which stores its state. The e-mail
+// opt-in link only shows a form on a GET.
+var writingGets = []string{
+	"/api/reminders/confirm",
+	"/api/reminders/unsubscribe",
+	"/minhas-mensagens/entrar",
+	"/minhas-mensagens/oauth/",
+}
+
+// mutatesState reports whether r may change a store.
+func mutatesState(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		for _, path := range writingGets {
+			if r.URL.Path == path || strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path) {
+				return true
+			}
+		}
+		return false
+	case http.MethodPost:
+		for _, prefix := range readOnlyPosts {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// withReadOnly answers the requests that would write with 503 read_only
+// while READ_ONLY is set.
+func withReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !readOnly() || !mutatesState(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(readOnlyRetryAfter))
+		w.Header().Set("Cache-Control", "no-store")
+		if isAPIRequest(r) {
+			writeAPIError(w, http.StatusServiceUnavailable, "read_only")
+			return
+		}
+		writeHTML(w, r, http.StatusServiceUnavailable, errorPage(apiErrorMessages["read_only"]))
+	})
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ReminderRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Date  string `json:"date"` // same formats as ?nascimento=
+}
+
+// reminder is a yearly birthday reminder. Its token is the key in the
+// store and authenticates both the confirmation and the unsubscribe links.
+type reminder struct {
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Day       int       `json:"day"`
+	Month     int       `json:"month"`
+	Year      int       `json:"year,omitempty"` // birth year, when given
+	Confirmed bool      `json:"confirmed"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSent  int       `json:"last_sent,omitempty"` // year of the last reminder
+}
+
+type reminderStore struct {
+	mu      sync.Mutex
+	loaded  bool
+	byToken map[string]*reminder
+}
+
+var reminders = reminderStore{
+	byToken: map[string]*reminder{},
+}
+
+var reminderLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: reminderRateWindow,
+	max:    reminderRateLimit,
+}
+
+// handleReminders serves POST /api/reminders: it stores the reminder and
+// asks the address to confirm it, unless the address already confirmed
+// another reminder.
+func handleReminders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if !smtpConfigured() {
+		writeAPIError(w, http.StatusServiceUnavailable, "unavailable")
+		return
+	}
+	if !reminderLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	body, err := readLimitedBody(r, maxSendBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+
+	var req ReminderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+	email, ok := parseEmailAddress(req.Email)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_email")
+		return
+	}
+	name, err := parseName(req.Name)
+	if err != nil || name == "" {
+		writeNameError(w, err)
+		return
+	}
+	born, err := parseBirthdate(req.Date, time.Now())
+	if err != nil || born.Day == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_birthdate")
+		return
+	}
+
+	if err := ensureRemindersLoaded(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	token, err := randomToken()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	rem := &reminder{Email: email, Name: name, Day: born.Day, Month: born.Month, Year: born.Year, CreatedAt: time.Now().UTC()}
+
+	reminders.mu.Lock()
+	count, confirmed := 0, false
+	for _, existing := range reminders.byToken {
+		if existing.Email == email {
+			count++
+			confirmed = confirmed || existing.Confirmed
+		}
+	}
+	if count >= maxRemindersPerEmail {
+		reminders.mu.Unlock()
+		writeAPIError(w, http.StatusTooManyRequests, "limit_reached")
+		return
+	}
+	if !confirmed && !optInLimiter.allow(emailHash(email)) {
+		reminders.mu.Unlock()
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	rem.Confirmed = confirmed
+	reminders.byToken[token] = rem
+	err = persistRemindersLocked()
+	reminders.mu.Unlock()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	if confirmed {
+		writeJSON(w, http.StatusCreated, SendResponse{Status: "subscribed"})
+		return
+	}
+	if err := sendEmail(email, buildReminderOptInEmail(token, rem)); err != nil {
+		slog.Error("reminder opt-in email failed", "error", err)
+		writeAPIError(w, http.StatusBadGateway, "upstream_failed")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, SendResponse{Status: "confirmation_sent"})
+}
+
+// handleReminderConfirm serves the opt-in link GET /api/reminders/confirm.
+func handleReminderConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if err := ensureRemindersLoaded(); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	reminders.mu.Lock()
+	rem, ok := reminders.byToken[r.URL.Query().Get("token")]
+	if ok && !rem.Confirmed {
+		if time.Since(rem.CreatedAt) <= optInTokenTTL {
+			rem.Confirmed = true
+			if err := persistRemindersLocked(); err != nil {
+				slog.Error("reminder store persist failed", "error", err)
+			}
+		} else {
+			ok = false
+		}
+	}
+	var name string
+	if ok {
+		name = rem.Name
+	}
+	reminders.mu.Unlock()
+	if !ok {
+		writeHTML(w, r, http.StatusNotFound, errorPage("Este link de confirmação é inválido ou expirou."))
+		return
+	}
+	writeHTML(w, r, http.StatusOK, messagePage("Confirmado", "Pronto!", fmt.Sprintf("Todo ano, na véspera do aniversário de %s, você receberá um lembrete com um cartão pronto.", name)))
+}
+
+// handleReminderUnsubscribe serves the unsubscribe link of every reminder
+// email. GET works from the link in the body and POST from mail clients'
+// one-click unsubscribe (RFC 8058).
+func handleReminderUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		return
+	}
+	if err := ensureRemindersLoaded(); err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	reminders.mu.Lock()
+	_, ok := reminders.byToken[token]
+	if ok {
+		delete(reminders.byToken, token)
+		if err := persistRemindersLocked(); err != nil {
+			slog.Error("reminder store persist failed", "error", err)
+		}
+	}
+	reminders.mu.Unlock()
+	// Unsubscribing twice is not an error
+	writeHTML(w, r, http.StatusOK, messagePage("Lembrete cancelado", "Lembrete cancelado", "Você não receberá mais este lembrete."))
+}
+
+// reminderDue reports whether rem's reminder goes out on now's date: the
+// eve of the birthday, once a year. Birthdays on 29 February are reminded
+// on the 27th in common years.
+func reminderDue(rem *reminder, now time.Time) bool {
+	tomorrow := now.AddDate(0, 0, 1)
+	day := rem.Day
+	if rem.Month == 2 && day == 29 && time.Date(tomorrow.Year(), time.March, 0, 0, 0, 0, 0, time.UTC).Day() == 28 {
+		day = 28
+	}
+	return rem.Confirmed && int(tomorrow.Month()) == rem.Month && tomorrow.Day() == day && rem.LastSent != tomorrow.Year()
+}
+
+// sendDueReminders emails every reminder due at now and returns how many
+// were sent. A failed reminder is retried on the next run.
+func sendDueReminders(now time.Time) (int, error) {
+	if err := ensureRemindersLoaded(); err != nil {
+		return 0, err
+	}
+	type dueReminder struct {
+		token string
+		rem   reminder
+	}
+	reminders.mu.Lock()
+	var due []dueReminder
+	for token, rem := range reminders.byToken {
+		if reminderDue(rem, now) {
+			due = append(due, dueReminder{token, *rem})
+		}
+	}
+	reminders.mu.Unlock()
+
+	year := now.AddDate(0, 0, 1).Year()
+	sent := 0
+	for _, d := range due {
+		if err := sendEmail(d.rem.Email, buildReminderEmail(d.token, &d.rem, year)); err != nil {
+			slog.Error("reminder email failed", "error", err)
+			continue
+		}
+		sent++
+		reminders.mu.Lock()
+		if rem, ok := reminders.byToken[d.token]; ok {
+			rem.LastSent = year
+		}
+		reminders.mu.Unlock()
+	}
+	if sent == 0 {
+		return 0, nil
+	}
+	reminders.mu.Lock()
+	defer reminders.mu.Unlock()
+	return sent, persistRemindersLocked()
+}
+
+func startReminderScheduler() {
+	if !smtpConfigured() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(reminderCheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			sent, err := sendDueReminders(now)
+			if err != nil {
+				slog.Error("reminder run failed", "error", err)
+				continue
+			}
+			if sent > 0 {
+				slog.Info("reminders sent", "count", sent)
+			}
+		}
+	}()
+}
+
+// reminderGreetingPath is the ready-made birthday card of a reminder, with
+// the age when the birth year is known.
+func reminderGreetingPath(rem *reminder, year int) string {
+	path := "/aniversario/" + encodePathSegment(rem.Name)
+	if rem.Year != 0 && year-rem.Year >= minAge && year-rem.Year <= maxAge {
+		path += fmt.Sprintf("/%d", year-rem.Year)
+	}
+	return path
+}
+
+func reminderURL(action, token string) string {
+	return strings.TrimRight(publicBaseURL(), "/") + "/api/reminders/" + action + "?token=" + url.QueryEscape(token)
+}
+
+func buildReminderOptInEmail(token string, rem *reminder) []byte {
+	var msg bytes.Buffer
+	writeEmailHeaders(&msg, rem.Email, "Confirme seu lembrete de aniversário", "text/plain; charset=utf-8")
+	fmt.Fprintf(&msg, "Você pediu ao %s um lembrete anual do aniversário de %s (%02d/%02d).\r\n\r\n", siteIdentity().Name, rem.Name, rem.Day, rem.Month)
+	fmt.Fprintf(&msg, "Para ativá-lo, confirme seu e-mail:\r\n%s\r\n\r\n", reminderURL("confirm", token))
+	msg.WriteString("Se você não reconhece este pedido, basta ignorar esta mensagem.\r\n")
+	return msg.Bytes()
+}
+
+func buildReminderEmail(token string, rem *reminder, year int) []byte {
+	link := strings.TrimRight(publicBaseURL(), "/") + reminderGreetingPath(rem, year)
+	unsubscribe := reminderURL("unsubscribe", token)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "List-Unsubscribe: <%s>\r\n", unsubscribe)
+	msg.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	writeEmailHeaders(&msg, rem.Email, fmt.Sprintf("Amanhã é aniversário de %s 🎂", rem.Name), "text/plain; charset=utf-8")
+	fmt.Fprintf(&msg, "Amanhã é aniversário de %s!\r\n\r\n", rem.Name)
+	fmt.Fprintf(&msg, "Seu cartão já está pronto, é só compartilhar:\r\n%s\r\n\r\n", link)
+	fmt.Fprintf(&msg, "Para não receber mais este lembrete:\r\n%s\r\n", unsubscribe)
+	return msg.Bytes()
+}
+
+func ensureRemindersLoaded() error {
+	reminders.mu.Lock()
+	defer reminders.mu.Unlock()
+	if reminders.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(remindersDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			reminders.loaded = true
+			return nil
+		}
+		return err
+	}
+	byToken := map[string]*reminder{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &byToken); err != nil {
+			return err
+		}
+	}
+	reminders.byToken = byToken
+	reminders.loaded = true
+	return nil
+}
+
+func persistRemindersLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := remindersDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	for token, rem := range reminders.byToken {
+		if !rem.Confirmed && time.Since(rem.CreatedAt) > optInTokenTTL {
+			delete(reminders.byToken, token)
+		}
+	}
+	data, err := json.MarshalIndent(reminders.byToken, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func remindersDBPath() string {
+	if value := os.Getenv("REMINDERS_DB"); value != "" {
+		return value
+	}
+	return "data/reminders.json"
+}
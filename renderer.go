@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// version is set at build time with -ldflags "-X main.version=…"; builds
+// without it report the VCS revision Go embeds.
+var version = ""
+
+// The SVG renderer (rsvg-convert, or OG_RENDERER) is looked up once at
+// startup. Without it the server runs degraded: OG images are the static
+// default, and card images and PDFs fail fast, rather than each request
+// paying for a failed lookup and a trip through the render queue.
+
+// RendererStatus describes the SVG renderer on /readyz and /version.
+type RendererStatus struct {
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+}
+
+var svgRenderer = struct {
+	mu     sync.RWMutex
+	probed bool
+	status RendererStatus
+}{}
+
+// rendererName returns OG_RENDERER, a path or a command name, defaulting to
+// rsvg-convert.
+func rendererName() string {
+	if name := os.Getenv("OG_RENDERER"); name != "" {
+		return name
+	}
+	return "rsvg-convert"
+}
+
+// probeRenderer looks up the renderer and records the result.
+func probeRenderer() RendererStatus {
+	status := RendererStatus{Name: rendererName()}
+	if path, err := exec.LookPath(status.Name); err != nil {
+		status.Error = err.Error()
+		slog.Error("SVG renderer unavailable: OG images fall back to the default, card images and PDFs fail", "renderer", status.Name, "error", err)
+	} else {
+		status.Path, status.Available = path, true
+		slog.Info("SVG renderer found", "path", path)
+	}
+	svgRenderer.mu.Lock()
+	svgRenderer.probed, svgRenderer.status = true, status
+	svgRenderer.mu.Unlock()
+	return status
+}
+
+// rendererStatus returns the status of the last probe. Before any, the
+// renderer is assumed available and looked up on each use.
+func rendererStatus() RendererStatus {
+	svgRenderer.mu.RLock()
+	defer svgRenderer.mu.RUnlock()
+	if !svgRenderer.probed {
+		return RendererStatus{Name: rendererName(), Available: true}
+	}
+	return svgRenderer.status
+}
+
+// rendererCommand returns the renderer's path, from the probe when there
+// was one.
+func rendererCommand() (string, error) {
+	svgRenderer.mu.RLock()
+	probed, status := svgRenderer.probed, svgRenderer.status
+	svgRenderer.mu.RUnlock()
+	if !probed {
+		return exec.LookPath(rendererName())
+	}
+	if !status.Available {
+		return "", fmt.Errorf("%s unavailable: %s", status.Name, status.Error)
+	}
+	return status.Path, nil
+}
+
+// Readiness is served by /readyz: "ok", or "degraded" when the server runs
+// without an optional dependency, has stopped rendering OG images after
+// repeated failures or is short of disk. Both answer 200, since pages are
+// served; read_only tells whether writes are refused (READ_ONLY).
+type Readiness struct {
+	Status          string         `json:"status"`
+	Renderer        RendererStatus `json:"renderer"`
+	OgRenderCircuit string         `json:"og_render_circuit"` // "closed" or "open"
+	Disks           []DiskStatus   `json:"disks,omitempty"`
+	ReadOnly        bool           `json:"read_only,omitempty"`
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	ready := Readiness{Status: "ok", Renderer: rendererStatus(), OgRenderCircuit: "closed", Disks: diskStatuses(), ReadOnly: readOnly()}
+	if ogQueue.breaker.isOpen() {
+		ready.OgRenderCircuit = "open"
+	}
+	if !ready.Renderer.Available || ready.OgRenderCircuit == "open" {
+		ready.Status = "degraded"
+	}
+	for _, disk := range ready.Disks {
+		if !disk.Healthy {
+			ready.Status = "degraded"
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, ready)
+}
+
+// VersionInfo is served by /version.
+type VersionInfo struct {
+	Version  string         `json:"version"`
+	Go       string         `json:"go"`
+	Renderer RendererStatus `json:"renderer"`
+}
+
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "dev"
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, VersionInfo{Version: buildVersion(), Go: runtime.Version(), Renderer: rendererStatus()})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+)
+
+// Messages support a tiny markup subset: **negrito**, *itálico* and the "~"
+// line break. Markers must hug non-space text on one line, so "2 * 3 * 4"
+// stays plain.
+var (
+	boldPattern   = regexp.MustCompile(`\*\*([^*\s](?:[^*]*[^*\s])?)\*\*`)
+	italicPattern = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*`)
+)
+
+// stripRichText removes the markers, for titles, previews, OG images and
+// speech.
+func stripRichText(value string) string {
+	value = boldPattern.ReplaceAllString(value, "$1")
+	return italicPattern.ReplaceAllString(value, "$1")
+}
+
+// richTextHTML escapes line and then turns the markers into <strong> and
+// <em>. Only those fixed tags are ever added, around already escaped text.
+func richTextHTML(line string) template.HTML {
+	escaped := template.HTMLEscapeString(line)
+	escaped = boldPattern.ReplaceAllString(escaped, "<strong>$1</strong>")
+	escaped = italicPattern.ReplaceAllString(escaped, "<em>$1</em>")
+	return template.HTML(escaped)
+}
+
+// richMessageLines splits a marked-up message into rendered lines.
+func richMessageLines(message string) []template.HTML {
+	var lines []template.HTML
+	for _, line := range messageLines(message) {
+		lines = append(lines, richTextHTML(line))
+	}
+	return lines
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client is a minimal S3 client for the object operations the backups
+// and the shortlink bucket need, signing requests with AWS Signature
+// Version 4. Without an endpoint it uses AWS's virtual-hosted URLs; with
+// one (MinIO, R2 and other S3-compatible stores) it uses path-style URLs
+// under it. Keys are under prefix, which List strips.
+type s3Client struct {
+	endpoint     string
+	region       string
+	bucket       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	prefix       string
+}
+
+var s3HTTPClient = &http.Client{Timeout: time.Minute}
+
+// s3ClientFromEnv configures a client from the variables named after
+// prefix: <prefix>_BUCKET, _REGION (default AWS_REGION, then us-east-1),
+// _PREFIX and _ENDPOINT, with the AWS_ credentials. It returns nil when
+// the bucket is not set.
+func s3ClientFromEnv(prefix string) (*s3Client, error) {
+	bucket := os.Getenv(prefix + "_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+	client := &s3Client{
+		endpoint:     os.Getenv(prefix + "_ENDPOINT"),
+		region:       os.Getenv(prefix + "_REGION"),
+		bucket:       bucket,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if client.accessKey == "" || client.secretKey == "" {
+		return nil, fmt.Errorf("%s_BUCKET needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", prefix)
+	}
+	if client.region == "" {
+		client.region = os.Getenv("AWS_REGION")
+	}
+	if client.region == "" {
+		client.region = "us-east-1"
+	}
+	if keyPrefix := strings.Trim(os.Getenv(prefix+"_PREFIX"), "/"); keyPrefix != "" {
+		client.prefix = keyPrefix + "/"
+	}
+	return client, nil
+}
+
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: c.bucket + ".s3." + c.region + ".amazonaws.com", Path: "/" + key}
+	if c.endpoint != "" {
+		base, _ := url.Parse(strings.TrimRight(c.endpoint, "/"))
+		u = &url.URL{Scheme: base.Scheme, Host: base.Host, Path: base.Path + "/" + c.bucket}
+		if key != "" {
+			u.Path += "/" + key
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u
+}
+
+// errS3PreconditionFailed is a conditional write refused because the
+// object is no longer the version its ETag named.
+var errS3PreconditionFailed = errors.New("s3: object changed since it was read")
+
+// errS3NotModified is a conditional read of an object still the version
+// its ETag named.
+var errS3NotModified = errors.New("s3: object not modified")
+
+// s3StatusError is a request the store answered with a status but 2xx.
+type s3StatusError struct {
+	Method     string
+	Key        string
+	Status     string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *s3StatusError) Error() string {
+	return fmt.Sprintf("s3 %s %s: %s: %s", e.Method, e.Key, e.Status, e.Body)
+}
+
+func isS3NotFound(err error) bool {
+	var statusErr *s3StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// do sends a signed request, failing on any status but 2xx.
+func (c *s3Client) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	data, _, err := c.send(method, key, query, nil, body)
+	return data, err
+}
+
+// send is do with the request's extra headers, which are signed too,
+// returning the response's headers as well.
+func (c *s3Client) send(method, key string, query url.Values, header http.Header, body []byte) ([]byte, http.Header, error) {
+	req, err := http.NewRequest(method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	signS3Request(req, body, c.accessKey, c.secretKey, c.sessionToken, c.region, time.Now())
+	resp, err := s3HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, &s3StatusError{Method: method, Key: key, Status: resp.Status, StatusCode: resp.StatusCode, Body: bytes.TrimSpace(data)}
+	}
+	return data, resp.Header, nil
+}
+
+func (c *s3Client) Put(key string, data []byte) error {
+	_, err := c.do(http.MethodPut, c.prefix+key, nil, data)
+	return err
+}
+
+func (c *s3Client) Get(key string) ([]byte, error) {
+	return c.do(http.MethodGet, c.prefix+key, nil, nil)
+}
+
+// GetVersion returns the object at key and its ETag, unless it is still
+// the version of etag, which gives errS3NotModified; "" reads it anyway.
+func (c *s3Client) GetVersion(key, etag string) ([]byte, string, error) {
+	var header http.Header
+	if etag != "" {
+		header = http.Header{"If-None-Match": {etag}}
+	}
+	data, header, err := c.send(http.MethodGet, c.prefix+key, nil, header, nil)
+	var statusErr *s3StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotModified {
+		return nil, etag, errS3NotModified
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, header.Get("ETag"), nil
+}
+
+// PutIfMatch writes the object at key only if it is still the version of
+// etag, or does not exist yet when etag is "", returning the new ETag. The
+// store refusing it, or a concurrent conditional write, gives
+// errS3PreconditionFailed.
+func (c *s3Client) PutIfMatch(key string, data []byte, etag string) (string, error) {
+	header := http.Header{"If-None-Match": {"*"}}
+	if etag != "" {
+		header = http.Header{"If-Match": {etag}}
+	}
+	_, respHeader, err := c.send(http.MethodPut, c.prefix+key, nil, header, data)
+	var statusErr *s3StatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusPreconditionFailed || statusErr.StatusCode == http.StatusConflict) {
+		return "", errS3PreconditionFailed
+	}
+	if err != nil {
+		return "", err
+	}
+	return respHeader.Get("ETag"), nil
+}
+
+func (c *s3Client) Delete(key string) error {
+	_, err := c.do(http.MethodDelete, c.prefix+key, nil, nil)
+	return err
+}
+
+// List returns the keys under prefix, following continuation tokens.
+func (c *s3Client) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {c.prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		data, err := c.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, c.prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// signS3Request adds the Signature Version 4 headers to req, signing the
+// host and every header already set.
+func signS3Request(req *http.Request, body []byte, accessKey, secretKey, sessionToken, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath percent-encodes a path as SigV4 wants it: everything but
+// unreserved characters and the slashes.
+func s3EscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape is url.QueryEscape with spaces as %20, the encoding SigV4
+// signs.
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Incoming greeting paths are sanitized once, before they are decoded,
+// routed or moderated. A path arrives percent-decoded by net/http, and its
+// message may still be percent-encoded a second time (shortlinks and the
+// composer encode it); decodePath undoes that second layer. sanitizePath
+// decodes that layer itself, except for the characters whose escapes mean
+// something different from the character ("/", "?", "#", "%"), so no escape
+// is ever decoded twice and the checks below see the text people will.
+
+var (
+	errPathInvalid = errors.New("invalid path")
+	errPathTooLong = errors.New("path too long")
+)
+
+// sanitizePath returns the canonical form of a decoded request path:
+//
+//   - percent escapes of other characters decoded, the kept ones
+//     uppercased, and a "%" starting no escape encoded as %25
+//   - no invalid UTF-8, NUL or other control characters besides tab and
+//     line breaks, nor bidirectional overrides that disguise text
+//   - at most maxPathRunes characters
+//   - accents typed as combining marks composed with their letter, so
+//     "Jose\u0301" and "José" are the same greeting
+//
+// It returns errPathInvalid or errPathTooLong for paths it rejects.
+func sanitizePath(path string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(path))
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(path) || !isHex(path[i+1]) || !isHex(path[i+2]) {
+			b.WriteString("%25")
+			continue
+		}
+		decoded := unhex(path[i+1])<<4 | unhex(path[i+2])
+		if strings.IndexByte("/?#%", decoded) >= 0 {
+			b.WriteString(strings.ToUpper(path[i : i+3]))
+		} else {
+			b.WriteByte(decoded)
+		}
+		i += 2
+	}
+	clean := b.String()
+
+	if !utf8.ValidString(clean) {
+		return "", errPathInvalid
+	}
+	runes := 0
+	for _, r := range clean {
+		if isDisallowedPathRune(r) {
+			return "", errPathInvalid
+		}
+		runes++
+	}
+	if runes > maxPathRunes {
+		return "", errPathTooLong
+	}
+	return composeAccents(clean), nil
+}
+
+func isDisallowedPathRune(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r':
+		return false
+	case r < 0x20 || (r >= 0x7f && r <= 0x9f):
+		return true
+	case r >= 0x202a && r <= 0x202e, r >= 0x2066 && r <= 0x2069:
+		return true
+	}
+	return false
+}
+
+// stripInvalidText drops from s the bytes that are not UTF-8 (truncated
+// and overlong sequences, encoded surrogates) and the characters
+// sanitizePath rejects. Text decoded from a sanitized path can still hold
+// them when it came from a path that never was, such as ?path= of the
+// preview API.
+func stripInvalidText(s string) string {
+	if utf8.ValidString(s) && !strings.ContainsFunc(s, isDisallowedPathRune) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !(r == utf8.RuneError && size == 1) && !isDisallowedPathRune(r) {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// accentCompositions maps a combining mark to the letters it composes
+// with and the precomposed results, position by position. The standard
+// library has no Unicode normalization; these cover the Latin letters of
+// the languages greetings are written in, which is what NFC would change.
+var accentCompositions = map[rune][2][]rune{
+	'\u0300': {[]rune("AEIOUaeiou"), []rune("ÀÈÌÒÙàèìòù")},
+	'\u0301': {[]rune("AEIOUYaeiouyCcNnSsZz"), []rune("ÁÉÍÓÚÝáéíóúýĆćŃńŚśŹź")},
+	'\u0302': {[]rune("AEIOUaeiou"), []rune("ÂÊÎÔÛâêîôû")},
+	'\u0303': {[]rune("AONaon"), []rune("ÃÕÑãõñ")},
+	'\u0308': {[]rune("AEIOUYaeiouy"), []rune("ÄËÏÖÜŸäëïöüÿ")},
+	'\u0327': {[]rune("Cc"), []rune("Çç")},
+}
+
+// composeAccents replaces a letter followed by a combining accent with the
+// precomposed letter.
+func composeAccents(s string) string {
+	if !strings.ContainsAny(s, "\u0300\u0301\u0302\u0303\u0308\u0327") {
+		return s
+	}
+	runes := []rune(s)
+	out := runes[:0]
+	for _, r := range runes {
+		if n := len(out); n > 0 {
+			if table, ok := accentCompositions[r]; ok {
+				if i := indexRune(table[0], out[n-1]); i >= 0 {
+					out[n-1] = table[1][i]
+					continue
+				}
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func indexRune(runes []rune, r rune) int {
+	for i, candidate := range runes {
+		if candidate == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// canonicalPath collapses repeated slashes and drops a trailing one, so
+// "/João/", "//João" and "/aniversario//João" are the greetings they look
+// like rather than messages of their own.
+func canonicalPath(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	if path = strings.TrimSuffix(path, "/"); path == "" {
+		return "/"
+	}
+	return path
+}
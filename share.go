@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ShareRequest struct {
+	Path string `json:"path"`
+}
+
+type ShareResponse struct {
+	ShortURL string `json:"short_url"`
+	Text     string `json:"text"`
+	WhatsApp string `json:"whatsapp"`
+	Telegram string `json:"telegram"`
+}
+
+// handleShare creates (or reuses) the shortlink for a greeting and returns
+// ready-made share URLs, so every client uses the same share text.
+func handleShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	owner, powSolved, ok := checkAnonymousShortlink(w, r)
+	if !ok {
+		return
+	}
+	if !diskHealthy("data") {
+		writeStorageUnavailable(w)
+		return
+	}
+	if err := ensureShortlinksLoaded(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	body, err := readLimitedBody(r, maxShortlinkBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+
+	var req ShareRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+	if strings.TrimSpace(req.Path) == "" {
+		writeAPIError(w, http.StatusBadRequest, "empty_message")
+		return
+	}
+	fullPath := normalizeGreetingPath(req.Path)
+	if status, code := checkGreetingPath(fullPath); status != http.StatusOK {
+		writeAPIError(w, status, code)
+		return
+	}
+	creator := shortlinkCreatorOf(r, "")
+	// Scored as an anonymous POST /s is, since it creates the same links
+	if owner == "" && !checkSpamScore(w, r, fullPath, nil, creator, powSolved) {
+		return
+	}
+	code, _, err := createShortlink(fullPath, creator)
+	if err != nil {
+		if err == errNoFreeCode {
+			writeAPIError(w, http.StatusServiceUnavailable, "no_free_code")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, shareLinks(fullPath, shortlinkResponse(code, fullPath).ShortURL))
+}
+
+func shareLinks(fullPath, shortURL string) ShareResponse {
+	pathOnly, rawQuery, _ := strings.Cut(fullPath, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	g := buildGreeting(pathOnly, greetingPageOptions(pathOnly, query))
+
+	headline := g.Emoji + " " + g.Title
+	text := headline + "\nAbra seu cartão: " + shortURL
+	return ShareResponse{
+		ShortURL: shortURL,
+		Text:     text,
+		WhatsApp: "https://wa.me/?text=" + url.QueryEscape(text),
+		Telegram: "https://t.me/share/url?url=" + url.QueryEscape(shortURL) + "&text=" + url.QueryEscape(headline),
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// With SHORTLINK_S3_BUCKET set, the shortlink store is kept as the object
+// shortlinks.json of an S3-compatible bucket (SHORTLINK_S3_ENDPOINT,
+// SHORTLINK_S3_REGION, SHORTLINK_S3_PREFIX) instead of at SHORTLINK_DB, for
+// servers without a persistent disk. Several servers may share it: each
+// write is conditional on the object's ETag as the server last read or
+// wrote it, and a write another server got in before takes that server's
+// changes in and is made again on top of them. Every
+// shortlinkBucketRefresh the object is read again, conditional on its
+// ETag, to take in the other servers' changes without a request waiting
+// on the bucket.
+
+// errShortlinkStoreChanged is a write of the store object refused because
+// another server wrote it since it was read here.
+var errShortlinkStoreChanged = errors.New("shortlink store changed since it was read")
+
+// shortlinkObject is the store object as last read or written here: its
+// ETag, which the next write is conditional on ("" when it did not exist),
+// and its content, which tells another server's changes from this one's.
+var shortlinkObject struct {
+	sync.Mutex
+	etag    string
+	entries shortlinkData
+}
+
+// shortlinkBucket returns the client of SHORTLINK_S3_BUCKET, or nil when
+// the store is the file at SHORTLINK_DB.
+func shortlinkBucket() (*s3Client, error) {
+	return s3ClientFromEnv("SHORTLINK_S3")
+}
+
+// loadShortlinkStore reads the store from the bucket, or from the file at
+// path when there is none, with what SHORTLINK_META_DB and
+// SHORTLINK_STATS_DB have for a store from before version 3.
+func loadShortlinkStore(path string) (shortlinkData, int, error) {
+	bucket, err := shortlinkBucket()
+	if err != nil {
+		return shortlinkData{}, 0, err
+	}
+	var data shortlinkData
+	var version int
+	if bucket == nil {
+		data, version, err = loadShortlinkFile(path)
+	} else {
+		data, version, err = loadShortlinkObject(bucket, "")
+	}
+	if err == nil && version < shortlinkStoreVersion {
+		err = readShortlinkSideStores(data)
+	}
+	return data, version, err
+}
+
+// writeShortlinkStore writes entries as the store to the bucket, or to the
+// file at path when there is none.
+func writeShortlinkStore(path string, entries shortlinkData) error {
+	if readOnly() {
+		return errReadOnly
+	}
+	bucket, err := shortlinkBucket()
+	if err != nil {
+		return err
+	}
+	if bucket == nil {
+		return writeShortlinkFile(path, entries)
+	}
+	return writeShortlinkObject(bucket, entries)
+}
+
+// shortlinkStoreName names the store in messages: the object's s3:// URL,
+// or path.
+func shortlinkStoreName(path string) string {
+	if bucket, _ := shortlinkBucket(); bucket != nil {
+		return "s3://" + bucket.bucket + "/" + bucket.prefix + shortlinkObjectName
+	}
+	return path
+}
+
+// loadShortlinkObject reads the store object, unless it is still the
+// version of etag, which gives errS3NotModified; a missing one is an empty
+// store. Invalid records are logged and left out, as there is no file to
+// quarantine them to: the bucket's versioning, when on, keeps them.
+func loadShortlinkObject(bucket *s3Client, etag string) (shortlinkData, int, error) {
+	data, etag, err := bucket.GetVersion(shortlinkObjectName, etag)
+	if isS3NotFound(err) {
+		data, etag, err = nil, "", nil
+	}
+	if err != nil {
+		return shortlinkData{}, 0, err
+	}
+	entries, version := newShortlinkData(), shortlinkStoreVersion
+	if data != nil {
+		var bad []badShortlink
+		if entries, version, bad, err = parseShortlinkRecords(data); err != nil {
+			return shortlinkData{}, 0, err
+		}
+		for _, record := range bad {
+			slog.Warn("shortlink record left out of the store", "code", record.Code, "reason", record.Reason)
+		}
+	}
+
+	shortlinkObject.Lock()
+	shortlinkObject.etag, shortlinkObject.entries = etag, entries.clone()
+	shortlinkObject.Unlock()
+	return entries, version, nil
+}
+
+// writeShortlinkObject writes entries as the store object, unless another
+// server wrote it since it was read here, which gives
+// errShortlinkStoreChanged.
+func writeShortlinkObject(bucket *s3Client, entries shortlinkData) error {
+	data, err := json.MarshalIndent(entries.storeFile(), "", "  ")
+	if err != nil {
+		return err
+	}
+	shortlinkObject.Lock()
+	defer shortlinkObject.Unlock()
+	etag, err := bucket.PutIfMatch(shortlinkObjectName, data, shortlinkObject.etag)
+	if errors.Is(err, errS3PreconditionFailed) {
+		return errShortlinkStoreChanged
+	}
+	if err != nil {
+		return err
+	}
+	shortlinkObject.etag, shortlinkObject.entries = etag, entries.clone()
+	return nil
+}
+
+// mergeShortlinkObject reads the store object again and applies to the
+// store in memory what other servers changed in it since it was read
+// here: the codes they added, unless taken here meanwhile, and the ones
+// they removed, the metadata they changed and the clicks they counted.
+// The changes made here are kept, to be written on top. An object no
+// other server wrote since is not read again.
+func mergeShortlinkObject(bucket *s3Client) error {
+	shortlinkObject.Lock()
+	base, etag := shortlinkObject.entries, shortlinkObject.etag
+	shortlinkObject.Unlock()
+	loaded, _, err := loadShortlinkObject(bucket, etag)
+	if errors.Is(err, errS3NotModified) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	remote := loaded.paths
+	migrateLegacyShortlinks(remote)
+
+	var removed []string
+	for code := range base.paths {
+		if _, ok := remote[code]; !ok {
+			removed = append(removed, code)
+		}
+	}
+	added := 0
+	shortlinks.mu.Lock()
+	for code, path := range remote {
+		if _, ok := base.paths[code]; ok {
+			continue
+		}
+		if _, ok := shortlinks.byCode[code]; ok {
+			continue
+		}
+		shortlinks.byCode[code] = path
+		if _, ok := shortlinks.byPath[path]; !ok {
+			shortlinks.byPath[path] = code
+		}
+		foldShortCodeLocked(code)
+		added++
+	}
+	mergeShortlinkMetasLocked(base.metas, loaded.metas)
+	mergeShortlinkStatsLocked(base.stats, loaded.stats)
+	shortlinks.mu.Unlock()
+	if added > 0 || len(removed) > 0 {
+		slog.Info("shortlink store merged with other servers' changes", "added", added, "removed", len(removed))
+	}
+	removeShortlinks(removed)
+	return nil
+}
+
+// mergeShortlinkMetasLocked takes in the metadata that other servers gave
+// the links since base, such as a stats token, unless it changed here too,
+// where the change made here wins. shortlinks.mu is held, so the links
+// added by the merge get theirs with them.
+func mergeShortlinkMetasLocked(base, remote map[string]shortlinkMeta) {
+	shortlinkMetas.mu.Lock()
+	defer shortlinkMetas.mu.Unlock()
+	for code, meta := range remote {
+		old, had := base[code]
+		if had && sameShortlinkMeta(meta, old) {
+			continue
+		}
+		take := false
+		if local, ok := shortlinkMetas.records[code]; ok {
+			take = had && sameShortlinkMeta(local, old)
+		} else {
+			// Unless removed here
+			_, take = shortlinks.byCode[code]
+		}
+		if take {
+			shortlinkMetas.records[code] = meta
+		}
+	}
+}
+
+// mergeShortlinkStatsLocked adds to the counts here the clicks that other
+// servers counted since base, so the clicks of every server add up.
+func mergeShortlinkStatsLocked(base, remote map[string]*ShortlinkStats) {
+	shortlinkClicks.mu.Lock()
+	defer shortlinkClicks.mu.Unlock()
+	for code, counted := range remote {
+		if _, ok := shortlinks.byCode[code]; !ok {
+			continue
+		}
+		old := base[code]
+		if old == nil {
+			old = &ShortlinkStats{}
+		}
+		if counted.Clicks <= old.Clicks {
+			continue
+		}
+		stats := shortlinkClicks.counts[code]
+		if stats == nil {
+			stats = &ShortlinkStats{Code: code, Referrers: map[string]int{}}
+			shortlinkClicks.counts[code] = stats
+		}
+		stats.Clicks += counted.Clicks - old.Clicks
+		for host, n := range counted.Referrers {
+			clicks := n - old.Referrers[host]
+			if clicks <= 0 {
+				continue
+			}
+			if _, known := stats.Referrers[host]; !known && len(stats.Referrers) >= maxShortlinkReferrers {
+				host = "other"
+			}
+			stats.Referrers[host] += clicks
+		}
+		if at := counted.LastAccessedAt; at != nil && (stats.LastAccessedAt == nil || at.After(*stats.LastAccessedAt)) {
+			stats.LastAccessedAt = at
+		}
+	}
+}
+
+// startShortlinkBucketRefresher takes the other servers' changes to the
+// store object in every shortlinkBucketRefresh, when there is a bucket.
+func startShortlinkBucketRefresher() {
+	if bucket, err := shortlinkBucket(); bucket == nil || err != nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(shortlinkBucketRefresh)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := refreshShortlinkObject(); err != nil {
+				slog.Warn("shortlink store refresh failed", "error", err)
+			}
+		}
+	}()
+}
+
+// refreshShortlinkObject merges the store object into the store in memory,
+// if another server wrote it since it was last read or written here.
+func refreshShortlinkObject() error {
+	bucket, err := shortlinkBucket()
+	if bucket == nil || err != nil {
+		return err
+	}
+	if err := ensureShortlinksLoaded(); err != nil {
+		return err
+	}
+	shortlinkWrites.Lock()
+	defer shortlinkWrites.Unlock()
+	return mergeShortlinkObject(bucket)
+}
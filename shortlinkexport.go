@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The export command writes every shortlink, with its metadata and click
+// counts, as one JSON object per line; the import command adds such lines to
+// the store. Both work on the store as written, so import is meant to run
+// with the server stopped, since a running one would overwrite it from
+// memory.
+
+// shortlinkRecord is a line of the export. StatsToken is the hash of the
+// creator's token, so the token keeps working after an import; the creator's
+// address hash only matches ?ip= under the same SESSION_SECRET.
+type shortlinkRecord struct {
+	Code           string         `json:"code"`
+	Path           string         `json:"path"`
+	CreatedAt      *time.Time     `json:"created_at,omitempty"`
+	ExpiresAt      *time.Time     `json:"expires_at,omitempty"`
+	StatsToken     string         `json:"stats_token,omitempty"`
+	Clicks         int            `json:"clicks,omitempty"`
+	LastAccessedAt *time.Time     `json:"last_accessed_at,omitempty"`
+	Referrers      map[string]int `json:"referrers,omitempty"`
+	ShortlinkCreator
+}
+
+// runShortlinkExport is the export command: it writes the records, by
+// code, to the file named by args or to out.
+func runShortlinkExport(args []string, out io.Writer) error {
+	data, _, err := loadShortlinkStore(shortlinkDBPath())
+	if err != nil {
+		return err
+	}
+	migrateLegacyShortlinks(data.paths)
+
+	dest := out
+	var file *os.File
+	if len(args) > 0 {
+		if file, err = os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+			return err
+		}
+		defer file.Close()
+		dest = file
+	}
+	codes := make([]string, 0, len(data.paths))
+	for code := range data.paths {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	w := bufio.NewWriter(dest)
+	enc := json.NewEncoder(w)
+	for _, code := range codes {
+		record := exportedShortlink(data.record(code))
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if file == nil {
+		return nil
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s: %d shortlinks exported\n", args[0], len(codes))
+	return err
+}
+
+// runShortlinkImport is the import command: it adds the records of the
+// file named by args, or of standard input, to the store. Codes already
+// there with the same path are left as they are; with another path, or
+// reserved, they are skipped and reported. A malformed line imports nothing.
+func runShortlinkImport(args []string, out io.Writer) error {
+	source, in := "stdin", io.Reader(os.Stdin)
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		source, in = args[0], f
+	}
+	records, err := readShortlinkRecords(in)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	path := shortlinkDBPath()
+	data, _, err := loadShortlinkStore(path)
+	if err != nil {
+		return err
+	}
+	migrateLegacyShortlinks(data.paths)
+	var added []shortlinkRecord
+	present := 0
+	for _, record := range records {
+		existing, ok := data.paths[record.Code]
+		switch {
+		case ok && existing == record.Path:
+			present++
+		case ok:
+			fmt.Fprintf(out, "conflict: %s is %s here, %s in %s; skipped\n", record.Code, existing, record.Path, source)
+		case isReservedShortCode(record.Code):
+			fmt.Fprintf(out, "conflict: %s is a reserved code; skipped\n", record.Code)
+		default:
+			data.add(record.stored())
+			added = append(added, record)
+		}
+	}
+	conflicts := len(records) - len(added) - present
+
+	if len(added) > 0 {
+		if err := writeShortlinkStore(path, data); err != nil {
+			return err
+		}
+		recordAudit(AuditEntry{Actor: "cli", Action: "shortlinks.import", Target: source,
+			After: auditState(map[string]int{"imported": len(added), "present": present, "conflicts": conflicts})})
+	}
+	_, err = fmt.Fprintf(out, "%s: %d shortlinks imported, %d already present, %d conflicting\n", source, len(added), present, conflicts)
+	return err
+}
+
+// readShortlinkRecords parses an export, failing on the first malformed
+// line with its number.
+func readShortlinkRecords(in io.Reader) ([]shortlinkRecord, error) {
+	var records []shortlinkRecord
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxShortlinkRecordBytes)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record shortlinkRecord
+		reason := ""
+		switch {
+		case json.Unmarshal(scanner.Bytes(), &record) != nil:
+			reason = "malformed record"
+		case record.Code == "" || strings.ContainsAny(record.Code, "/?# \t\n"):
+			reason = "malformed code"
+		case !strings.HasPrefix(record.Path, "/"):
+			reason = "malformed path"
+		case seen[record.Code]:
+			reason = "duplicate code " + record.Code
+		default:
+			// Only paths /s would take: "//evil.com" must not redirect off
+			// the site
+			record.Path = normalizeGreetingPath(record.Path)
+			if status, code := checkGreetingPath(record.Path); status != http.StatusOK {
+				reason = "invalid path (" + code + ")"
+			}
+		}
+		if reason != "" {
+			return nil, fmt.Errorf("line %d: %s", line, reason)
+		}
+		seen[record.Code] = true
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// exportedShortlink is the line of the export of a store record.
+func exportedShortlink(stored storedShortlink) shortlinkRecord {
+	return shortlinkRecord{
+		Code:             stored.Code,
+		Path:             stored.Path,
+		CreatedAt:        stored.CreatedAt,
+		ExpiresAt:        stored.ExpiresAt,
+		StatsToken:       stored.StatsToken,
+		Clicks:           stored.Hits,
+		LastAccessedAt:   stored.LastAccessedAt,
+		Referrers:        stored.Referrers,
+		ShortlinkCreator: stored.ShortlinkCreator,
+	}
+}
+
+// stored is the store record of an exported line.
+func (r shortlinkRecord) stored() storedShortlink {
+	return storedShortlink{
+		Code:             r.Code,
+		Path:             r.Path,
+		CreatedAt:        r.CreatedAt,
+		ExpiresAt:        r.ExpiresAt,
+		StatsToken:       r.StatsToken,
+		Hits:             r.Clicks,
+		LastAccessedAt:   r.LastAccessedAt,
+		Referrers:        r.Referrers,
+		ShortlinkCreator: r.ShortlinkCreator,
+	}
+}
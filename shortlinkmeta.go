@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// shortlinkMeta is what is kept about a shortlink besides its path and its
+// clicks, in its record of the store. Links created before it existed have
+// none.
+type shortlinkMeta struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	StatsToken string     `json:"stats_token,omitempty"` // hash of the creator's token
+	ShortlinkCreator
+}
+
+// ShortlinkCreator is who asked for a shortlink, for abuse investigations.
+// The address is kept only as a keyed hash, which creatorIPHash recomputes
+// for a suspect address; ID is whatever the client sent as creator_id.
+type ShortlinkCreator struct {
+	CreatorIPHash    string `json:"creator_ip_hash,omitempty"`
+	CreatorUserAgent string `json:"creator_user_agent,omitempty"`
+	CreatorID        string `json:"creator_id,omitempty"`
+}
+
+// shortlinkCreatorOf describes the client of r, with id from the request.
+func shortlinkCreatorOf(r *http.Request, id string) ShortlinkCreator {
+	userAgent := r.UserAgent()
+	if runes := []rune(userAgent); len(runes) > maxCreatorUserAgentLen {
+		userAgent = string(runes[:maxCreatorUserAgentLen])
+	}
+	return ShortlinkCreator{CreatorIPHash: creatorIPHash(clientIP(r)), CreatorUserAgent: userAgent, CreatorID: id}
+}
+
+// creatorIPHash is an HMAC of ip with SESSION_SECRET: without the secret
+// the hashes of the whole IPv4 space cannot be computed to reverse it.
+func creatorIPHash(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte("shortlink-creator:" + ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// validCreatorID reports whether a client-supplied creator_id can be kept:
+// at most maxCreatorIDLen characters, none of them control characters.
+func validCreatorID(id string) bool {
+	if !utf8.ValidString(id) || utf8.RuneCountInString(id) > maxCreatorIDLen {
+		return false
+	}
+	for _, r := range id {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// expired reports whether the link stopped resolving at now.
+func (m shortlinkMeta) expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+type shortlinkMetaStore struct {
+	mu      sync.Mutex
+	records map[string]shortlinkMeta
+}
+
+// shortlinkMetas is filled by ensureShortlinksLoaded and written with the
+// store's records.
+var shortlinkMetas = shortlinkMetaStore{
+	records: map[string]shortlinkMeta{},
+}
+
+// shortlinkMetaOf returns the metadata of code, if it has any.
+func shortlinkMetaOf(code string) (shortlinkMeta, bool) {
+	shortlinkMetas.mu.Lock()
+	defer shortlinkMetas.mu.Unlock()
+	meta, ok := shortlinkMetas.records[code]
+	return meta, ok
+}
+
+// sameShortlinkMeta reports whether a and b hold the same metadata, however
+// their times were read.
+func sameShortlinkMeta(a, b shortlinkMeta) bool {
+	if (a.ExpiresAt == nil) != (b.ExpiresAt == nil) || a.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt) {
+		return false
+	}
+	return a.CreatedAt.Equal(b.CreatedAt) && a.StatsToken == b.StatsToken && a.ShortlinkCreator == b.ShortlinkCreator
+}
+
+// setShortlinkMetaLocked keeps the metadata of a new code, with
+// shortlinks.mu held for the change that adds the code, so the two are
+// written together.
+func setShortlinkMetaLocked(code string, meta shortlinkMeta) {
+	shortlinkMetas.mu.Lock()
+	defer shortlinkMetas.mu.Unlock()
+	shortlinkMetas.records[code] = meta
+}
+
+// issueShortlinkStatsToken gives code a new stats token, returning it; only
+// its hash is kept.
+func issueShortlinkStatsToken(code string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	shortlinkMetas.mu.Lock()
+	meta, had := shortlinkMetas.records[code]
+	if !had {
+		meta.CreatedAt = time.Now().UTC()
+	}
+	meta.StatsToken = tokenHash(token)
+	shortlinkMetas.records[code] = meta
+	shortlinkMetas.mu.Unlock()
+	schedulePersistShortlinks()
+	return token, nil
+}
+
+// expiringShortlinks returns the codes with an expiry and when they expire.
+func expiringShortlinks() map[string]time.Time {
+	shortlinkMetas.mu.Lock()
+	defer shortlinkMetas.mu.Unlock()
+	return expiryTimes(shortlinkMetas.records)
+}
+
+func expiryTimes(metas map[string]shortlinkMeta) map[string]time.Time {
+	expiring := map[string]time.Time{}
+	for code, meta := range metas {
+		if meta.ExpiresAt != nil {
+			expiring[code] = *meta.ExpiresAt
+		}
+	}
+	return expiring
+}
+
+// shortlinkCreationTimes maps the codes whose creation time is known to it.
+func shortlinkCreationTimes() map[string]time.Time {
+	shortlinkMetas.mu.Lock()
+	defer shortlinkMetas.mu.Unlock()
+	created := map[string]time.Time{}
+	for code, meta := range shortlinkMetas.records {
+		if !meta.CreatedAt.IsZero() {
+			created[code] = meta.CreatedAt
+		}
+	}
+	return created
+}
+
+// deleteShortlinkMetas removes the metadata of codes.
+func deleteShortlinkMetas(codes []string) {
+	shortlinkMetas.mu.Lock()
+	defer shortlinkMetas.mu.Unlock()
+	for _, code := range codes {
+		delete(shortlinkMetas.records, code)
+	}
+}
+
+// shortlinkMetaDBPath defaults to shortlink-meta.json next to SHORTLINK_DB.
+// Stores from before version 3 kept the metadata there.
+func shortlinkMetaDBPath() string {
+	if value := os.Getenv("SHORTLINK_META_DB"); value != "" {
+		return value
+	}
+	return filepath.Join(filepath.Dir(shortlinkDBPath()), "shortlink-meta.json")
+}
@@ -1,20 +1,44 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/json"
-	"math/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
 type shortlinkStore struct {
-	mu     sync.Mutex
+	// mu is held for reading by lookups, which run side by side, and for
+	// writing only while the maps change; the store is written to disk off
+	// it, by flushShortlinks.
+	mu     sync.RWMutex
 	loaded bool
+	// loadMu is held through the first load, so requests racing to it
+	// wait for one read of the file rather than each quarantining or
+	// migrating it.
+	loadMu sync.Mutex
 	byCode map[string]string
 	byPath map[string]string
+	// aliases maps the codes of a path other than its canonical one, as
+	// found on load, to the canonical code. They stay in byCode, so they
+	// keep resolving.
+	aliases map[string]string
+	// folded maps the foldShortCode of each code to the code, or to "" when
+	// several codes fold alike and only their exact spelling resolves.
+	folded map[string]string
 }
 
 var shortlinks = shortlinkStore{
@@ -22,6 +46,22 @@ var shortlinks = shortlinkStore{
 	byPath: map[string]string{},
 }
 
+// shortlinkFlush holds the changes of the store not yet written: dirty is
+// set when it changed since it was last written to path, SHORTLINK_DB at
+// the change, and timer writes it at due. Its lock is taken after any
+// other, so a click marks the store changed without shortlinks.mu.
+var shortlinkFlush struct {
+	sync.Mutex
+	dirty bool
+	path  string
+	timer *time.Timer
+	due   time.Time
+}
+
+// shortlinkWrites serializes the writes of the store, so a snapshot never
+// replaces a newer one on disk.
+var shortlinkWrites sync.Mutex
+
 var shortlinkLimiter = &rateLimiter{
 	hits:   map[string][]time.Time{},
 	window: shortlinkRateWindow,
@@ -32,18 +72,8 @@ func shortlinkResponse(code, path string) ShortLinkResponse {
 	base := strings.TrimRight(publicBaseURL(), "/")
 	shortURL := base + "/s/" + code
 
-	// New format: path starts with "/" (full path with occasion/query)
-	// Old format: just the message
-	var destination string
-	var cleanPath string
-	if strings.HasPrefix(path, "/") {
-		destination = base + path
-		cleanPath = strings.TrimPrefix(path, "/")
-	} else {
-		cleanPath = strings.TrimPrefix(strings.TrimSpace(path), "/")
-		destPath := encodePathSegment(cleanPath)
-		destination = base + "/" + destPath
-	}
+	destination := base + path
+	cleanPath := strings.TrimPrefix(path, "/")
 
 	return ShortLinkResponse{
 		Code:        code,
@@ -53,54 +83,853 @@ func shortlinkResponse(code, path string) ShortLinkResponse {
 	}
 }
 
-func ensureShortlinksLoaded() error {
+var errNoFreeCode = fmt.Errorf("no free shortlink code")
+
+// createShortlink returns the code for fullPath, allocating a new one for
+// creator when the path has none yet. created reports whether it is new.
+func createShortlink(fullPath string, creator ShortlinkCreator) (code string, created bool, err error) {
 	shortlinks.mu.Lock()
-	if shortlinks.loaded {
+	if code, ok := shortlinks.byPath[fullPath]; ok {
 		shortlinks.mu.Unlock()
-		return nil
+		return code, false, nil
+	}
+
+	code, err = freeCodeLocked()
+	if err != nil {
+		shortlinks.mu.Unlock()
+		return "", false, err
 	}
+	shortlinks.byCode[code] = fullPath
+	shortlinks.byPath[fullPath] = code
+	foldShortCodeLocked(code)
+	setShortlinkMetaLocked(code, shortlinkMeta{CreatedAt: time.Now().UTC(), ShortlinkCreator: creator})
+	schedulePersistShortlinks()
 	shortlinks.mu.Unlock()
 
-	path := shortlinkDBPath()
-	data, err := os.ReadFile(path)
+	// Off the lock, so lookups never wait on the webhooks
+	notifyShortlinkCreated(code, fullPath)
+	return code, true, nil
+}
+
+// createExpiringShortlink allocates a new code for fullPath that stops
+// resolving at expiresAt. Expiring codes are never reused, nor indexed by
+// path, so a later permanent link of the same greeting gets its own code.
+func createExpiringShortlink(fullPath string, expiresAt time.Time, creator ShortlinkCreator) (string, error) {
+	shortlinks.mu.Lock()
+	code, err := freeCodeLocked()
 	if err != nil {
-		if os.IsNotExist(err) {
-			shortlinks.mu.Lock()
-			shortlinks.loaded = true
-			shortlinks.mu.Unlock()
-			return nil
+		shortlinks.mu.Unlock()
+		return "", err
+	}
+	// The expiry is in the link's record, so it is never written without it
+	setShortlinkMetaLocked(code, shortlinkMeta{CreatedAt: time.Now().UTC(), ExpiresAt: &expiresAt, ShortlinkCreator: creator})
+	shortlinks.byCode[code] = fullPath
+	foldShortCodeLocked(code)
+	schedulePersistShortlinks()
+	shortlinks.mu.Unlock()
+
+	notifyShortlinkCreated(code, fullPath)
+	return code, nil
+}
+
+// freeCodeLocked returns a random code that no link uses, even spelled in
+// another case.
+func freeCodeLocked() (code string, err error) {
+	for i := 0; i < 10; i++ {
+		if code, err = newShortCode(); err != nil {
+			return "", err
 		}
-		return err
+		if !shortCodeTakenLocked(code) {
+			return code, nil
+		}
+	}
+	return "", errNoFreeCode
+}
+
+func shortCodeTakenLocked(code string) bool {
+	_, exists := shortlinks.byCode[code]
+	_, folds := shortlinks.folded[foldShortCode(code)]
+	return exists || folds || isReservedShortCode(code)
+}
+
+// foldShortCode is the spelling of code that codes read out loud share: in
+// lowercase, with the digit 0 as the letter o, which base58 codes never
+// have.
+func foldShortCode(code string) string {
+	return strings.ReplaceAll(strings.ToLower(code), "0", "o")
+}
+
+// foldShortCodeLocked adds code to the folded index.
+func foldShortCodeLocked(code string) {
+	if shortlinks.folded == nil {
+		shortlinks.folded = map[string]string{}
+	}
+	key := foldShortCode(code)
+	if other, ok := shortlinks.folded[key]; ok && other != code {
+		shortlinks.folded[key] = ""
+		return
+	}
+	shortlinks.folded[key] = code
+}
+
+// canonicalShortCode returns the code that code, typed in any case, stands
+// for, when exactly one link's code folds like it.
+func canonicalShortCode(code string) (string, bool) {
+	shortlinks.mu.RLock()
+	defer shortlinks.mu.RUnlock()
+	canonical := shortlinks.folded[foldShortCode(code)]
+	return canonical, canonical != ""
+}
+
+// futureShortCodes are reserved for routes to come.
+var futureShortCodes = []string{
+	"about", "ajuda", "assets", "docs", "healthz", "help", "login", "logout",
+	"privacidade", "sobre", "static", "stats",
+}
+
+var (
+	reservedShortCodesOnce sync.Once
+	reservedShortCodes     map[string]bool
+)
+
+// isReservedShortCode reports whether code folds like a word no new code may
+// be: the first segment, without extension, of a route of the mux or of
+// handlePage, a locale prefix, or one of futureShortCodes. Links stored
+// under one before it was reserved keep resolving.
+func isReservedShortCode(code string) bool {
+	reservedShortCodesOnce.Do(loadReservedShortCodes)
+	return reservedShortCodes[foldShortCode(code)]
+}
+
+func loadReservedShortCodes() {
+	words := append(slices.Clone(futureShortCodes), routeWords()...)
+	for code := range locales {
+		words = append(words, code)
+	}
+	reservedShortCodes = map[string]bool{}
+	for _, word := range words {
+		if word != "" {
+			reservedShortCodes[foldShortCode(word)] = true
+		}
+	}
+}
+
+// purgeExpiredShortlinks removes the codes that expired longer than
+// shortlinkExpiredGrace before now, returning how many it removed. Until
+// then they answer the expired page rather than an unknown code's 404.
+func purgeExpiredShortlinks(now time.Time) (int, error) {
+	if err := ensureShortlinksLoaded(); err != nil {
+		return 0, err
+	}
+	var purged []string
+	for code, expiresAt := range expiringShortlinks() {
+		if now.Sub(expiresAt) >= shortlinkExpiredGrace {
+			purged = append(purged, code)
+		}
+	}
+	removeShortlinks(purged)
+	return len(purged), nil
+}
+
+// purgeUnusedShortlinks removes the codes last opened, or created when
+// never opened, longer than unused before now, returning how many it
+// removed. Links of unknown age that were never opened are kept.
+func purgeUnusedShortlinks(now time.Time, unused time.Duration) (int, error) {
+	if err := ensureShortlinksLoaded(); err != nil {
+		return 0, err
+	}
+	lastUsed := shortlinkCreationTimes()
+	for code, at := range shortlinkLastClicks() {
+		if at.After(lastUsed[code]) {
+			lastUsed[code] = at
+		}
+	}
+
+	var purged []string
+	shortlinks.mu.RLock()
+	for code := range shortlinks.byCode {
+		if at, ok := lastUsed[code]; ok && now.Sub(at) >= unused {
+			purged = append(purged, code)
+		}
+	}
+	shortlinks.mu.RUnlock()
+	removeShortlinks(purged)
+	return len(purged), nil
+}
+
+// removeShortlinks deletes codes from the stores, with their metadata and
+// counts.
+func removeShortlinks(codes []string) {
+	if len(codes) == 0 {
+		return
+	}
+	shortlinks.mu.Lock()
+	for _, code := range codes {
+		if path, ok := shortlinks.byCode[code]; ok && shortlinks.byPath[path] == code {
+			delete(shortlinks.byPath, path)
+		}
+		delete(shortlinks.byCode, code)
+		delete(shortlinks.aliases, code)
+		// An ambiguous spelling stays so: the codes left keep their own
+		if key := foldShortCode(code); shortlinks.folded[key] == code {
+			delete(shortlinks.folded, key)
+		}
+	}
+	schedulePersistShortlinks()
+	shortlinks.mu.Unlock()
+	deleteShortlinkMetas(codes)
+	deleteShortlinkStats(codes)
+}
+
+// shortlinkUnusedAfter is SHORTLINK_UNUSED_DAYS, after which links nobody
+// opened are removed; 0, the default, keeps them. Redirects served by a CDN
+// are not counted, so fewer than minShortlinkUnusedDays is ignored too.
+func shortlinkUnusedAfter() time.Duration {
+	if value := os.Getenv("SHORTLINK_UNUSED_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil && days >= minShortlinkUnusedDays {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return 0
+}
+
+func startShortlinkSweeper() {
+	go func() {
+		ticker := time.NewTicker(shortlinkSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			sweepShortlinks(now)
+		}
+	}()
+}
+
+// sweepShortlinks purges the expired links and, when SHORTLINK_UNUSED_DAYS
+// is set, the unused ones, and rewrites the store.
+func sweepShortlinks(now time.Time) {
+	if purged, err := purgeExpiredShortlinks(now); err != nil {
+		slog.Error("shortlink sweep failed", "error", err)
+	} else if purged > 0 {
+		slog.Info("expired shortlinks purged", "count", purged)
+	}
+	unused := shortlinkUnusedAfter()
+	if unused == 0 {
+		return
+	}
+	if purged, err := purgeUnusedShortlinks(now, unused); err != nil {
+		slog.Error("unused shortlink sweep failed", "error", err)
+	} else if purged > 0 {
+		slog.Info("unused shortlinks purged", "count", purged)
+	}
+}
+
+func ensureShortlinksLoaded() error {
+	shortlinks.mu.RLock()
+	if shortlinks.loaded {
+		shortlinks.mu.RUnlock()
+		return nil
+	}
+	shortlinks.mu.RUnlock()
+
+	shortlinks.loadMu.Lock()
+	defer shortlinks.loadMu.Unlock()
+	shortlinks.mu.RLock()
+	loaded := shortlinks.loaded
+	shortlinks.mu.RUnlock()
+	if loaded {
+		return nil
 	}
 
-	var entries map[string]string
-	if err := json.Unmarshal(data, &entries); err != nil {
+	data, version, err := loadShortlinkStore(shortlinkDBPath())
+	if err != nil {
 		return err
 	}
 
+	entries := data.paths
+	migrated := migrateLegacyShortlinks(entries)
+	// Expiring codes are left out of the path index, so they are never
+	// handed out again
+	expiring := expiryTimes(data.metas)
+	permanent := make(map[string]string, len(entries))
+	for code, path := range entries {
+		if _, ok := expiring[code]; !ok {
+			permanent[code] = path
+		}
+	}
+	byPath, duplicates := indexShortlinks(permanent)
+	aliases := map[string]string{}
+	for _, dup := range duplicates {
+		for _, alias := range dup.Aliases {
+			aliases[alias] = dup.Canonical
+		}
+	}
+
 	shortlinks.mu.Lock()
 	defer shortlinks.mu.Unlock()
-	if !shortlinks.loaded {
-		shortlinks.byCode = entries
-		shortlinks.byPath = make(map[string]string)
-		for code, path := range entries {
-			shortlinks.byPath[path] = code
-		}
-		shortlinks.loaded = true
+	if migrated > 0 {
+		slog.Info("legacy shortlinks migrated to full paths", "count", migrated)
+	}
+	if version < shortlinkStoreVersion {
+		slog.Info("shortlink store upgraded", "from", version, "to", shortlinkStoreVersion, "count", len(entries))
+	}
+	// Written by the flush, like any change, rather than under the lock
+	if migrated > 0 || version < shortlinkStoreVersion {
+		schedulePersistShortlinks()
 	}
+	for _, dup := range duplicates {
+		slog.Warn("shortlink path has several codes", "path", dup.Path, "canonical", dup.Canonical, "aliases", dup.Aliases)
+	}
+	if len(duplicates) > 0 {
+		slog.Warn("shortlink store has duplicate paths", "paths", len(duplicates), "aliases", len(aliases))
+	}
+	shortlinks.byCode = entries
+	shortlinks.byPath = byPath
+	shortlinks.aliases = aliases
+	shortlinks.folded = map[string]string{}
+	for code := range entries {
+		foldShortCodeLocked(code)
+	}
+	shortlinkMetas.mu.Lock()
+	shortlinkMetas.records = data.metas
+	shortlinkMetas.mu.Unlock()
+	shortlinkClicks.mu.Lock()
+	shortlinkClicks.counts = data.stats
+	shortlinkClicks.mu.Unlock()
+	shortlinks.loaded = true
 	return nil
 }
 
-func persistShortlinksLocked() error {
+// migrateLegacyShortlinks rewrites, in place, the records of the old format
+// that stored only the message ("João") into the full path the redirect used
+// to build for them ("/Jo%C3%A3o"). It returns how many it rewrote.
+func migrateLegacyShortlinks(entries map[string]string) int {
+	migrated := 0
+	for code, path := range entries {
+		if strings.HasPrefix(path, "/") {
+			continue
+		}
+		entries[code] = "/" + encodePathSegment(path)
+		migrated++
+	}
+	return migrated
+}
+
+// runShortlinkMigration is the migrate-shortlinks command: it migrates the
+// legacy records of the store, on disk or in the bucket, and the store to the current format,
+// without loading it into the server.
+func runShortlinkMigration(out io.Writer) error {
 	path := shortlinkDBPath()
+	data, version, err := loadShortlinkStore(path)
+	if err != nil {
+		return err
+	}
+	migrated := migrateLegacyShortlinks(data.paths)
+	if migrated > 0 || version < shortlinkStoreVersion {
+		if err := writeShortlinkStore(path, data); err != nil {
+			return err
+		}
+		recordAudit(AuditEntry{Actor: "cli", Action: "shortlinks.migrate", Target: shortlinkStoreName(path),
+			After: auditState(map[string]int{"migrated": migrated, "from_version": version, "to_version": shortlinkStoreVersion})})
+	}
+	fmt.Fprintf(out, "%s: %d of %d shortlinks migrated\n", shortlinkStoreName(path), migrated, len(data.paths))
+	if version < shortlinkStoreVersion {
+		fmt.Fprintf(out, "%s: store upgraded from version %d to %d\n", shortlinkStoreName(path), version, shortlinkStoreVersion)
+	}
+	return nil
+}
+
+// shortlinkDuplicate is a path that more than one code maps to.
+type shortlinkDuplicate struct {
+	Path      string
+	Canonical string
+	Aliases   []string
+}
+
+// indexShortlinks builds the path index of entries. When several codes map
+// to a path, the lexicographically smallest is its canonical code, so the
+// choice does not depend on map or file order; the others are reported as
+// its aliases, sorted.
+func indexShortlinks(entries map[string]string) (map[string]string, []shortlinkDuplicate) {
+	codesByPath := make(map[string][]string, len(entries))
+	for code, path := range entries {
+		codesByPath[path] = append(codesByPath[path], code)
+	}
+	byPath := make(map[string]string, len(codesByPath))
+	var duplicates []shortlinkDuplicate
+	for path, codes := range codesByPath {
+		sort.Strings(codes)
+		byPath[path] = codes[0]
+		if len(codes) > 1 {
+			duplicates = append(duplicates, shortlinkDuplicate{Path: path, Canonical: codes[0], Aliases: codes[1:]})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Path < duplicates[j].Path })
+	return byPath, duplicates
+}
+
+// storedShortlink is a record of the store: a link with what is kept
+// about it and its clicks. The theme and occasion are what the path says,
+// for whoever reads the file, and are not read back.
+type storedShortlink struct {
+	Code           string         `json:"code"`
+	Path           string         `json:"path"`
+	Theme          string         `json:"theme,omitempty"`
+	Occasion       string         `json:"occasion,omitempty"`
+	CreatedAt      *time.Time     `json:"created_at,omitempty"`
+	ExpiresAt      *time.Time     `json:"expires_at,omitempty"`
+	StatsToken     string         `json:"stats_token,omitempty"` // hash of the creator's token
+	Hits           int            `json:"hits,omitempty"`
+	LastAccessedAt *time.Time     `json:"last_accessed_at,omitempty"`
+	Referrers      map[string]int `json:"referrers,omitempty"`
+	ShortlinkCreator
+}
+
+// shortlinkStoreFile is the store on disk from version 2 on. Version 1 is
+// the bare {"code": "path"} object; version 2 had the metadata and counts
+// in SHORTLINK_META_DB and SHORTLINK_STATS_DB, and only copies of some of
+// them in its records. Both are still read, and rewritten in the current
+// version on load.
+type shortlinkStoreFile struct {
+	Version    int               `json:"version"`
+	Shortlinks []storedShortlink `json:"shortlinks"`
+}
+
+// shortlinkData is the content of the store: the paths by code and, for
+// the codes that have them, their metadata and counts.
+type shortlinkData struct {
+	paths map[string]string
+	metas map[string]shortlinkMeta
+	stats map[string]*ShortlinkStats
+}
+
+func newShortlinkData() shortlinkData {
+	return shortlinkData{paths: map[string]string{}, metas: map[string]shortlinkMeta{}, stats: map[string]*ShortlinkStats{}}
+}
+
+// add puts the link of record in d.
+func (d shortlinkData) add(record storedShortlink) {
+	d.paths[record.Code] = record.Path
+	meta := shortlinkMeta{ExpiresAt: record.ExpiresAt, StatsToken: record.StatsToken, ShortlinkCreator: record.ShortlinkCreator}
+	if record.CreatedAt != nil {
+		meta.CreatedAt = *record.CreatedAt
+	}
+	if meta != (shortlinkMeta{}) {
+		d.metas[record.Code] = meta
+	}
+	if record.Hits > 0 || record.LastAccessedAt != nil {
+		referrers := record.Referrers
+		if referrers == nil {
+			referrers = map[string]int{}
+		}
+		d.stats[record.Code] = &ShortlinkStats{Code: record.Code, Clicks: record.Hits, LastAccessedAt: record.LastAccessedAt, Referrers: referrers}
+	}
+}
+
+// record is the record of code, which d has.
+func (d shortlinkData) record(code string) storedShortlink {
+	path := d.paths[code]
+	pathOnly, rawQuery, _ := strings.Cut(path, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	occasion, _ := parseOccasionFromPath(pathOnly)
+	record := storedShortlink{Code: code, Path: path, Theme: query.Get("theme"), Occasion: occasion.Prefix}
+	if meta, ok := d.metas[code]; ok {
+		if !meta.CreatedAt.IsZero() {
+			createdAt := meta.CreatedAt
+			record.CreatedAt = &createdAt
+		}
+		record.ExpiresAt, record.StatsToken, record.ShortlinkCreator = meta.ExpiresAt, meta.StatsToken, meta.ShortlinkCreator
+	}
+	if stats := d.stats[code]; stats != nil {
+		record.Hits, record.LastAccessedAt = stats.Clicks, stats.LastAccessedAt
+		if len(stats.Referrers) > 0 {
+			record.Referrers = stats.Referrers
+		}
+	}
+	return record
+}
+
+// clone copies d, so it is not changed with the original.
+func (d shortlinkData) clone() shortlinkData {
+	copied := shortlinkData{paths: maps.Clone(d.paths), metas: maps.Clone(d.metas), stats: make(map[string]*ShortlinkStats, len(d.stats))}
+	for code, stats := range d.stats {
+		counted := copyShortlinkStats(stats)
+		copied.stats[code] = &counted
+	}
+	return copied
+}
+
+// storeFile is d as the current version of the store, by code.
+func (d shortlinkData) storeFile() shortlinkStoreFile {
+	codes := make([]string, 0, len(d.paths))
+	for code := range d.paths {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	file := shortlinkStoreFile{Version: shortlinkStoreVersion, Shortlinks: make([]storedShortlink, 0, len(codes))}
+	for _, code := range codes {
+		file.Shortlinks = append(file.Shortlinks, d.record(code))
+	}
+	return file
+}
+
+// currentShortlinkData copies the stores in memory, for a write. The
+// metadata of a new link is kept under the same hold of shortlinks.mu that
+// adds it, so a link copied here has its metadata too.
+func currentShortlinkData() shortlinkData {
+	var d shortlinkData
+	shortlinks.mu.RLock()
+	d.paths = maps.Clone(shortlinks.byCode)
+	shortlinks.mu.RUnlock()
+	shortlinkMetas.mu.Lock()
+	d.metas = maps.Clone(shortlinkMetas.records)
+	shortlinkMetas.mu.Unlock()
+	shortlinkClicks.mu.Lock()
+	d.stats = make(map[string]*ShortlinkStats, len(shortlinkClicks.counts))
+	for code, stats := range shortlinkClicks.counts {
+		copied := copyShortlinkStats(stats)
+		d.stats[code] = &copied
+	}
+	shortlinkClicks.mu.Unlock()
+	return d
+}
+
+// readShortlinkSideStores adds to d, read from a store before version 3,
+// what SHORTLINK_META_DB and SHORTLINK_STATS_DB have about its codes. They
+// are authoritative over the copies of version 2, and missing ones are
+// fine: the store then has only what its records say.
+func readShortlinkSideStores(d shortlinkData) error {
+	var metas map[string]shortlinkMeta
+	if err := readShortlinkSideStore(shortlinkMetaDBPath(), &metas); err != nil {
+		return err
+	}
+	for code, meta := range metas {
+		if _, ok := d.paths[code]; ok {
+			d.metas[code] = meta
+		}
+	}
+	var counts map[string]*ShortlinkStats
+	if err := readShortlinkSideStore(shortlinkStatsDBPath(), &counts); err != nil {
+		return err
+	}
+	for code, stats := range counts {
+		if _, ok := d.paths[code]; !ok || stats == nil {
+			continue
+		}
+		if stats.Referrers == nil {
+			stats.Referrers = map[string]int{}
+		}
+		stats.Code = code
+		d.stats[code] = stats
+	}
+	return nil
+}
+
+func readShortlinkSideStore(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || err == nil && len(data) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// errShortlinkStoreTooNew is a store written by a newer version of the
+// server, which is left as it is rather than read without its new fields.
+var errShortlinkStoreTooNew = errors.New("shortlink store is newer than this server")
+
+// loadShortlinkFile reads the store at path, returning its content and the
+// version of its format. Invalid records are moved to the quarantine
+// file rather than failing the load. When the file is missing or cannot be
+// parsed at all, the backup writeShortlinkFile keeps is used instead, and
+// an unparsable file is set aside so the next write does not replace that
+// backup with it.
+func loadShortlinkFile(path string) (shortlinkData, int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		backup, backupErr := os.ReadFile(shortlinkBackupPath(path))
+		if os.IsNotExist(backupErr) {
+			return newShortlinkData(), shortlinkStoreVersion, nil
+		}
+		if backupErr != nil {
+			return shortlinkData{}, 0, backupErr
+		}
+		slog.Warn("shortlink store missing, using backup", "path", path)
+		return acceptShortlinkRecords(path, backup)
+	}
+	if err != nil {
+		return shortlinkData{}, 0, err
+	}
+
+	entries, version, err := acceptShortlinkRecords(path, data)
+	if err == nil || errors.Is(err, errShortlinkStoreTooNew) {
+		return entries, version, err
+	}
+	backup, backupErr := os.ReadFile(shortlinkBackupPath(path))
+	if backupErr != nil {
+		return shortlinkData{}, 0, err
+	}
+	entries, version, backupErr = acceptShortlinkRecords(path, backup)
+	if backupErr != nil {
+		return shortlinkData{}, 0, err
+	}
+	if readOnly() {
+		slog.Error("shortlink store unreadable, using backup", "error", err, "entries", len(entries.paths))
+		return entries, version, nil
+	}
+	corrupt := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+	if renameErr := os.Rename(path, corrupt); renameErr != nil {
+		return shortlinkData{}, 0, renameErr
+	}
+	slog.Error("shortlink store unreadable, using backup", "error", err, "moved_to", corrupt, "entries", len(entries.paths))
+	return entries, version, nil
+}
+
+// acceptShortlinkRecords parses a store read from path, quarantining the
+// records it cannot use.
+func acceptShortlinkRecords(path string, data []byte) (shortlinkData, int, error) {
+	entries, version, bad, err := parseShortlinkRecords(data)
+	if err != nil {
+		return shortlinkData{}, 0, err
+	}
+	if len(bad) > 0 {
+		if err := quarantineShortlinks(path, bad); err != nil {
+			return shortlinkData{}, 0, err
+		}
+		slog.Warn("shortlink records quarantined", "count", len(bad), "file", shortlinkQuarantinePath(path))
+	}
+	return entries, version, nil
+}
+
+// badShortlink is a store record set aside by parseShortlinkRecords.
+type badShortlink struct {
+	Code   string          `json:"code"`
+	Value  json.RawMessage `json:"value"`
+	Reason string          `json:"reason"`
+	At     time.Time       `json:"at"`
+}
+
+// parseShortlinkRecords parses a store of any version, returning its valid
+// records, its version and, separately, the records with an empty or
+// malformed code or path and the repeats of a code, of which the first is
+// kept. It fails only when data is not a store at all.
+func parseShortlinkRecords(data []byte) (shortlinkData, int, []badShortlink, error) {
+	// A version 1 store has no "version", or a code of that name with a
+	// path, which is not a number
+	var versioned struct {
+		Version    int               `json:"version"`
+		Shortlinks []json.RawMessage `json:"shortlinks"`
+	}
+	if json.Unmarshal(data, &versioned) != nil || versioned.Version == 0 {
+		entries, bad, err := parseLegacyShortlinkRecords(data)
+		d := newShortlinkData()
+		if entries != nil {
+			d.paths = entries
+		}
+		return d, 1, bad, err
+	}
+	if versioned.Version > shortlinkStoreVersion {
+		return shortlinkData{}, 0, nil, fmt.Errorf("%w: version %d", errShortlinkStoreTooNew, versioned.Version)
+	}
+
+	d := newShortlinkData()
+	var bad []badShortlink
+	for _, raw := range versioned.Shortlinks {
+		var record storedShortlink
+		err := json.Unmarshal(raw, &record)
+		if reason := shortlinkRecordProblem(record.Code, record.Path, err, d.paths); reason != "" {
+			bad = append(bad, badShortlink{Code: record.Code, Value: raw, Reason: reason, At: time.Now().UTC()})
+			continue
+		}
+		d.add(record)
+	}
+	return d, versioned.Version, bad, nil
+}
+
+// parseLegacyShortlinkRecords parses the {"code": "path"} store of version
+// 1. It fails only when data is not a JSON object.
+func parseLegacyShortlinkRecords(data []byte) (map[string]string, []badShortlink, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, err
+	} else if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("shortlink store is not a JSON object")
+	}
+	entries := map[string]string{}
+	var bad []badShortlink
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		code, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+		var path string
+		err = json.Unmarshal(raw, &path)
+		if reason := shortlinkRecordProblem(code, path, err, entries); reason != "" {
+			bad = append(bad, badShortlink{Code: code, Value: raw, Reason: reason, At: time.Now().UTC()})
+			continue
+		}
+		entries[code] = path
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, fmt.Errorf("shortlink store has trailing data")
+	}
+	return entries, bad, nil
+}
+
+// shortlinkRecordProblem is why a record of code and path, decoded with
+// err, cannot join entries, or "" when it can.
+func shortlinkRecordProblem(code, path string, err error, entries map[string]string) string {
+	switch {
+	case err != nil:
+		return "malformed path"
+	case strings.TrimSpace(path) == "":
+		return "empty path"
+	case code == "" || strings.ContainsAny(code, "/?# \t\n"):
+		return "malformed code"
+	case entries[code] != "":
+		return "duplicate code"
+	}
+	return ""
+}
+
+// quarantineShortlinks appends bad records to the quarantine file, one
+// JSON object per line.
+func quarantineShortlinks(path string, bad []badShortlink) error {
+	// Left in the store for a server that may write to move out
+	if readOnly() {
+		return nil
+	}
+	file, err := os.OpenFile(shortlinkQuarantinePath(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	enc := json.NewEncoder(file)
+	for _, record := range bad {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schedulePersistShortlinks marks the store as changed. The changes of the
+// next shortlinkPersistDelay are written together, off the lock the
+// requests wait on.
+func schedulePersistShortlinks() {
+	markShortlinksDirty(shortlinkPersistDelay)
+}
+
+// schedulePersistShortlinkClicks marks the store as changed by a click,
+// which waits shortlinkClickPersistDelay, or the next change of the links,
+// to be written: counts lost to a crash cost less than rewriting the store
+// on every redirect.
+func schedulePersistShortlinkClicks() {
+	markShortlinksDirty(shortlinkClickPersistDelay)
+}
+
+func markShortlinksDirty(delay time.Duration) {
+	if readOnly() {
+		return
+	}
+	shortlinkFlush.Lock()
+	defer shortlinkFlush.Unlock()
+	shortlinkFlush.dirty = true
+	shortlinkFlush.path = shortlinkDBPath()
+	scheduleShortlinkFlushLocked(delay)
+}
+
+// scheduleShortlinkFlushLocked has the store written after delay, unless a
+// flush is due sooner.
+func scheduleShortlinkFlushLocked(delay time.Duration) {
+	due := time.Now().Add(delay)
+	if shortlinkFlush.timer != nil {
+		// A timer that already fired has its flush on the way
+		if !due.Before(shortlinkFlush.due) || !shortlinkFlush.timer.Stop() {
+			return
+		}
+	}
+	shortlinkFlush.due = due
+	shortlinkFlush.timer = time.AfterFunc(delay, func() {
+		if err := flushShortlinks(); err != nil {
+			slog.Error("shortlink store write failed", "error", err)
+		}
+	})
+}
+
+// flushShortlinks writes the store to SHORTLINK_DB, or the bucket, if it
+// changed since it was last written. A failed write is retried after
+// shortlinkPersistRetry.
+func flushShortlinks() error {
+	shortlinkWrites.Lock()
+	defer shortlinkWrites.Unlock()
+	shortlinkFlush.Lock()
+	shortlinkFlush.timer = nil
+	if !shortlinkFlush.dirty {
+		shortlinkFlush.Unlock()
+		return nil
+	}
+	path := shortlinkFlush.path
+	shortlinkFlush.dirty = false
+	shortlinkFlush.Unlock()
+	// Copied alongside lookups: a change in between marks the store dirty
+	// again and is written once more, at worst
+	entries := currentShortlinkData()
+
+	err := writeShortlinkStore(path, entries)
+	if bucket, _ := shortlinkBucket(); bucket != nil && errors.Is(err, errShortlinkStoreChanged) {
+		// Another server got its write in first: it is made again on top
+		if err = mergeShortlinkObject(bucket); err == nil {
+			err = writeShortlinkStore(path, currentShortlinkData())
+		}
+	}
+	if err != nil {
+		shortlinkFlush.Lock()
+		// Retried where it failed, unless a later change is pending
+		if !shortlinkFlush.dirty {
+			shortlinkFlush.dirty, shortlinkFlush.path = true, path
+		}
+		scheduleShortlinkFlushLocked(shortlinkPersistRetry)
+		shortlinkFlush.Unlock()
+	}
+	return err
+}
+
+// writeShortlinkFile writes entries as the store at path through a
+// temporary file, keeping the previous version as the backup
+// loadShortlinkFile falls back to.
+func writeShortlinkFile(path string, entries shortlinkData) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(shortlinks.byCode, "", "  ")
+	data, err := json.MarshalIndent(entries.storeFile(), "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0o644)
+	// Until the new file is in place, a missing store loads from the backup
+	if err := os.Rename(path, shortlinkBackupPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+func shortlinkBackupPath(path string) string {
+	return path + ".bak"
+}
+
+func shortlinkQuarantinePath(path string) string {
+	return path + ".quarantine"
 }
 
 func shortlinkDBPath() string {
@@ -110,11 +939,95 @@ func shortlinkDBPath() string {
 	return "data/shortlinks.json"
 }
 
-func generateCode(length int) string {
-	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = alphabet[rand.Intn(len(alphabet))]
+// newShortCode returns a random code of SHORTCODE_LENGTH characters of
+// SHORTCODE_ALPHABET.
+func newShortCode() (string, error) {
+	return generateCode(shortCodeLength(), shortCodeAlphabet())
+}
+
+// generateCode returns length characters of alphabet read from crypto/rand.
+// Bytes past the largest multiple of the alphabet's size are drawn again,
+// so every character is equally likely.
+func generateCode(length int, alphabet string) (string, error) {
+	limit := 256 - 256%len(alphabet)
+	code := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(code) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < length {
+				code = append(code, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// shortCodeLength is SHORTCODE_LENGTH, or shortCodeLen when it is unset
+// or invalid, which checkShortCodeConfig refuses at startup.
+func shortCodeLength() int {
+	n, err := parseShortCodeLength(os.Getenv("SHORTCODE_LENGTH"))
+	if err != nil {
+		return shortCodeLen
+	}
+	return n
+}
+
+// shortCodeAlphabet is SHORTCODE_ALPHABET, or defaultShortCodeAlphabet
+// when it is unset or invalid, which checkShortCodeConfig refuses at
+// startup.
+func shortCodeAlphabet() string {
+	alphabet, err := parseShortCodeAlphabet(os.Getenv("SHORTCODE_ALPHABET"))
+	if err != nil {
+		return defaultShortCodeAlphabet
+	}
+	return alphabet
+}
+
+// checkShortCodeConfig reports a SHORTCODE_LENGTH or SHORTCODE_ALPHABET
+// that is set but invalid, so the server does not start giving codes of
+// the defaults instead.
+func checkShortCodeConfig() error {
+	_, lengthErr := parseShortCodeLength(os.Getenv("SHORTCODE_LENGTH"))
+	_, alphabetErr := parseShortCodeAlphabet(os.Getenv("SHORTCODE_ALPHABET"))
+	return errors.Join(lengthErr, alphabetErr)
+}
+
+// parseShortCodeLength reads a code length between minShortCodeLen and
+// maxShortCodeLen; "" is shortCodeLen.
+func parseShortCodeLength(value string) (int, error) {
+	if value == "" {
+		return shortCodeLen, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minShortCodeLen || n > maxShortCodeLen {
+		return 0, fmt.Errorf("SHORTCODE_LENGTH %q: not a number from %d to %d", value, minShortCodeLen, maxShortCodeLen)
+	}
+	return n, nil
+}
+
+// parseShortCodeAlphabet reads an alphabet of at least two characters,
+// none repeated, all letters, digits, "-", "_" or "~" so codes need no
+// escaping in a URL; "" is defaultShortCodeAlphabet.
+func parseShortCodeAlphabet(value string) (string, error) {
+	if value == "" {
+		return defaultShortCodeAlphabet, nil
+	}
+	if len(value) < 2 {
+		return "", fmt.Errorf("SHORTCODE_ALPHABET %q: fewer than 2 characters", value)
+	}
+	seen := map[rune]bool{}
+	for _, r := range value {
+		urlSafe := 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("-_~", r)
+		if !urlSafe {
+			return "", fmt.Errorf("SHORTCODE_ALPHABET %q: %q is not a letter, digit, \"-\", \"_\" or \"~\"", value, r)
+		}
+		if seen[r] {
+			return "", fmt.Errorf("SHORTCODE_ALPHABET %q: %q repeated", value, r)
+		}
+		seen[r] = true
 	}
-	return string(b)
+	return value, nil
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShortlinkStats counts the redirects of a shortlink. Referrers maps the
+// host of the Referer header, "direct" without one and "other" past
+// maxShortlinkReferrers hosts, to its redirects.
+type ShortlinkStats struct {
+	Code           string         `json:"code"`
+	Clicks         int            `json:"clicks"`
+	LastAccessedAt *time.Time     `json:"last_accessed_at,omitempty"`
+	Referrers      map[string]int `json:"referrers"`
+}
+
+type shortlinkStatsStore struct {
+	mu     sync.Mutex
+	counts map[string]*ShortlinkStats
+}
+
+// shortlinkClicks is filled by ensureShortlinksLoaded and written with the
+// store's records.
+var shortlinkClicks = shortlinkStatsStore{
+	counts: map[string]*ShortlinkStats{},
+}
+
+// referrerHost names the source of a click for the referrer breakdown.
+func referrerHost(referer string) string {
+	if referer == "" {
+		return "direct"
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Hostname() == "" {
+		return "other"
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// recordShortlinkClick counts a redirect of code from referer at now.
+func recordShortlinkClick(code, referer string, now time.Time) {
+	shortlinkClicks.mu.Lock()
+	stats := shortlinkClicks.counts[code]
+	if stats == nil {
+		stats = &ShortlinkStats{Code: code, Referrers: map[string]int{}}
+		shortlinkClicks.counts[code] = stats
+	}
+	host := referrerHost(referer)
+	if _, known := stats.Referrers[host]; !known && len(stats.Referrers) >= maxShortlinkReferrers {
+		host = "other"
+	}
+	at := now.UTC()
+	stats.Clicks++
+	stats.LastAccessedAt = &at
+	stats.Referrers[host]++
+	shortlinkClicks.mu.Unlock()
+	schedulePersistShortlinkClicks()
+}
+
+// shortlinkStatsOf returns the counts of code, zero when it has none.
+func shortlinkStatsOf(code string) ShortlinkStats {
+	shortlinkClicks.mu.Lock()
+	defer shortlinkClicks.mu.Unlock()
+	if counted := shortlinkClicks.counts[code]; counted != nil {
+		return copyShortlinkStats(counted)
+	}
+	return ShortlinkStats{Code: code, Referrers: map[string]int{}}
+}
+
+func copyShortlinkStats(stats *ShortlinkStats) ShortlinkStats {
+	copied := *stats
+	copied.Referrers = make(map[string]int, len(stats.Referrers))
+	for host, n := range stats.Referrers {
+		copied.Referrers[host] = n
+	}
+	return copied
+}
+
+// shortlinkLastClicks maps the codes opened at least once to their last
+// click.
+func shortlinkLastClicks() map[string]time.Time {
+	shortlinkClicks.mu.Lock()
+	defer shortlinkClicks.mu.Unlock()
+	last := map[string]time.Time{}
+	for code, stats := range shortlinkClicks.counts {
+		if stats.LastAccessedAt != nil {
+			last[code] = *stats.LastAccessedAt
+		}
+	}
+	return last
+}
+
+// deleteShortlinkStats removes the counts of codes.
+func deleteShortlinkStats(codes []string) {
+	shortlinkClicks.mu.Lock()
+	defer shortlinkClicks.mu.Unlock()
+	for _, code := range codes {
+		delete(shortlinkClicks.counts, code)
+	}
+}
+
+// handleShortlinkStats serves GET /s/{code}/stats to the holder of the
+// link's stats token, returned when it was created, or of the admin token.
+// A wrong token looks like a missing link.
+func handleShortlinkStats(w http.ResponseWriter, r *http.Request, code string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || given == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="shortlink stats"`)
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := ensureShortlinksLoaded(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	shortlinks.mu.RLock()
+	_, exists := shortlinks.byCode[code]
+	shortlinks.mu.RUnlock()
+	meta, _ := shortlinkMetaOf(code)
+	admin := adminToken() != "" && subtle.ConstantTimeCompare([]byte(given), []byte(adminToken())) == 1
+	creator := meta.StatsToken != "" && subtle.ConstantTimeCompare([]byte(tokenHash(given)), []byte(meta.StatsToken)) == 1
+	if !exists || !admin && !creator {
+		writeAPIError(w, http.StatusNotFound, "not_found")
+		return
+	}
+
+	stats := shortlinkStatsOf(code)
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// shortlinkStatsDBPath defaults to shortlink-stats.json next to SHORTLINK_DB.
+// Stores from before version 3 kept the counts there.
+func shortlinkStatsDBPath() string {
+	if value := os.Getenv("SHORTLINK_STATS_DB"); value != "" {
+		return value
+	}
+	return filepath.Join(filepath.Dir(shortlinkDBPath()), "shortlink-stats.json")
+}
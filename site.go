@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SiteIdentity is the branding of a deployment, set under "site" in
+// CONFIG_FILE so white-label deployments don't need source edits.
+type SiteIdentity struct {
+	Domain          string `json:"domain"`
+	Name            string `json:"name"`
+	DefaultGreeting string `json:"default_greeting"`
+	FooterText      string `json:"footer_text"`
+}
+
+var defaultSite = SiteIdentity{
+	Domain:          "parabens.vc",
+	Name:            "parabens.vc",
+	DefaultGreeting: "Parabéns",
+}
+
+var customSite SiteIdentity
+
+// siteIdentity returns the configured identity, with unset fields taken
+// from the defaults (the name falls back to the domain).
+func siteIdentity() SiteIdentity {
+	configMu.RLock()
+	custom := customSite
+	configMu.RUnlock()
+
+	site := defaultSite
+	if custom.Domain != "" {
+		site.Domain = custom.Domain
+		site.Name = custom.Domain
+	}
+	if custom.Name != "" {
+		site.Name = custom.Name
+	}
+	if custom.DefaultGreeting != "" {
+		site.DefaultGreeting = custom.DefaultGreeting
+	}
+	site.FooterText = custom.FooterText
+	return site
+}
+
+// generalOccasion is the occasion of paths without a prefix, greeting with
+// the site's default greeting.
+func generalOccasion() Occasion {
+	occ := defaultOccasion
+	occ.Greeting = siteIdentity().DefaultGreeting
+	return occ
+}
+
+func validateSite(site *SiteIdentity) error {
+	site.Domain = strings.ToLower(strings.TrimSpace(site.Domain))
+	site.Name = strings.TrimSpace(site.Name)
+	site.DefaultGreeting = strings.TrimSpace(site.DefaultGreeting)
+	site.FooterText = strings.TrimSpace(site.FooterText)
+	for _, r := range site.Domain {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '.' {
+			return fmt.Errorf("invalid domain %q", site.Domain)
+		}
+	}
+	if strings.HasPrefix(site.Domain, ".") || strings.HasSuffix(site.Domain, ".") || strings.Contains(site.Domain, "..") {
+		return fmt.Errorf("invalid domain %q", site.Domain)
+	}
+	if utf8.RuneCountInString(site.Name) > maxSiteNameLen || utf8.RuneCountInString(site.DefaultGreeting) > maxSiteNameLen {
+		return fmt.Errorf("name and default_greeting are limited to %d characters", maxSiteNameLen)
+	}
+	if utf8.RuneCountInString(site.FooterText) > maxFooterTextLen {
+		return fmt.Errorf("footer_text is limited to %d characters", maxFooterTextLen)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// POST /api/integrations/slack is the request URL of a Slack slash command:
+// "/parabens @maria formatura" creates the shortlink of /formatura/Maria as
+// POST /s would and answers in the channel with the share text, which Slack
+// unfurls. Requests are verified with the app's SLACK_SIGNING_SECRET; the
+// endpoint is disabled without one.
+
+// SlackResponse is the message answering a slash command.
+type SlackResponse struct {
+	ResponseType string `json:"response_type"` // "in_channel" or "ephemeral"
+	Text         string `json:"text"`
+	UnfurlLinks  bool   `json:"unfurl_links,omitempty"`
+}
+
+// slackMention matches an escaped user mention, "<@U123|maria>".
+var slackMention = regexp.MustCompile(`^<@[A-Z0-9]+\|([^>]+)>$`)
+
+const (
+	slackUsage    = "Use `/parabens @nome [ocasião]`, como `/parabens @maria formatura`."
+	slackDeferred = "O link vai ficar pronto em alguns minutos. Use o comando de novo para compartilhá-lo."
+)
+
+func slackSigningSecret() string {
+	return os.Getenv("SLACK_SIGNING_SECRET")
+}
+
+// verifySlackSignature checks X-Slack-Signature, the HMAC-SHA256 of
+// "v0:timestamp:body", and that the timestamp is recent, so a captured
+// request cannot be replayed.
+func verifySlackSignature(header http.Header, body []byte, secret string, now time.Time) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > slackSignatureMaxAge || age < -slackSignatureMaxAge {
+		return false
+	}
+	given, ok := strings.CutPrefix(header.Get("X-Slack-Signature"), "v0=")
+	if !ok {
+		return false
+	}
+	signature, err := hex.DecodeString(given)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(signature, hmacSHA256([]byte(secret), "v0:"+timestamp+":"+string(body)))
+}
+
+// parseSlackCommand reads "name [occasion]" or "occasion name" into the
+// greeting path. The name may be a mention ("@maria", "<@U1|maria>");
+// lowercase handles are title-cased and their dots and underscores become
+// spaces.
+func parseSlackCommand(text string) (string, error) {
+	words := strings.Fields(text)
+	prefix := ""
+	if len(words) > 1 {
+		if occ, ok := lookupOccasion(words[len(words)-1]); ok {
+			prefix, words = occ.Prefix, words[:len(words)-1]
+		} else if occ, ok := lookupOccasion(words[0]); ok {
+			prefix, words = occ.Prefix, words[1:]
+		}
+	}
+	for i, word := range words {
+		if m := slackMention.FindStringSubmatch(word); m != nil {
+			word = m[1]
+		}
+		words[i] = strings.NewReplacer(".", " ", "_", " ").Replace(strings.TrimPrefix(word, "@"))
+	}
+	name, err := parseName(strings.Join(words, " "))
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", nil
+	}
+	if name == strings.ToLower(name) {
+		name = titleCaseName(name)
+	}
+	if prefix == "" {
+		return "/" + encodePathSegment(name), nil
+	}
+	return "/" + prefix + "/" + encodePathSegment(name), nil
+}
+
+// slackReply answers only the user who ran the command.
+func slackReply(w http.ResponseWriter, text string) {
+	writeJSON(w, http.StatusOK, SlackResponse{ResponseType: "ephemeral", Text: text})
+}
+
+func handleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	secret := slackSigningSecret()
+	if secret == "" {
+		writeAPIError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	body, err := readLimitedBody(r, maxSlackBodyBytes)
+	if err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+	if !verifySlackSignature(r.Header, body, secret, time.Now()) {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+
+	// Slack shows a non-200 answer as a bare failure, so errors are
+	// ephemeral messages
+	if !shortlinkLimiter.allow("slack:" + form.Get("team_id")) {
+		slackReply(w, apiErrorMessages["rate_limited"])
+		return
+	}
+	path, err := parseSlackCommand(form.Get("text"))
+	if err == errNameBlocked {
+		slackReply(w, apiErrorMessages["blocked_name"])
+		return
+	}
+	if err != nil || path == "" {
+		slackReply(w, slackUsage)
+		return
+	}
+	fullPath := normalizeGreetingPath(path)
+	if status, code := checkGreetingPath(fullPath); status != http.StatusOK {
+		slackReply(w, apiErrorMessages[code])
+		return
+	}
+	if !diskHealthy("data") {
+		slackReply(w, apiErrorMessages["storage_unavailable"])
+		return
+	}
+	if err := ensureShortlinksLoaded(); err != nil {
+		slackReply(w, apiErrorMessages["internal_error"])
+		return
+	}
+	// The request comes from Slack's servers; the user is the creator
+	creator := shortlinkCreatorOf(r, "slack:"+form.Get("team_id")+"/"+form.Get("user_id"))
+	if !checkSlackSpamScore(form.Get("team_id"), fullPath, creator) {
+		slackReply(w, slackDeferred)
+		return
+	}
+	code, _, err := createShortlink(fullPath, creator)
+	if err != nil {
+		slog.Error("slack shortlink failed", "path", fullPath, "error", err)
+		if err == errNoFreeCode {
+			slackReply(w, apiErrorMessages["no_free_code"])
+		} else {
+			slackReply(w, apiErrorMessages["internal_error"])
+		}
+		return
+	}
+	slog.Info("slack shortlink", "team", form.Get("team_id"), "user", form.Get("user_id"), "code", code)
+	links := shareLinks(fullPath, shortlinkResponse(code, fullPath).ShortURL)
+	writeJSON(w, http.StatusOK, SlackResponse{ResponseType: "in_channel", Text: links.Text, UnfurlLinks: true})
+}
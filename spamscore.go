@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Velocity scoring of anonymous shortlink creations. Each request gets a
+// 0-100 score from the creations of its IP in the last spamWindow, how many
+// recent creations carried the same message once names and numbers are
+// ignored, and how link-like the message is. A score of spamChallengeScore
+// asks for a proof of work first; spamDeferScore queues the shortlink and
+// creates it only after spamDeferDelay, so link farms cannot use it right
+// away. Scores are kept in memory as a histogram for /api/stats/spam.
+
+type spamCreation struct {
+	IP       string
+	Skeleton string
+	At       time.Time
+}
+
+type deferredShortlink struct {
+	Path      string
+	At        time.Time
+	ExpiresAt *time.Time // an expiring link's expiry, from the request
+	Creator   ShortlinkCreator
+}
+
+var spam = struct {
+	mu         sync.Mutex
+	recent     []spamCreation
+	deferred   []deferredShortlink
+	histogram  [11]int // scores 0-9, 10-19, …, 100
+	challenged int
+	deferCount int
+}{}
+
+var urlLikePattern = regexp.MustCompile(`(?i)https?|www\.|://|\.(com|net|org|info|biz|ru|xyz|top|io)\b`)
+
+// messageSkeleton reduces a message to its lowercase letters, so spam
+// varying a number or punctuation still matches.
+func messageSkeleton(message string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(message) {
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// spamScore scores a creation of message from ip without recording it.
+func spamScore(ip, message string, now time.Time) int {
+	skeleton := messageSkeleton(message)
+	spam.mu.Lock()
+	sameIP, sameMessage := 0, 0
+	for _, c := range spam.recent {
+		if now.Sub(c.At) > spamWindow {
+			continue
+		}
+		if c.IP == ip {
+			sameIP++
+		}
+		if skeleton != "" && c.Skeleton == skeleton {
+			sameMessage++
+		}
+	}
+	spam.mu.Unlock()
+
+	score := min(max(sameIP-spamBurstAllowance, 0)*10, 40)
+	switch {
+	case sameMessage >= 2:
+		score += 30
+	case sameMessage == 1:
+		score += 15
+	}
+	if urlLikePattern.MatchString(message) {
+		score += 30
+	}
+	letters, others := 0, 0
+	for _, r := range message {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsSpace(r):
+			letters++
+		default:
+			others++
+		}
+	}
+	if others > 0 && float64(others)/float64(letters+others) > 0.3 {
+		score += 20
+	}
+	return min(score, 100)
+}
+
+func recordSpamCreation(ip, message string, score int, now time.Time) {
+	spam.mu.Lock()
+	defer spam.mu.Unlock()
+	kept := spam.recent[:0]
+	for _, c := range spam.recent {
+		if now.Sub(c.At) <= spamWindow {
+			kept = append(kept, c)
+		}
+	}
+	spam.recent = append(kept, spamCreation{IP: ip, Skeleton: messageSkeleton(message), At: now})
+	spam.histogram[score/10]++
+}
+
+// checkSpamScore scores an anonymous creation of fullPath. It answers 428
+// pow_required with a proof-of-work challenge when the score calls for one
+// the request has not solved, and 202 when the shortlink is deferred; in both cases it
+// returns false and the caller stops.
+func checkSpamScore(w http.ResponseWriter, r *http.Request, fullPath string, expiresAt *time.Time, creator ShortlinkCreator, powSolved int) bool {
+	now := time.Now()
+	ip := clientIP(r)
+	message := spamMessage(fullPath)
+	score := spamScore(ip, message, now)
+
+	if score >= spamChallengeScore && powSolved < spamPowDifficulty {
+		difficulty := max(spamPowDifficulty, powDifficulty())
+		challenge, err := newPowChallenge(now, difficulty)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal_error")
+			return false
+		}
+		spam.mu.Lock()
+		spam.challenged++
+		spam.mu.Unlock()
+		writeJSON(w, http.StatusPreconditionRequired, PowRequiredResponse{
+			Error:        APIError{Code: "pow_required", Message: apiErrorMessages["pow_required"]},
+			PowChallenge: PowChallenge{Challenge: challenge, Difficulty: difficulty},
+		})
+		return false
+	}
+	recordSpamCreation(ip, message, score, now)
+	if score < spamDeferScore {
+		return true
+	}
+	deferShortlink(ip, fullPath, expiresAt, creator, score, now)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "deferred"})
+	return false
+}
+
+// checkSlackSpamScore scores a creation of fullPath by the Slack command,
+// counting the creations of the workspace rather than of Slack's servers.
+// Slack cannot solve a proof of work, so a score that calls for one defers
+// the shortlink as well. It reports whether the shortlink is created now.
+func checkSlackSpamScore(team, fullPath string, creator ShortlinkCreator) bool {
+	now := time.Now()
+	key := "slack:" + team
+	message := spamMessage(fullPath)
+	score := spamScore(key, message, now)
+	recordSpamCreation(key, message, score, now)
+	if score < spamChallengeScore {
+		return true
+	}
+	deferShortlink(key, fullPath, nil, creator, score, now)
+	return false
+}
+
+// spamMessage is the message of the greeting at fullPath, as scored.
+func spamMessage(fullPath string) string {
+	pathOnly, _, _ := strings.Cut(fullPath, "?")
+	_, rawMessage := parseOccasionFromPath(pathOnly)
+	return decodePath(rawMessage)
+}
+
+// deferShortlink queues the shortlink of fullPath, from ip, for
+// releaseDeferredShortlinks, unless maxDeferredShortlinks are waiting.
+func deferShortlink(ip, fullPath string, expiresAt *time.Time, creator ShortlinkCreator, score int, now time.Time) {
+	spam.mu.Lock()
+	queued := len(spam.deferred) < maxDeferredShortlinks
+	if queued {
+		spam.deferred = append(spam.deferred, deferredShortlink{Path: fullPath, At: now, ExpiresAt: expiresAt, Creator: creator})
+	}
+	spam.deferCount++
+	spam.mu.Unlock()
+	slog.Info("shortlink deferred", "score", score, "ip", ip, "path", fullPath, "queued", queued)
+}
+
+// releaseDeferredShortlinks creates the deferred shortlinks older than
+// spamDeferDelay, returning how many it created. The ones it fails to
+// create are queued again, to be retried on the next release.
+func releaseDeferredShortlinks(now time.Time) (int, error) {
+	spam.mu.Lock()
+	var due []deferredShortlink
+	kept := spam.deferred[:0]
+	for _, d := range spam.deferred {
+		if now.Sub(d.At) >= spamDeferDelay {
+			due = append(due, d)
+		} else {
+			kept = append(kept, d)
+		}
+	}
+	spam.deferred = kept
+	spam.mu.Unlock()
+
+	if len(due) == 0 {
+		return 0, nil
+	}
+	if err := ensureShortlinksLoaded(); err != nil {
+		requeueDeferredShortlinks(due)
+		return 0, err
+	}
+	created := 0
+	var failed []deferredShortlink
+	var errs []error
+	for _, d := range due {
+		if d.ExpiresAt != nil {
+			if _, err := createExpiringShortlink(d.Path, *d.ExpiresAt, d.Creator); err != nil {
+				failed, errs = append(failed, d), append(errs, err)
+				continue
+			}
+			created++
+		} else if _, isNew, err := createShortlink(d.Path, d.Creator); err != nil {
+			failed, errs = append(failed, d), append(errs, err)
+		} else if isNew {
+			created++
+		}
+	}
+	requeueDeferredShortlinks(failed)
+	return created, errors.Join(errs...)
+}
+
+// requeueDeferredShortlinks puts back at the head of the queue, in order,
+// deferred shortlinks a release failed to create.
+func requeueDeferredShortlinks(failed []deferredShortlink) {
+	if len(failed) == 0 {
+		return
+	}
+	spam.mu.Lock()
+	spam.deferred = append(failed, spam.deferred...)
+	spam.mu.Unlock()
+}
+
+func startDeferredShortlinkReleaser() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if n, err := releaseDeferredShortlinks(now); err != nil {
+				slog.Error("deferred shortlink release failed", "error", err)
+			} else if n > 0 {
+				slog.Info("deferred shortlinks released", "count", n)
+			}
+		}
+	}()
+}
+
+// SpamStats is served by /api/stats/spam.
+type SpamStats struct {
+	// Scores[i] counts creations scored 10*i to 10*i+9 (the last is 100)
+	Scores     [11]int `json:"scores"`
+	Challenged int     `json:"challenged"`
+	Deferred   int     `json:"deferred"`
+	Queued     int     `json:"queued"`
+}
+
+// handleSpamStats serves the admin endpoint GET /api/stats/spam: the score
+// distribution since the server started.
+func handleSpamStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	spam.mu.Lock()
+	stats := SpamStats{Scores: spam.histogram, Challenged: spam.challenged, Deferred: spam.deferCount, Queued: len(spam.deferred)}
+	spam.mu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, stats)
+}
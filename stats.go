@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// yearStats aggregates a year of page views without keeping any per-visit
+// data: the input of /retrospectiva.
+type yearStats struct {
+	Greetings int            `json:"greetings"` // greetings viewed for the first time
+	Views     int            `json:"views"`
+	Days      map[string]int `json:"days"`  // "2026-12-25" -> views
+	Names     map[string]int `json:"names"` // leading name -> views
+}
+
+type statsStore struct {
+	mu     sync.Mutex
+	loaded bool
+	years  map[string]*yearStats
+	// Hashes of the visitor and greeting pairs counted on seenDay, kept in
+	// memory only, so a visitor's repeated beacons count once a day
+	seenDay string
+	seen    map[[sha256.Size]byte]bool
+}
+
+var stats = statsStore{
+	years: map[string]*yearStats{},
+}
+
+// statsFlush writes the aggregates viewPersistDelay after a view, as
+// viewsFlush does the counts.
+var statsFlush = &storeFlush{name: "stats", delay: viewPersistDelay, write: func() error {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return persistStatsLocked()
+}}
+
+// recordStats adds a page view of path by visitor (the client's address)
+// to the aggregates; count is the greeting's view count after the view, so
+// 1 marks a new greeting. Only paths the page renders are counted, each
+// once a day per visitor, and no new visitor once maxStatsVisitors were
+// counted that day: a name on /retrospectiva takes that many visitors, not
+// that many beacons from one.
+func recordStats(path, visitor string, count int, now time.Time) error {
+	if status, _ := checkGreetingPath(path); status != http.StatusOK {
+		return nil
+	}
+	key, message := guestbookTarget(path)
+	if message == "" {
+		return nil
+	}
+	if err := ensureStatsLoaded(); err != nil {
+		return err
+	}
+	local := now.In(countdownZone)
+	day := local.Format("2006-01-02")
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.seenDay != day || stats.seen == nil {
+		stats.seenDay, stats.seen = day, map[[sha256.Size]byte]bool{}
+	}
+	seen := sha256.Sum256([]byte(visitor + "\x00" + key))
+	if stats.seen[seen] || len(stats.seen) >= maxStatsVisitors {
+		return nil
+	}
+	stats.seen[seen] = true
+	year := strconv.Itoa(local.Year())
+	ys := stats.years[year]
+	if ys == nil {
+		ys = &yearStats{Days: map[string]int{}, Names: map[string]int{}}
+		stats.years[year] = ys
+	}
+	if count == 1 {
+		ys.Greetings++
+	}
+	ys.Views++
+	ys.Days[day]++
+	if name := leadingName(message); name != "" {
+		ys.Names[name]++
+	}
+	statsFlush.schedule()
+	return nil
+}
+
+// Retrospective is the summary of a year shown at /retrospectiva.
+type Retrospective struct {
+	Year        int
+	Greetings   int
+	Views       int
+	TopNames    []string
+	BusiestDay  string // "25/12"
+	BusiestDayN int
+}
+
+// retrospective summarizes a year. Only names with at least
+// retrospectiveMinNameViews views are listed, so private cards stay private.
+func retrospective(year int) (Retrospective, error) {
+	if err := ensureStatsLoaded(); err != nil {
+		return Retrospective{}, err
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	retro := Retrospective{Year: year}
+	ys := stats.years[strconv.Itoa(year)]
+	if ys == nil {
+		return retro, nil
+	}
+	retro.Greetings = ys.Greetings
+	retro.Views = ys.Views
+
+	names := make([]string, 0, len(ys.Names))
+	for name, count := range ys.Names {
+		if count >= retrospectiveMinNameViews && !isBlockedMessage(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ys.Names[names[i]] != ys.Names[names[j]] {
+			return ys.Names[names[i]] > ys.Names[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > retrospectiveTopNames {
+		names = names[:retrospectiveTopNames]
+	}
+	retro.TopNames = names
+
+	for day, count := range ys.Days {
+		if count > retro.BusiestDayN || (count == retro.BusiestDayN && day < retro.BusiestDay) {
+			retro.BusiestDay, retro.BusiestDayN = day, count
+		}
+	}
+	if date, err := time.Parse("2006-01-02", retro.BusiestDay); err == nil {
+		retro.BusiestDay = date.Format("02/01")
+	}
+	return retro, nil
+}
+
+func ensureStatsLoaded() error {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.loaded {
+		return nil
+	}
+
+	path := statsDBPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			stats.loaded = true
+			return nil
+		}
+		return err
+	}
+
+	years := map[string]*yearStats{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &years); err != nil {
+			return err
+		}
+	}
+	for _, ys := range years {
+		if ys.Days == nil {
+			ys.Days = map[string]int{}
+		}
+		if ys.Names == nil {
+			ys.Names = map[string]int{}
+		}
+	}
+	stats.years = years
+	stats.loaded = true
+	return nil
+}
+
+func persistStatsLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := statsDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(stats.years, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+func statsDBPath() string {
+	if value := os.Getenv("STATS_DB"); value != "" {
+		return value
+	}
+	return "data/stats.json"
+}
+
+// formatCount writes n with Brazilian thousands separators: 12.345.
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	var b strings.Builder
+	for i, r := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// RetrospectivePageData is the data model of public/retrospective.html.
+type RetrospectivePageData struct {
+	Retrospective
+	Title     string
+	OgDesc    string
+	OgURL     string
+	OgImage   string
+	Greetings string
+	Views     string
+	Site      SiteIdentity
+}
+
+// handleRetrospective serves /retrospectiva (the current year) and
+// /retrospectiva/{ano}.
+func handleRetrospective(w http.ResponseWriter, r *http.Request, rawYear string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	current := time.Now().In(countdownZone).Year()
+	year := current
+	if rawYear = strings.Trim(rawYear, "/"); rawYear != "" {
+		parsed, err := strconv.Atoi(rawYear)
+		if err != nil || parsed < 2000 || parsed > current {
+			writeHTML(w, r, http.StatusNotFound, errorPage("Retrospectiva não encontrada."))
+			return
+		}
+		year = parsed
+	}
+	retro, err := retrospective(year)
+	if err != nil {
+		slog.Error("stats load failed", "error", err)
+		writeHTML(w, r, http.StatusInternalServerError, errorPage("Não foi possível montar esta página."))
+		return
+	}
+
+	site := siteIdentity()
+	base := strings.TrimRight(publicBaseURL(), "/")
+	data := RetrospectivePageData{
+		Retrospective: retro,
+		Title:         fmt.Sprintf("Retrospectiva %d - %s", year, site.Name),
+		OgDesc:        fmt.Sprintf("%s mensagens de parabéns criadas em %d 🎉", formatCount(retro.Greetings), year),
+		OgURL:         fmt.Sprintf("%s/retrospectiva/%d", base, year),
+		OgImage:       ogImageURL(base, ogImageSpec{Text: fmt.Sprintf("Retrospectiva %d\n%s mensagens de parabéns", year, formatCount(retro.Greetings))}),
+		Greetings:     formatCount(retro.Greetings),
+		Views:         formatCount(retro.Views),
+		Site:          site,
+	}
+	var b strings.Builder
+	if err := retrospectiveTemplate.Execute(&b, data); err != nil {
+		slog.Error("retrospective render failed", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeHTML(w, r, http.StatusOK, b.String())
+}
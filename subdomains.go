@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Subdomains of the site domain mapped to occasion prefixes, from the config
+// file: "aniversario" serves aniversario.parabens.vc/João as /aniversario/João.
+var customSubdomains = map[string]string{}
+
+func validateSubdomains(subdomains map[string]string, cfgOccasions []Occasion) error {
+	for label, prefix := range subdomains {
+		if !isSlug(label) {
+			return fmt.Errorf("invalid label %q", label)
+		}
+		_, known := occasions[prefix]
+		for _, occ := range cfgOccasions {
+			known = known || occ.Prefix == prefix
+		}
+		if !known {
+			return fmt.Errorf("%q: unknown occasion %q", label, prefix)
+		}
+	}
+	return nil
+}
+
+// subdomainOccasion returns the occasion prefix of a request host such as
+// "aniversario.parabens.vc:8080".
+func subdomainOccasion(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+siteIdentity().Domain)
+	if !ok {
+		return "", false
+	}
+	configMu.RLock()
+	defer configMu.RUnlock()
+	prefix, ok := customSubdomains[label]
+	return prefix, ok
+}
+
+// subdomainPath maps the path of a request to an occasion subdomain onto the
+// path-based route. Paths that already name an occasion are kept, so links
+// like aniversario.parabens.vc/natal/Ana still work.
+func subdomainPath(prefix, path string) string {
+	first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if _, ok := lookupOccasion(first); ok {
+		return path
+	}
+	return "/" + prefix + path
+}
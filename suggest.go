@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	popularNamesOnce sync.Once
+	popularNames     []string
+)
+
+var suggestLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: suggestRateWindow,
+	max:    suggestRateLimit,
+}
+
+// accentFolder maps Portuguese accented letters to their base letter so
+// "fab" matches "Fábio".
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "ê", "e", "ë", "e",
+	"í", "i", "ï", "i",
+	"ó", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+func suggestKey(value string) string {
+	return accentFolder.Replace(normalizeForBlock(value))
+}
+
+func loadPopularNames() {
+	data, err := embeddedFiles.ReadFile("public/names.txt")
+	if err != nil {
+		popularNames = nil
+		return
+	}
+	lines := strings.Split(string(data), "\n")
+	popularNames = make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		popularNames = append(popularNames, line)
+	}
+}
+
+// trending is the index trendingNames serves, built from the view counts
+// when they are loaded and each time they are written, so a keystroke on
+// /api/suggest neither walks every greeting nor waits on views.mu.
+var trending struct {
+	sync.RWMutex
+	names []string
+}
+
+// trendingNames returns the first names of greetings with at least
+// suggestTrendingMinViews views, most viewed first. The threshold keeps
+// one-off names of private cards out of the suggestions.
+func trendingNames() ([]string, error) {
+	if err := ensureViewsLoaded(); err != nil {
+		return nil, err
+	}
+	trending.RLock()
+	defer trending.RUnlock()
+	return trending.names, nil
+}
+
+// refreshTrendingLocked rebuilds the trending index, keeping the
+// suggestTrendingMax most viewed names. Counts are only recorded for
+// greetings the page renders, but the store may hold some from before,
+// so paths and blocked names are skipped here too. views.mu is held.
+func refreshTrendingLocked() {
+	counts := map[string]int{}
+	for key, count := range views.counts {
+		_, message, _ := strings.Cut(key, "/")
+		if looksLikePath(message) {
+			continue
+		}
+		if name := leadingName(message); name != "" {
+			counts[name] += count
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name, count := range counts {
+		if count >= suggestTrendingMinViews && !isBlockedMessage(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > suggestTrendingMax {
+		names = names[:suggestTrendingMax]
+	}
+
+	trending.Lock()
+	trending.names = slices.Clip(names)
+	trending.Unlock()
+}
+
+// leadingName returns the first word of a message when it looks like a
+// capitalized first name ("João, você é demais" -> "João").
+func leadingName(message string) string {
+	fields := strings.FieldsFunc(message, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(fields) == 0 || utf8.RuneCountInString(fields[0]) < 2 {
+		return ""
+	}
+	if !isCapitalized(fields[0]) || !strings.HasPrefix(strings.TrimSpace(message), fields[0]) {
+		return ""
+	}
+	return fields[0]
+}
+
+// suggestNames returns up to suggestLimit names starting with prefix,
+// ignoring case and accents: trending names first, then the dataset.
+func suggestNames(prefix string) []string {
+	popularNamesOnce.Do(loadPopularNames)
+	key := suggestKey(prefix)
+	candidates := popularNames
+	if trending, err := trendingNames(); err != nil {
+		slog.Error("views load failed", "error", err)
+	} else {
+		candidates = append(trending, popularNames...)
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, name := range candidates {
+		nameKey := suggestKey(name)
+		if seen[nameKey] || !strings.HasPrefix(nameKey, key) || isBlockedMessage(name) {
+			continue
+		}
+		seen[nameKey] = true
+		names = append(names, name)
+		if len(names) == suggestLimit {
+			break
+		}
+	}
+	return names
+}
+
+// handleSuggest serves name autocomplete: GET /api/suggest?q=Jo
+func handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !suggestLimiter.allow(clientIP(r)) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if suggestKey(q) == "" || utf8.RuneCountInString(q) > maxNameLen {
+		writeAPIError(w, http.StatusBadRequest, "invalid_query")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, suggestNames(q))
+}
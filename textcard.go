@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Terminal clients get a text card instead of HTML; the first ones also get
+// ANSI colors (wget usually saves to a file, so it gets plain text).
+var (
+	colorTerminalAgents = []string{"curl/", "httpie/", "xh/"}
+	plainTerminalAgents = []string{"wget/", "powershell/"}
+)
+
+// textCardMode reports whether r should get the text card, and whether in
+// color: terminal user agents, or an Accept asking for text/plain but not
+// HTML.
+func textCardMode(r *http.Request) (text, color bool) {
+	agent := strings.ToLower(r.UserAgent())
+	for _, prefix := range colorTerminalAgents {
+		if strings.HasPrefix(agent, prefix) {
+			return true, true
+		}
+	}
+	for _, prefix := range plainTerminalAgents {
+		if strings.HasPrefix(agent, prefix) {
+			return true, false
+		}
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/html") {
+		return true, false
+	}
+	return false, false
+}
+
+// pickedByUserAgent reports whether the User-Agent of r, rather than its
+// Accept, decides how a greeting page answers it: a terminal agent, or an
+// Accept naming none of HTML, JSON and plain text, such as curl's */*.
+// Greeting pages vary on Accept only, so these answers are kept out of
+// shared caches rather than varied on every User-Agent.
+func pickedByUserAgent(r *http.Request) bool {
+	agent := strings.ToLower(r.UserAgent())
+	for _, prefix := range append(colorTerminalAgents, plainTerminalAgents...) {
+		if strings.HasPrefix(agent, prefix) {
+			return true
+		}
+	}
+	accept := r.Header.Get("Accept")
+	for _, mediaType := range []string{"text/html", "application/json", "text/plain"} {
+		if strings.Contains(accept, mediaType) {
+			return false
+		}
+	}
+	return true
+}
+
+// ANSI colors of the balloons, in order.
+var textCardColors = []string{"31", "33", "32", "36", "35"}
+
+// renderTextCard draws the greeting as a terminal card:
+//
+//	 ,-.   ,-.   ,-.
+//	(   ) (   ) (   )
+//	 `-'   `-'   `-'
+//	  |     |     |
+//
+//	Feliz Aniversário,
+//	João!
+func renderTextCard(g greeting, sender string, color bool) string {
+	paint := func(code, text string) string {
+		if !color || text == "" {
+			return text
+		}
+		return "\x1b[" + code + "m" + text + "\x1b[0m"
+	}
+	balloons := []string{" ,-.  ", "(   ) ", " `-'  ", "  |   "}
+	var b strings.Builder
+	b.WriteString("\n")
+	for row, part := range balloons {
+		b.WriteString("  ")
+		for _, code := range textCardColors {
+			if row == len(balloons)-1 {
+				code = "2" // dim strings
+			}
+			b.WriteString(paint(code, part))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	lines := messageLines(g.DisplayMessage)
+	lines[len(lines)-1] += g.Punct
+	fmt.Fprintf(&b, "  %s\n", paint("1;33", g.Greeting+","))
+	for _, line := range lines {
+		fmt.Fprintf(&b, "  %s\n", paint("1", line))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  %s\n", paint("36", g.Subtitle))
+	if sender != "" {
+		fmt.Fprintf(&b, "  %s\n", paint("35", "— "+fromWord(g.Locale)+" "+sender))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  %s\n\n", paint("2;4", g.OgURL))
+	return b.String()
+}
+
+func writeText(w http.ResponseWriter, r *http.Request, status int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write([]byte(body))
+}
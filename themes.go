@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Theme is a color scheme selectable with ?theme=.
+type Theme struct {
+	Name    string       `json:"name"`
+	Label   string       `json:"label"`
+	Class   string       `json:"class"`
+	Palette ThemePalette `json:"palette"`
+}
+
+// ThemePalette holds "#RRGGBB" colors used for previews and OG images.
+// Custom themes also apply them to the page through /theme.css.
+type ThemePalette struct {
+	Background string `json:"background"`
+	Accent     string `json:"accent"`
+	Text       string `json:"text"`
+}
+
+// Built-in themes, in picker order; their CSS lives in styles.css
+var builtinThemes = []Theme{
+	{Name: "", Label: "Padrão", Class: "", Palette: ThemePalette{Background: "#0f172a", Accent: "#fbbf24", Text: "#f8fafc"}},
+	{Name: "light", Label: "Claro", Class: "theme-light", Palette: ThemePalette{Background: "#faf9f6", Accent: "#e89b1c", Text: "#1e293b"}},
+	{Name: "warm", Label: "Quente", Class: "theme-warm", Palette: ThemePalette{Background: "#4a1c1c", Accent: "#f59e0b", Text: "#fef3e2"}},
+	{Name: "elegant", Label: "Elegante", Class: "theme-elegant", Palette: ThemePalette{Background: "#1a1a1a", Accent: "#d4af37", Text: "#f5e6c8"}},
+	{Name: "pixel", Label: "Pixel", Class: "theme-pixel", Palette: ThemePalette{Background: "#1a1a2e", Accent: "#ffd700", Text: "#eaeaea"}},
+}
+
+// Colors of the default OG template replaced by a theme palette
+var ogTemplateColors = struct {
+	Background []string
+	Text       []string
+}{
+	Background: []string{"#0f172a", "#1e293b"},
+	Text:       []string{"#f8fafc", "#cbd5f5"},
+}
+
+// lookupTheme finds a theme by name, checking config-defined themes first.
+func lookupTheme(name string) (Theme, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	configMu.RLock()
+	theme, ok := customThemes[name]
+	configMu.RUnlock()
+	if ok {
+		return theme, true
+	}
+	for _, theme := range builtinThemes {
+		if theme.Name == name {
+			return theme, true
+		}
+	}
+	return Theme{}, false
+}
+
+// isCustomTheme reports whether the theme comes from the config file, whose
+// palette is served from /theme.css since styles.css has no class for it.
+func isCustomTheme(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	configMu.RLock()
+	defer configMu.RUnlock()
+	_, ok := customThemes[name]
+	return ok
+}
+
+func allThemes() []Theme {
+	configMu.RLock()
+	custom := make([]Theme, 0, len(customThemes))
+	for _, theme := range customThemes {
+		custom = append(custom, theme)
+	}
+	configMu.RUnlock()
+	sort.Slice(custom, func(i, j int) bool { return custom[i].Name < custom[j].Name })
+
+	themes := make([]Theme, 0, len(builtinThemes)+len(custom))
+	for _, theme := range builtinThemes {
+		if !isCustomTheme(theme.Name) {
+			themes = append(themes, theme)
+		}
+	}
+	return append(themes, custom...)
+}
+
+// themeColor is the page's accent as "#rrggbb": the ?cor= color when set,
+// otherwise the theme's palette accent.
+func themeColor(theme, accent string) string {
+	if color := accentColor(accent); color != "" {
+		return "#" + color
+	}
+	if t, ok := lookupTheme(theme); ok {
+		return t.Palette.Accent
+	}
+	return builtinThemes[0].Palette.Accent
+}
+
+func handleThemes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, allThemes())
+}
+
+// paletteVersion hashes the palette of t, so what is cached from it, the
+// OG images and /theme.css, is not served after a reload changes it.
+func (t Theme) paletteVersion() string {
+	return shortHash(fmt.Sprint(t.Palette))[:8]
+}
+
+// themeCSSURL returns the stylesheet with a config theme's palette and the
+// ?cor= accent, or "" when styles.css covers the page. The CSP forbids inline
+// styles, so these colors are served from /theme.css.
+func themeCSSURL(theme, accent string) string {
+	query := url.Values{}
+	if name := themeName(theme); name != "" && isCustomTheme(name) {
+		query.Set("theme", name)
+		if t, ok := lookupTheme(name); ok {
+			query.Set("v", t.paletteVersion())
+		}
+	}
+	if color := accentColor(accent); color != "" {
+		query.Set("cor", color)
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "/theme.css?" + query.Encode()
+}
+
+func themeCSS(theme, accent string) string {
+	var b strings.Builder
+	if t, ok := lookupTheme(theme); ok && t.Name != "" && isCustomTheme(t.Name) {
+		p := t.Palette
+		fmt.Fprintf(&b, ".%s {\n    --bg: %s;\n    --bg-gradient-1: %s;\n    --bg-gradient-2: %s;\n    --accent: %s;\n    --white: %s;\n    --text: %s;\n}\n",
+			t.Class, p.Background, p.Background, p.Background, p.Accent, p.Text, p.Text)
+	}
+	if color := accentColor(accent); color != "" {
+		// :root body outranks the .theme-* classes set on <body>
+		fmt.Fprintf(&b, ":root body {\n    --accent: #%s;\n}\n", color)
+	}
+	return b.String()
+}
+
+func handleThemeCSS(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	css := themeCSS(query.Get("theme"), query.Get("cor"))
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(css)))
+	setCacheHeaders(w, cacheStaticMedia, "static")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.WriteString(w, css)
+}
+
+func validateTheme(theme *Theme) error {
+	theme.Name = strings.ToLower(strings.TrimSpace(theme.Name))
+	theme.Label = strings.TrimSpace(theme.Label)
+	theme.Class = strings.TrimSpace(theme.Class)
+	if theme.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if !isSlug(theme.Name) {
+		return fmt.Errorf("invalid name %q", theme.Name)
+	}
+	if theme.Label == "" {
+		theme.Label = theme.Name
+	}
+	if theme.Class == "" {
+		theme.Class = "theme-" + theme.Name
+	}
+	if !isSlug(theme.Class) {
+		return fmt.Errorf("theme %q: invalid class %q", theme.Name, theme.Class)
+	}
+	for field, color := range map[string]*string{
+		"background": &theme.Palette.Background,
+		"accent":     &theme.Palette.Accent,
+		"text":       &theme.Palette.Text,
+	} {
+		hex, ok := strings.CutPrefix(strings.TrimSpace(*color), "#")
+		if !ok || accentColor(hex) == "" {
+			return fmt.Errorf("theme %q: palette %s must be #RRGGBB", theme.Name, field)
+		}
+		*color = "#" + accentColor(hex)
+	}
+	return nil
+}
+
+func isSlug(value string) bool {
+	for _, r := range value {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return value != ""
+}
+
+// ogApplyPalette recolors the default OG template with a theme palette.
+// The accent is left alone when keepAccent is set (?cor= already applied).
+func ogApplyPalette(svg string, palette ThemePalette, keepAccent bool) string {
+	pairs := []string{}
+	for _, color := range ogTemplateColors.Background {
+		pairs = append(pairs, color, palette.Background)
+	}
+	for _, color := range ogTemplateColors.Text {
+		pairs = append(pairs, color, palette.Text)
+	}
+	if !keepAccent {
+		pairs = append(pairs, ogDefaultAccent, palette.Accent)
+	}
+	return strings.NewReplacer(pairs...).Replace(svg)
+}
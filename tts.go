@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ttsLimiter = &rateLimiter{
+	hits:   map[string][]time.Time{},
+	window: ttsRateWindow,
+	max:    ttsRateLimit,
+}
+
+// synthesizeSpeechFunc is the TTS backend: it writes a WAV file saying text
+// to destPath.
+var synthesizeSpeechFunc = synthesizeSpeech
+
+// ttsEnabled reports whether a TTS backend is configured; the card only
+// links the audio greeting when it is.
+func ttsEnabled() bool {
+	return os.Getenv("TTS_CMD") != ""
+}
+
+// synthesizeSpeech runs TTS_CMD with the output path as its only argument
+// and the text on stdin, e.g. a wrapper around
+// `espeak-ng -v pt-br --stdin -w "$1"` or a cloud TTS client.
+func synthesizeSpeech(text, destPath string) error {
+	command := os.Getenv("TTS_CMD")
+	if command == "" {
+		return fmt.Errorf("TTS_CMD not configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ttsTimeout)
+	defer cancel()
+	// Write next to the cache entry and rename, so a failed or slow run
+	// never leaves a truncated file behind.
+	tmpPath := destPath + ".tmp"
+	cmd := exec.CommandContext(ctx, command, tmpPath)
+	cmd.Stdin = strings.NewReader(text)
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("tts: %w", err)
+	}
+	return os.Rename(tmpPath, destPath)
+}
+
+// speechText is what the audio greeting says, e.g. "Parabéns, João!".
+func speechText(g greeting) string {
+	return fmt.Sprintf("%s, %s%s", g.Greeting, strings.Join(messageLines(g.DisplayMessage), " "), g.Punct)
+}
+
+func speechCachePath(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return filepath.Join(ogCacheDir(), "tts", hex.EncodeToString(sum[:16])+".wav")
+}
+
+// speechURL links the audio greeting of a card, or returns "" when there is
+// no backend or no message to say.
+func speechURL(g greeting) string {
+	if !ttsEnabled() || g.Message == "" {
+		return ""
+	}
+	path := "/tts/"
+	if g.Occasion.Prefix != "" {
+		path += g.Occasion.Prefix + "/"
+	}
+	path += encodePathSegment(strings.ReplaceAll(g.Message, "\n", "~"))
+	if g.Age > 0 {
+		path += "?idade=" + strconv.Itoa(g.Age)
+	}
+	return path
+}
+
+// handleSpeech serves GET /tts/{path}: the greeting read aloud, synthesized
+// once per text and cached, with Range support for mobile players.
+func handleSpeech(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !ttsEnabled() {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	_, rawMessage := parseOccasionFromPath(path)
+	message := decodePath(rawMessage)
+	if message == "" || looksLikePath(message) {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	if isBlockedMessage(message) {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	if _, err := greetingAge(path, query); err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	text := speechText(buildGreeting(path, greetingPageOptions(path, query)))
+	cachePath := speechCachePath(text)
+	if ok, err := fileExists(cachePath); !ok || err != nil {
+		// Only synthesis is limited; cached audio is cheap to serve
+		if !ttsLimiter.allow(clientIP(r)) {
+			http.Error(w, "", http.StatusTooManyRequests)
+			return
+		}
+		if err := synthesizeSpeechFunc(text, cachePath); err != nil {
+			slog.Error("tts failed", "error", err)
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	file, err := os.Open(cachePath)
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "parabens.wav", info.ModTime(), file)
+}
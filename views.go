@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type viewStore struct {
+	mu     sync.Mutex
+	loaded bool
+	counts map[string]int
+}
+
+var views = viewStore{
+	counts: map[string]int{},
+}
+
+// viewsFlush writes the counts viewPersistDelay after a view: /api/track is
+// public, so a client must not be able to force a write per request.
+var viewsFlush = &storeFlush{name: "views", delay: viewPersistDelay, write: func() error {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	if err := persistViewsLocked(); err != nil {
+		return err
+	}
+	refreshTrendingLocked()
+	return nil
+}}
+
+// crawlerTokens are lowercase User-Agent fragments of link unfurlers,
+// crawlers and scripts, whose requests are not counted as views.
+var crawlerTokens = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "headless",
+	"facebookexternalhit", "whatsapp", "telegram", "skypeuripreview",
+	"curl", "wget", "python-requests", "go-http-client",
+}
+
+func isCrawler(r *http.Request) bool {
+	if purpose := r.Header.Get("Sec-Purpose") + r.Header.Get("Purpose"); strings.Contains(purpose, "prefetch") {
+		return true
+	}
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" {
+		return true
+	}
+	for _, token := range crawlerTokens {
+		if strings.Contains(ua, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordView increments the view counter of a greeting path, keyed like
+// the guestbook so every spelling of the path shares one counter, and
+// returns the count, or 0 when the view is not counted. /api/track takes
+// any path, so only those the page would render are counted, and no new
+// greeting once maxViewKeys are.
+func recordView(path string) (int, error) {
+	if status, _ := checkGreetingPath(path); status != http.StatusOK {
+		return 0, nil
+	}
+	key, _ := guestbookTarget(path)
+	if key == "" {
+		return 0, nil
+	}
+	if err := ensureViewsLoaded(); err != nil {
+		return 0, err
+	}
+	views.mu.Lock()
+	if _, ok := views.counts[key]; !ok && len(views.counts) >= maxViewKeys {
+		views.mu.Unlock()
+		return 0, nil
+	}
+	views.counts[key]++
+	count := views.counts[key]
+	views.mu.Unlock()
+	viewsFlush.schedule()
+	return count, nil
+}
+
+func viewCount(path string) (int, error) {
+	key, _ := guestbookTarget(path)
+	if key == "" {
+		return 0, nil
+	}
+	if err := ensureViewsLoaded(); err != nil {
+		return 0, err
+	}
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	return views.counts[key], nil
+}
+
+func ensureViewsLoaded() error {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	if views.loaded {
+		return nil
+	}
+
+	path := viewsDBPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			views.loaded = true
+			refreshTrendingLocked()
+			return nil
+		}
+		return err
+	}
+
+	counts := map[string]int{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &counts); err != nil {
+			return err
+		}
+	}
+	views.counts = counts
+	views.loaded = true
+	refreshTrendingLocked()
+	return nil
+}
+
+func persistViewsLocked() error {
+	if readOnly() {
+		return errReadOnly
+	}
+	path := viewsDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(views.counts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+func viewsDBPath() string {
+	if value := os.Getenv("VIEWS_DB"); value != "" {
+		return value
+	}
+	return "data/views.json"
+}
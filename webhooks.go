@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every new shortlink is posted to the WEBHOOK_URLS as a JSON
+// WebhookPayload, signed with WEBHOOK_SECRET: the X-Webhook-Signature header
+// is "sha256=" and the hex HMAC-SHA256 of the body. Deliveries run in the
+// background and are retried with a doubling delay on network errors, 429s
+// and 5xx; the recent ones are listed by GET /api/webhooks.
+
+// WebhookPayload is the body posted on each new shortlink.
+type WebhookPayload struct {
+	Event     string    `json:"event"` // "shortlink.created"
+	Code      string    `json:"code"`
+	Path      string    `json:"path"`
+	Occasion  string    `json:"occasion"` // prefix, "" for the general one
+	ShortURL  string    `json:"short_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is the status of posting one event to one URL.
+type WebhookDelivery struct {
+	ID          string     `json:"id"`
+	URL         string     `json:"url"`
+	Event       string     `json:"event"`
+	Code        string     `json:"code"`
+	Status      string     `json:"status"` // "pending", "delivered" or "failed"
+	Attempts    int        `json:"attempts"`
+	LastStatus  int        `json:"last_status,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+var webhookHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookRetryDelay is the wait before the first retry, doubled on each
+// further one.
+var webhookRetryDelay = 2 * time.Second
+
+// webhookLog keeps the last maxWebhookDeliveries deliveries, oldest first.
+var webhookLog = struct {
+	mu         sync.Mutex
+	deliveries []*WebhookDelivery
+}{}
+
+// webhookURLs are the WEBHOOK_URLS, separated by commas.
+func webhookURLs() []string {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// webhookSignature signs body with WEBHOOK_SECRET, or returns "" without
+// one.
+func webhookSignature(body []byte) string {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		return ""
+	}
+	return "sha256=" + hex.EncodeToString(hmacSHA256([]byte(secret), string(body)))
+}
+
+// notifyShortlinkCreated starts delivering the creation of code to every
+// webhook. It does not block.
+func notifyShortlinkCreated(code, fullPath string) {
+	urls := webhookURLs()
+	if len(urls) == 0 {
+		return
+	}
+	pathOnly, _, _ := strings.Cut(fullPath, "?")
+	occasion, _ := parseOccasionFromPath(pathOnly)
+	payload := WebhookPayload{
+		Event:     "shortlink.created",
+		Code:      code,
+		Path:      fullPath,
+		Occasion:  occasion.Prefix,
+		ShortURL:  strings.TrimRight(publicBaseURL(), "/") + "/s/" + code,
+		CreatedAt: time.Now().UTC(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("webhook payload failed", "code", code, "error", err)
+		return
+	}
+	for _, u := range urls {
+		id, err := randomToken()
+		if err != nil {
+			slog.Error("webhook delivery id failed", "error", err)
+			return
+		}
+		delivery := &WebhookDelivery{ID: id, URL: u, Event: payload.Event, Code: code, Status: "pending", CreatedAt: payload.CreatedAt}
+		webhookLog.mu.Lock()
+		webhookLog.deliveries = append(webhookLog.deliveries, delivery)
+		if excess := len(webhookLog.deliveries) - maxWebhookDeliveries; excess > 0 {
+			webhookLog.deliveries = append([]*WebhookDelivery(nil), webhookLog.deliveries[excess:]...)
+		}
+		webhookLog.mu.Unlock()
+		go deliverWebhook(delivery, body)
+	}
+}
+
+// deliverWebhook posts body until it is accepted, the URL refuses it with
+// a 4xx other than 429, or webhookMaxAttempts have failed.
+func deliverWebhook(delivery *WebhookDelivery, body []byte) {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		status, err := postWebhook(delivery, body)
+		retry := err != nil && (status == 0 || status == http.StatusTooManyRequests || status >= 500)
+
+		webhookLog.mu.Lock()
+		delivery.Attempts, delivery.LastStatus = attempt, status
+		switch {
+		case err == nil:
+			now := time.Now().UTC()
+			delivery.Status, delivery.LastError, delivery.DeliveredAt = "delivered", "", &now
+		case retry && attempt < webhookMaxAttempts:
+			delivery.LastError = err.Error()
+		default:
+			delivery.Status, delivery.LastError = "failed", err.Error()
+		}
+		done := delivery.Status != "pending"
+		webhookLog.mu.Unlock()
+
+		if done {
+			if err != nil {
+				slog.Error("webhook delivery failed", "url", delivery.URL, "code", delivery.Code, "attempts", attempt, "error", err)
+			}
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postWebhook makes one delivery attempt, returning the response status (0
+// when there is none).
+func postWebhook(delivery *WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "parabens-webhook")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	if signature := webhookSignature(body); signature != "" {
+		req.Header.Set("X-Webhook-Signature", signature)
+	}
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// WebhookStatus is served by GET /api/webhooks.
+type WebhookStatus struct {
+	URLs       []string          `json:"urls"`
+	Signed     bool              `json:"signed"`
+	Deliveries []WebhookDelivery `json:"deliveries"` // newest first
+}
+
+// handleWebhooks serves the admin endpoint GET /api/webhooks: the
+// configured URLs and the recent deliveries since the server started.
+func handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	status := WebhookStatus{URLs: webhookURLs(), Signed: os.Getenv("WEBHOOK_SECRET") != "", Deliveries: []WebhookDelivery{}}
+	if status.URLs == nil {
+		status.URLs = []string{}
+	}
+	webhookLog.mu.Lock()
+	for i := len(webhookLog.deliveries) - 1; i >= 0; i-- {
+		status.Deliveries = append(status.Deliveries, *webhookLog.deliveries[i])
+	}
+	webhookLog.mu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, status)
+}